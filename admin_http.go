@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// allowedDatabasesOverride replaces clickhouse.allowed_databases once set
+// through the admin endpoint. It lives outside viper because viper is not
+// safe for writes concurrent with the reads every query makes.
+var (
+	allowedDatabasesMu       sync.RWMutex
+	allowedDatabasesOverride []string
+)
+
+func setAllowedDatabases(dbs []string) {
+	allowedDatabasesMu.Lock()
+	defer allowedDatabasesMu.Unlock()
+	allowedDatabasesOverride = append([]string(nil), dbs...)
+}
+
+// databasePatternRe matches database names and glob patterns over them.
+var databasePatternRe = regexp.MustCompile(`^[A-Za-z0-9_*?\[\]^!-]+$`)
+
+// allowedDatabasesRequest is the body of PUT /admin/allowed-databases.
+type allowedDatabasesRequest struct {
+	Databases []string `json:"databases"`
+}
+
+// adminAllowedDatabasesHandler serves /admin/allowed-databases: GET returns
+// the databases clickhouse_query may read, PUT replaces them until the next
+// restart. Callers must be authenticated by the admin token.
+func adminAllowedDatabasesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req allowedDatabasesRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := validateDatabaseNames(req.Databases); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			previous := getAllowedDatabases()
+			setAllowedDatabases(req.Databases)
+			logrus.WithFields(logrus.Fields{
+				"remote_addr": r.RemoteAddr,
+				"user_agent":  r.Header.Get("User-Agent"),
+				"identity":    "admin token",
+				"previous":    previous,
+				"databases":   req.Databases,
+			}).Warn("Allowed databases changed via admin endpoint")
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string][]string{"allowed_databases": getAllowedDatabases()})
+	})
+}
+
+// validateDatabaseNames requires a non-empty list of database names or glob
+// patterns.
+func validateDatabaseNames(dbs []string) error {
+	if len(dbs) == 0 {
+		return fmt.Errorf("databases must not be empty")
+	}
+	for _, db := range dbs {
+		if err := validateDatabasePattern(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateDatabasePattern checks one allowed_databases entry.
+func validateDatabasePattern(db string) error {
+	if !databasePatternRe.MatchString(db) {
+		return fmt.Errorf("invalid database name %q", db)
+	}
+	if _, err := path.Match(db, ""); err != nil {
+		return fmt.Errorf("invalid database pattern %q: %v", db, err)
+	}
+	return nil
+}
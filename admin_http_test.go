@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/spf13/viper"
+)
+
+func TestAdminAllowedDatabases(t *testing.T) {
+	defer setAllowedDatabases(nil)
+	defer viper.Set("http.admin_token", nil)
+	defer viper.Set("clickhouse.allowed_databases", nil)
+	viper.Set("clickhouse.allowed_databases", []string{"system"})
+	viper.Set("http.admin_token", "admin-secret")
+	srv := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0.0.0"}, &mcp.ServerOptions{})
+	handler := newHTTPHandler(srv, "client-secret")
+
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		body       string
+		wantStatus int
+		wantDBs    []string
+	}{
+		{name: "client token rejected", method: http.MethodGet, token: "client-secret", wantStatus: http.StatusUnauthorized},
+		{name: "get configured list", method: http.MethodGet, token: "admin-secret", wantStatus: http.StatusOK, wantDBs: []string{"system"}},
+		{name: "invalid name", method: http.MethodPut, token: "admin-secret", body: `{"databases": ["system", "a.b"]}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid pattern", method: http.MethodPut, token: "admin-secret", body: `{"databases": ["app_[x"]}`, wantStatus: http.StatusBadRequest},
+		{name: "empty list", method: http.MethodPut, token: "admin-secret", body: `{"databases": []}`, wantStatus: http.StatusBadRequest},
+		{name: "replace list", method: http.MethodPut, token: "admin-secret", body: `{"databases": ["system", "models"]}`, wantStatus: http.StatusOK, wantDBs: []string{"system", "models"}},
+		{name: "wrong method", method: http.MethodDelete, token: "admin-secret", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/admin/allowed-databases", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantDBs == nil {
+				return
+			}
+			var got map[string][]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !equalSlices(got["allowed_databases"], tt.wantDBs) {
+				t.Errorf("allowed_databases = %v, want %v", got["allowed_databases"], tt.wantDBs)
+			}
+		})
+	}
+
+	if !isTableAllowed("models.events") {
+		t.Error("isTableAllowed(models.events) = false after the admin update")
+	}
+}
+
+func TestAdminEndpointDisabledWithoutToken(t *testing.T) {
+	srv := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0.0.0"}, &mcp.ServerOptions{})
+	handler := newHTTPHandler(srv, "")
+	req := httptest.NewRequest(http.MethodPut, "/admin/allowed-databases", strings.NewReader(`{"databases": ["models"]}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code == http.StatusOK {
+		t.Error("admin endpoint served without http.admin_token set")
+	}
+	if isTableAllowed("models.events") {
+		t.Error("allowed databases changed without http.admin_token set")
+	}
+}
@@ -3,8 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/sirupsen/logrus"
@@ -58,7 +62,70 @@ var querySystemTableTool = &genai.Tool{
 	},
 }
 
+// checkAgentTable enforces agent.allowed_tables / agent.denied_tables for the
+// analysis agent. The denylist always wins; an empty allowlist allows any table
+// not denied. Matching is case-insensitive on the full db.table name.
+func checkAgentTable(table string) error {
+	t := strings.ToLower(strings.TrimSpace(table))
+	for _, denied := range viper.GetStringSlice("agent.denied_tables") {
+		if t == strings.ToLower(strings.TrimSpace(denied)) {
+			return fmt.Errorf("table %s is not available to the analysis agent", table)
+		}
+	}
+	allowed := viper.GetStringSlice("agent.allowed_tables")
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, a := range allowed {
+		if t == strings.ToLower(strings.TrimSpace(a)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("table %s is not available to the analysis agent; allowed tables: %s", table, strings.Join(allowed, ", "))
+}
+
+// qualifiedNameRe matches a db.table name in lowercased, quote-stripped SQL.
+var qualifiedNameRe = regexp.MustCompile(`\b([a-z_][a-z0-9_]*)\.([a-z_][a-z0-9_]*)\b`)
+
+// checkAgentClauses rejects columns, where and order_by that could read a
+// table other than args.Table: subqueries, and names of tables in a database
+// the agent lists (e.g. x IN system.users), which must pass checkAgentTable.
+// Dotted column names such as ProfileEvents.Names are not table names.
+func checkAgentClauses(args QuerySystemTableArgs) error {
+	databases := map[string]bool{"system": true}
+	for _, key := range []string{"agent.allowed_tables", "agent.denied_tables"} {
+		for _, t := range viper.GetStringSlice(key) {
+			if db, _, ok := strings.Cut(strings.ToLower(strings.TrimSpace(t)), "."); ok {
+				databases[db] = true
+			}
+		}
+	}
+	for _, c := range append([]string{args.Where, args.OrderBy}, args.Columns...) {
+		lower := strings.ReplaceAll(strings.ToLower(stripQuotedLiterals(c)), "`", "")
+		for _, kw := range []string{"select", "from", "join"} {
+			if containsWord(lower, kw) {
+				return fmt.Errorf("subqueries are not allowed in columns, where or order_by; query one table per call")
+			}
+		}
+		for _, m := range qualifiedNameRe.FindAllStringSubmatch(lower, -1) {
+			if !databases[m[1]] {
+				continue
+			}
+			if err := checkAgentTable(m[0]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func QuerySystemTable(ctx context.Context, conn driver.Conn, args QuerySystemTableArgs) ([]map[string]interface{}, error) {
+	if err := checkAgentTable(args.Table); err != nil {
+		return nil, err
+	}
+	if err := checkAgentClauses(args); err != nil {
+		return nil, err
+	}
 	cluster := viper.GetString("clickhouse.cluster")
 
 	var query strings.Builder
@@ -84,9 +151,11 @@ func QuerySystemTable(ctx context.Context, conn driver.Conn, args QuerySystemTab
 		fmt.Fprintf(&query, " LIMIT %d", args.Limit)
 	}
 
+	ctx, queryID := withQueryID(ctx)
 	rows, err := conn.Query(ctx, query.String())
+	usage.recordQuery(err)
 	if err != nil {
-		return nil, fmt.Errorf("query error: %w", err)
+		return nil, fmt.Errorf("%w (query_id: %s)", err, queryID)
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
@@ -167,6 +236,7 @@ func QuerySystemTable(ctx context.Context, conn driver.Conn, args QuerySystemTab
 
 	logrus.WithFields(logrus.Fields{
 		"query":        query.String(),
+		"query_id":     queryID,
 		"row_count":    len(results),
 		"columns":      columns,
 		"column_types": columnTypes,
@@ -175,30 +245,12 @@ func QuerySystemTable(ctx context.Context, conn driver.Conn, args QuerySystemTab
 	return results, nil
 }
 
-func AnalyzeErrorsWithAgent(chErrors CHErrors) string {
-	ctx := context.Background()
+// AnalyzeErrorsWithAgent asks Gemini to investigate chErrors, letting it query
+// system tables for context, and returns a Slack-formatted summary led by
+// conditions, the result of detectCriticalConditions.
+func AnalyzeErrorsWithAgent(ctx context.Context, chErrors CHErrors, conditions []criticalCondition) (string, error) {
 	logrus.WithField("error_count", len(chErrors)).Info("Starting Gemini error analysis")
 
-	apiKey := viper.GetString("gemini_key")
-
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  apiKey,
-		Backend: genai.BackendGeminiAPI,
-	})
-	if err != nil {
-		logrus.WithError(err).Fatal("Error creating Gemini client")
-	}
-
-	conn, err := connect()
-	if err != nil {
-		logrus.WithError(err).Fatal("Error connecting to ClickHouse for analysis")
-	}
-	defer func() {
-		if err := conn.Close(); err != nil {
-			logrus.WithError(err).Warn("Error closing ClickHouse connection")
-		}
-	}()
-
 	systemPrompt := `You are a ClickHouse database administrator analyzing system errors.
 You have access to query any ClickHouse system table to gather more context about errors.
 Available system tables include but are not limited to:
@@ -221,15 +273,6 @@ IMPORTANT: Keep your response CONCISE and under 2500 characters total.
 Format your final analysis for a Slack channel message using markdown.
 Prioritize the most critical issues and actionable recommendations.`
 
-	config := &genai.GenerateContentConfig{
-		Temperature:     genai.Ptr(float32(0.7)),
-		MaxOutputTokens: 2000,
-		SystemInstruction: &genai.Content{
-			Parts: []*genai.Part{{Text: systemPrompt}},
-		},
-		Tools: []*genai.Tool{querySystemTableTool},
-	}
-
 	prompt := fmt.Sprintf(`Analyze the following ClickHouse errors from the past hour.
 Use the query_clickhouse_system_table function to gather additional context about these errors.
 For example, you might want to check:
@@ -247,107 +290,76 @@ Provide a CONCISE analysis (under 2500 characters) with:
 3. Immediate action items
 4. Use Slack markdown formatting with urgency indicators (🔴 critical, 🟡 warning, 🟢 info)
 
-Be brief and focus only on actionable insights.`, chErrors.String())
+Be brief and focus only on actionable insights.`, activePromptGuard().Guard("system.errors", chErrors.String()))
 
-	logrus.Debug("Creating Gemini chat for error analysis")
-	chat, err := client.Chats.Create(ctx, "gemini-1.5-flash", config, nil)
-	if err != nil {
-		logrus.WithError(err).Fatal("Error creating Gemini chat")
+	if failed := queryLogContext(ctx, chErrors); failed != "" {
+		prompt += "\n\nRecent failed queries in system.query_log with these error codes, one line per normalized_query_hash (query text omitted):\n" +
+			activePromptGuard().Guard("system.query_log", failed)
 	}
 
-	logrus.Debug("Sending initial message to Gemini")
-	resp, err := chat.SendMessage(ctx, genai.Part{Text: prompt})
-	if err != nil {
-		logrus.WithError(err).Fatal("Error sending message to Gemini")
+	// Read-only replicas and full disks are checked up front and always lead
+	// the summary, whatever the model makes of them.
+	critical := formatCriticalConditions(conditions)
+	if critical != "" {
+		prompt += "\n\nThese conditions were verified before this analysis. Report them first, as 🔴 critical, with the affected hosts:\n" + critical
 	}
 
-	maxIterations := 5
-	for i := range maxIterations {
-		functionCalls := resp.FunctionCalls()
-		if len(functionCalls) == 0 {
-			logrus.WithField("iteration", i).Debug("No more function calls from Gemini")
-			break
-		}
-		logrus.WithFields(logrus.Fields{
-			"iteration":      i,
-			"function_count": len(functionCalls),
-		}).Debug("Processing Gemini function calls")
-
-		var funcResponses []genai.Part
-		for _, call := range functionCalls {
-			if call.Name == "query_clickhouse_system_table" {
-				var args QuerySystemTableArgs
-				if argsJSON, err := json.Marshal(call.Args); err == nil {
-					if err := json.Unmarshal(argsJSON, &args); err == nil {
-						results, err := QuerySystemTable(ctx, conn, args)
-						if err != nil {
-							logrus.WithFields(logrus.Fields{
-								"table":   args.Table,
-								"columns": args.Columns,
-								"where":   args.Where,
-								"error":   err,
-							}).Error("QuerySystemTable failed")
-							funcResponses = append(funcResponses, genai.Part{
-								FunctionResponse: &genai.FunctionResponse{
-									Name: call.Name,
-									Response: map[string]interface{}{
-										"error": err.Error(),
-									},
-								},
-							})
-						} else {
-							funcResponses = append(funcResponses, genai.Part{
-								FunctionResponse: &genai.FunctionResponse{
-									Name: call.Name,
-									Response: map[string]interface{}{
-										"results": results,
-										"count":   len(results),
-									},
-								},
-							})
-						}
-					}
-				}
-			}
-		}
-
-		if len(funcResponses) > 0 {
-			logrus.WithField("response_count", len(funcResponses)).Debug("Sending function responses to Gemini")
-			resp, err = chat.SendMessage(ctx, funcResponses...)
-			if err != nil {
-				logrus.WithError(err).Fatal("Error processing function responses")
-			}
-		}
+	systemPrompt = renderSystemPrompt("errors", withToolSchemas(systemPrompt, "query_clickhouse_system_table"), "query_clickhouse_system_table")
+	summary, err := runCachedGeminiAgent(ctx, geminiModel("errors"), systemPrompt, prompt)
+	if err != nil || critical == "" {
+		return summary, err
 	}
-
-	result := resp.Text()
-	logrus.WithField("response_length", len(result)).Debug("Gemini analysis complete")
-	return result
+	return critical + "\n" + summary, nil
 }
 
-func AnalyzeQueryPerformanceWithAgent() string {
-	ctx := context.Background()
-	logrus.Info("Starting Gemini query performance analysis")
+// ExplainErrorWithAgent asks Gemini to explain a single error, given its
+// system.errors rows from each replica, and returns the likely cause and
+// remediation.
+func ExplainErrorWithAgent(ctx context.Context, occurrences CHErrors) (string, error) {
+	logrus.WithFields(logrus.Fields{
+		"error":    occurrences[0].Name,
+		"replicas": len(occurrences),
+	}).Info("Starting Gemini error explanation")
+
+	systemPrompt := `You are a ClickHouse database administrator explaining a single server error to an operator.
+You have access to query any ClickHouse system table to gather more context about the error.
+Useful system tables include:
+- system.processes: Currently running queries
+- system.merges and system.mutations: Background operations in progress
+- system.replicas and system.replication_queue: Replication status
+- system.query_log: Recent queries, including failed ones (exception_code, exception)
+- system.metrics and system.asynchronous_metrics: Current resource usage
+- system.parts: Part counts per table
+
+Use the query_clickhouse_system_table function to find what is causing this error right now.
+Explain what the error means, its most likely cause on this cluster, and how to fix it.
+
+IMPORTANT: Keep your response CONCISE and under 2500 characters total, formatted in markdown.`
+
+	prompt := fmt.Sprintf(`Explain the ClickHouse error %s (code %d).
+Below are its system.errors counters from each replica where it occurred since the server started.
+Use the query_clickhouse_system_table function to gather related context, for example:
+- Failed queries in system.query_log with exception_code = %d
+- Running queries in system.processes if it is a timeout or memory error
+- Merges, mutations or replication status if it is a table operation or replication error
+
+Occurrences from system.errors:
+%s
 
-	apiKey := viper.GetString("gemini_key")
+Reply with:
+1. What the error means
+2. The most likely cause here, with the evidence you found
+3. Concrete remediation steps`, occurrences[0].Name, occurrences[0].Code, occurrences[0].Code,
+		activePromptGuard().Guard("system.errors", occurrences.String()))
 
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  apiKey,
-		Backend: genai.BackendGeminiAPI,
-	})
-	if err != nil {
-		logrus.WithError(err).Fatal("Error creating Gemini client")
-	}
+	systemPrompt = renderSystemPrompt("errors", withToolSchemas(systemPrompt, "query_clickhouse_system_table"), "query_clickhouse_system_table")
+	return runCachedGeminiAgent(ctx, geminiModel("errors"), systemPrompt, prompt)
+}
 
-	conn, err := connect()
-	if err != nil {
-		logrus.WithError(err).Fatal("Error connecting to ClickHouse for analysis")
-	}
-	defer func() {
-		if err := conn.Close(); err != nil {
-			logrus.WithError(err).Warn("Error closing ClickHouse connection")
-		}
-	}()
+// AnalyzeQueryPerformanceWithAgent asks Gemini to find recent expensive queries
+// and optimization opportunities, and returns a Slack-formatted summary.
+func AnalyzeQueryPerformanceWithAgent(ctx context.Context) (string, error) {
+	logrus.Info("Starting Gemini query performance analysis")
 
 	systemPrompt := `You are a ClickHouse database performance analyst specializing in query optimization.
 You have access to query any ClickHouse system table to analyze query performance and identify optimization opportunities.
@@ -374,15 +386,6 @@ IMPORTANT: Keep your response CONCISE and under 2500 characters total.
 Format your final analysis for a Slack channel message using markdown.
 Prioritize the most impactful optimization opportunities.`
 
-	config := &genai.GenerateContentConfig{
-		Temperature:     genai.Ptr(float32(0.7)),
-		MaxOutputTokens: 2000,
-		SystemInstruction: &genai.Content{
-			Parts: []*genai.Part{{Text: systemPrompt}},
-		},
-		Tools: []*genai.Tool{querySystemTableTool},
-	}
-
 	prompt := `Analyze recent query performance and identify optimization opportunities.
 
 STEP 1: First query system.query_log for expensive queries with specific columns:
@@ -428,17 +431,103 @@ Provide a CONCISE analysis (under 2500 characters) with:
 
 Focus on actionable insights that will provide the biggest performance gains.`
 
-	logrus.Debug("Creating Gemini chat for performance analysis")
-	chat, err := client.Chats.Create(ctx, "gemini-2.5-flash", config, nil)
+	systemPrompt = renderSystemPrompt("performance", withToolSchemas(systemPrompt, "query_clickhouse_system_table"), "query_clickhouse_system_table")
+	summary, _, err := runGeminiAgent(ctx, geminiModel("performance"), systemPrompt, prompt)
+	return summary, err
+}
+
+// connectAnalysis opens the ClickHouse connection used by the Gemini analysis
+// agents. It uses analysis.clickhouse.* config so the agents can run as a
+// separate, read-only user, falling back to the clickhouse.* connection.
+func connectAnalysis() (driver.Conn, error) {
+	if viper.GetString("analysis.clickhouse.user") == "" {
+		logrus.Debug("analysis.clickhouse.user not set; analysis uses the default clickhouse connection")
+	}
+	return openClickHouse(chConnConfigFrom("analysis.clickhouse"), "housekeeper-analysis")
+}
+
+// geminiModel returns the model configured for an analysis task
+// (gemini.<task>_model), falling back to gemini.model.
+func geminiModel(task string) string {
+	if m := viper.GetString("gemini." + task + "_model"); m != "" {
+		return m
+	}
+	return viper.GetString("gemini.model")
+}
+
+// runGeminiAgent drives a Gemini chat that may call query_clickhouse_system_table
+// for up to 5 rounds within gemini.max_seconds, then returns the model's final
+// text, and whether it is a partial summary after the budget ran out. Tool
+// failures are reported back to the model; client, connection and transport
+// failures are returned.
+func runGeminiAgent(ctx context.Context, model, systemPrompt, prompt string) (summary string, partial bool, err error) {
+	systemPrompt = withGuardNotice(activePromptGuard(), systemPrompt)
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:  viper.GetString("gemini_key"),
+		Backend: genai.BackendGeminiAPI,
+	})
 	if err != nil {
-		logrus.WithError(err).Fatal("Error creating Gemini chat")
+		return "", false, fmt.Errorf("creating Gemini client: %w", err)
 	}
 
-	logrus.Debug("Sending performance analysis prompt to Gemini")
-	resp, err := chat.SendMessage(ctx, genai.Part{Text: prompt})
+	conn, err := connectAnalysis()
 	if err != nil {
-		logrus.WithError(err).Fatal("Error sending message to Gemini")
+		return "", false, fmt.Errorf("connecting to ClickHouse for analysis: %w", err)
 	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing ClickHouse connection")
+		}
+	}()
+
+	config := &genai.GenerateContentConfig{
+		Temperature:     genai.Ptr(float32(0.7)),
+		MaxOutputTokens: 2000,
+		SystemInstruction: &genai.Content{
+			Parts: []*genai.Part{{Text: systemPrompt}},
+		},
+		Tools: []*genai.Tool{querySystemTableTool},
+	}
+
+	logrus.WithField("model", model).Debug("Creating Gemini chat")
+	chat, err := client.Chats.Create(ctx, model, config, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("creating Gemini chat: %w", err)
+	}
+
+	return runGeminiLoop(ctx, chat, conn, prompt, time.Duration(viper.GetInt("gemini.max_seconds"))*time.Second)
+}
+
+// geminiChat is the part of *genai.Chat the agent loop uses.
+type geminiChat interface {
+	SendMessage(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error)
+}
+
+// geminiSummaryTimeout bounds the extra turn that asks for a summary once the
+// gemini.max_seconds budget is spent.
+const geminiSummaryTimeout = 30 * time.Second
+
+const geminiBudgetNudge = "Time budget reached. Do not call any more functions; give your final analysis of the findings so far now."
+
+// runGeminiLoop sends prompt and answers the model's system table calls for up
+// to 5 rounds. Chat turns and queries share a budget (0 = none); when it runs
+// out mid-investigation the model is asked once more, without the budget, to
+// summarize what it has found, and partial is set. Cancelling ctx stops the
+// loop with ctx's error.
+func runGeminiLoop(ctx context.Context, chat geminiChat, conn driver.Conn, prompt string, budget time.Duration) (summary string, partial bool, err error) {
+	runCtx := ctx
+	if budget > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+
+	logrus.Debug("Sending initial message to Gemini")
+	resp, err := chat.SendMessage(runCtx, genai.Part{Text: prompt})
+	if err != nil {
+		return "", false, fmt.Errorf("sending message to Gemini: %w", err)
+	}
+	recordGeminiUsage(resp)
 
 	maxIterations := 5
 	for i := range maxIterations {
@@ -452,54 +541,177 @@ Focus on actionable insights that will provide the biggest performance gains.`
 			"function_count": len(functionCalls),
 		}).Debug("Processing Gemini function calls")
 
-		var funcResponses []genai.Part
-		for _, call := range functionCalls {
-			if call.Name == "query_clickhouse_system_table" {
-				var args QuerySystemTableArgs
-				if argsJSON, err := json.Marshal(call.Args); err == nil {
-					if err := json.Unmarshal(argsJSON, &args); err == nil {
-						results, err := QuerySystemTable(ctx, conn, args)
-						if err != nil {
-							logrus.WithFields(logrus.Fields{
-								"table":   args.Table,
-								"columns": args.Columns,
-								"where":   args.Where,
-								"error":   err,
-							}).Error("QuerySystemTable failed")
-							funcResponses = append(funcResponses, genai.Part{
-								FunctionResponse: &genai.FunctionResponse{
-									Name: call.Name,
-									Response: map[string]interface{}{
-										"error": err.Error(),
-									},
-								},
-							})
-						} else {
-							funcResponses = append(funcResponses, genai.Part{
-								FunctionResponse: &genai.FunctionResponse{
-									Name: call.Name,
-									Response: map[string]interface{}{
-										"results": results,
-										"count":   len(results),
-									},
-								},
-							})
-						}
-					}
-				}
-			}
-		}
+		funcResponses := handleSystemTableCalls(runCtx, conn, functionCalls)
 
 		if len(funcResponses) > 0 {
+			if runCtx.Err() != nil {
+				return summarizeAfterBudget(ctx, chat, funcResponses)
+			}
 			logrus.WithField("response_count", len(funcResponses)).Debug("Sending function responses to Gemini")
-			resp, err = chat.SendMessage(ctx, funcResponses...)
+			resp, err = chat.SendMessage(runCtx, funcResponses...)
 			if err != nil {
-				logrus.WithError(err).Fatal("Error processing function responses")
+				if runCtx.Err() != nil {
+					return summarizeAfterBudget(ctx, chat, funcResponses)
+				}
+				return "", false, fmt.Errorf("sending function responses to Gemini: %w", err)
 			}
+			recordGeminiUsage(resp)
 		}
 	}
 
-	result := resp.Text()
+	if len(resp.FunctionCalls()) > 0 {
+		return "", false, fmt.Errorf("%w: it was still querying system tables after %d rounds", errNoAnalysis, maxIterations)
+	}
+	result, err := geminiResponseText(resp)
+	if err != nil {
+		return "", false, err
+	}
 	logrus.WithField("response_length", len(result)).Debug("Gemini analysis complete")
-	return result
+	return result, false, nil
+}
+
+// errNoAnalysis is wrapped by errors for agent runs that ended without a
+// usable answer: blocked, filtered or empty model responses.
+var errNoAnalysis = errors.New("the model did not produce an answer")
+
+// summarizeAfterBudget delivers the function responses the model is waiting
+// for together with a request to stop and summarize, and returns that summary
+// marked as partial. It returns ctx's error instead if ctx itself is done.
+func summarizeAfterBudget(ctx context.Context, chat geminiChat, pending []genai.Part) (summary string, partial bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, fmt.Errorf("gemini analysis stopped: %w", err)
+	}
+	logrus.Info("Gemini analysis hit gemini.max_seconds; asking for a summary of findings so far")
+	summaryCtx, cancel := context.WithTimeout(ctx, geminiSummaryTimeout)
+	defer cancel()
+	parts := append(append([]genai.Part(nil), pending...), genai.Part{Text: geminiBudgetNudge})
+	resp, err := chat.SendMessage(summaryCtx, parts...)
+	if err != nil {
+		return "", false, fmt.Errorf("asking Gemini to summarize after the time budget: %w", err)
+	}
+	recordGeminiUsage(resp)
+	text, err := geminiResponseText(resp)
+	if err != nil {
+		return "", false, err
+	}
+	return text + "\n\n(note: time budget reached; summary reflects findings so far)", true, nil
+}
+
+// geminiResponseText returns the text of resp's first candidate, or an error
+// explaining why there is none: the prompt was blocked, the answer was stopped
+// by a safety or policy filter, or the model returned no text.
+func geminiResponseText(resp *genai.GenerateContentResponse) (string, error) {
+	if resp == nil || len(resp.Candidates) == 0 || resp.Candidates[0] == nil {
+		if resp != nil && resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+			return "", fmt.Errorf("%w: Gemini blocked the request (%s) %s", errNoAnalysis,
+				resp.PromptFeedback.BlockReason, resp.PromptFeedback.BlockReasonMessage)
+		}
+		return "", fmt.Errorf("%w: Gemini returned no candidates", errNoAnalysis)
+	}
+
+	reason := resp.Candidates[0].FinishReason
+	switch reason {
+	case genai.FinishReasonSafety, genai.FinishReasonRecitation, genai.FinishReasonBlocklist,
+		genai.FinishReasonProhibitedContent, genai.FinishReasonSPII, genai.FinishReasonLanguage:
+		return "", fmt.Errorf("%w: Gemini stopped the response (%s)", errNoAnalysis, reason)
+	case genai.FinishReasonMalformedFunctionCall, genai.FinishReasonUnexpectedToolCall:
+		return "", fmt.Errorf("%w: Gemini made an invalid function call (%s)", errNoAnalysis, reason)
+	}
+
+	text := strings.TrimSpace(resp.Text())
+	if text == "" {
+		return "", fmt.Errorf("%w: Gemini returned an empty response (finish reason %s)", errNoAnalysis, reason)
+	}
+	if reason == genai.FinishReasonMaxTokens {
+		logrus.Warn("Gemini response hit the output token limit and may be cut off")
+	}
+	return text, nil
+}
+
+// recordGeminiUsage adds one Gemini response to the usage summary.
+func recordGeminiUsage(resp *genai.GenerateContentResponse) {
+	var in, out int64
+	if resp.UsageMetadata != nil {
+		in = int64(resp.UsageMetadata.PromptTokenCount)
+		out = int64(resp.UsageMetadata.CandidatesTokenCount)
+	}
+	usage.recordLLM(in, out)
+}
+
+// handleSystemTableCalls runs the query_clickhouse_system_table calls from one
+// model turn concurrently, at most agent.max_concurrent_queries at a time, and
+// returns their responses in the order the calls were made. Calls to other
+// functions are ignored.
+func handleSystemTableCalls(ctx context.Context, conn driver.Conn, calls []*genai.FunctionCall) []genai.Part {
+	var tableCalls []*genai.FunctionCall
+	for _, call := range calls {
+		if call.Name == "query_clickhouse_system_table" {
+			tableCalls = append(tableCalls, call)
+		}
+	}
+
+	limit := viper.GetInt("agent.max_concurrent_queries")
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	responses := make([]genai.Part, len(tableCalls))
+	var wg sync.WaitGroup
+	for i, call := range tableCalls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			responses[i] = handleSystemTableCall(ctx, conn, call)
+		}()
+	}
+	wg.Wait()
+	return responses
+}
+
+// handleSystemTableCall runs one query_clickhouse_system_table call and wraps
+// the rows (or the error) as a function response for the model.
+func handleSystemTableCall(ctx context.Context, conn driver.Conn, call *genai.FunctionCall) genai.Part {
+	respond := func(response map[string]interface{}) genai.Part {
+		return genai.Part{
+			FunctionResponse: &genai.FunctionResponse{
+				Name:     call.Name,
+				Response: response,
+			},
+		}
+	}
+
+	if err := checkToolArgs(agentToolSchema(call.Name), call.Args); err != nil {
+		return respond(map[string]interface{}{"error": err.Error()})
+	}
+	var args QuerySystemTableArgs
+	argsJSON, err := json.Marshal(call.Args)
+	if err == nil {
+		err = json.Unmarshal(argsJSON, &args)
+	}
+	if err != nil {
+		return respond(map[string]interface{}{"error": fmt.Sprintf("invalid arguments: %v", err)})
+	}
+
+	results, err := QuerySystemTable(ctx, conn, args)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"table":   args.Table,
+			"columns": args.Columns,
+			"where":   args.Where,
+			"error":   err,
+		}).Error("QuerySystemTable failed")
+		return respond(map[string]interface{}{"error": err.Error()})
+	}
+	// Rows are passed as guarded JSON text rather than structured values so
+	// that the data is delimited from anything the model should act on.
+	resultsJSON, err := json.Marshal(results)
+	if err != nil {
+		return respond(map[string]interface{}{"error": fmt.Sprintf("encoding results: %v", err)})
+	}
+	return respond(map[string]interface{}{
+		"results": activePromptGuard().Guard(args.Table, string(resultsJSON)),
+		"count":   len(results),
+	})
 }
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"google.golang.org/genai"
+)
+
+func TestCheckAgentTable(t *testing.T) {
+	viper.Set("agent.allowed_tables", []string{"system.query_log", "system.parts", "system.users"})
+	viper.Set("agent.denied_tables", []string{"system.users", "system.grants"})
+	defer viper.Set("agent.allowed_tables", nil)
+	defer viper.Set("agent.denied_tables", nil)
+
+	tests := []struct {
+		name    string
+		table   string
+		wantErr bool
+	}{
+		{name: "allowed table", table: "system.query_log", wantErr: false},
+		{name: "allowed table, different case", table: "SYSTEM.Parts", wantErr: false},
+		{name: "allowed table with whitespace", table: " system.parts ", wantErr: false},
+		{name: "not in allowlist", table: "system.zookeeper", wantErr: true},
+		{name: "denied wins over allowed", table: "system.users", wantErr: true},
+		{name: "denied table", table: "system.grants", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkAgentTable(tt.table)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkAgentTable(%q) error = %v, wantErr %v", tt.table, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckAgentTableEmptyAllowlist(t *testing.T) {
+	viper.Set("agent.allowed_tables", []string{})
+	viper.Set("agent.denied_tables", []string{"system.users"})
+	defer viper.Set("agent.allowed_tables", nil)
+	defer viper.Set("agent.denied_tables", nil)
+
+	if err := checkAgentTable("system.asynchronous_metrics"); err != nil {
+		t.Errorf("checkAgentTable() with empty allowlist error = %v, want nil", err)
+	}
+	if err := checkAgentTable("system.users"); err == nil {
+		t.Error("checkAgentTable() with empty allowlist should still reject denied tables")
+	}
+}
+
+func TestQuerySystemTableRejectsDeniedTable(t *testing.T) {
+	viper.Set("agent.denied_tables", []string{"system.users"})
+	defer viper.Set("agent.denied_tables", nil)
+
+	// The mock would panic on a nil Rows if the query were actually issued.
+	mockConn := &MockConn{}
+	_, err := QuerySystemTable(context.Background(), mockConn, QuerySystemTableArgs{Table: "system.users"})
+	if err == nil {
+		t.Fatal("QuerySystemTable() expected error for denied table, got nil")
+	}
+	if !contains(err.Error(), "not available to the analysis agent") {
+		t.Errorf("QuerySystemTable() error = %v, want denial message", err)
+	}
+}
+
+func TestCheckAgentClauses(t *testing.T) {
+	viper.Set("agent.allowed_tables", []string{"system.query_log", "system.parts"})
+	viper.Set("agent.denied_tables", []string{"system.users", "system.grants"})
+	defer viper.Set("agent.allowed_tables", nil)
+	defer viper.Set("agent.denied_tables", nil)
+
+	tests := []struct {
+		name    string
+		args    QuerySystemTableArgs
+		wantErr bool
+	}{
+		{name: "plain clauses", args: QuerySystemTableArgs{Columns: []string{"query_id", "ProfileEvents.Names"}, Where: "type = 'QueryFinish' AND user IN ('app', 'etl')", OrderBy: "event_time DESC"}},
+		{name: "subquery in where", args: QuerySystemTableArgs{Where: "1 IN (SELECT name FROM system.users)"}, wantErr: true},
+		{name: "scalar subquery in columns", args: QuerySystemTableArgs{Columns: []string{"(select count() from system.grants) AS n"}}, wantErr: true},
+		{name: "subquery in order_by", args: QuerySystemTableArgs{OrderBy: "(SELECT 1 FROM system.zookeeper WHERE path = '/')"}, wantErr: true},
+		{name: "IN a denied table", args: QuerySystemTableArgs{Where: "user IN system.users"}, wantErr: true},
+		{name: "IN a table outside the allowlist", args: QuerySystemTableArgs{Where: "database IN `system`.`databases`"}, wantErr: true},
+		{name: "IN an allowed table", args: QuerySystemTableArgs{Where: "table IN system.parts"}},
+		{name: "keywords in literals", args: QuerySystemTableArgs{Where: "query LIKE '%SELECT name FROM system.users%'"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkAgentClauses(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkAgentClauses() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleSystemTableCallsKeepsOrder(t *testing.T) {
+	viper.Set("agent.allowed_tables", []string{})
+	viper.Set("agent.denied_tables", []string{"system.t0", "system.t1", "system.t2", "system.t3", "system.t4"})
+	viper.Set("agent.max_concurrent_queries", 2)
+	defer viper.Set("agent.allowed_tables", nil)
+	defer viper.Set("agent.denied_tables", nil)
+	defer viper.Set("agent.max_concurrent_queries", nil)
+
+	tables := []string{"system.t0", "system.t1", "system.t2", "system.t3", "system.t4"}
+	var calls []*genai.FunctionCall
+	for i, table := range tables {
+		calls = append(calls, &genai.FunctionCall{Name: "query_clickhouse_system_table", Args: map[string]any{"table": table}})
+		if i == 2 {
+			// Calls to other functions are dropped.
+			calls = append(calls, &genai.FunctionCall{Name: "something_else"})
+		}
+	}
+
+	responses := handleSystemTableCalls(context.Background(), &MockConn{}, calls)
+	if len(responses) != len(tables) {
+		t.Fatalf("handleSystemTableCalls() returned %d responses, want %d", len(responses), len(tables))
+	}
+	for i, table := range tables {
+		msg, _ := responses[i].FunctionResponse.Response["error"].(string)
+		if !strings.Contains(msg, table) {
+			t.Errorf("response %d = %q, want error for %s", i, msg, table)
+		}
+	}
+}
+
+func TestGeminiModel(t *testing.T) {
+	viper.Set("gemini.model", "shared-model")
+	viper.Set("gemini.errors_model", "errors-model")
+	viper.Set("gemini.performance_model", "")
+	defer viper.Set("gemini.model", nil)
+	defer viper.Set("gemini.errors_model", nil)
+	defer viper.Set("gemini.performance_model", nil)
+
+	if got := geminiModel("errors"); got != "errors-model" {
+		t.Errorf("geminiModel(errors) = %q, want errors-model", got)
+	}
+	if got := geminiModel("performance"); got != "shared-model" {
+		t.Errorf("geminiModel(performance) = %q, want shared-model", got)
+	}
+}
+
+func TestGeminiResponseText(t *testing.T) {
+	textCandidate := func(reason genai.FinishReason, text string) *genai.GenerateContentResponse {
+		return &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{
+			FinishReason: reason,
+			Content:      &genai.Content{Parts: []*genai.Part{{Text: text}}},
+		}}}
+	}
+
+	tests := []struct {
+		name    string
+		resp    *genai.GenerateContentResponse
+		want    string
+		wantErr string
+	}{
+		{name: "text", resp: textCandidate(genai.FinishReasonStop, " summary \n"), want: "summary"},
+		{name: "truncated text is kept", resp: textCandidate(genai.FinishReasonMaxTokens, "partial"), want: "partial"},
+		{name: "nil response", resp: nil, wantErr: "no candidates"},
+		{
+			name: "blocked prompt",
+			resp: &genai.GenerateContentResponse{PromptFeedback: &genai.GenerateContentResponsePromptFeedback{
+				BlockReason: genai.BlockedReasonSafety,
+			}},
+			wantErr: "blocked the request (SAFETY)",
+		},
+		{name: "safety stop", resp: textCandidate(genai.FinishReasonSafety, ""), wantErr: "stopped the response (SAFETY)"},
+		{name: "malformed call", resp: textCandidate(genai.FinishReasonMalformedFunctionCall, ""), wantErr: "invalid function call"},
+		{name: "empty text", resp: textCandidate(genai.FinishReasonStop, "  "), wantErr: "empty response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := geminiResponseText(tt.resp)
+			if tt.wantErr == "" {
+				if err != nil || got != tt.want {
+					t.Errorf("geminiResponseText() = %q, %v, want %q", got, err, tt.want)
+				}
+				return
+			}
+			if err == nil || !errors.Is(err, errNoAnalysis) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("geminiResponseText() error = %v, want errNoAnalysis containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// scriptedChat replays responses in order, failing with ctx's error once ctx
+// is done, and records the parts of each message it was sent.
+type scriptedChat struct {
+	responses []*genai.GenerateContentResponse
+	// onSend, if set, runs after each recorded message (e.g. to cancel ctx or
+	// let a budget expire mid-run).
+	onSend func(call int)
+	sent   [][]genai.Part
+}
+
+func (c *scriptedChat) SendMessage(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.sent = append(c.sent, parts)
+	call := len(c.sent)
+	if c.onSend != nil {
+		c.onSend(call)
+	}
+	if call > len(c.responses) {
+		return nil, errors.New("unexpected message")
+	}
+	return c.responses[call-1], nil
+}
+
+func functionCallResponse() *genai.GenerateContentResponse {
+	return &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{
+		Content: &genai.Content{Parts: []*genai.Part{{FunctionCall: &genai.FunctionCall{
+			Name: "query_clickhouse_system_table",
+			Args: map[string]any{"table": "system.errors"},
+		}}}},
+		FinishReason: genai.FinishReasonStop,
+	}}}
+}
+
+func textResponse(text string) *genai.GenerateContentResponse {
+	return &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{
+		Content:      &genai.Content{Parts: []*genai.Part{{Text: text}}},
+		FinishReason: genai.FinishReasonStop,
+	}}}
+}
+
+func TestRunGeminiLoopCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	chat := &scriptedChat{
+		responses: []*genai.GenerateContentResponse{functionCallResponse(), functionCallResponse(), functionCallResponse()},
+		onSend:    func(int) { cancel() },
+	}
+	conn := &MockConn{queryError: errors.New("query failed")}
+
+	_, _, err := runGeminiLoop(ctx, chat, conn, "analyze", time.Minute)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("runGeminiLoop() error = %v, want context.Canceled", err)
+	}
+	if len(chat.sent) != 1 {
+		t.Errorf("chat received %d messages after cancellation, want 1", len(chat.sent))
+	}
+}
+
+func TestRunGeminiLoopAnswer(t *testing.T) {
+	chat := &scriptedChat{responses: []*genai.GenerateContentResponse{textResponse("all good")}}
+
+	got, partial, err := runGeminiLoop(context.Background(), chat, &MockConn{}, "analyze", time.Minute)
+	if err != nil || got != "all good" || partial {
+		t.Errorf("runGeminiLoop() = %q, %v, %v, want the full answer", got, partial, err)
+	}
+}
+
+func TestRunGeminiLoopBudget(t *testing.T) {
+	budget := 20 * time.Millisecond
+	chat := &scriptedChat{
+		responses: []*genai.GenerateContentResponse{functionCallResponse(), textResponse("partial findings")},
+		onSend: func(call int) {
+			if call == 1 {
+				time.Sleep(2 * budget)
+			}
+		},
+	}
+	conn := &MockConn{queryError: errors.New("query failed")}
+
+	got, partial, err := runGeminiLoop(context.Background(), chat, conn, "analyze", budget)
+	if err != nil {
+		t.Fatalf("runGeminiLoop() error = %v", err)
+	}
+	if !strings.HasPrefix(got, "partial findings") || !strings.Contains(got, "time budget reached") || !partial {
+		t.Errorf("runGeminiLoop() = %q, partial %v, want partial summary with a note", got, partial)
+	}
+	if len(chat.sent) != 2 {
+		t.Fatalf("chat received %d messages, want 2", len(chat.sent))
+	}
+	last := chat.sent[1]
+	if last[0].FunctionResponse == nil || last[len(last)-1].Text != geminiBudgetNudge {
+		t.Errorf("summary request = %+v, want the pending function response and the budget nudge", last)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// analysisArgs is the (empty) input to the analyze_* tools.
+type analysisArgs struct{}
+
+// analysisResult is the structured output of the analyze_* tools.
+type analysisResult struct {
+	Summary string `json:"summary" jsonschema:"Markdown summary written by the analysis agent"`
+}
+
+// explainErrorArgs is the input to the explain_error tool.
+type explainErrorArgs struct {
+	Name string `json:"name,omitempty" jsonschema:"Error name from system.errors, e.g. MEMORY_LIMIT_EXCEEDED"`
+	Code int32  `json:"code,omitempty" jsonschema:"Error code from system.errors, e.g. 241; used when name is empty"`
+}
+
+var errorNameRe = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// parseExplainErrorArgs normalizes the error name to upper case and checks that
+// a name or a positive code was given.
+func parseExplainErrorArgs(a explainErrorArgs) (string, int32, error) {
+	name := strings.ToUpper(strings.TrimSpace(a.Name))
+	if name == "" && a.Code <= 0 {
+		return "", 0, fmt.Errorf("name or code is required")
+	}
+	if name != "" && !errorNameRe.MatchString(name) {
+		return "", 0, fmt.Errorf("invalid error name %q", a.Name)
+	}
+	return name, a.Code, nil
+}
+
+// matchCHErrors returns the rows of errs for the given error name, or for code
+// when name is empty.
+func matchCHErrors(errs []CHError, name string, code int32) CHErrors {
+	var out CHErrors
+	for _, e := range errs {
+		if (name != "" && e.Name == name) || (name == "" && e.Code == code) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// analysisToolsEnabled reports whether the Gemini-backed analyze_* tools should
+// be exposed. They send ClickHouse data to an external LLM, so they are off
+// unless mcp.analysis_tools is set and a gemini_key is configured.
+func analysisToolsEnabled() bool {
+	if !viper.GetBool("mcp.analysis_tools") {
+		return false
+	}
+	if viper.GetString("gemini_key") == "" {
+		logrus.Warn("mcp.analysis_tools is enabled but gemini_key is not set; analyze tools disabled. Set gemini_key or HOUSEKEEPER_GEMINI_KEY to enable them")
+		return false
+	}
+	return true
+}
+
+// registerAnalysisTools adds analyze_errors and analyze_performance, which run
+// the same Gemini agents as analyze mode and return their summary, and
+// explain_error, which investigates a single error.
+func registerAnalysisTools(srv *mcp.Server) {
+	addTool[analysisArgs, *analysisResult](
+		srv,
+		&mcp.Tool{
+			Name:        "analyze_errors",
+			Title:       "Analyze recent ClickHouse errors (Gemini)",
+			Description: `Investigate ClickHouse errors from the last hour across the cluster. Runs a Gemini agent that queries system tables for context and returns a concise summary with recommendations. Sends error details to an external LLM.`,
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		},
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[analysisArgs]) (*mcp.CallToolResultFor[*analysisResult], error) {
+			chErrors, err := CHErrorAnalysis(ctx)
+			if err != nil {
+				return nil, err
+			}
+			conditions := detectCriticalConditions(ctx, chErrors)
+			if len(chErrors) == 0 {
+				if len(conditions) > 0 {
+					return analysisToolResult(formatCriticalConditions(conditions)), nil
+				}
+				return analysisToolResult("No errors found in the last hour."), nil
+			}
+			summary, err := AnalyzeErrorsWithAgent(ctx, chErrors, conditions)
+			if err != nil {
+				return nil, err
+			}
+			return analysisToolResult(summary), nil
+		},
+	)
+
+	addTool[analysisArgs, *analysisResult](
+		srv,
+		&mcp.Tool{
+			Name:        "analyze_performance",
+			Title:       "Analyze ClickHouse query performance (Gemini)",
+			Description: `Investigate recent query performance across the cluster. Runs a Gemini agent that queries system tables (query_log, processes, merges, ...) and returns a concise summary with optimization recommendations. Sends query statistics to an external LLM.`,
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		},
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[analysisArgs]) (*mcp.CallToolResultFor[*analysisResult], error) {
+			summary, err := AnalyzeQueryPerformanceWithAgent(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return analysisToolResult(summary), nil
+		},
+	)
+
+	addTool[explainErrorArgs, *analysisResult](
+		srv,
+		&mcp.Tool{
+			Name:        "explain_error",
+			Title:       "Explain a ClickHouse error (Gemini)",
+			Description: `Explain one ClickHouse error, given its system.errors name or code. Collects its occurrences across the cluster and runs a Gemini agent that checks related processes, merges and logs, then returns the likely cause and remediation. Sends error details to an external LLM.`,
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		},
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[explainErrorArgs]) (*mcp.CallToolResultFor[*analysisResult], error) {
+			name, code, err := parseExplainErrorArgs(req.Arguments)
+			if err != nil {
+				return nil, err
+			}
+			conn, err := connectAnalysis()
+			if err != nil {
+				return nil, fmt.Errorf("connecting to ClickHouse for analysis: %w", err)
+			}
+			current, err := pollCHErrors(ctx, conn)
+			if cerr := conn.Close(); cerr != nil {
+				logrus.WithError(cerr).Warn("Error closing ClickHouse connection")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading system.errors: %w", err)
+			}
+			matched := matchCHErrors(current, name, code)
+			if len(matched) == 0 {
+				return analysisToolResult("No occurrences of this error in system.errors since the servers last started."), nil
+			}
+			summary, err := ExplainErrorWithAgent(ctx, matched)
+			if err != nil {
+				return nil, err
+			}
+			return analysisToolResult(summary), nil
+		},
+	)
+}
+
+func analysisToolResult(summary string) *mcp.CallToolResultFor[*analysisResult] {
+	return &mcp.CallToolResultFor[*analysisResult]{
+		Content:           []mcp.Content{&mcp.TextContent{Text: summary}},
+		StructuredContent: &analysisResult{Summary: summary},
+	}
+}
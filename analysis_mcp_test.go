@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestAnalysisToolsEnabled(t *testing.T) {
+	defer viper.Set("mcp.analysis_tools", nil)
+	defer viper.Set("gemini_key", nil)
+
+	tests := []struct {
+		name      string
+		enabled   bool
+		geminiKey string
+		want      bool
+	}{
+		{name: "disabled by default", want: false},
+		{name: "enabled without key", enabled: true, want: false},
+		{name: "key without flag", geminiKey: "k", want: false},
+		{name: "enabled with key", enabled: true, geminiKey: "k", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("mcp.analysis_tools", tt.enabled)
+			viper.Set("gemini_key", tt.geminiKey)
+			if got := analysisToolsEnabled(); got != tt.want {
+				t.Errorf("analysisToolsEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExplainErrorArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     explainErrorArgs
+		wantName string
+		wantCode int32
+		wantErr  bool
+	}{
+		{name: "empty", args: explainErrorArgs{}, wantErr: true},
+		{name: "name", args: explainErrorArgs{Name: "MEMORY_LIMIT_EXCEEDED"}, wantName: "MEMORY_LIMIT_EXCEEDED"},
+		{name: "lower case name", args: explainErrorArgs{Name: " timeout_exceeded "}, wantName: "TIMEOUT_EXCEEDED"},
+		{name: "code", args: explainErrorArgs{Code: 241}, wantCode: 241},
+		{name: "negative code", args: explainErrorArgs{Code: -1}, wantErr: true},
+		{name: "injection", args: explainErrorArgs{Name: "X' OR 1=1"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, code, err := parseExplainErrorArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExplainErrorArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if name != tt.wantName || code != tt.wantCode {
+				t.Errorf("parseExplainErrorArgs() = %q, %d, want %q, %d", name, code, tt.wantName, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestMatchCHErrors(t *testing.T) {
+	errs := []CHError{
+		{Hostname: "a", Name: "MEMORY_LIMIT_EXCEEDED", Code: 241},
+		{Hostname: "b", Name: "MEMORY_LIMIT_EXCEEDED", Code: 241},
+		{Hostname: "a", Name: "TIMEOUT_EXCEEDED", Code: 159},
+	}
+
+	if got := matchCHErrors(errs, "MEMORY_LIMIT_EXCEEDED", 0); len(got) != 2 {
+		t.Errorf("match by name = %d rows, want 2", len(got))
+	}
+	if got := matchCHErrors(errs, "", 159); len(got) != 1 || got[0].Name != "TIMEOUT_EXCEEDED" {
+		t.Errorf("match by code = %v, want TIMEOUT_EXCEEDED", got)
+	}
+	if got := matchCHErrors(errs, "NO_SUCH_ERROR", 241); len(got) != 0 {
+		t.Errorf("name takes precedence over code, got %d rows", len(got))
+	}
+}
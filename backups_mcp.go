@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// backupsArgs is the input to the clickhouse_backups tool.
+type backupsArgs struct {
+	Cluster    string `json:"cluster,omitempty" jsonschema:"cluster to read backups across; defaults to the configured cluster"`
+	Since      string `json:"since,omitempty" jsonschema:"only operations started within this Go duration (e.g. 48h); default 168h (7 days), maximum 2160h"`
+	FailedOnly bool   `json:"failed_only,omitempty" jsonschema:"only return failed backups and restores"`
+	Limit      int    `json:"limit,omitempty" jsonschema:"maximum number of operations to return, most recent first; default 50, maximum 500"`
+}
+
+// backupOperation is one row of system.backups on one replica.
+type backupOperation struct {
+	Host             string    `json:"host"`
+	ID               string    `json:"id"`
+	Name             string    `json:"name" jsonschema:"backup destination, e.g. Disk('backups', 'daily.zip') or S3(...)"`
+	Status           string    `json:"status" jsonschema:"e.g. CREATING_BACKUP, BACKUP_CREATED, BACKUP_FAILED, RESTORING, RESTORED, RESTORE_FAILED"`
+	Error            string    `json:"error,omitempty"`
+	StartTime        time.Time `json:"start_time"`
+	EndTime          time.Time `json:"end_time,omitempty"`
+	NumFiles         uint64    `json:"num_files"`
+	UncompressedSize uint64    `json:"uncompressed_size" jsonschema:"bytes"`
+	CompressedSize   uint64    `json:"compressed_size" jsonschema:"bytes"`
+	Failed           bool      `json:"failed"`
+}
+
+// backupsResult is the structured output of clickhouse_backups.
+type backupsResult struct {
+	Operations []backupOperation `json:"operations"`
+	Total      int               `json:"total" jsonschema:"operations read, before failed_only filtering"`
+	Failed     int               `json:"failed"`
+	InProgress int               `json:"in_progress"`
+}
+
+const (
+	defaultBackupsWindow = 7 * 24 * time.Hour
+	maxBackupsWindow     = 90 * 24 * time.Hour
+	defaultBackupsLimit  = 50
+	maxBackupsLimit      = 500
+
+	unknownTableCode = 60 // UNKNOWN_TABLE
+
+	backupsUnavailable = "system.backups does not exist on this server: native BACKUP/RESTORE needs ClickHouse 22.8 or later. Backups made with other tools (e.g. clickhouse-backup) are not recorded there."
+)
+
+// parseBackupsArgs applies defaults and bounds to the window and limit.
+func parseBackupsArgs(a backupsArgs) (time.Duration, int, error) {
+	window := defaultBackupsWindow
+	if strings.TrimSpace(a.Since) != "" {
+		d, err := time.ParseDuration(a.Since)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid since: %v", err)
+		}
+		window = d
+	}
+	if window <= 0 || window > maxBackupsWindow {
+		return 0, 0, fmt.Errorf("since must be positive and at most %s", maxBackupsWindow)
+	}
+
+	limit := a.Limit
+	if limit == 0 {
+		limit = defaultBackupsLimit
+	}
+	if limit < 1 || limit > maxBackupsLimit {
+		return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxBackupsLimit)
+	}
+	return window, limit, nil
+}
+
+// backupFailed reports whether status is a failed backup or restore.
+func backupFailed(status string) bool {
+	return strings.HasSuffix(status, "_FAILED")
+}
+
+// backupInProgress reports whether status is a backup or restore still
+// running.
+func backupInProgress(status string) bool {
+	return status == "CREATING_BACKUP" || status == "RESTORING"
+}
+
+// buildBackupsQuery returns the system.backups query on every replica of
+// cluster, most recent first, and its bind arguments.
+func buildBackupsQuery(cluster string, window time.Duration, failedOnly bool, limit int) (string, []interface{}) {
+	query := "SELECT hostName() AS host, id, name, toString(status) AS status, error, start_time, end_time," +
+		" num_files, uncompressed_size, compressed_size" +
+		" FROM clusterAllReplicas(?, system.backups)" +
+		" WHERE start_time >= now() - toIntervalSecond(?)"
+	args := []interface{}{cluster, int64(window / time.Second)}
+	if failedOnly {
+		query += " AND status IN ('BACKUP_FAILED', 'RESTORE_FAILED')"
+	}
+	query += " ORDER BY start_time DESC LIMIT ?"
+	args = append(args, limit)
+	return query, args
+}
+
+func registerBackupsTool(srv *mcp.Server) {
+	addTool[backupsArgs, *backupsResult](
+		srv,
+		&mcp.Tool{
+			Name:        "clickhouse_backups",
+			Title:       "ClickHouse backup status",
+			Description: `List recent native BACKUP and RESTORE operations on every replica (system.backups), most recent first, with status, size, file count and error. Failed operations are flagged. Use for "are our backups succeeding?". system.backups only holds operations since each server last started, so an empty result after a restart does not mean no backups ran. Filter with since and failed_only.`,
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		},
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[backupsArgs]) (*mcp.CallToolResultFor[*backupsResult], error) {
+			a := req.Arguments
+			window, limit, err := parseBackupsArgs(a)
+			if err != nil {
+				return nil, err
+			}
+			cluster := strings.TrimSpace(a.Cluster)
+			if cluster == "" {
+				cluster = viper.GetString("clickhouse.cluster")
+			}
+
+			conn, err := connect()
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				if err := conn.Close(); err != nil {
+					logrus.WithError(err).Warn("Error closing ClickHouse connection")
+				}
+			}()
+
+			res, err := fetchBackups(ctx, conn, cluster, window, a.FailedOnly, limit)
+			if hasErrorCode(err, unknownTableCode) {
+				return nil, fmt.Errorf("%s", backupsUnavailable)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &mcp.CallToolResultFor[*backupsResult]{
+				Content:           []mcp.Content{&mcp.TextContent{Text: summarizeBackups(res)}},
+				StructuredContent: res,
+			}, nil
+		},
+	)
+}
+
+// fetchBackups reads system.backups and counts failed and running operations.
+func fetchBackups(ctx context.Context, conn driver.Conn, cluster string, window time.Duration, failedOnly bool, limit int) (*backupsResult, error) {
+	query, args := buildBackupsQuery(cluster, window, failedOnly, limit)
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
+
+	var ops []backupOperation
+	for rows.Next() {
+		var op backupOperation
+		if err := rows.Scan(&op.Host, &op.ID, &op.Name, &op.Status, &op.Error, &op.StartTime, &op.EndTime,
+			&op.NumFiles, &op.UncompressedSize, &op.CompressedSize); err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return classifyBackups(ops), nil
+}
+
+// classifyBackups flags failed operations and counts failed and running ones.
+func classifyBackups(ops []backupOperation) *backupsResult {
+	res := &backupsResult{Operations: []backupOperation{}, Total: len(ops)}
+	for _, op := range ops {
+		op.Failed = backupFailed(op.Status)
+		if op.Failed {
+			res.Failed++
+		}
+		if backupInProgress(op.Status) {
+			res.InProgress++
+		}
+		res.Operations = append(res.Operations, op)
+	}
+	return res
+}
+
+// summarizeBackups renders the counts and one line per operation, most recent
+// first, with the error of failed ones.
+func summarizeBackups(res *backupsResult) string {
+	if len(res.Operations) == 0 {
+		return "No backup or restore operations found. system.backups only holds operations since each server last started."
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d operation(s): %d failed, %d in progress.", res.Total, res.Failed, res.InProgress)
+	for _, op := range res.Operations {
+		flag := ""
+		if op.Failed {
+			flag = "FAILED "
+		}
+		took := ""
+		if !op.EndTime.IsZero() && op.EndTime.After(op.StartTime) {
+			took = fmt.Sprintf(" in %s", op.EndTime.Sub(op.StartTime).Round(time.Second))
+		}
+		fmt.Fprintf(&b, "\n%s%s on %s: %s, started %s%s, %d files, %s",
+			flag, op.Name, op.Host, op.Status, op.StartTime.UTC().Format(time.RFC3339), took,
+			op.NumFiles, humanBytes(float64(op.CompressedSize)))
+		if op.Error != "" {
+			msg := strings.Join(strings.Fields(op.Error), " ")
+			if len(msg) > 300 {
+				msg = msg[:300] + "..."
+			}
+			b.WriteString(": " + msg)
+		}
+	}
+	return b.String()
+}
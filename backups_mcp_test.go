@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBackupsArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       backupsArgs
+		wantWindow time.Duration
+		wantLimit  int
+		wantErr    string
+	}{
+		{name: "defaults", args: backupsArgs{}, wantWindow: 7 * 24 * time.Hour, wantLimit: 50},
+		{name: "explicit", args: backupsArgs{Since: "48h", Limit: 500}, wantWindow: 48 * time.Hour, wantLimit: 500},
+		{name: "window too long", args: backupsArgs{Since: "2161h"}, wantErr: "since must be"},
+		{name: "invalid window", args: backupsArgs{Since: "a week"}, wantErr: "invalid since"},
+		{name: "limit over max", args: backupsArgs{Limit: 501}, wantErr: "limit must be between 1 and 500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, limit, err := parseBackupsArgs(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseBackupsArgs() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBackupsArgs() error = %v", err)
+			}
+			if window != tt.wantWindow || limit != tt.wantLimit {
+				t.Errorf("parseBackupsArgs() = %s, %d", window, limit)
+			}
+		})
+	}
+}
+
+func TestBuildBackupsQuery(t *testing.T) {
+	query, args := buildBackupsQuery("main", time.Hour, false, 10)
+	if !strings.Contains(query, "FROM clusterAllReplicas(?, system.backups) WHERE start_time >= now() - toIntervalSecond(?) ORDER BY start_time DESC LIMIT ?") {
+		t.Errorf("query = %s", query)
+	}
+	if len(args) != 3 || args[0] != "main" || args[1] != int64(3600) || args[2] != 10 {
+		t.Errorf("args = %v", args)
+	}
+
+	query, _ = buildBackupsQuery("main", time.Hour, true, 10)
+	if !strings.Contains(query, "AND status IN ('BACKUP_FAILED', 'RESTORE_FAILED')") {
+		t.Errorf("failed_only query = %s", query)
+	}
+}
+
+func TestClassifyAndSummarizeBackups(t *testing.T) {
+	start := time.Date(2026, 10, 15, 2, 0, 0, 0, time.UTC)
+	res := classifyBackups([]backupOperation{
+		{Host: "ch1", Name: "S3('s3://b/daily')", Status: "BACKUP_CREATED", StartTime: start, EndTime: start.Add(90 * time.Second), NumFiles: 12, CompressedSize: 3 << 30},
+		{Host: "ch2", Name: "S3('s3://b/daily')", Status: "BACKUP_FAILED", StartTime: start, Error: "Code: 243.\nNot enough space"},
+		{Host: "ch1", Name: "Disk('backups', 'x')", Status: "RESTORING", StartTime: start},
+	})
+	if res.Total != 3 || res.Failed != 1 || res.InProgress != 1 || !res.Operations[1].Failed || res.Operations[0].Failed {
+		t.Fatalf("classifyBackups() = %+v", res)
+	}
+
+	got := summarizeBackups(res)
+	for _, want := range []string{
+		"3 operation(s): 1 failed, 1 in progress.",
+		"S3('s3://b/daily') on ch1: BACKUP_CREATED, started 2026-10-15T02:00:00Z in 1m30s, 12 files, 3.00 GB",
+		"FAILED S3('s3://b/daily') on ch2: BACKUP_FAILED, started 2026-10-15T02:00:00Z, 0 files, 0 B: Code: 243. Not enough space",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("summary missing %q:\n%s", want, got)
+		}
+	}
+
+	if got := summarizeBackups(classifyBackups(nil)); !strings.HasPrefix(got, "No backup or restore operations found.") {
+		t.Errorf("empty summary = %q", got)
+	}
+}
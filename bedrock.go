@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -58,7 +59,9 @@ func runBedrockAgent(
 	temperature float32,
 ) (string, error) {
 	toolCfg := &types.ToolConfiguration{Tools: make([]types.Tool, 0, len(tools))}
+	schemas := make(map[string]map[string]any, len(tools))
 	for _, t := range tools {
+		schemas[t.name] = t.inputSchema
 		toolCfg.Tools = append(toolCfg.Tools, &types.ToolMemberToolSpec{
 			Value: types.ToolSpecification{
 				Name:        aws.String(t.name),
@@ -104,6 +107,9 @@ func runBedrockAgent(
 		if out.Usage != nil {
 			inTok += aws.ToInt32(out.Usage.InputTokens)
 			outTok += aws.ToInt32(out.Usage.OutputTokens)
+			usage.recordLLM(int64(aws.ToInt32(out.Usage.InputTokens)), int64(aws.ToInt32(out.Usage.OutputTokens)))
+		} else {
+			usage.recordLLM(0, 0)
 		}
 
 		msgOut, ok := out.Output.(*types.ConverseOutputMemberMessage)
@@ -130,7 +136,11 @@ func runBedrockAgent(
 					}
 				}
 				logrus.WithFields(logrus.Fields{"tool": name, "iter": i}).Debug("diagnose: tool call")
-				result, herr := handle(name, input)
+				var result string
+				herr := checkToolArgs(schemas[name], input)
+				if herr == nil {
+					result, herr = handle(name, input)
+				}
 				trb := types.ToolResultBlock{ToolUseId: tu.ToolUseId}
 				if herr != nil {
 					trb.Status = types.ToolResultStatusError
@@ -151,6 +161,14 @@ func runBedrockAgent(
 			logrus.WithFields(logrus.Fields{
 				"iterations": i + 1, "input_tokens": inTok, "output_tokens": outTok, "timed_out": timedOut,
 			}).Info("diagnose: complete")
+			if strings.TrimSpace(finalText) == "" {
+				switch out.StopReason {
+				case types.StopReasonContentFiltered, types.StopReasonGuardrailIntervened:
+					return "", fmt.Errorf("%w: Bedrock blocked the response (%s)", errNoAnalysis, out.StopReason)
+				default:
+					return "", fmt.Errorf("%w: Bedrock returned an empty response (stop reason %s)", errNoAnalysis, out.StopReason)
+				}
+			}
 			if timedOut {
 				return finalText + "\n\n(note: time budget reached; summary reflects findings so far)", nil
 			}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -23,12 +24,23 @@ type CHError struct {
 	LastErrorTime    time.Time
 	LastErrorMessage string
 	LastErrorTrace   []uint64
+	LastErrorFrames  []string
 	Remote           bool
 }
 
+// String renders e for the analysis prompt. The trace is included as
+// symbolized frames when present, else as raw addresses when present, and
+// omitted otherwise.
 func (e *CHError) String() string {
-	return fmt.Sprintf("Hostname: %s, Name: %s, Code: %d, Value: %d, LastErrorTime: %s, LastErrorMessage: %s, LastErrorTrace: %v, Remote: %t",
-		e.Hostname, e.Name, e.Code, e.Value, e.LastErrorTime, e.LastErrorMessage, e.LastErrorTrace, e.Remote)
+	s := fmt.Sprintf("Hostname: %s, Name: %s, Code: %d, Value: %d, LastErrorTime: %s, LastErrorMessage: %s",
+		e.Hostname, e.Name, e.Code, e.Value, e.LastErrorTime, e.LastErrorMessage)
+	switch {
+	case len(e.LastErrorFrames) > 0:
+		s += ", LastErrorFrames: " + strings.Join(e.LastErrorFrames, " <- ")
+	case len(e.LastErrorTrace) > 0:
+		s += fmt.Sprintf(", LastErrorTrace: %v", e.LastErrorTrace)
+	}
+	return s + fmt.Sprintf(", Remote: %t", e.Remote)
 }
 
 func (es *CHErrors) String() string {
@@ -39,72 +51,281 @@ func (es *CHErrors) String() string {
 	return strings.Join(errors, "\n")
 }
 
-func CHErrorAnalysis() ([]CHError, error) {
+// CHErrorAnalysis reads the past hour's errors from system.errors on every
+// replica. The query is abandoned when ctx is done.
+func CHErrorAnalysis(ctx context.Context) ([]CHError, error) {
 	logrus.Debug("Connecting to ClickHouse for error analysis")
 	conn, err := connect()
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing ClickHouse connection")
+		}
+	}()
 
-	ctx := context.Background()
 	return getCHErrors(ctx, conn)
 }
 
 func connect() (driver.Conn, error) {
+	return openClickHouse(chConnConfigFrom("clickhouse"), "gemini-go-clickhouse")
+}
+
+// chConnConfig is the address and credentials of one ClickHouse connection.
+type chConnConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Database string
+}
+
+// chConnConfigFrom reads <prefix>.host/port/user/password/database. Empty
+// fields fall back to the clickhouse.* connection; user and password fall back
+// together, so a dedicated user is never paired with the default password.
+func chConnConfigFrom(prefix string) chConnConfig {
+	cfg := chConnConfig{
+		Host:     viper.GetString(prefix + ".host"),
+		Port:     viper.GetInt(prefix + ".port"),
+		User:     viper.GetString(prefix + ".user"),
+		Password: viper.GetString(prefix + ".password"),
+		Database: viper.GetString(prefix + ".database"),
+	}
+	if cfg.Host == "" {
+		cfg.Host = viper.GetString("clickhouse.host")
+	}
+	if cfg.Port == 0 {
+		cfg.Port = viper.GetInt("clickhouse.port")
+	}
+	if cfg.User == "" {
+		cfg.User = viper.GetString("clickhouse.user")
+		cfg.Password = viper.GetString("clickhouse.password")
+	}
+	if cfg.Database == "" {
+		cfg.Database = viper.GetString("clickhouse.database")
+	}
+	return cfg
+}
+
+// openClickHouse opens and pings a connection for cfg, applying the shared
+// TLS, proxy and logging options. Driver debug output and returned errors have
+// the password masked. product is reported in the client info so
+// connections can be told apart in system.query_log.
+func openClickHouse(cfg chConnConfig, product string) (driver.Conn, error) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: true}
+	dial, dialErr := clickhouseDialer(tlsCfg)
+	if dialErr != nil {
+		return nil, dialErr
+	}
+
 	var (
 		ctx       = context.Background()
-		addr      = fmt.Sprintf("%s:%d", viper.GetString("clickhouse.host"), viper.GetInt("clickhouse.port"))
+		addr      = fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
 		conn, err = clickhouse.Open(&clickhouse.Options{
 			Addr: []string{addr},
 			Auth: clickhouse.Auth{
-				Database: viper.GetString("clickhouse.database"),
-				Username: viper.GetString("clickhouse.user"),
-				Password: viper.GetString("clickhouse.password"),
+				Database: cfg.Database,
+				Username: cfg.User,
+				Password: cfg.Password,
 			},
-			TLS: &tls.Config{InsecureSkipVerify: true},
+			TLS:         tlsCfg,
+			DialContext: dial,
 			ClientInfo: clickhouse.ClientInfo{
 				Products: []struct {
 					Name    string
 					Version string
 				}{
-					{Name: "gemini-go-clickhouse", Version: "0.1"},
+					{Name: product, Version: "0.1"},
 				},
 			},
 			Debugf: func(format string, v ...interface{}) {
-				logrus.Debugf(format, v...)
+				logrus.Debug(maskPassword(fmt.Sprintf(format, v...)))
 			},
 		})
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, maskError(err)
 	}
 
 	logrus.WithFields(logrus.Fields{
-		"host":     viper.GetString("clickhouse.host"),
-		"port":     viper.GetInt("clickhouse.port"),
-		"database": viper.GetString("clickhouse.database"),
-		"user":     viper.GetString("clickhouse.user"),
+		"host":     cfg.Host,
+		"port":     cfg.Port,
+		"database": cfg.Database,
+		"user":     cfg.User,
+		"product":  product,
 	}).Debug("Attempting to connect to ClickHouse")
 
 	if err := conn.Ping(ctx); err != nil {
 		if exception, ok := err.(*clickhouse.Exception); ok {
-			logrus.WithFields(logrus.Fields{
+			logDeduped(logrus.WithError(err).WithFields(logrus.Fields{
 				"code":       exception.Code,
 				"message":    exception.Message,
 				"stacktrace": exception.StackTrace,
-			}).Error("ClickHouse exception occurred")
+			}), logrus.ErrorLevel, "ClickHouse exception occurred")
 		}
-		return nil, err
+		return nil, maskError(err)
 	}
 	logrus.Debug("Successfully connected to ClickHouse")
 	return conn, nil
 }
 
+type queryIDKey struct{}
+
+// withQueryID tags ctx with a fresh ClickHouse query_id and returns it, so the
+// query can be looked up in system.query_log afterwards.
+func withQueryID(ctx context.Context) (context.Context, string) {
+	queryID := uuid.NewString()
+	ctx = context.WithValue(ctx, queryIDKey{}, queryID)
+	return clickhouse.Context(ctx, clickhouse.WithQueryID(queryID)), queryID
+}
+
+// queryIDFrom returns the query_id set on ctx by withQueryID, or "" if none.
+// The driver doesn't expose its query options, so the ID is kept alongside.
+func queryIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(queryIDKey{}).(string)
+	return id
+}
+
+// detectCluster checks clickhouse.cluster against system.clusters and logs the
+// available cluster names when it isn't there, so a typo surfaces at startup
+// rather than as a clusterAllReplicas failure. If exactly one cluster exists it
+// is used instead. A configured cluster that exists is never changed.
+func detectCluster() {
+	conn, err := connect()
+	if err != nil {
+		logrus.WithError(err).Warn("Cluster detection skipped: could not connect to ClickHouse")
+		return
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing ClickHouse connection")
+		}
+	}()
+
+	clusters, err := listClusters(context.Background(), conn)
+	if err != nil {
+		logrus.WithError(err).Warn("Cluster detection skipped: could not read system.clusters")
+		return
+	}
+	configured := viper.GetString("clickhouse.cluster")
+	cluster, err := resolveCluster(configured, clusters)
+	if err != nil {
+		logrus.WithError(err).Warn("clickhouse.cluster is misconfigured; clusterAllReplicas queries will fail")
+		return
+	}
+	if cluster != configured {
+		logrus.WithFields(logrus.Fields{
+			"configured": configured,
+			"detected":   cluster,
+		}).Warn("clickhouse.cluster not found; using the only cluster in system.clusters")
+		viper.Set("clickhouse.cluster", cluster)
+	}
+}
+
+// listClusters returns the distinct cluster names in system.clusters.
+func listClusters(ctx context.Context, conn driver.Conn) ([]string, error) {
+	rows, err := conn.Query(ctx, "SELECT DISTINCT cluster FROM system.clusters ORDER BY cluster")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
+
+	var clusters []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, name)
+	}
+	return clusters, rows.Err()
+}
+
+// resolveCluster returns configured if it is one of available, or the sole
+// available cluster if there is exactly one. Otherwise it returns an error
+// listing the available names.
+func resolveCluster(configured string, available []string) (string, error) {
+	for _, c := range available {
+		if c == configured {
+			return configured, nil
+		}
+	}
+	switch len(available) {
+	case 0:
+		return "", fmt.Errorf("cluster %q not found: system.clusters is empty (single-node server?)", configured)
+	case 1:
+		return available[0], nil
+	default:
+		return "", fmt.Errorf("cluster %q not found in system.clusters; available clusters: %s", configured, strings.Join(available, ", "))
+	}
+}
+
+// Values of analysis.stack_traces.
+const (
+	stackTracesDrop      = "drop"
+	stackTracesRaw       = "raw"
+	stackTracesSymbolize = "symbolize"
+)
+
+// maxErrorFrames caps the number of symbolized frames kept per error.
+const maxErrorFrames = 20
+
+// stackTracesMode returns analysis.stack_traces, treating unknown values as
+// drop.
+func stackTracesMode() string {
+	switch mode := strings.ToLower(strings.TrimSpace(viper.GetString("analysis.stack_traces"))); mode {
+	case stackTracesRaw, stackTracesSymbolize:
+		return mode
+	default:
+		return stackTracesDrop
+	}
+}
+
+// getCHErrors returns the errors raised across the cluster in the past hour,
+// with last_error_trace handled according to analysis.stack_traces. If the
+// server refuses to symbolize (introspection functions not allowed), the
+// traces are dropped instead.
 func getCHErrors(ctx context.Context, conn driver.Conn) ([]CHError, error) {
+	mode := stackTracesMode()
+	errors, err := queryCHErrors(ctx, conn, mode)
+	if err != nil && mode == stackTracesSymbolize {
+		logrus.WithError(err).Warn("Could not symbolize error stack traces; dropping them")
+		return queryCHErrors(ctx, conn, stackTracesDrop)
+	}
+	return errors, err
+}
+
+// cleanFrames drops frames that could not be resolved and keeps at most
+// maxErrorFrames.
+func cleanFrames(frames []string) []string {
+	var out []string
+	for _, f := range frames {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+		if len(out) == maxErrorFrames {
+			break
+		}
+	}
+	return out
+}
+
+func queryCHErrors(ctx context.Context, conn driver.Conn, mode string) ([]CHError, error) {
 	cluster := viper.GetString("clickhouse.cluster")
-	query := "SELECT hostname() hostname, name, code, value, last_error_time, last_error_message, last_error_trace, remote" +
-		" FROM clusterAllReplicas(" + cluster + ", system.errors)" +
+	query := "SELECT hostname() hostname, name, code, value, last_error_time, last_error_message, last_error_trace, remote"
+	if mode == stackTracesSymbolize {
+		// Addresses are only meaningful to the server that produced them, so
+		// resolve them inside clusterAllReplicas on each replica.
+		query += ", arrayMap(x -> concat(demangle(addressToSymbol(x)), ' ', addressToLine(x)), last_error_trace) last_error_frames"
+		ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{"allow_introspection_functions": 1}))
+	}
+	query += " FROM clusterAllReplicas(" + cluster + ", system.errors)" +
 		" WHERE last_error_time > now() - INTERVAL 1 HOUR"
 	
 	logrus.WithFields(logrus.Fields{
@@ -120,7 +341,7 @@ func getCHErrors(ctx context.Context, conn driver.Conn) ([]CHError, error) {
 	var errors []CHError
 	for rows.Next() {
 		var chError CHError
-		if err := rows.Scan(
+		dest := []interface{}{
 			&chError.Hostname,
 			&chError.Name,
 			&chError.Code,
@@ -129,10 +350,21 @@ func getCHErrors(ctx context.Context, conn driver.Conn) ([]CHError, error) {
 			&chError.LastErrorMessage,
 			&chError.LastErrorTrace,
 			&chError.Remote,
-		); err != nil {
+		}
+		if mode == stackTracesSymbolize {
+			dest = append(dest, &chError.LastErrorFrames)
+		}
+		if err := rows.Scan(dest...); err != nil {
 			logrus.WithError(err).Error("Failed to scan error row")
 			return nil, err
 		}
+		switch mode {
+		case stackTracesDrop:
+			chError.LastErrorTrace = nil
+		case stackTracesSymbolize:
+			chError.LastErrorFrames = cleanFrames(chError.LastErrorFrames)
+			chError.LastErrorTrace = nil
+		}
 		errors = append(errors, chError)
 	}
 
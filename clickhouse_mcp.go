@@ -2,12 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/big"
+	"net"
+	"path"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/chcol"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -17,6 +28,20 @@ import (
 // normalized_query_hash.
 const maxSafeJSONInt = 1<<53 - 1
 
+// Generous upper bounds on free-form SQL; anything past them is far more
+// likely a runaway generation or parser-exhaustion attempt than a real query.
+const (
+	defaultMaxSQLLength  = 100_000
+	defaultMaxSQLNesting = 64
+)
+
+// defaultMaxCellLength is the length, in characters, past which string values
+// in results are truncated; a query_log.query cell can be many KB.
+const defaultMaxCellLength = 4096
+
+// truncatedMarker is appended to truncated string values.
+const truncatedMarker = "...(truncated)"
+
 // JSON-RPC transport types
 type queryArgs struct {
 	Table   string   `json:"table"`
@@ -25,44 +50,92 @@ type queryArgs struct {
 	OrderBy string   `json:"order_by,omitempty"`
 	Limit   int      `json:"limit,omitempty"`
 	SQL     string   `json:"sql,omitempty"`
+	// Table modifiers for structured queries only. FINAL merges rows of
+	// Replacing/Collapsing MergeTree tables at read time; SAMPLE reads a
+	// fraction of a table that declares SAMPLE BY.
+	Final  bool    `json:"final,omitempty" jsonschema:"read the table with FINAL, merging rows not yet merged in the background (ReplacingMergeTree etc.); slower, structured queries only"`
+	Sample float64 `json:"sample,omitempty" jsonschema:"read only this fraction of the table (0 < sample < 1) via SAMPLE; the table must declare SAMPLE BY; structured queries only"`
+	// Query-level ClickHouse settings; names must be in
+	// clickhouse.allowed_query_settings.
+	Settings map[string]string `json:"settings,omitempty"`
+	// Return string values in full instead of cutting them at
+	// clickhouse.max_cell_length.
+	Verbose bool `json:"verbose,omitempty" jsonschema:"return long string values in full instead of truncated to clickhouse.max_cell_length characters"`
+}
+
+// queryResult is the structured output of the clickhouse_query tool. Its
+// shape is published to clients as the tool's output schema.
+type queryResult struct {
+	Results []map[string]interface{} `json:"results" jsonschema:"rows returned by the query, keyed by column name; only the first rows when result_uri is set"`
+	Count   int                      `json:"count" jsonschema:"number of rows the query returned"`
+	Columns []string                 `json:"columns" jsonschema:"column names in result order"`
+	QueryID string                   `json:"query_id,omitempty" jsonschema:"ClickHouse query_id the query ran under; look it up in system.query_log"`
+	SQL     string                   `json:"sql,omitempty" jsonschema:"the SQL that was executed (only when mcp.include_sql is enabled)"`
+	// Set only when clickhouse.skip_unavailable_shards is enabled.
+	UnavailableReplicas []string `json:"unavailable_replicas,omitempty" jsonschema:"replicas with recent connection errors; their rows may be missing from results"`
+	// Set only when mcp.large_results is enabled and the rows were too large
+	// to return inline.
+	ResultURI string `json:"result_uri,omitempty" jsonschema:"MCP resource URI to read all rows from, as a JSON array"`
+	// Set when string values longer than clickhouse.max_cell_length were cut.
+	TruncatedCells int `json:"truncated_cells,omitempty" jsonschema:"number of string values truncated and marked ...(truncated); repeat with verbose for full values"`
 }
 
 // (SDK server implemented in sdk_mcp.go)
 
 func validateQueryArgs(a queryArgs) error {
+	if safeMode {
+		if err := validateSafeModeArgs(a); err != nil {
+			return err
+		}
+	}
+	if err := validateQuerySettings(a.Settings); err != nil {
+		return err
+	}
 	// Free-form SQL path
 	if strings.TrimSpace(a.SQL) != "" {
+		if !freeformSQLAllowed() {
+			return invalidQuery(reasonSQLDisabled, "free-form sql is disabled on this server; use the structured fields table, columns, where, order_by and limit instead")
+		}
+		if a.Final || a.Sample != 0 {
+			return invalidQuery(reasonInvalidClause, "final and sample apply only to structured queries; write FINAL or SAMPLE in the sql instead")
+		}
 		return validateFreeformSQL(a.SQL)
 	}
 
 	if a.Table == "" {
-		return fmt.Errorf("table is required (or provide 'sql')")
+		return invalidQuery(reasonMissingTable, "table is required (or provide 'sql')")
 	}
 	t := strings.TrimSpace(a.Table)
 	if !isTableAllowed(t) {
-		allowedDbs := getAllowedDatabases()
-		return fmt.Errorf("table must be in allowed databases: %v", allowedDbs)
+		return tableNotAllowed("table must be in allowed databases: %v")
 	}
 	if strings.ContainsAny(t, ";\n\r\t") {
-		return fmt.Errorf("invalid table name")
+		return invalidQuery(reasonInvalidIdentifier, "invalid table name")
 	}
 	for _, c := range a.Columns {
 		if strings.ContainsAny(c, ";\n\r\t") || c == "" {
-			return fmt.Errorf("invalid column name: %q", c)
+			return invalidQuery(reasonInvalidIdentifier, "invalid column name: %q", c)
 		}
 	}
 	if strings.Contains(a.Where, ";") || strings.Contains(a.OrderBy, ";") {
-		return fmt.Errorf("invalid clause")
+		return invalidQuery(reasonInvalidClause, "invalid clause")
 	}
 	if a.Limit < 0 {
-		return fmt.Errorf("limit must be >= 0")
+		return invalidQuery(reasonInvalidLimit, "limit must be >= 0")
+	}
+	if a.Sample < 0 || a.Sample >= 1 {
+		return invalidQuery(reasonInvalidClause, "sample must be between 0 and 1 (exclusive)")
 	}
 	return nil
 }
 
-func runClickhouseQuery(a queryArgs) ([]map[string]interface{}, error) {
+// runClickhouseQuery executes the query described by a and returns its rows,
+// column names and the ClickHouse query_id it ran under. Errors raised after
+// the query was sent carry the query_id.
+func runClickhouseQuery(ctx context.Context, a queryArgs) (*queryResult, error) {
 	conn, err := connect()
 	if err != nil {
+		logDeduped(logrus.WithError(err), logrus.ErrorLevel, "Could not connect to ClickHouse")
 		return nil, err
 	}
 	defer func() {
@@ -71,6 +144,107 @@ func runClickhouseQuery(a queryArgs) ([]map[string]interface{}, error) {
 		}
 	}()
 
+	res, err := queryClickhouse(withQuerySettings(ctx, a.Settings), conn, buildQuery(a))
+	if err != nil {
+		// Dedupe on the underlying error, not the per-call query_id suffix.
+		cause := err
+		if u := errors.Unwrap(err); u != nil {
+			cause = u
+		}
+		logDeduped(logrus.WithError(cause), logrus.WarnLevel, "ClickHouse query failed")
+	}
+	return res, err
+}
+
+// builtinDefaultColumns are the columns selected from well-known wide system
+// tables when a structured query names none. Queries on these tables run
+// across replicas, so each set starts with the replica's host name.
+var builtinDefaultColumns = map[string][]string{
+	"system.query_log": {
+		"hostName() AS host", "event_time", "query_id", "type", "query_kind", "user",
+		"normalized_query_hash", "query_duration_ms", "read_rows", "read_bytes",
+		"result_rows", "memory_usage", "exception_code",
+	},
+	"system.errors": {
+		"hostName() AS host", "name", "code", "value", "last_error_time", "last_error_message", "remote",
+	},
+	"system.parts": {
+		"hostName() AS host", "database", "table", "partition", "name", "active", "level",
+		"rows", "bytes_on_disk", "modification_time",
+	},
+	"system.merges": {
+		"hostName() AS host", "database", "table", "elapsed", "progress", "is_mutation",
+		"num_parts", "result_part_name", "total_size_bytes_compressed", "memory_usage",
+	},
+	"system.mutations": {
+		"hostName() AS host", "database", "table", "mutation_id", "command", "create_time",
+		"parts_to_do", "is_done", "latest_fail_time", "latest_fail_reason",
+	},
+	"system.replicas": {
+		"hostName() AS host", "database", "table", "is_readonly", "is_session_expired",
+		"queue_size", "inserts_in_queue", "merges_in_queue", "absolute_delay",
+		"active_replicas", "total_replicas",
+	},
+	"system.replication_queue": {
+		"hostName() AS host", "database", "table", "type", "create_time", "is_currently_executing",
+		"num_tries", "last_exception", "postpone_reason",
+	},
+	"system.processes": {
+		"hostName() AS host", "query_id", "user", "elapsed", "read_rows", "read_bytes",
+		"memory_usage", "is_cancelled",
+	},
+	"system.tables": {
+		"hostName() AS host", "database", "name", "engine", "total_rows", "total_bytes",
+		"metadata_modification_time",
+	},
+}
+
+// defaultColumns returns the columns to select from table when none are given:
+// the clickhouse.default_columns entry for it if configured (an empty list
+// means *), else the built-in set, else nil for *.
+func defaultColumns(table string) []string {
+	table = strings.ToLower(strings.TrimSpace(table))
+	if cols, ok := configuredDefaultColumns()[table]; ok {
+		return cols
+	}
+	return builtinDefaultColumns[table]
+}
+
+// configuredDefaultColumns reads clickhouse.default_columns. Keys are
+// database.table; YAML keys containing a dot may also arrive nested as
+// database: {table: [...]}, so both shapes are accepted.
+func configuredDefaultColumns() map[string][]string {
+	out := make(map[string][]string)
+	for key, v := range viper.GetStringMap("clickhouse.default_columns") {
+		if nested, ok := v.(map[string]interface{}); ok {
+			for table, cols := range nested {
+				out[strings.ToLower(key+"."+table)] = columnList(cols)
+			}
+			continue
+		}
+		out[strings.ToLower(key)] = columnList(v)
+	}
+	return out
+}
+
+// columnList converts a configured column list to strings.
+func columnList(v interface{}) []string {
+	switch t := v.(type) {
+	case []string:
+		return t
+	case []interface{}:
+		cols := make([]string, 0, len(t))
+		for _, c := range t {
+			cols = append(cols, fmt.Sprint(c))
+		}
+		return cols
+	default:
+		return nil
+	}
+}
+
+// buildQuery returns a.SQL, or the SELECT described by the structured fields.
+func buildQuery(a queryArgs) string {
 	var query string
 	if strings.TrimSpace(a.SQL) != "" {
 		query = a.SQL
@@ -79,6 +253,8 @@ func runClickhouseQuery(a queryArgs) ([]map[string]interface{}, error) {
 		sb.WriteString("SELECT ")
 		if len(a.Columns) > 0 {
 			sb.WriteString(strings.Join(a.Columns, ", "))
+		} else if cols := defaultColumns(a.Table); len(cols) > 0 {
+			sb.WriteString(strings.Join(cols, ", "))
 		} else {
 			sb.WriteString("*")
 		}
@@ -90,6 +266,12 @@ func runClickhouseQuery(a queryArgs) ([]map[string]interface{}, error) {
 		} else {
 			fmt.Fprintf(&sb, " FROM %s", a.Table)
 		}
+		if a.Final {
+			sb.WriteString(" FINAL")
+		}
+		if a.Sample > 0 {
+			fmt.Fprintf(&sb, " SAMPLE %s", strconv.FormatFloat(a.Sample, 'g', -1, 64))
+		}
 		
 		if a.Where != "" {
 			sb.WriteString(" WHERE ")
@@ -99,14 +281,122 @@ func runClickhouseQuery(a queryArgs) ([]map[string]interface{}, error) {
 			sb.WriteString(" ORDER BY ")
 			sb.WriteString(a.OrderBy)
 		}
-		if a.Limit > 0 {
-			fmt.Fprintf(&sb, " LIMIT %d", a.Limit)
+		limit := a.Limit
+		if safeMode && limit == 0 {
+			limit = safeModeMaxRows
+		}
+		if limit > 0 {
+			fmt.Fprintf(&sb, " LIMIT %d", limit)
 		}
 		query = sb.String()
 	}
+	return query
+}
 
-	ctx := context.Background()
-	rows, err := conn.Query(ctx, query)
+// ClickHouse error codes handled specially by queryClickhouse.
+const (
+	clusterNotFoundCode      = 170 // CLUSTER_DOESNT_EXIST
+	allConnectionsFailedCode = 279 // ALL_CONNECTION_TRIES_FAILED
+	tooManyRowsCode          = 158 // TOO_MANY_ROWS
+	tooManyBytesCode         = 307 // TOO_MANY_BYTES
+)
+
+// clusterAllReplicasRe matches clusterAllReplicas(<cluster>, <db.table>),
+// capturing the cluster and the table.
+var clusterAllReplicasRe = regexp.MustCompile(`(?i)clusterAllReplicas\(\s*'?([\w.-]+)'?\s*,\s*([\w.` + "`" + `]+)\s*\)`)
+
+// queryClickhouse runs query on conn. If it fails because the cluster named in
+// clusterAllReplicas doesn't exist (single-node or misconfigured server), the
+// query is retried once against the local tables. If it fails because a
+// replica is unreachable, the error suggests clickhouse.skip_unavailable_shards.
+// With clickhouse.validate_with_explain set, the query is first checked with
+// EXPLAIN and not run at all if that fails.
+func queryClickhouse(ctx context.Context, conn driver.Conn, query string) (*queryResult, error) {
+	if viper.GetBool("clickhouse.validate_with_explain") {
+		if err := explainQuery(ctx, conn, query); err != nil {
+			return nil, err
+		}
+	}
+	res, err := execQuery(ctx, conn, query)
+	if err == nil {
+		return res, nil
+	}
+	if hasErrorCode(err, allConnectionsFailedCode) && !viper.GetBool("clickhouse.skip_unavailable_shards") {
+		return nil, fmt.Errorf("%w\nhint: a replica is unreachable; enable clickhouse.skip_unavailable_shards to get results from the healthy replicas", err)
+	}
+	if hasErrorCode(err, tooManyRowsCode) || hasErrorCode(err, tooManyBytesCode) {
+		return nil, fmt.Errorf("%w\nhint: the query would scan more data than allowed; add a WHERE on the table's partition key or primary key (e.g. a time range) rather than relying on LIMIT", err)
+	}
+	if !isClusterNotFound(err) {
+		return nil, err
+	}
+	local := clusterAllReplicasRe.ReplaceAllString(query, "$2")
+	if local == query {
+		return nil, err
+	}
+	logrus.WithError(err).Warn("Cluster not found; retrying query against the local node only")
+	return execQuery(ctx, conn, local)
+}
+
+// explainQuery runs EXPLAIN on query, which parses it and resolves its tables,
+// columns and functions without reading data. Failures other than a missing
+// cluster (left to queryClickhouse's local fallback) are returned as
+// validation errors.
+func explainQuery(ctx context.Context, conn driver.Conn, query string) error {
+	rows, err := conn.Query(ctx, "EXPLAIN "+query)
+	usage.recordQuery(err)
+	if err != nil {
+		if isClusterNotFound(err) || ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("query failed validation with EXPLAIN and was not run: %w", err)
+	}
+	if err := rows.Close(); err != nil {
+		logrus.WithError(err).Warn("Error closing rows")
+	}
+	return nil
+}
+
+// isClusterNotFound reports whether err is ClickHouse's CLUSTER_DOESNT_EXIST.
+func isClusterNotFound(err error) bool {
+	var exception *clickhouse.Exception
+	if errors.As(err, &exception) {
+		return exception.Code == clusterNotFoundCode
+	}
+	return strings.Contains(err.Error(), "CLUSTER_DOESNT_EXIST")
+}
+
+// hasErrorCode reports whether err is a ClickHouse exception with code.
+func hasErrorCode(err error, code int32) bool {
+	var exception *clickhouse.Exception
+	return errors.As(err, &exception) && exception.Code == code
+}
+
+// clusterNames returns the distinct clusters referenced via clusterAllReplicas.
+func clusterNames(query string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range clusterAllReplicasRe.FindAllStringSubmatch(query, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// replicasWithErrors returns host:port of replicas in clusters that
+// system.clusters currently reports connection errors for. errors_count is a
+// counter that decays over time rather than a record of which replicas a query
+// skipped, so the result is a hint: a replica may be listed after it recovered,
+// or missing if it failed once and its count already decayed. ctx should not
+// carry the settings or query_id of the query being checked; it runs under a
+// query_id of its own.
+func replicasWithErrors(ctx context.Context, conn driver.Conn, clusters []string) ([]string, error) {
+	ctx, _ = withQueryID(ctx)
+	rows, err := conn.Query(ctx,
+		"SELECT host_name, port FROM system.clusters WHERE cluster IN (?) AND errors_count > 0 ORDER BY host_name, port",
+		clusters)
 	if err != nil {
 		return nil, err
 	}
@@ -115,6 +405,79 @@ func runClickhouseQuery(a queryArgs) ([]map[string]interface{}, error) {
 			logrus.WithError(err).Warn("Error closing rows")
 		}
 	}()
+	var hosts []string
+	for rows.Next() {
+		var host string
+		var port uint16
+		if err := rows.Scan(&host, &port); err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, fmt.Sprintf("%s:%d", host, port))
+	}
+	return hosts, rows.Err()
+}
+
+// execQuery runs query on conn under a fresh query_id and collects its rows.
+// If ctx is cancelled while the query runs and clickhouse.kill_on_cancel is
+// set, the query is also killed server-side by its query_id.
+func execQuery(ctx context.Context, conn driver.Conn, query string) (res *queryResult, err error) {
+	callerCtx := ctx
+	ctx, queryID := withQueryID(ctx)
+	defer func() {
+		if err != nil && ctx.Err() != nil && viper.GetBool("clickhouse.kill_on_cancel") {
+			killQuery(conn, queryID)
+		}
+	}()
+	clusters := clusterNames(query)
+	settings := querySettingsFrom(ctx)
+	capSetting(settings, "max_rows_to_read", viper.GetInt64("clickhouse.max_rows_to_read"))
+	capSetting(settings, "max_bytes_to_read", viper.GetInt64("clickhouse.max_bytes_to_read"))
+	skipUnavailable := viper.GetBool("clickhouse.skip_unavailable_shards") && len(clusters) > 0
+	if skipUnavailable {
+		settings["skip_unavailable_shards"] = 1
+	}
+	if len(settings) > 0 {
+		ctx = clickhouse.Context(ctx, clickhouse.WithSettings(settings))
+	}
+	logrus.WithFields(logrus.Fields{
+		"query":    normalizeSQL(query),
+		"query_id": queryID,
+	}).Debug("Executing ClickHouse query")
+
+	rows, err := conn.Query(ctx, query)
+	usage.recordQuery(err)
+	if err != nil {
+		return nil, fmt.Errorf("%w (query_id: %s)", err, queryID)
+	}
+	cols, results, err := scanRows(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%w (query_id: %s)", err, queryID)
+	}
+	res = &queryResult{Results: results, Count: len(results), Columns: cols, QueryID: queryID}
+	if viper.GetBool("mcp.include_sql") {
+		res.SQL = normalizeSQL(query)
+	}
+	if skipUnavailable {
+		// Best effort: the query already succeeded, so a failed lookup only
+		// means we can't say whether the results are partial. The rows are
+		// closed by now, and callerCtx has neither the query's settings nor
+		// its query_id.
+		if hosts, err := replicasWithErrors(callerCtx, conn, clusters); err != nil {
+			logrus.WithError(err).Debug("Could not check system.clusters for unavailable replicas")
+		} else {
+			res.UnavailableReplicas = hosts
+		}
+	}
+	return res, nil
+}
+
+// scanRows reads all of rows into JSON-friendly maps and closes it.
+func scanRows(rows driver.Rows) ([]string, []map[string]interface{}, error) {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
 
 	cols := rows.Columns()
 	colTypes := rows.ColumnTypes()
@@ -132,7 +495,7 @@ func runClickhouseQuery(a queryArgs) ([]map[string]interface{}, error) {
 			ptrs[i] = dest.Interface()
 		}
 		if err := rows.Scan(ptrs...); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		row := make(map[string]interface{}, len(cols))
 		for i, c := range cols {
@@ -145,22 +508,43 @@ func runClickhouseQuery(a queryArgs) ([]map[string]interface{}, error) {
 					continue
 				}
 				base := vptr.Elem().Interface()
-				row[c] = normalizeValue(base)
+				row[c] = normalizeColumnValue(colTypes[i].DatabaseTypeName(), base)
 			} else {
 				base := holders[i].Elem().Interface() // T
-				row[c] = normalizeValue(base)
+				row[c] = normalizeColumnValue(colTypes[i].DatabaseTypeName(), base)
 			}
 		}
 		results = append(results, row)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return results, nil
+	return cols, results, nil
+}
+
+// killQueryTimeout bounds the KILL QUERY issued for a cancelled query.
+const killQueryTimeout = 5 * time.Second
+
+// killQuery asks the server to stop queryID. The driver cancels the query when
+// its context is done, but that only reaches the server if the connection is
+// still usable; KILL QUERY guarantees an abandoned query doesn't keep running.
+func killQuery(conn driver.Conn, queryID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), killQueryTimeout)
+	defer cancel()
+	entry := logrus.WithField("query_id", queryID)
+	if err := conn.Exec(ctx, "KILL QUERY WHERE query_id = ? ASYNC", queryID); err != nil {
+		entry.WithError(err).Warn("Could not kill cancelled ClickHouse query")
+		return
+	}
+	entry.Info("Killed cancelled ClickHouse query")
 }
 
 // normalizeValue converts scanned values into JSON-friendly representations
-// while preserving useful numeric types. Unknown types fall back to fmt.Sprint.
+// while preserving useful numeric types. Big integers (Int128 and wider) and
+// Decimals are rendered as exact strings. Driver types for UUID, IPv4/IPv6,
+// Variant/Dynamic and JSON columns are unwrapped; Tuple, Nested and Map values
+// are normalized element-wise (Map keys are stringified). Unknown types fall
+// back to fmt.Sprint.
 func normalizeValue(v interface{}) interface{} {
 	switch t := v.(type) {
 	case nil:
@@ -169,6 +553,23 @@ func normalizeValue(v interface{}) interface{} {
 		return t
 	case []byte:
 		return string(t)
+	case uuid.UUID:
+		// [16]byte — would otherwise render as an array of numbers
+		return t.String()
+	case net.IP:
+		// IPv4/IPv6 — would otherwise render as an array of numbers
+		if len(t) == 0 {
+			return nil
+		}
+		return t.String()
+	case chcol.Variant:
+		// Variant(...) and Dynamic columns wrap the concrete value
+		if t.Nil() {
+			return nil
+		}
+		return normalizeValue(t.Any())
+	case chcol.JSON:
+		return normalizeValue(t.NestedMap())
 	case bool:
 		return t
 	case int, int8, int16, int32, int64:
@@ -185,11 +586,35 @@ func normalizeValue(v interface{}) interface{} {
 		return u
 	case float32, float64:
 		return reflect.ValueOf(t).Float()
+	case *big.Int:
+		// Int128/UInt128/Int256/UInt256 — exact decimal string; a float would
+		// lose precision and the struct itself has no useful fmt form.
+		if t == nil {
+			return nil
+		}
+		return t.String()
+	case big.Int:
+		return t.String()
+	case decimal.Decimal:
+		// Decimal(P, S) — exact string, keeping the column's scale ("12.50")
+		if exp := t.Exponent(); exp < 0 {
+			return t.StringFixed(-exp)
+		}
+		return t.String()
 	case time.Time:
-		return t.Format(time.RFC3339Nano)
+		// Structured results are always UTC; display.timezone only affects
+		// the human-facing summary.
+		return t.UTC().Format(time.RFC3339Nano)
 	}
-	// Handle slices/arrays generically
 	rv := reflect.ValueOf(v)
+	// Nullable elements inside Array/Map/Tuple arrive as pointers
+	if rv.IsValid() && rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		return normalizeValue(rv.Elem().Interface())
+	}
+	// Handle slices/arrays generically (Array, unnamed Tuple, Nested)
 	if rv.IsValid() && (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) {
 		n := rv.Len()
 		out := make([]interface{}, n)
@@ -198,19 +623,97 @@ func normalizeValue(v interface{}) interface{} {
 		}
 		return out
 	}
-	// Handle maps generically with string keys if possible
-	if rv.IsValid() && rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
+	// Handle maps generically (Map(K, V), named Tuple). JSON objects need
+	// string keys, so non-string keys are rendered via their normalized form.
+	if rv.IsValid() && rv.Kind() == reflect.Map {
 		out := make(map[string]interface{}, rv.Len())
 		for _, key := range rv.MapKeys() {
-			out[key.String()] = normalizeValue(rv.MapIndex(key).Interface())
+			var k string
+			if key.Kind() == reflect.String {
+				k = key.String()
+			} else {
+				k = fmt.Sprint(normalizeValue(key.Interface()))
+			}
+			out[k] = normalizeValue(rv.MapIndex(key).Interface())
 		}
 		return out
 	}
 	return fmt.Sprint(v)
 }
 
-// getAllowedDatabases returns the list of databases the MCP server can query
+// normalizeColumnValue is normalizeValue for a value of a column of ClickHouse
+// type dbType. Date and Date32 values are rendered as YYYY-MM-DD rather than
+// as a midnight UTC timestamp, so summaries don't shift them into
+// display.timezone (and onto the previous day west of UTC).
+func normalizeColumnValue(dbType string, v interface{}) interface{} {
+	if t, ok := v.(time.Time); ok && isDateType(dbType) {
+		return t.Format(time.DateOnly)
+	}
+	return normalizeValue(v)
+}
+
+// isDateType reports whether dbType is Date or Date32, possibly Nullable or
+// LowCardinality.
+func isDateType(dbType string) bool {
+	for {
+		inner, ok := strings.CutPrefix(dbType, "Nullable(")
+		if !ok {
+			inner, ok = strings.CutPrefix(dbType, "LowCardinality(")
+		}
+		if !ok {
+			break
+		}
+		dbType = strings.TrimSuffix(inner, ")")
+	}
+	return dbType == "Date" || dbType == "Date32"
+}
+
+// truncateCells cuts string values in rows, including inside arrays and maps,
+// to max characters followed by truncatedMarker, and returns how many were
+// cut. max <= 0 leaves rows unchanged.
+func truncateCells(rows []map[string]interface{}, max int) int {
+	if max <= 0 {
+		return 0
+	}
+	n := 0
+	for _, row := range rows {
+		for k, v := range row {
+			row[k] = truncateValue(v, max, &n)
+		}
+	}
+	return n
+}
+
+func truncateValue(v interface{}, max int, n *int) interface{} {
+	switch t := v.(type) {
+	case string:
+		if utf8.RuneCountInString(t) <= max {
+			return t
+		}
+		*n++
+		runes := []rune(t)
+		return string(runes[:max]) + truncatedMarker
+	case []interface{}:
+		for i := range t {
+			t[i] = truncateValue(t[i], max, n)
+		}
+	case map[string]interface{}:
+		for k := range t {
+			t[k] = truncateValue(t[k], max, n)
+		}
+	}
+	return v
+}
+
+// getAllowedDatabases returns the list of databases the MCP server can query:
+// the list set through the admin endpoint if any, else the configured one.
 func getAllowedDatabases() []string {
+	allowedDatabasesMu.RLock()
+	override := allowedDatabasesOverride
+	allowedDatabasesMu.RUnlock()
+	if override != nil {
+		return override
+	}
 	allowed := viper.GetStringSlice("clickhouse.allowed_databases")
 	if len(allowed) == 0 {
 		// Default to system if nothing configured
@@ -219,41 +722,63 @@ func getAllowedDatabases() []string {
 	return allowed
 }
 
-// isTableAllowed checks if a table reference is in the allowed databases
-func isTableAllowed(table string) bool {
-	allowedDbs := getAllowedDatabases()
-	for _, db := range allowedDbs {
-		if strings.HasPrefix(strings.ToLower(table), strings.ToLower(db)+".") {
+// databaseAllowed reports whether db matches an allowed database. Entries are
+// exact names or glob patterns (app_*, metrics_?, [ab]_logs). Matching ignores
+// case unless clickhouse.case_sensitive_databases is set.
+func databaseAllowed(db string) bool {
+	caseSensitive := viper.GetBool("clickhouse.case_sensitive_databases")
+	if !caseSensitive {
+		db = strings.ToLower(db)
+	}
+	for _, pattern := range getAllowedDatabases() {
+		if !caseSensitive {
+			pattern = strings.ToLower(pattern)
+		}
+		if ok, err := path.Match(pattern, db); err == nil && ok {
 			return true
 		}
 	}
 	return false
 }
 
+// isTableAllowed checks if a table reference is in the allowed databases
+func isTableAllowed(table string) bool {
+	db, _, ok := strings.Cut(table, ".")
+	return ok && databaseAllowed(db)
+}
+
 // validateFreeformSQL ensures the provided SQL is a single SELECT/WITH query and
 // references only allowed database tables (including inside clusterAllReplicas(),
 // CTEs declared via WITH, and parenthesized subqueries used as table sources).
 func validateFreeformSQL(sql string) error {
-	s := strings.TrimSpace(sql)
+	if limit := maxSQLLength(); len(sql) > limit {
+		return invalidQuery(reasonQueryTooLong, "sql is too long (%d bytes, max %d)", len(sql), limit)
+	}
+	s := normalizeSQL(sql)
 	if s == "" {
-		return fmt.Errorf("sql is empty")
+		return invalidQuery(reasonEmptyQuery, "sql is empty")
 	}
 	if strings.Contains(s, ";") {
-		return fmt.Errorf("multiple statements are not allowed")
+		return invalidQuery(reasonMultipleStatements, "multiple statements are not allowed")
 	}
 	// Strip simple quoted strings to avoid false positives when scanning tokens
 	sanitized := stripQuotedLiterals(s)
+	if limit, depth := maxSQLNesting(), parenDepth(sanitized); depth > limit {
+		return invalidQuery(reasonQueryTooNested, "sql is nested too deeply (%d levels of parentheses, max %d)", depth, limit)
+	}
 	lower := strings.ToLower(strings.TrimSpace(sanitized))
 	if !strings.HasPrefix(lower, "select ") && !strings.HasPrefix(lower, "with ") {
-		return fmt.Errorf("only SELECT/WITH queries are allowed")
+		return invalidQuery(reasonNotSelect, "only SELECT/WITH queries are allowed")
 	}
 	// Disallow obvious write/DDL keywords
-	forbidden := []string{" insert ", " alter ", " update ", " delete ", " attach ", " detach ", " drop ", " create ", " truncate ", " kill ", " optimize ", " grant ", " revoke ", " set ", " use "}
-	lpad := " " + lower + " "
-	for _, kw := range forbidden {
-		if strings.Contains(lpad, kw) {
-			return fmt.Errorf("forbidden keyword detected: %s", strings.TrimSpace(kw))
-		}
+	if kw := findForbiddenKeyword(lower); kw != "" {
+		return invalidQuery(reasonForbiddenKeyword, "forbidden keyword detected: %s", kw)
+	}
+	// Inline settings would bypass the settings allowlist and the configured
+	// scan limits, which only apply to the settings field. Setting names may
+	// be quoted identifiers, so check before those are stripped.
+	if settingsClauseRe.MatchString(stripStringLiterals(s)) {
+		return invalidQuery(reasonSettingNotAllowed, "SETTINGS clauses are not allowed in sql; pass query-level settings in the settings field instead")
 	}
 	// Collect CTE names declared via WITH ... AS (...) so references to them
 	// from FROM/JOIN aren't treated as unauthorized table references.
@@ -261,6 +786,98 @@ func validateFreeformSQL(sql string) error {
 	return validateTableRefs(sanitized, cteNames)
 }
 
+// freeformSQLAllowed reports whether clickhouse_query accepts the sql field
+// (clickhouse.allow_freeform_sql, true when unset).
+func freeformSQLAllowed() bool {
+	return !viper.IsSet("clickhouse.allow_freeform_sql") || viper.GetBool("clickhouse.allow_freeform_sql")
+}
+
+// maxSQLLength returns clickhouse.max_sql_length, or the default when unset.
+func maxSQLLength() int {
+	if n := viper.GetInt("clickhouse.max_sql_length"); n > 0 {
+		return n
+	}
+	return defaultMaxSQLLength
+}
+
+// maxCellLength returns clickhouse.max_cell_length, or the default when unset.
+// 0 disables truncation.
+func maxCellLength() int {
+	if !viper.IsSet("clickhouse.max_cell_length") {
+		return defaultMaxCellLength
+	}
+	return viper.GetInt("clickhouse.max_cell_length")
+}
+
+// maxSQLNesting returns clickhouse.max_sql_nesting, or the default when unset.
+func maxSQLNesting() int {
+	if n := viper.GetInt("clickhouse.max_sql_nesting"); n > 0 {
+		return n
+	}
+	return defaultMaxSQLNesting
+}
+
+// parenDepth returns the maximum parenthesis nesting depth in s. Quotes are
+// assumed already stripped by stripQuotedLiterals.
+func parenDepth(s string) int {
+	depth, deepest := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+			if depth > deepest {
+				deepest = depth
+			}
+		case ')':
+			depth--
+		}
+	}
+	return deepest
+}
+
+// forbiddenKeywords are write/DDL/session statements that must not appear as
+// standalone words in a free-form query.
+var forbiddenKeywords = map[string]struct{}{
+	"insert": {}, "alter": {}, "update": {}, "delete": {}, "attach": {},
+	"detach": {}, "drop": {}, "create": {}, "truncate": {}, "kill": {},
+	"optimize": {}, "grant": {}, "revoke": {}, "set": {}, "use": {},
+}
+
+// settingsClauseRe matches a SETTINGS clause (SETTINGS name = ...) in SQL
+// whose string literals are stripped, with the name bare, "double-quoted" or
+// `backtick-quoted`. The system.settings table and a column or alias named
+// settings don't match.
+var settingsClauseRe = regexp.MustCompile("(?i)(^|[^\\w.])settings\\s+(\\w+|\"[^\"]*\"|`[^`]*`)\\s*=")
+
+// findForbiddenKeyword scans the (lowercased, quote-stripped) SQL word by word
+// and returns the first forbidden keyword found, or "". Words are whole
+// identifier tokens, so names that merely contain a keyword (dropped_parts,
+// useragent, settings) don't match, while keywords adjacent to punctuation
+// (",drop", "(kill") still do. Dot-qualified tokens (t.use, drop.tbl) are
+// identifiers, not statements, and are skipped.
+func findForbiddenKeyword(lower string) string {
+	for i := 0; i < len(lower); {
+		if !isIdentChar(lower[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(lower) && isIdentChar(lower[i]) {
+			i++
+		}
+		if start > 0 && lower[start-1] == '.' {
+			continue
+		}
+		if i < len(lower) && lower[i] == '.' {
+			continue
+		}
+		if _, ok := forbiddenKeywords[lower[start:i]]; ok {
+			return lower[start:i]
+		}
+	}
+	return ""
+}
+
 // extractCTENames returns the set of CTE identifiers declared by a top-level
 // WITH clause in the (already quote-stripped) SQL. Handles both:
 //
@@ -369,7 +986,7 @@ func validateTableRefs(sanitized string, cteNames map[string]struct{}) error {
 		if sanitized[next] == '(' {
 			end, ok := matchParen(sanitized, next)
 			if !ok {
-				return fmt.Errorf("unbalanced parentheses near FROM/JOIN")
+				return invalidQuery(reasonInvalidSubquery, "unbalanced parentheses near FROM/JOIN")
 			}
 			inner := strings.TrimSpace(sanitized[next+1 : end])
 			innerLower := strings.ToLower(inner)
@@ -381,7 +998,7 @@ func validateTableRefs(sanitized string, cteNames map[string]struct{}) error {
 					return err
 				}
 			} else {
-				return fmt.Errorf("subquery in FROM/JOIN must be a SELECT or WITH")
+				return invalidQuery(reasonInvalidSubquery, "subquery in FROM/JOIN must be a SELECT or WITH")
 			}
 			i = end + 1
 			continue
@@ -409,8 +1026,7 @@ func validateTableRefs(sanitized string, cteNames map[string]struct{}) error {
 						tbl = strings.TrimSpace(tbl[:c])
 					}
 					if !isTableAllowed(tbl) {
-						allowedDbs := getAllowedDatabases()
-						return fmt.Errorf("clusterAllReplicas must target tables from allowed databases: %v", allowedDbs)
+						return tableNotAllowed("clusterAllReplicas must target tables from allowed databases: %v")
 					}
 				}
 			}
@@ -421,8 +1037,7 @@ func validateTableRefs(sanitized string, cteNames map[string]struct{}) error {
 			}
 			// Raw table reference must be in allowed databases
 			if !isTableAllowed(ref) {
-				allowedDbs := getAllowedDatabases()
-				return fmt.Errorf("only tables from allowed databases %v are allowed (found: %s)", allowedDbs, ref)
+				return tableNotAllowed("only tables from allowed databases %[2]v are allowed (found: %[1]s)", ref)
 			}
 		}
 		i = end
@@ -473,6 +1088,99 @@ func isIdentChar(b byte) bool {
 	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b == '_'
 }
 
+// sqlKeywords are lowercased by normalizeSQL so the validator's keyword scans
+// and logged queries look the same regardless of how the caller cased them.
+var sqlKeywords = map[string]struct{}{
+	"select": {}, "from": {}, "where": {}, "prewhere": {}, "with": {}, "as": {},
+	"join": {}, "left": {}, "right": {}, "inner": {}, "outer": {}, "full": {},
+	"cross": {}, "array": {}, "global": {}, "any": {}, "on": {}, "using": {},
+	"group": {}, "by": {}, "order": {}, "having": {}, "limit": {}, "offset": {},
+	"union": {}, "all": {}, "distinct": {}, "and": {}, "or": {}, "not": {},
+	"in": {}, "is": {}, "null": {}, "like": {}, "ilike": {}, "between": {},
+	"case": {}, "when": {}, "then": {}, "else": {}, "end": {}, "asc": {},
+	"desc": {}, "interval": {}, "final": {}, "sample": {}, "settings": {},
+	"format": {}, "into": {}, "values": {}, "insert": {}, "alter": {},
+	"update": {}, "delete": {}, "attach": {}, "detach": {}, "drop": {},
+	"create": {}, "truncate": {}, "kill": {}, "optimize": {}, "grant": {},
+	"revoke": {}, "set": {}, "use": {},
+}
+
+// normalizeSQL collapses runs of whitespace (tabs, newlines, repeated spaces)
+// into a single space and lowercases keywords, leaving quoted literals and
+// backtick-quoted identifiers untouched. The result is used for validation and
+// logging only; the original SQL is what gets executed.
+func normalizeSQL(sql string) string {
+	var b strings.Builder
+	s := strings.TrimSpace(sql)
+	pendingSpace := false
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case isSpace(ch):
+			pendingSpace = true
+			i++
+			continue
+		case ch == '\'' || ch == '"' || ch == '`':
+			// Copy the literal verbatim, including an unterminated tail.
+			end := strings.IndexByte(s[i+1:], ch)
+			if end < 0 {
+				end = len(s)
+			} else {
+				end += i + 2
+			}
+			if pendingSpace {
+				b.WriteByte(' ')
+				pendingSpace = false
+			}
+			b.WriteString(s[i:end])
+			i = end
+			continue
+		}
+		if pendingSpace {
+			b.WriteByte(' ')
+			pendingSpace = false
+		}
+		if !isIdentChar(ch) {
+			b.WriteByte(ch)
+			i++
+			continue
+		}
+		start := i
+		for i < len(s) && isIdentChar(s[i]) {
+			i++
+		}
+		word := s[start:i]
+		if _, ok := sqlKeywords[strings.ToLower(word)]; ok {
+			word = strings.ToLower(word)
+		}
+		b.WriteString(word)
+	}
+	return b.String()
+}
+
+// stripStringLiterals blanks single-quoted string literals in s, keeping
+// double-quoted and backtick-quoted identifiers.
+func stripStringLiterals(s string) string {
+	var b strings.Builder
+	var quote byte // the open quote, or 0 outside quotes
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		inString := quote == '\''
+		switch {
+		case quote == 0 && (ch == '\'' || ch == '"' || ch == '`'):
+			quote = ch
+			inString = ch == '\''
+		case ch == quote:
+			quote = 0
+		}
+		if inString {
+			ch = ' '
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
 func stripQuotedLiterals(s string) string {
 	var b strings.Builder
 	inSingle, inDouble := false, false
@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"math/big"
+	"net"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/chcol"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 	"github.com/spf13/viper"
 )
 
@@ -105,6 +114,18 @@ func TestValidateQueryArgs(t *testing.T) {
 			wantErr: true,
 			errMsg:  "only SELECT/WITH",
 		},
+		{
+			name:    "final with free-form SQL",
+			args:    queryArgs{SQL: "SELECT * FROM models.predictions", Final: true},
+			wantErr: true,
+			errMsg:  "structured queries",
+		},
+		{
+			name:    "sample out of range",
+			args:    queryArgs{Table: "models.predictions", Sample: 1.5},
+			wantErr: true,
+			errMsg:  "sample must be between 0 and 1",
+		},
 		{
 			name: "SQL with multiple statements",
 			args: queryArgs{
@@ -213,6 +234,50 @@ func TestIsTableAllowed(t *testing.T) {
 	}
 }
 
+func TestIsTableAllowedPatterns(t *testing.T) {
+	viper.Set("clickhouse.allowed_databases", []string{"system", "app_*", "metrics_?", "[ab]_logs"})
+	defer viper.Set("clickhouse.allowed_databases", nil)
+	defer viper.Set("clickhouse.case_sensitive_databases", nil)
+
+	tests := []struct {
+		name          string
+		table         string
+		caseSensitive bool
+		want          bool
+	}{
+		{name: "exact", table: "system.parts", want: true},
+		{name: "star", table: "app_billing.events", want: true},
+		{name: "star matches empty suffix", table: "app_.events", want: true},
+		{name: "star needs prefix", table: "myapp_billing.events", want: false},
+		{name: "question mark", table: "metrics_1.samples", want: true},
+		{name: "question mark is one character", table: "metrics_12.samples", want: false},
+		{name: "character class", table: "b_logs.lines", want: true},
+		{name: "character class miss", table: "c_logs.lines", want: false},
+		{name: "pattern ignores case", table: "APP_Billing.events", want: true},
+		{name: "exact ignores case", table: "System.parts", want: true},
+		{name: "case-sensitive exact", table: "System.parts", caseSensitive: true, want: false},
+		{name: "case-sensitive pattern", table: "APP_billing.events", caseSensitive: true, want: false},
+		{name: "case-sensitive match", table: "app_billing.events", caseSensitive: true, want: true},
+		{name: "no database", table: "app_billing", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("clickhouse.case_sensitive_databases", tt.caseSensitive)
+			if got := isTableAllowed(tt.table); got != tt.want {
+				t.Errorf("isTableAllowed(%q) = %v, want %v", tt.table, got, tt.want)
+			}
+		})
+	}
+
+	if err := validateFreeformSQL("SELECT * FROM app_billing.events JOIN metrics_1.samples USING (id)"); err != nil {
+		t.Errorf("validateFreeformSQL() with pattern-matched tables = %v", err)
+	}
+	if err := validateFreeformSQL("SELECT * FROM billing.events"); err == nil {
+		t.Error("validateFreeformSQL() accepted a table outside the patterns")
+	}
+}
+
 func TestValidateFreeformSQL(t *testing.T) {
 	viper.Set("clickhouse.allowed_databases", []string{"system"})
 
@@ -320,6 +385,116 @@ func TestValidateFreeformSQL(t *testing.T) {
 			sql:     "SELECT * FROM system.query_log WHERE query_id IN (SELECT query_id FROM system.processes)",
 			wantErr: false,
 		},
+		{
+			name:    "multiline query with allowed table",
+			sql:     "SELECT\n\tquery_id,\n\tquery_duration_ms\nFROM system.query_log\nWHERE query_duration_ms > 1000",
+			wantErr: false,
+		},
+		{
+			name:    "newline before FROM still validates table",
+			sql:     "SELECT *\nFROM users.data",
+			wantErr: true,
+			errMsg:  "only tables from allowed databases",
+		},
+		{
+			name:    "tab before JOIN still validates table",
+			sql:     "SELECT * FROM system.query_log q\tJOIN users.data d ON q.query_id = d.id",
+			wantErr: true,
+			errMsg:  "only tables from allowed databases",
+		},
+		{
+			name:    "leading newline and mixed-case SELECT",
+			sql:     "\n  SeLeCt count()\r\n  FROM system.parts",
+			wantErr: false,
+		},
+		{
+			name:    "column containing drop is not a keyword",
+			sql:     "SELECT dropped_parts FROM system.parts",
+			wantErr: false,
+		},
+		{
+			name:    "alias containing use is not a keyword",
+			sql:     "SELECT count() AS use_count FROM system.query_log",
+			wantErr: false,
+		},
+		{
+			name:    "useragent column",
+			sql:     "SELECT http_user_agent AS useragent FROM system.query_log WHERE useragent != ''",
+			wantErr: false,
+		},
+		{
+			name:    "settings table and alias",
+			sql:     "SELECT name AS settings, value FROM system.settings WHERE changed",
+			wantErr: false,
+		},
+		{
+			name:    "qualified column named like a keyword",
+			sql:     "SELECT m.update FROM system.mutations m",
+			wantErr: false,
+		},
+		{
+			name:    "keyword after comma is still caught",
+			sql:     "SELECT 1 FROM system.one,drop",
+			wantErr: true,
+			errMsg:  "forbidden keyword detected: drop",
+		},
+		{
+			name:    "keyword after parenthesis is still caught",
+			sql:     "SELECT * FROM system.one WHERE 1 IN (kill)",
+			wantErr: true,
+			errMsg:  "forbidden keyword detected: kill",
+		},
+		{
+			name:    "keyword inside string literal is ignored",
+			sql:     "SELECT * FROM system.query_log WHERE query LIKE '%DROP TABLE%'",
+			wantErr: false,
+		},
+		{
+			name:    "forbidden keyword after newline",
+			sql:     "SELECT * FROM system.query_log\nSETTINGS readonly = 0\nSET x = 1",
+			wantErr: true,
+			errMsg:  "forbidden keyword detected: set",
+		},
+		{
+			name:    "inline protected setting",
+			sql:     "SELECT * FROM system.parts SETTINGS allow_introspection_functions = 1",
+			wantErr: true,
+			errMsg:  "SETTINGS clauses are not allowed",
+		},
+		{
+			name:    "inline setting in a subquery",
+			sql:     "SELECT count() FROM (SELECT * FROM system.parts settings max_threads=64)",
+			wantErr: true,
+			errMsg:  "SETTINGS clauses are not allowed",
+		},
+		{
+			name:    "inline setting with a backtick-quoted name",
+			sql:     "SELECT * FROM system.parts SETTINGS `allow_introspection_functions`=1",
+			wantErr: true,
+			errMsg:  "SETTINGS clauses are not allowed",
+		},
+		{
+			name:    "inline setting with a double-quoted name",
+			sql:     `SELECT * FROM system.parts SETTINGS "allow_introspection_functions" = 1`,
+			wantErr: true,
+			errMsg:  "SETTINGS clauses are not allowed",
+		},
+		{
+			name:    "inline setting after an identifier holding a quote",
+			sql:     `SELECT 1 AS "it's" FROM system.parts SETTINGS allow_introspection_functions = 1`,
+			wantErr: true,
+			errMsg:  "SETTINGS clauses are not allowed",
+		},
+		{
+			name:    "settings clause inside a string literal",
+			sql:     "SELECT count() FROM system.query_log WHERE query LIKE '%SETTINGS max_threads = 1%'",
+			wantErr: false,
+		},
+		{
+			name:    "settings map column",
+			sql:     "SELECT Settings['max_threads'] AS threads FROM system.query_log WHERE Settings['max_threads'] != ''",
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -337,6 +512,72 @@ func TestValidateFreeformSQL(t *testing.T) {
 	}
 }
 
+func TestValidateFreeformSQLLimits(t *testing.T) {
+	viper.Set("clickhouse.allowed_databases", []string{"system"})
+	viper.Set("clickhouse.max_sql_length", 200)
+	viper.Set("clickhouse.max_sql_nesting", 3)
+	defer viper.Set("clickhouse.max_sql_length", nil)
+	defer viper.Set("clickhouse.max_sql_nesting", nil)
+
+	tests := []struct {
+		name    string
+		sql     string
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "within bounds",
+			sql:     "SELECT count() FROM system.query_log WHERE toDate(event_time) = today()",
+			wantErr: false,
+		},
+		{
+			name:    "too long",
+			sql:     "SELECT " + strings.Repeat("1 + ", 60) + "1 FROM system.one",
+			wantErr: true,
+			errMsg:  "sql is too long",
+		},
+		{
+			name:    "at nesting limit",
+			sql:     "SELECT ((abs(1))) FROM system.one",
+			wantErr: false,
+		},
+		{
+			name:    "too deeply nested",
+			sql:     "SELECT (((abs(1)))) FROM system.one",
+			wantErr: true,
+			errMsg:  "nested too deeply",
+		},
+		{
+			name:    "parentheses inside literals don't count",
+			sql:     "SELECT * FROM system.query_log WHERE query = '(((((('",
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateFreeformSQL(tt.sql)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateFreeformSQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && tt.errMsg != "" && !contains(err.Error(), tt.errMsg) {
+				t.Errorf("validateFreeformSQL() error message = %v, want to contain %v", err.Error(), tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestSQLLimitDefaults(t *testing.T) {
+	viper.Set("clickhouse.max_sql_length", nil)
+	viper.Set("clickhouse.max_sql_nesting", nil)
+	if got := maxSQLLength(); got != defaultMaxSQLLength {
+		t.Errorf("maxSQLLength() = %d, want %d", got, defaultMaxSQLLength)
+	}
+	if got := maxSQLNesting(); got != defaultMaxSQLNesting {
+		t.Errorf("maxSQLNesting() = %d, want %d", got, defaultMaxSQLNesting)
+	}
+}
+
 func TestStripQuotedLiterals(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -374,6 +615,58 @@ func TestStripQuotedLiterals(t *testing.T) {
 	}
 }
 
+func TestNormalizeSQL(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "collapses tabs and newlines",
+			input: "SELECT\tquery_id,\n\n  event_time\r\nFROM   system.query_log",
+			want:  "select query_id, event_time from system.query_log",
+		},
+		{
+			name:  "trims surrounding whitespace",
+			input: "\n\t SELECT 1 \n",
+			want:  "select 1",
+		},
+		{
+			name:  "lowercases keywords but not identifiers",
+			input: "SELECT Name FROM system.Tables WHERE Engine = 1 ORDER BY Name DESC",
+			want:  "select Name from system.Tables where Engine = 1 order by Name desc",
+		},
+		{
+			name:  "preserves single-quoted literals",
+			input: "SELECT * FROM system.query_log WHERE query = 'SELECT   1\nFROM X'",
+			want:  "select * from system.query_log where query = 'SELECT   1\nFROM X'",
+		},
+		{
+			name:  "preserves double-quoted and backtick identifiers",
+			input: "SELECT \"My  Col\", `Other\tCol` FROM system.one",
+			want:  "select \"My  Col\", `Other\tCol` from system.one",
+		},
+		{
+			name:  "keyword prefix inside identifier is untouched",
+			input: "SELECT Selected_Count FROM system.one",
+			want:  "select Selected_Count from system.one",
+		},
+		{
+			name:  "unterminated literal is kept",
+			input: "SELECT 'abc",
+			want:  "select 'abc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeSQL(tt.input); got != tt.want {
+				t.Errorf("normalizeSQL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestNormalizeValue(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -415,6 +708,11 @@ func TestNormalizeValue(t *testing.T) {
 			value: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
 			want:  "2024-01-01T12:00:00Z",
 		},
+		{
+			name:  "time value in another zone is converted to UTC",
+			value: time.Date(2024, 1, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*60*60)),
+			want:  "2024-01-01T12:00:00Z",
+		},
 		{
 			name:  "slice of ints",
 			value: []int{1, 2, 3},
@@ -449,6 +747,159 @@ func TestNormalizeValue(t *testing.T) {
 	}
 }
 
+func TestNormalizeValueComplexTypes(t *testing.T) {
+	name := "alice"
+	var nilName *string
+	variant := chcol.NewVariant(int64(7))
+	uint128Max, _ := new(big.Int).SetString("340282366920938463463374607431768211455", 10)
+	int256Min, _ := new(big.Int).SetString("-57896044618658097711785492504343953926634992332820282019728792003956564819968", 10)
+	bigPtr, _ := new(big.Int).SetString("170141183460469231731687303715884105727", 10)
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{
+			name:  "UInt128 beyond float64 precision",
+			value: uint128Max,
+			want:  "340282366920938463463374607431768211455",
+		},
+		{
+			name:  "Int256 negative",
+			value: int256Min,
+			want:  "-57896044618658097711785492504343953926634992332820282019728792003956564819968",
+		},
+		{
+			name:  "nullable Int128",
+			value: &bigPtr,
+			want:  "170141183460469231731687303715884105727",
+		},
+		{
+			name:  "nil big int",
+			value: (*big.Int)(nil),
+			want:  nil,
+		},
+		{
+			name:  "Decimal128 keeps all digits and scale",
+			value: decimal.RequireFromString("12345678901234567890.1234567890"),
+			want:  "12345678901234567890.1234567890",
+		},
+		{
+			name:  "Decimal with trailing zeros",
+			value: decimal.New(1250, -2),
+			want:  "12.50",
+		},
+		{
+			name:  "Array of Decimal",
+			value: []decimal.Decimal{decimal.New(1, -1), decimal.New(99999999999999999, -3)},
+			want:  []interface{}{"0.1", "99999999999999.999"},
+		},
+		{
+			name:  "UUID",
+			value: uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8"),
+			want:  "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+		},
+		{
+			name:  "IPv4",
+			value: net.ParseIP("10.0.0.1").To4(),
+			want:  "10.0.0.1",
+		},
+		{
+			name:  "IPv6",
+			value: net.ParseIP("2001:db8::1"),
+			want:  "2001:db8::1",
+		},
+		{
+			name:  "Map with string keys",
+			value: map[string]uint64{"a": 1},
+			want:  map[string]interface{}{"a": uint64(1)},
+		},
+		{
+			name:  "Map with integer keys",
+			value: map[int32]string{1: "one", 2: "two"},
+			want:  map[string]interface{}{"1": "one", "2": "two"},
+		},
+		{
+			name:  "Map with UUID keys",
+			value: map[uuid.UUID]int64{uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8"): 3},
+			want:  map[string]interface{}{"6ba7b810-9dad-11d1-80b4-00c04fd430c8": int64(3)},
+		},
+		{
+			name:  "unnamed Tuple",
+			value: []interface{}{"x", uint8(1), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			want:  []interface{}{"x", uint64(1), "2024-01-01T00:00:00Z"},
+		},
+		{
+			name:  "named Tuple",
+			value: map[string]interface{}{"host": "h1", "ip": net.ParseIP("10.0.0.2").To4()},
+			want:  map[string]interface{}{"host": "h1", "ip": "10.0.0.2"},
+		},
+		{
+			name:  "Nested",
+			value: []map[string]interface{}{{"k": "a", "v": int32(1)}, {"k": "b", "v": int32(2)}},
+			want:  []interface{}{map[string]interface{}{"k": "a", "v": int64(1)}, map[string]interface{}{"k": "b", "v": int64(2)}},
+		},
+		{
+			name:  "Array(Nullable(String))",
+			value: []*string{&name, nilName},
+			want:  []interface{}{"alice", nil},
+		},
+		{
+			name:  "nil pointer",
+			value: nilName,
+			want:  nil,
+		},
+		{
+			name:  "Variant",
+			value: variant,
+			want:  int64(7),
+		},
+		{
+			name:  "NULL Variant",
+			value: chcol.NewVariant(nil),
+			want:  nil,
+		},
+		{
+			name:  "LowCardinality / Enum scan as string",
+			value: "Active",
+			want:  "Active",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := normalizeValue(tt.value)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeValue() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeColumnValue(t *testing.T) {
+	ts := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		dbType string
+		value  interface{}
+		want   interface{}
+	}{
+		{dbType: "Date", value: ts, want: "2026-10-15"},
+		{dbType: "Date32", value: ts, want: "2026-10-15"},
+		{dbType: "Nullable(Date)", value: ts, want: "2026-10-15"},
+		{dbType: "LowCardinality(Nullable(Date))", value: ts, want: "2026-10-15"},
+		{dbType: "DateTime", value: ts, want: "2026-10-15T00:00:00Z"},
+		{dbType: "DateTime64(3, 'UTC')", value: ts, want: "2026-10-15T00:00:00Z"},
+		{dbType: "Date", value: "not a time", want: "not a time"},
+		{dbType: "UInt64", value: uint64(7), want: uint64(7)},
+	}
+	for _, tt := range tests {
+		if got := normalizeColumnValue(tt.dbType, tt.value); got != tt.want {
+			t.Errorf("normalizeColumnValue(%q, %v) = %#v, want %#v", tt.dbType, tt.value, got, tt.want)
+		}
+	}
+}
+
 func TestQueryBuilding(t *testing.T) {
 	// Set up test configuration
 	viper.Set("clickhouse.cluster", "test_cluster")
@@ -479,43 +930,357 @@ func TestQueryBuilding(t *testing.T) {
 			},
 			wantQuery: "FROM models.predictions",
 		},
+		{
+			name: "final on replicated system table",
+			args: queryArgs{
+				Table:   "system.query_log",
+				Columns: []string{"query"},
+				Where:   "type = 2",
+				Final:   true,
+			},
+			wantQuery: "FROM clusterAllReplicas(test_cluster, system.query_log) FINAL WHERE type = 2",
+		},
+		{
+			name: "final and sample on table",
+			args: queryArgs{
+				Table:   "models.predictions",
+				Columns: []string{"id"},
+				Final:   true,
+				Sample:  0.1,
+				Limit:   5,
+			},
+			wantQuery: "FROM models.predictions FINAL SAMPLE 0.1 LIMIT 5",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// We can't easily test runClickhouseQuery without a connection,
-			// but we can verify the query building logic by checking what
-			// query would be generated
-			
-			// Build the query string similar to how runClickhouseQuery does it
-			var sb strings.Builder
-			sb.WriteString("SELECT ")
-			if len(tt.args.Columns) > 0 {
-				sb.WriteString(strings.Join(tt.args.Columns, ", "))
-			} else {
-				sb.WriteString("*")
+			query := buildQuery(tt.args)
+			if !contains(query, tt.wantQuery) {
+				t.Errorf("Query building: got query = %v, want to contain %v", query, tt.wantQuery)
 			}
-			
-			// Only use clusterAllReplicas for system tables
-			if strings.HasPrefix(strings.ToLower(tt.args.Table), "system.") {
-				cluster := viper.GetString("clickhouse.cluster")
-				sb.WriteString(" FROM clusterAllReplicas(" + cluster + ", " + tt.args.Table + ")")
-			} else {
-				sb.WriteString(" FROM " + tt.args.Table)
+		})
+	}
+}
+
+func TestDefaultColumns(t *testing.T) {
+	viper.Set("clickhouse.cluster", "test_cluster")
+	defer viper.Set("clickhouse.default_columns", nil)
+	viper.Set("clickhouse.default_columns", map[string]interface{}{
+		"system.parts":     []interface{}{},
+		"models.events":    []interface{}{"id", "ts"},
+		"system":           map[string]interface{}{"merges": []interface{}{"table", "progress"}},
+		"system.query_log": []interface{}{"event_time", "query_id"},
+	})
+
+	tests := []struct {
+		name      string
+		args      queryArgs
+		wantQuery string
+	}{
+		{
+			name:      "built-in set",
+			args:      queryArgs{Table: "system.errors"},
+			wantQuery: "SELECT hostName() AS host, name, code, value, last_error_time, last_error_message, remote FROM clusterAllReplicas(test_cluster, system.errors)",
+		},
+		{
+			name:      "configured set replaces built-in",
+			args:      queryArgs{Table: "system.query_log"},
+			wantQuery: "SELECT event_time, query_id FROM",
+		},
+		{
+			name:      "nested config key",
+			args:      queryArgs{Table: "system.merges"},
+			wantQuery: "SELECT table, progress FROM",
+		},
+		{
+			name:      "empty configured set selects star",
+			args:      queryArgs{Table: "system.parts"},
+			wantQuery: "SELECT * FROM",
+		},
+		{
+			name:      "configured non-system table",
+			args:      queryArgs{Table: "models.events"},
+			wantQuery: "SELECT id, ts FROM models.events",
+		},
+		{
+			name:      "unknown table selects star",
+			args:      queryArgs{Table: "system.disks"},
+			wantQuery: "SELECT * FROM",
+		},
+		{
+			name:      "explicit columns win",
+			args:      queryArgs{Table: "system.errors", Columns: []string{"name"}},
+			wantQuery: "SELECT name FROM",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if query := buildQuery(tt.args); !strings.HasPrefix(query, tt.wantQuery) {
+				t.Errorf("buildQuery() = %q, want prefix %q", query, tt.wantQuery)
 			}
-			
-			if tt.args.Where != "" {
-				sb.WriteString(" WHERE ")
-				sb.WriteString(tt.args.Where)
+		})
+	}
+}
+
+// clusterFallbackConn fails any query using clusterAllReplicas with
+// CLUSTER_DOESNT_EXIST and records every query it receives.
+type clusterFallbackConn struct {
+	MockConn
+	queries []string
+}
+
+func (c *clusterFallbackConn) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
+	c.queries = append(c.queries, query)
+	if strings.Contains(query, "clusterAllReplicas") {
+		return nil, &clickhouse.Exception{Code: clusterNotFoundCode, Name: "DB::Exception", Message: "Requested cluster 'test_cluster' not found"}
+	}
+	return &MockRows{}, nil
+}
+
+func TestQueryClickhouseClusterFallback(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		wantQueries []string
+		wantErr     bool
+	}{
+		{
+			name:  "structured query falls back to local table",
+			query: "SELECT name FROM clusterAllReplicas(test_cluster, system.tables) LIMIT 5",
+			wantQueries: []string{
+				"SELECT name FROM clusterAllReplicas(test_cluster, system.tables) LIMIT 5",
+				"SELECT name FROM system.tables LIMIT 5",
+			},
+		},
+		{
+			name:  "free-form query with quoted cluster falls back",
+			query: "SELECT count() FROM clusterAllReplicas('test_cluster', system.parts) WHERE active",
+			wantQueries: []string{
+				"SELECT count() FROM clusterAllReplicas('test_cluster', system.parts) WHERE active",
+				"SELECT count() FROM system.parts WHERE active",
+			},
+		},
+		{
+			name:        "local query is not retried",
+			query:       "SELECT 1",
+			wantQueries: []string{"SELECT 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &clusterFallbackConn{}
+			_, err := queryClickhouse(context.Background(), conn, tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("queryClickhouse() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			if tt.args.Limit > 0 {
-				fmt.Fprintf(&sb, " LIMIT %d", tt.args.Limit)
+			if !equalSlices(conn.queries, tt.wantQueries) {
+				t.Errorf("queries = %q, want %q", conn.queries, tt.wantQueries)
 			}
-			
-			query := sb.String()
-			
-			if !contains(query, tt.wantQuery) {
-				t.Errorf("Query building: got query = %v, want to contain %v", query, tt.wantQuery)
+		})
+	}
+}
+
+// failingConn fails every query with err.
+type failingConn struct {
+	MockConn
+	err error
+}
+
+func (c *failingConn) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
+	return nil, c.err
+}
+
+func TestQueryClickhouseUnavailableReplicaHint(t *testing.T) {
+	defer viper.Set("clickhouse.skip_unavailable_shards", nil)
+	conn := &failingConn{err: &clickhouse.Exception{Code: allConnectionsFailedCode, Message: "All connection tries failed"}}
+	query := "SELECT count() FROM clusterAllReplicas(c, system.parts)"
+
+	viper.Set("clickhouse.skip_unavailable_shards", false)
+	_, err := queryClickhouse(context.Background(), conn, query)
+	if err == nil || !strings.Contains(err.Error(), "skip_unavailable_shards") {
+		t.Errorf("queryClickhouse() error = %v, want skip_unavailable_shards hint", err)
+	}
+	if !hasErrorCode(err, allConnectionsFailedCode) {
+		t.Errorf("queryClickhouse() error no longer wraps the ClickHouse exception: %v", err)
+	}
+
+	viper.Set("clickhouse.skip_unavailable_shards", true)
+	_, err = queryClickhouse(context.Background(), conn, query)
+	if err == nil || strings.Contains(err.Error(), "hint:") {
+		t.Errorf("queryClickhouse() error = %v, want the plain error when skipping is already enabled", err)
+	}
+}
+
+// unavailableReplicaConn answers a query and the system.clusters lookup that
+// follows it, recording the lookup's query_id and whether the query's rows were
+// closed by then.
+type unavailableReplicaConn struct {
+	MockConn
+	rows           *closeTrackingRows
+	queryID        string
+	lookupQueryID  string
+	closedAtLookup bool
+}
+
+func (c *unavailableReplicaConn) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
+	if strings.Contains(query, "system.clusters") {
+		c.lookupQueryID = queryIDFrom(ctx)
+		c.closedAtLookup = c.rows.closed
+		return &hostRows{hosts: []string{"ch2"}}, nil
+	}
+	c.queryID = queryIDFrom(ctx)
+	c.rows = &closeTrackingRows{}
+	return c.rows, nil
+}
+
+// closeTrackingRows is an empty result that records whether it was closed.
+type closeTrackingRows struct {
+	MockRows
+	closed bool
+}
+
+func (r *closeTrackingRows) Close() error {
+	r.closed = true
+	return nil
+}
+
+// hostRows returns one (host_name, port) row per host.
+type hostRows struct {
+	MockRows
+	hosts []string
+}
+
+func (r *hostRows) Next() bool {
+	r.currentRow++
+	return r.currentRow <= len(r.hosts)
+}
+
+func (r *hostRows) Scan(dest ...interface{}) error {
+	*dest[0].(*string) = r.hosts[r.currentRow-1]
+	*dest[1].(*uint16) = 9000
+	return nil
+}
+
+func TestExecQueryUnavailableReplicas(t *testing.T) {
+	viper.Set("clickhouse.skip_unavailable_shards", true)
+	defer viper.Set("clickhouse.skip_unavailable_shards", nil)
+
+	conn := &unavailableReplicaConn{}
+	res, err := execQuery(context.Background(), conn, "SELECT count() FROM clusterAllReplicas(c, system.parts)")
+	if err != nil {
+		t.Fatalf("execQuery() error = %v", err)
+	}
+	if !reflect.DeepEqual(res.UnavailableReplicas, []string{"ch2:9000"}) {
+		t.Errorf("UnavailableReplicas = %v, want [ch2:9000]", res.UnavailableReplicas)
+	}
+	if !conn.closedAtLookup {
+		t.Error("system.clusters was queried while the query's rows were still open")
+	}
+	if conn.lookupQueryID == "" || conn.lookupQueryID == conn.queryID {
+		t.Errorf("system.clusters lookup ran under query_id %q, want a fresh one (query ran under %q)", conn.lookupQueryID, conn.queryID)
+	}
+	if res.QueryID != conn.queryID {
+		t.Errorf("QueryID = %q, want the query's %q", res.QueryID, conn.queryID)
+	}
+}
+
+func TestQueryClickhouseScanLimitHint(t *testing.T) {
+	for _, code := range []int32{tooManyRowsCode, tooManyBytesCode} {
+		conn := &failingConn{err: &clickhouse.Exception{Code: code, Message: "Limit for rows or bytes to read exceeded"}}
+		_, err := queryClickhouse(context.Background(), conn, "SELECT * FROM system.query_log LIMIT 10")
+		if err == nil || !strings.Contains(err.Error(), "add a WHERE") {
+			t.Errorf("code %d: queryClickhouse() error = %v, want WHERE hint", code, err)
+		}
+		if !hasErrorCode(err, code) {
+			t.Errorf("code %d: queryClickhouse() error no longer wraps the ClickHouse exception: %v", code, err)
+		}
+	}
+}
+
+// unknownColumnConn fails any query that mentions missing_col, as ClickHouse
+// does for an unknown identifier, and records the queries it was sent.
+type unknownColumnConn struct {
+	MockConn
+	queries []string
+}
+
+func (c *unknownColumnConn) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
+	c.queries = append(c.queries, query)
+	if strings.Contains(query, "missing_col") {
+		return nil, &clickhouse.Exception{Code: 47, Message: "Unknown expression identifier `missing_col`"}
+	}
+	return &MockRows{}, nil
+}
+
+func TestQueryClickhouseValidateWithExplain(t *testing.T) {
+	defer viper.Set("clickhouse.validate_with_explain", nil)
+	viper.Set("clickhouse.validate_with_explain", true)
+
+	conn := &unknownColumnConn{}
+	_, err := queryClickhouse(context.Background(), conn, "SELECT missing_col FROM system.parts")
+	if err == nil || !strings.Contains(err.Error(), "failed validation") || !hasErrorCode(err, 47) {
+		t.Errorf("queryClickhouse() error = %v, want validation error wrapping code 47", err)
+	}
+	if !equalSlices(conn.queries, []string{"EXPLAIN SELECT missing_col FROM system.parts"}) {
+		t.Errorf("queries = %q, want only the EXPLAIN", conn.queries)
+	}
+
+	conn = &unknownColumnConn{}
+	if _, err := queryClickhouse(context.Background(), conn, "SELECT name FROM system.parts"); err != nil {
+		t.Fatalf("queryClickhouse() error = %v", err)
+	}
+	if !equalSlices(conn.queries, []string{"EXPLAIN SELECT name FROM system.parts", "SELECT name FROM system.parts"}) {
+		t.Errorf("queries = %q, want EXPLAIN then the query", conn.queries)
+	}
+
+	// A missing cluster is left to the local-table fallback.
+	fallback := &clusterFallbackConn{}
+	if _, err := queryClickhouse(context.Background(), fallback, "SELECT 1 FROM clusterAllReplicas(test_cluster, system.one)"); err != nil {
+		t.Fatalf("queryClickhouse() error = %v", err)
+	}
+	if got := fallback.queries[len(fallback.queries)-1]; got != "SELECT 1 FROM system.one" {
+		t.Errorf("last query = %q, want the local fallback", got)
+	}
+}
+
+func TestClusterNames(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "SELECT 1", want: nil},
+		{query: "SELECT * FROM clusterAllReplicas(main, system.parts)", want: []string{"main"}},
+		{
+			query: "SELECT * FROM clusterAllReplicas('main', system.parts) p JOIN clusterAllReplicas('main', system.tables) t USING (table) JOIN clusterAllReplicas(other, system.disks) d ON 1",
+			want:  []string{"main", "other"},
+		},
+	}
+	for _, tt := range tests {
+		if got := clusterNames(tt.query); !equalSlices(got, tt.want) {
+			t.Errorf("clusterNames(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestIsClusterNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "cluster exception", err: &clickhouse.Exception{Code: clusterNotFoundCode}, want: true},
+		{name: "wrapped cluster exception", err: fmt.Errorf("%w (query_id: x)", &clickhouse.Exception{Code: clusterNotFoundCode}), want: true},
+		{name: "other exception", err: &clickhouse.Exception{Code: 60}, want: false},
+		{name: "plain error naming the code", err: fmt.Errorf("code: 170, CLUSTER_DOESNT_EXIST"), want: true},
+		{name: "unrelated error", err: fmt.Errorf("connection refused"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isClusterNotFound(tt.err); got != tt.want {
+				t.Errorf("isClusterNotFound() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -536,4 +1301,95 @@ func equalSlices(a, b []string) bool {
 		}
 	}
 	return true
-}
\ No newline at end of file
+}
+
+// cancelledConn blocks each query until its context is done and records the
+// statements passed to Exec.
+type cancelledConn struct {
+	MockConn
+	execs [][]interface{}
+}
+
+func (c *cancelledConn) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c *cancelledConn) Exec(ctx context.Context, query string, args ...interface{}) error {
+	c.execs = append(c.execs, append([]interface{}{query}, args...))
+	return nil
+}
+
+func TestExecQueryKillOnCancel(t *testing.T) {
+	defer viper.Set("clickhouse.kill_on_cancel", nil)
+
+	for _, enabled := range []bool{true, false} {
+		viper.Set("clickhouse.kill_on_cancel", enabled)
+		conn := &cancelledConn{}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := execQuery(ctx, conn, "SELECT sleep(3)")
+		if err == nil {
+			t.Fatalf("kill_on_cancel=%v: execQuery() succeeded on a cancelled context", enabled)
+		}
+		if !enabled {
+			if len(conn.execs) != 0 {
+				t.Errorf("kill_on_cancel=false: Exec called %v", conn.execs)
+			}
+			continue
+		}
+		if len(conn.execs) != 1 {
+			t.Fatalf("kill_on_cancel=true: Exec called %d times, want 1", len(conn.execs))
+		}
+		kill := conn.execs[0]
+		if !strings.HasPrefix(kill[0].(string), "KILL QUERY WHERE query_id = ?") {
+			t.Errorf("Exec query = %q, want KILL QUERY", kill[0])
+		}
+		if len(kill) != 2 || !strings.Contains(err.Error(), fmt.Sprintf("query_id: %s", kill[1])) {
+			t.Errorf("KILL args = %v, want the query_id from error %q", kill[1:], err)
+		}
+	}
+}
+
+func TestTruncateCells(t *testing.T) {
+	long := strings.Repeat("x", 12)
+	rows := []map[string]interface{}{
+		{"query": long, "short": "abc", "n": int64(5), "null": nil},
+		{"query": "héllo wörld", "tags": []interface{}{long, "ok"}, "settings": map[string]interface{}{"a": long}},
+	}
+	if got := truncateCells(rows, 5); got != 4 {
+		t.Errorf("truncateCells() = %d, want 4", got)
+	}
+	want := []map[string]interface{}{
+		{"query": "xxxxx" + truncatedMarker, "short": "abc", "n": int64(5), "null": nil},
+		{"query": "héllo" + truncatedMarker, "tags": []interface{}{"xxxxx" + truncatedMarker, "ok"}, "settings": map[string]interface{}{"a": "xxxxx" + truncatedMarker}},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("truncateCells() rows = %v, want %v", rows, want)
+	}
+
+	untouched := []map[string]interface{}{{"query": long}}
+	if got := truncateCells(untouched, 0); got != 0 || untouched[0]["query"] != long {
+		t.Errorf("truncateCells(max=0) = %d, %v", got, untouched)
+	}
+}
+
+func TestMaxCellLength(t *testing.T) {
+	if got := maxCellLength(); got != defaultMaxCellLength {
+		t.Errorf("maxCellLength() unset = %d, want %d", got, defaultMaxCellLength)
+	}
+	viper.Set("clickhouse.max_cell_length", 0)
+	defer viper.Set("clickhouse.max_cell_length", nil)
+	if got := maxCellLength(); got != 0 {
+		t.Errorf("maxCellLength() = %d, want 0", got)
+	}
+}
+
+func TestSummarizeQueryResultTruncated(t *testing.T) {
+	res := &queryResult{Results: []map[string]interface{}{{"query": "SELECT 1" + truncatedMarker}}, Count: 1, TruncatedCells: 1}
+	got := summarizeQueryResult(res)
+	if !strings.Contains(got, "note: 1 long string value(s) truncated; repeat with verbose: true for full values") {
+		t.Errorf("summarizeQueryResult() = %q", got)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/net/proxy"
+)
+
+// dialFunc matches clickhouse.Options.DialContext.
+type dialFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+// clickhouseDialer returns a DialContext that reaches ClickHouse through
+// clickhouse.proxy_url, or nil when no proxy is configured (the driver then
+// dials directly). Supported schemes are socks5/socks5h (e.g. an `ssh -D`
+// tunnel to a bastion) and http/https CONNECT proxies.
+//
+// The driver skips its own TLS setup when DialContext is set, so when tlsCfg
+// is non-nil the returned dialer performs the TLS handshake itself.
+func clickhouseDialer(tlsCfg *tls.Config) (dialFunc, error) {
+	raw := strings.TrimSpace(viper.GetString("clickhouse.proxy_url"))
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clickhouse.proxy_url: %v", err)
+	}
+	if u.Host == "" || u.Port() == "" {
+		return nil, fmt.Errorf("clickhouse.proxy_url must include host and port (got %q)", u.Redacted())
+	}
+
+	var dial dialFunc
+	switch strings.ToLower(u.Scheme) {
+	case "socks5", "socks5h":
+		d, err := proxy.FromURL(u, &net.Dialer{Timeout: 30 * time.Second})
+		if err != nil {
+			return nil, fmt.Errorf("invalid clickhouse.proxy_url: %v", err)
+		}
+		cd, ok := d.(proxy.ContextDialer)
+		if !ok {
+			return nil, fmt.Errorf("socks5 dialer does not support contexts")
+		}
+		dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return cd.DialContext(ctx, "tcp", addr)
+		}
+	case "http", "https":
+		dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialHTTPConnect(ctx, u, addr)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported clickhouse.proxy_url scheme %q (use socks5, socks5h, http or https)", u.Scheme)
+	}
+
+	logrus.WithField("proxy", u.Redacted()).Debug("Routing ClickHouse connections through proxy")
+	if tlsCfg == nil {
+		return dial, nil
+	}
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg := tlsCfg.Clone()
+		if cfg.ServerName == "" {
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				cfg.ServerName = host
+			}
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}, nil
+}
+
+// dialHTTPConnect opens a tunnel to addr through an HTTP(S) proxy using
+// CONNECT. Credentials in the proxy URL are sent as Proxy-Authorization.
+func dialHTTPConnect(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("dialing proxy %s: %w", proxyURL.Host, err)
+	}
+	if strings.EqualFold(proxyURL.Scheme, "https") {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("proxy TLS handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("writing CONNECT request: %w", err)
+	}
+	// The client speaks first on both the native protocol and TLS, so the
+	// proxy sends nothing past its response and the buffered reader can be
+	// dropped safely.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("reading CONNECT response: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", addr, resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
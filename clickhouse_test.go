@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -365,7 +366,7 @@ func TestCHErrorAnalysisIntegration(t *testing.T) {
 	viper.Set("clickhouse.cluster", "default")
 	
 	// Try to run the analysis
-	errors, err := CHErrorAnalysis()
+	errors, err := CHErrorAnalysis(context.Background())
 	
 	// If we can't connect, that's okay for this test
 	if err != nil {
@@ -378,4 +379,211 @@ func TestCHErrorAnalysisIntegration(t *testing.T) {
 		// Successfully connected and ran query
 		t.Logf("CHErrorAnalysis() returned %d errors", len(errors))
 	}
-}
\ No newline at end of file
+}
+
+func TestWithQueryID(t *testing.T) {
+	ctx1, id1 := withQueryID(context.Background())
+	ctx2, id2 := withQueryID(context.Background())
+
+	if id1 == "" || id2 == "" {
+		t.Fatal("withQueryID() returned an empty query_id")
+	}
+	if id1 == id2 {
+		t.Errorf("withQueryID() returned duplicate query_id %q", id1)
+	}
+	if got := queryIDFrom(ctx1); got != id1 {
+		t.Errorf("queryIDFrom(ctx1) = %q, want %q", got, id1)
+	}
+	if got := queryIDFrom(ctx2); got != id2 {
+		t.Errorf("queryIDFrom(ctx2) = %q, want %q", got, id2)
+	}
+	if got := queryIDFrom(context.Background()); got != "" {
+		t.Errorf("queryIDFrom(background) = %q, want empty", got)
+	}
+
+	// execQuery sends the query under the query_id it reports.
+	conn := &ctxRecordingConn{}
+	_, err := execQuery(context.Background(), conn, "SELECT 1")
+	_, id, ok := strings.Cut(fmt.Sprint(err), "(query_id: ")
+	id = strings.TrimSuffix(id, ")")
+	if !ok || id == "" {
+		t.Fatalf("execQuery() error = %v, want a query_id suffix", err)
+	}
+	if queryIDFrom(conn.ctx) != id {
+		t.Errorf("execQuery() did not send the query with query_id %s", id)
+	}
+}
+
+// ctxRecordingConn records the context of the last query and fails it.
+type ctxRecordingConn struct {
+	MockConn
+	ctx context.Context
+}
+
+func (c *ctxRecordingConn) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
+	c.ctx = ctx
+	return nil, fmt.Errorf("query failed")
+}
+
+func TestResolveCluster(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		available  []string
+		want       string
+		wantErr    bool
+	}{
+		{name: "configured cluster exists", configured: "posthog", available: []string{"default", "posthog"}, want: "posthog"},
+		{name: "configured cluster kept even when it is the only one", configured: "posthog", available: []string{"posthog"}, want: "posthog"},
+		{name: "single cluster auto-selected", configured: "default", available: []string{"posthog"}, want: "posthog"},
+		{name: "missing among several", configured: "typo", available: []string{"a", "b"}, wantErr: true},
+		{name: "no clusters", configured: "default", available: nil, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveCluster(tt.configured, tt.available)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveCluster() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveCluster() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChConnConfigFrom(t *testing.T) {
+	viper.Set("clickhouse.host", "main-host")
+	viper.Set("clickhouse.port", 9000)
+	viper.Set("clickhouse.user", "main-user")
+	viper.Set("clickhouse.password", "main-pass")
+	viper.Set("clickhouse.database", "main-db")
+	defer func() {
+		for _, k := range []string{"clickhouse.host", "clickhouse.port", "clickhouse.user", "clickhouse.password", "clickhouse.database",
+			"analysis.clickhouse.host", "analysis.clickhouse.user", "analysis.clickhouse.password"} {
+			viper.Set(k, nil)
+		}
+	}()
+
+	tests := []struct {
+		name     string
+		host     string
+		user     string
+		password string
+		want     chConnConfig
+	}{
+		{
+			name: "nothing set falls back entirely",
+			want: chConnConfig{Host: "main-host", Port: 9000, User: "main-user", Password: "main-pass", Database: "main-db"},
+		},
+		{
+			name:     "dedicated user keeps its own password",
+			user:     "analysis-ro",
+			password: "ro-pass",
+			want:     chConnConfig{Host: "main-host", Port: 9000, User: "analysis-ro", Password: "ro-pass", Database: "main-db"},
+		},
+		{
+			name: "dedicated user without password does not inherit the main password",
+			user: "analysis-ro",
+			want: chConnConfig{Host: "main-host", Port: 9000, User: "analysis-ro", Password: "", Database: "main-db"},
+		},
+		{
+			name: "host override",
+			host: "replica-host",
+			want: chConnConfig{Host: "replica-host", Port: 9000, User: "main-user", Password: "main-pass", Database: "main-db"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("analysis.clickhouse.host", tt.host)
+			viper.Set("analysis.clickhouse.user", tt.user)
+			viper.Set("analysis.clickhouse.password", tt.password)
+			if got := chConnConfigFrom("analysis.clickhouse"); got != tt.want {
+				t.Errorf("chConnConfigFrom() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCHErrorStringTrace(t *testing.T) {
+	e := CHError{Hostname: "host1", Name: "X", LastErrorFrames: []string{"DB::a()", "DB::b()"}, LastErrorTrace: []uint64{1}}
+	if got := e.String(); !contains(got, "LastErrorFrames: DB::a() <- DB::b()") || contains(got, "LastErrorTrace") {
+		t.Errorf("CHError.String() with frames = %v", got)
+	}
+	e = CHError{Hostname: "host1", Name: "X"}
+	if got := e.String(); contains(got, "LastErrorTrace") || contains(got, "LastErrorFrames") {
+		t.Errorf("CHError.String() without trace = %v", got)
+	}
+}
+
+func TestCleanFrames(t *testing.T) {
+	frames := []string{"DB::a() /src/a.cpp:1", " ", "", "DB::b() /src/b.cpp:2"}
+	want := []string{"DB::a() /src/a.cpp:1", "DB::b() /src/b.cpp:2"}
+	if got := cleanFrames(frames); !equalSlices(got, want) {
+		t.Errorf("cleanFrames() = %q, want %q", got, want)
+	}
+
+	long := make([]string, maxErrorFrames+5)
+	for i := range long {
+		long[i] = fmt.Sprintf("f%d", i)
+	}
+	if got := cleanFrames(long); len(got) != maxErrorFrames {
+		t.Errorf("cleanFrames() kept %d frames, want %d", len(got), maxErrorFrames)
+	}
+}
+
+// symbolizeRefusingConn fails queries that use introspection functions, as a
+// server without allow_introspection_functions does.
+type symbolizeRefusingConn struct {
+	MockConn
+	queries []string
+}
+
+func (c *symbolizeRefusingConn) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
+	c.queries = append(c.queries, query)
+	if contains(query, "addressToSymbol") {
+		return nil, &clickhouse.Exception{Code: 446, Message: "Introspection functions are disabled"}
+	}
+	return c.MockConn.Query(ctx, query, args...)
+}
+
+func TestGetCHErrorsStackTraces(t *testing.T) {
+	viper.Set("clickhouse.cluster", "test_cluster")
+	defer viper.Set("analysis.stack_traces", nil)
+	testErrors := []CHError{{Hostname: "host1", Name: "ERROR1", LastErrorTrace: []uint64{1, 2, 3}}}
+
+	for _, tt := range []struct {
+		mode      string
+		wantTrace bool
+	}{
+		{mode: "", wantTrace: false},
+		{mode: "drop", wantTrace: false},
+		{mode: "raw", wantTrace: true},
+		{mode: "bogus", wantTrace: false},
+	} {
+		viper.Set("analysis.stack_traces", tt.mode)
+		conn := &MockConn{queryRows: &MockRows{maxRows: 1, errors: testErrors}}
+		errors, err := getCHErrors(context.Background(), conn)
+		if err != nil {
+			t.Fatalf("mode %q: getCHErrors() unexpected error: %v", tt.mode, err)
+		}
+		if got := len(errors[0].LastErrorTrace) > 0; got != tt.wantTrace {
+			t.Errorf("mode %q: trace kept = %v, want %v", tt.mode, got, tt.wantTrace)
+		}
+	}
+
+	viper.Set("analysis.stack_traces", "symbolize")
+	conn := &symbolizeRefusingConn{MockConn: MockConn{queryRows: &MockRows{maxRows: 1, errors: testErrors}}}
+	errors, err := getCHErrors(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("symbolize fallback: getCHErrors() unexpected error: %v", err)
+	}
+	if len(conn.queries) != 2 {
+		t.Fatalf("symbolize fallback ran %d queries, want 2", len(conn.queries))
+	}
+	if len(errors) != 1 || len(errors[0].LastErrorTrace) > 0 || len(errors[0].LastErrorFrames) > 0 {
+		t.Errorf("symbolize fallback = %+v, want the error without a trace", errors)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// clustersArgs is the input to the clickhouse_clusters tool.
+type clustersArgs struct {
+	Cluster string `json:"cluster,omitempty" jsonschema:"only this cluster; default all clusters"`
+}
+
+// clusterReplica is one row of system.clusters.
+type clusterReplica struct {
+	Replica     uint32 `json:"replica"`
+	Host        string `json:"host"`
+	Address     string `json:"address"`
+	Port        uint16 `json:"port"`
+	IsLocal     bool   `json:"is_local" jsonschema:"true for the server this tool is connected to"`
+	ErrorsCount uint32 `json:"errors_count" jsonschema:"recent connection errors to this replica"`
+}
+
+type clusterShard struct {
+	Shard    uint32           `json:"shard"`
+	Replicas []clusterReplica `json:"replicas"`
+}
+
+type clusterTopology struct {
+	Name   string         `json:"name"`
+	Shards []clusterShard `json:"shards"`
+}
+
+// clustersResult is the structured output of clickhouse_clusters.
+type clustersResult struct {
+	ConfiguredCluster string            `json:"configured_cluster" jsonschema:"clickhouse.cluster, the name used in clusterAllReplicas by default"`
+	ConfiguredFound   bool              `json:"configured_found" jsonschema:"whether configured_cluster exists in system.clusters"`
+	Clusters          []clusterTopology `json:"clusters"`
+}
+
+// clusterRow is a system.clusters row before grouping.
+type clusterRow struct {
+	Cluster string
+	Shard   uint32
+	clusterReplica
+}
+
+// groupClusters nests rows, which must be ordered by cluster, shard and
+// replica, into clusters and shards, keeping only cluster when it is set.
+func groupClusters(rows []clusterRow, configured, cluster string) *clustersResult {
+	res := &clustersResult{ConfiguredCluster: configured, Clusters: []clusterTopology{}}
+	for _, r := range rows {
+		if r.Cluster == configured {
+			res.ConfiguredFound = true
+		}
+		if cluster != "" && r.Cluster != cluster {
+			continue
+		}
+		n := len(res.Clusters)
+		if n == 0 || res.Clusters[n-1].Name != r.Cluster {
+			res.Clusters = append(res.Clusters, clusterTopology{Name: r.Cluster})
+			n++
+		}
+		c := &res.Clusters[n-1]
+		if s := len(c.Shards); s == 0 || c.Shards[s-1].Shard != r.Shard {
+			c.Shards = append(c.Shards, clusterShard{Shard: r.Shard})
+		}
+		s := &c.Shards[len(c.Shards)-1]
+		s.Replicas = append(s.Replicas, r.clusterReplica)
+	}
+	return res
+}
+
+func registerClustersTool(srv *mcp.Server) {
+	addTool[clustersArgs, *clustersResult](
+		srv,
+		&mcp.Tool{
+			Name:        "clickhouse_clusters",
+			Title:       "ClickHouse cluster topology",
+			Description: `List the clusters in system.clusters with their shards, replicas and hosts, and which cluster name clusterAllReplicas uses by default. Call this before cluster-wide queries, or when a query fails with "cluster not found", to pick the right cluster name.`,
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		},
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[clustersArgs]) (*mcp.CallToolResultFor[*clustersResult], error) {
+			conn, err := connect()
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				if err := conn.Close(); err != nil {
+					logrus.WithError(err).Warn("Error closing ClickHouse connection")
+				}
+			}()
+
+			rows, err := fetchClusterRows(ctx, conn)
+			if err != nil {
+				return nil, err
+			}
+			res := groupClusters(rows, viper.GetString("clickhouse.cluster"), strings.TrimSpace(req.Arguments.Cluster))
+			return &mcp.CallToolResultFor[*clustersResult]{
+				Content:           []mcp.Content{&mcp.TextContent{Text: summarizeClusters(res)}},
+				StructuredContent: res,
+			}, nil
+		},
+	)
+}
+
+// fetchClusterRows reads system.clusters. It is small, so every cluster is
+// read and filtering happens in groupClusters.
+func fetchClusterRows(ctx context.Context, conn driver.Conn) ([]clusterRow, error) {
+	rows, err := conn.Query(ctx, "SELECT cluster, shard_num, replica_num, host_name, host_address, port, is_local, errors_count FROM system.clusters ORDER BY cluster, shard_num, replica_num")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
+
+	var out []clusterRow
+	for rows.Next() {
+		var r clusterRow
+		var isLocal uint8
+		if err := rows.Scan(&r.Cluster, &r.Shard, &r.Replica, &r.Host, &r.Address, &r.Port, &isLocal, &r.ErrorsCount); err != nil {
+			return nil, err
+		}
+		r.IsLocal = isLocal == 1
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// summarizeClusters renders one line per cluster and replica, and flags a
+// configured cluster that doesn't exist.
+func summarizeClusters(res *clustersResult) string {
+	var b strings.Builder
+	if len(res.Clusters) == 0 {
+		b.WriteString("No matching clusters in system.clusters. On a single-node server, query system tables directly instead of through clusterAllReplicas.")
+	}
+	for i, c := range res.Clusters {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		replicas := 0
+		for _, s := range c.Shards {
+			replicas += len(s.Replicas)
+		}
+		marker := ""
+		if c.Name == res.ConfiguredCluster {
+			marker = " (configured)"
+		}
+		fmt.Fprintf(&b, "cluster %s%s: %d shard(s), %d replica(s)", c.Name, marker, len(c.Shards), replicas)
+		for _, s := range c.Shards {
+			for _, r := range s.Replicas {
+				fmt.Fprintf(&b, "\n  shard %d replica %d: %s:%d", s.Shard, r.Replica, r.Host, r.Port)
+				if r.IsLocal {
+					b.WriteString(" (local)")
+				}
+				if r.ErrorsCount > 0 {
+					fmt.Fprintf(&b, " errors=%d", r.ErrorsCount)
+				}
+			}
+		}
+	}
+	if res.ConfiguredFound {
+		fmt.Fprintf(&b, "\nUse clusterAllReplicas('%s', system.<table>) for cluster-wide system tables.", res.ConfiguredCluster)
+	} else {
+		fmt.Fprintf(&b, "\nwarning: clickhouse.cluster %q is not in system.clusters, so clusterAllReplicas queries using it fail with \"cluster not found\".", res.ConfiguredCluster)
+	}
+	return b.String()
+}
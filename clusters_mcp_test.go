@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func testClusterRows() []clusterRow {
+	row := func(cluster string, shard, replica uint32, host string, local bool) clusterRow {
+		return clusterRow{Cluster: cluster, Shard: shard, clusterReplica: clusterReplica{Replica: replica, Host: host, Port: 9000, IsLocal: local}}
+	}
+	return []clusterRow{
+		row("main", 1, 1, "ch1", true),
+		row("main", 1, 2, "ch2", false),
+		row("main", 2, 1, "ch3", false),
+		row("ops", 1, 1, "ops1", false),
+	}
+}
+
+func TestGroupClusters(t *testing.T) {
+	res := groupClusters(testClusterRows(), "main", "")
+	if !res.ConfiguredFound || len(res.Clusters) != 2 {
+		t.Fatalf("groupClusters() = %+v", res)
+	}
+	main := res.Clusters[0]
+	if main.Name != "main" || len(main.Shards) != 2 || len(main.Shards[0].Replicas) != 2 || main.Shards[1].Replicas[0].Host != "ch3" {
+		t.Errorf("main cluster = %+v", main)
+	}
+
+	res = groupClusters(testClusterRows(), "main", "ops")
+	if !res.ConfiguredFound || len(res.Clusters) != 1 || res.Clusters[0].Name != "ops" {
+		t.Errorf("filtered groupClusters() = %+v", res)
+	}
+
+	res = groupClusters(testClusterRows(), "default", "")
+	if res.ConfiguredFound {
+		t.Error("missing configured cluster reported as found")
+	}
+}
+
+func TestSummarizeClusters(t *testing.T) {
+	got := summarizeClusters(groupClusters(testClusterRows(), "main", ""))
+	for _, want := range []string{
+		"cluster main (configured): 2 shard(s), 3 replica(s)",
+		"shard 1 replica 1: ch1:9000 (local)",
+		"cluster ops: 1 shard(s), 1 replica(s)",
+		"clusterAllReplicas('main', system.<table>)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("summary missing %q:\n%s", want, got)
+		}
+	}
+
+	got = summarizeClusters(groupClusters(testClusterRows(), "default", ""))
+	if !strings.Contains(got, `clickhouse.cluster "default" is not in system.clusters`) {
+		t.Errorf("summary does not flag the missing cluster:\n%s", got)
+	}
+	got = summarizeClusters(groupClusters(nil, "default", ""))
+	if !strings.Contains(got, "No matching clusters") {
+		t.Errorf("empty summary = %q", got)
+	}
+}
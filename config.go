@@ -1,20 +1,29 @@
 package main
 
 import (
+	_ "embed"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/prometheus/common/model"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
-// loadConfig loads configuration from an explicit path if provided, otherwise
+// loadConfig loads configuration from the given paths if any, otherwise
 // searches several conventional locations to work when launched by external hosts (e.g., MCP clients).
-// Priority (highest to lowest): CLI flags > env vars > config file > defaults.
+// Priority (highest to lowest): CLI flags > env vars > config files > defaults,
+// with later paths overriding earlier ones (see mergeConfigFiles).
+// The merged result is decoded into appConfig; invalid values are returned as
+// an error and unknown keys are logged. A missing config file is not an error.
 // Env vars use the prefix HOUSEKEEPER_ with dots replaced by underscores, e.g.:
 //   HOUSEKEEPER_CLICKHOUSE_HOST, HOUSEKEEPER_CLICKHOUSE_PASSWORD, HOUSEKEEPER_HTTP_AUTH_TOKEN
-func loadConfig(explicitPath string) error {
+func loadConfig(paths []string) error {
 	// Enable environment variable support
 	viper.SetEnvPrefix("HOUSEKEEPER")
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
@@ -22,18 +31,74 @@ func loadConfig(explicitPath string) error {
 
 	// Set defaults for all configuration values
 	// These can be overridden by env vars, config file, or command-line flags
+	// Keys without a natural default are still registered so that values given
+	// only as env vars (e.g. HOUSEKEEPER_GEMINI_KEY) reach appConfig.
+	viper.SetDefault("gemini_key", "")
+	viper.SetDefault("slack.webhook_url", "")
+	viper.SetDefault("github.token", "")
+	viper.SetDefault("github.repo", "")
+	viper.SetDefault("clickhouse.allowed_databases", []string{})
+
 	viper.SetDefault("clickhouse.host", "127.0.0.1")
 	viper.SetDefault("clickhouse.port", 9000)
 	viper.SetDefault("clickhouse.user", "default")
 	viper.SetDefault("clickhouse.password", "")
 	viper.SetDefault("clickhouse.database", "default")
 	viper.SetDefault("clickhouse.cluster", "default")
+	// Check clickhouse.cluster against system.clusters at startup, logging the
+	// available names (and switching to the only cluster) if it isn't found.
+	viper.SetDefault("clickhouse.detect_cluster", false)
+	// Run clusterAllReplicas queries with skip_unavailable_shards=1 so a down
+	// replica yields partial results (flagged in the output) instead of an error.
+	viper.SetDefault("clickhouse.skip_unavailable_shards", false)
+	// Issue KILL QUERY for a clickhouse_query whose client disconnected or
+	// timed out, in case the driver's own cancellation doesn't reach the server.
+	viper.SetDefault("clickhouse.kill_on_cancel", false)
+	// Check clickhouse_query SQL with EXPLAIN before running it, so unknown
+	// tables or columns come back as validation errors.
+	viper.SetDefault("clickhouse.validate_with_explain", false)
+	// Abort clickhouse_query queries that would scan more rows or bytes than
+	// this (max_rows_to_read / max_bytes_to_read), whatever their LIMIT. 0 = off.
+	viper.SetDefault("clickhouse.max_rows_to_read", 0)
+	viper.SetDefault("clickhouse.max_bytes_to_read", 0)
+	// Per-table column lists used by structured clickhouse_query calls without
+	// columns, keyed by database.table. Entries replace the built-in sets for
+	// common system tables; an empty list selects *.
+	viper.SetDefault("clickhouse.default_columns", map[string]interface{}{})
+	// Settings clickhouse_query callers may pass per query (readonly, allow_ddl
+	// and allow_introspection_functions are always refused).
+	viper.SetDefault("clickhouse.allowed_query_settings", defaultAllowedQuerySettings)
+	// clickhouse.allowed_databases entries may be glob patterns (app_*). They
+	// match regardless of case unless this is set; ClickHouse itself treats
+	// database names as case-sensitive.
+	viper.SetDefault("clickhouse.case_sensitive_databases", false)
+	// Optional proxy for reaching ClickHouse from outside its network:
+	// socks5://, socks5h:// (e.g. `ssh -D` to a bastion) or http(s):// CONNECT.
+	viper.SetDefault("clickhouse.proxy_url", "")
+	// Accept the sql field of clickhouse_query; false limits it to structured
+	// queries (table, columns, where, ...).
+	viper.SetDefault("clickhouse.allow_freeform_sql", true)
+	// Upper bounds on free-form SQL accepted by clickhouse_query and run_sql.
+	viper.SetDefault("clickhouse.max_sql_length", defaultMaxSQLLength)
+	viper.SetDefault("clickhouse.max_sql_nesting", defaultMaxSQLNesting)
+	// Cut string values in query results past this many characters unless
+	// verbose is set (0 = never).
+	viper.SetDefault("clickhouse.max_cell_length", defaultMaxCellLength)
+	// Queries on system.query_log without an event_date/event_time filter:
+	// off runs them as is, inject adds the past query_log_window to structured
+	// queries, reject refuses them. Free-form SQL is refused unless off.
+	viper.SetDefault("clickhouse.query_log_time_filter", queryLogFilterOff)
+	viper.SetDefault("clickhouse.query_log_window", "24h")
 	
 	viper.SetDefault("prometheus.host", "localhost")
 	viper.SetDefault("prometheus.port", 8481)
 	viper.SetDefault("prometheus.vm_cluster_mode", false)
 	viper.SetDefault("prometheus.vm_tenant_id", "0")
 	viper.SetDefault("prometheus.vm_path_prefix", "")
+	// Guards for prometheus_query*: the longest query time range and range
+	// selector window (0 = no limit), and functions to reject by name.
+	viper.SetDefault("prometheus.max_range", "0")
+	viper.SetDefault("prometheus.denied_functions", []string{})
 
 	// Optional second endpoint for ClickHouse-internal metrics. Empty host disables it.
 	viper.SetDefault("prometheus_clickhouse.host", "")
@@ -44,9 +109,58 @@ func loadConfig(explicitPath string) error {
 	
 	viper.SetDefault("logging.level", "info")
 	viper.SetDefault("logging.format", "text")
+	// Identical high-frequency warnings/errors (connection failures, rejected
+	// requests) are logged once per window with a repeat count; the count of a
+	// flood that stops is logged within a window after it. 0 disables.
+	viper.SetDefault("logging.dedup_window", "30s")
+	// Log aggregate tool, query and LLM usage at this interval (0 = off).
+	viper.SetDefault("logging.usage_interval", "15m")
+	// Replace ClickHouse passwords with **** in log lines and in connection
+	// errors returned to callers.
+	viper.SetDefault("logging.mask_passwords", true)
+
+	// --analyze also files critical summaries as a GitHub issue in github.repo
+	// ("owner/name") when a token is set, commenting on the open issue with
+	// github.label for the same top error instead of opening a duplicate.
+	viper.SetDefault("github.label", "housekeeper")
+	viper.SetDefault("github.api_url", "https://api.github.com")
+
+	// Outbound Slack messages allowed per minute across all modes; excess
+	// messages are dropped and counted in the next one sent, or in a notice of
+	// their own at the end of the minute. 0 disables.
+	viper.SetDefault("slack.max_messages_per_minute", 20)
+
+	// Optional Go templates for the --analyze notification per target, over
+	// {{.Severity}}, {{.Title}} and {{.Body}} (the summary). Empty sends the
+	// summary as is.
+	viper.SetDefault("slack.template", "")
+	viper.SetDefault("github.template", "")
+
+	// --tail-errors: how often to poll system.errors, and whether to post each
+	// batch of new errors to slack.webhook_url as well as printing it.
+	viper.SetDefault("tail_errors.interval", "10s")
+	viper.SetDefault("tail_errors.slack", false)
+
+	// Probe ClickHouse and Prometheus once at MCP server startup, logging each
+	// as ready or unreachable. This only checks connectivity: tool calls still
+	// open their own ClickHouse connections.
+	viper.SetDefault("startup.warmup", true)
 
 	viper.SetDefault("http.addr", ":8080")
 	viper.SetDefault("http.auth_token", "")
+	// Bearer token for the /admin/ endpoints; empty leaves them unregistered.
+	viper.SetDefault("http.admin_token", "")
+	// Largest request body accepted by the HTTP server; larger requests get 413.
+	viper.SetDefault("http.max_body_bytes", 4<<20)
+	// Browser GETs on / render a landing page listing the server's tools (only
+	// the server name when http.auth_token is set); banner is optional free
+	// text (MOTD) shown at the top of that page.
+	viper.SetDefault("http.landing_page", true)
+	viper.SetDefault("http.banner", "")
+
+	// IANA zone (e.g. "Europe/Berlin") for timestamps in human-facing tool
+	// summaries. Structured results stay UTC. Empty shows UTC.
+	viper.SetDefault("display.timezone", "")
 
 	// Deployment-specific guidance. extra_tool_description is shared facts
 	// appended to BOTH clickhouse_query and the diagnose agent (topology,
@@ -54,7 +168,27 @@ func loadConfig(explicitPath string) error {
 	// appended ONLY to clickhouse_query — for restricted-route caveats (column
 	// REVOKEs, etc.) that don't apply to the elevated diagnose connection.
 	viper.SetDefault("mcp.extra_tool_description", "")
+	// Names of the tools to register; empty registers every available tool.
+	// Tools that are off for other reasons (e.g. no Bedrock config) stay off.
+	viper.SetDefault("mcp.enabled_tools", []string{})
 	viper.SetDefault("mcp.query_extra_description", "")
+	// Expose the Gemini error/performance agents as analyze_* MCP tools. Off by
+	// default because they send ClickHouse data to an external LLM; also
+	// requires gemini_key.
+	viper.SetDefault("mcp.analysis_tools", false)
+	// Return the executed SQL with clickhouse_query results (including the SQL
+	// built from structured args). Off by default: it echoes query literals.
+	viper.SetDefault("mcp.include_sql", false)
+	// clickhouse_query results whose rows encode to more than threshold_bytes
+	// of JSON are kept in memory for ttl and returned as a resource link with
+	// a preview (0 = always inline). max_bytes bounds all stored results.
+	viper.SetDefault("mcp.large_results.threshold_bytes", 0)
+	viper.SetDefault("mcp.large_results.ttl", "15m")
+	viper.SetDefault("mcp.large_results.max_bytes", 64<<20)
+	// Bounds for clickhouse_watch: max runs per call and max span from the first
+	// run to the last.
+	viper.SetDefault("mcp.watch.max_count", 30)
+	viper.SetDefault("mcp.watch.max_duration", "2m")
 
 	// Bedrock-backed in-MCP diagnose tool. Empty region/model_id disables the
 	// diagnose tool. model_id is a Bedrock model or inference-profile
@@ -69,6 +203,82 @@ func loadConfig(explicitPath string) error {
 	viper.SetDefault("bedrock.max_seconds", 25)
 	viper.SetDefault("bedrock.temperature", 0.2)
 
+	// Tables the Gemini analysis agent (--analyze) may read via
+	// query_clickhouse_system_table. denied_tables always wins; an empty
+	// allowed_tables allows anything not denied.
+	viper.SetDefault("agent.allowed_tables", []string{
+		"system.errors",
+		"system.metrics",
+		"system.processes",
+		"system.parts",
+		"system.replicas",
+		"system.replication_queue",
+		"system.mutations",
+		"system.merges",
+		"system.query_log",
+		"system.settings",
+		"system.clusters",
+		"system.kafka_consumers",
+		"system.tables",
+		"system.columns",
+	})
+	viper.SetDefault("agent.denied_tables", []string{
+		"system.users",
+		"system.roles",
+		"system.grants",
+		"system.role_grants",
+		"system.quotas",
+		"system.row_policies",
+		"system.settings_profiles",
+		"system.zookeeper",
+	})
+	// Max system-table queries the agent runs concurrently within one turn.
+	viper.SetDefault("agent.max_concurrent_queries", 4)
+
+	// How ClickHouse data is presented to the analysis and diagnose agents:
+	// "delimit" wraps it in tags the model is told to treat as data only and
+	// strips instruction-like text; "off" sends it as-is.
+	viper.SetDefault("agent.prompt_guard", "delimit")
+
+	// System prompt overrides for the errors and performance (Gemini) and
+	// diagnose (Bedrock) agents, as Go templates. {{.Default}} is the built-in
+	// prompt and {{.Tools}} the agent's function names. Empty keeps the default.
+	viper.SetDefault("prompts.errors", "")
+	viper.SetDefault("prompts.performance", "")
+	viper.SetDefault("prompts.diagnose", "")
+
+	// Gemini models for the analysis agents. A per-task model overrides
+	// gemini.model; empty (the default) uses the shared one.
+	viper.SetDefault("gemini.model", "gemini-2.5-flash")
+	viper.SetDefault("gemini.errors_model", "")
+	viper.SetDefault("gemini.performance_model", "")
+	// Wall-clock budget for one analysis run; once exceeded the agent stops
+	// querying and summarizes what it found. 0 disables.
+	viper.SetDefault("gemini.max_seconds", 120)
+	// Error analyses of identical system.errors data within this window reuse
+	// the earlier summary instead of calling Gemini again. 0 disables.
+	viper.SetDefault("gemini.cache_ttl", "10m")
+
+	// Optional separate ClickHouse connection for the Gemini analysis agents
+	// (--analyze and the analyze_* tools), e.g. a read-only user with grants
+	// limited to system tables. Empty fields fall back to clickhouse.*.
+	viper.SetDefault("analysis.clickhouse.host", "")
+	viper.SetDefault("analysis.clickhouse.port", 0)
+	viper.SetDefault("analysis.clickhouse.user", "")
+	viper.SetDefault("analysis.clickhouse.password", "")
+	viper.SetDefault("analysis.clickhouse.database", "")
+
+	// How system.errors stack traces are given to the error analysis agent:
+	// "drop" (default), "raw" addresses, or "symbolize" into function names
+	// and source lines (needs allow_introspection_functions).
+	viper.SetDefault("analysis.stack_traces", "drop")
+	// Error analysis flags disks with less than this share of free space as a
+	// critical condition, alongside read-only replicas. 0 disables the check.
+	viper.SetDefault("analysis.min_free_disk_ratio", 0.1)
+	// Add the past hour's failed queries from system.query_log to the error
+	// analysis prompt for query-related error codes (syntax, timeout, memory).
+	viper.SetDefault("analysis.query_log_context", false)
+
 	// Optional separate ClickHouse connection used only by the server-side
 	// diagnose agent. Empty fields fall back to the clickhouse.* connection.
 	viper.SetDefault("analyst_clickhouse.host", "")
@@ -77,19 +287,16 @@ func loadConfig(explicitPath string) error {
 	viper.SetDefault("analyst_clickhouse.password", "")
 	viper.SetDefault("analyst_clickhouse.database", "")
 
-	if explicitPath == "" {
+	if len(paths) == 0 {
 		if env := os.Getenv("HOUSEKEEPER_CONFIG"); env != "" {
-			explicitPath = env
+			paths = strings.Split(env, ",")
 		}
 	}
 
-	if explicitPath != "" {
-		viper.SetConfigFile(explicitPath)
-		if err := viper.ReadInConfig(); err != nil {
-			// Don't fail if config file doesn't exist when flags are provided
-			logrus.WithError(err).Debug("Could not read config file, using defaults and flags")
-		} else {
-			logrus.WithField("config_file", viper.ConfigFileUsed()).Debug("Loaded config file")
+	if len(paths) > 0 {
+		// Don't fail if a config file doesn't exist when flags are provided
+		if err := mergeConfigFiles(viper.GetViper(), paths); err != nil {
+			return err
 		}
 	} else {
 		viper.SetConfigName("config")
@@ -122,15 +329,51 @@ func loadConfig(explicitPath string) error {
 		}
 	}
 
+	c, unknown, err := decodeConfig(viper.GetViper())
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if err := c.validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	appConfig = c
+
 	// Configure logging after config is loaded
 	configureLogging()
+	if len(unknown) > 0 {
+		logrus.WithField("keys", strings.Join(unknown, ", ")).Warn("Ignoring unknown config keys; check for typos")
+	}
 	return nil
 }
 
+// exampleConfig is the commented sample config written by --config-init.
+//
+//go:embed configs/config.yml.sample
+var exampleConfig []byte
+
+// writeExampleConfig writes exampleConfig to path, creating parent directories.
+// An existing file is only replaced when force is set. The file is created
+// owner-only since it is meant to hold credentials once filled in.
+func writeExampleConfig(path string, force bool) error {
+	if !force {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, exampleConfig, 0o600)
+}
+
 // configureLogging sets up logrus based on configuration
 func configureLogging() {
 	// Set log level
-	level := viper.GetString("logging.level")
+	level := appConfig.Logging.Level
 	if level == "" {
 		level = "info"
 	}
@@ -141,9 +384,10 @@ func configureLogging() {
 		parsedLevel = logrus.InfoLevel
 	}
 	logrus.SetLevel(parsedLevel)
+	installPasswordMask()
 
 	// Set log format
-	format := viper.GetString("logging.format")
+	format := appConfig.Logging.Format
 	if strings.ToLower(format) == "json" {
 		logrus.SetFormatter(&logrus.JSONFormatter{})
 	} else {
@@ -158,3 +402,301 @@ func configureLogging() {
 		"format": format,
 	}).Debug("Logging configured")
 }
+
+// Config is the typed view of the configuration, decoded once by loadConfig
+// after defaults, the config file, env vars and flags are merged. Every key
+// housekeeper reads has a field here, so keys in the config file that match
+// none are reported as likely typos.
+type Config struct {
+	GeminiKey            string           `mapstructure:"gemini_key"`
+	Gemini               GeminiConfig     `mapstructure:"gemini"`
+	Logging              LoggingConfig    `mapstructure:"logging"`
+	Slack                SlackConfig      `mapstructure:"slack"`
+	GitHub               GitHubConfig     `mapstructure:"github"`
+	TailErrors           TailErrorsConfig `mapstructure:"tail_errors"`
+	ClickHouse           ClickHouseConfig `mapstructure:"clickhouse"`
+	Prometheus           PrometheusConfig `mapstructure:"prometheus"`
+	PrometheusClickHouse PrometheusConfig `mapstructure:"prometheus_clickhouse"`
+	Agent                AgentConfig      `mapstructure:"agent"`
+	Prompts              PromptsConfig    `mapstructure:"prompts"`
+	Startup              StartupConfig    `mapstructure:"startup"`
+	HTTP                 HTTPConfig       `mapstructure:"http"`
+	Display              DisplayConfig    `mapstructure:"display"`
+	MCP                  MCPConfig        `mapstructure:"mcp"`
+	Bedrock              BedrockConfig    `mapstructure:"bedrock"`
+	Analysis             AnalysisConfig   `mapstructure:"analysis"`
+	AnalystClickHouse    ConnectionConfig `mapstructure:"analyst_clickhouse"`
+}
+
+type GeminiConfig struct {
+	Model            string        `mapstructure:"model"`
+	ErrorsModel      string        `mapstructure:"errors_model"`
+	PerformanceModel string        `mapstructure:"performance_model"`
+	MaxSeconds       int           `mapstructure:"max_seconds"`
+	CacheTTL         time.Duration `mapstructure:"cache_ttl"`
+}
+
+type LoggingConfig struct {
+	Level         string        `mapstructure:"level"`
+	Format        string        `mapstructure:"format"`
+	DedupWindow   time.Duration `mapstructure:"dedup_window"`
+	UsageInterval time.Duration `mapstructure:"usage_interval"`
+	MaskPasswords bool          `mapstructure:"mask_passwords"`
+}
+
+type SlackConfig struct {
+	WebhookURL           string `mapstructure:"webhook_url"`
+	MaxMessagesPerMinute int    `mapstructure:"max_messages_per_minute"`
+	Template             string `mapstructure:"template"`
+}
+
+type GitHubConfig struct {
+	Token    string `mapstructure:"token"`
+	Repo     string `mapstructure:"repo"`
+	Label    string `mapstructure:"label"`
+	APIURL   string `mapstructure:"api_url"`
+	Template string `mapstructure:"template"`
+}
+
+type TailErrorsConfig struct {
+	Interval time.Duration `mapstructure:"interval"`
+	Slack    bool          `mapstructure:"slack"`
+}
+
+// ConnectionConfig is a ClickHouse connection; analysis.clickhouse and
+// analyst_clickhouse fall back to clickhouse.* for empty fields.
+type ConnectionConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	Database string `mapstructure:"database"`
+}
+
+type ClickHouseConfig struct {
+	ConnectionConfig      `mapstructure:",squash"`
+	Cluster               string   `mapstructure:"cluster"`
+	DetectCluster         bool     `mapstructure:"detect_cluster"`
+	SkipUnavailableShards bool     `mapstructure:"skip_unavailable_shards"`
+	KillOnCancel          bool     `mapstructure:"kill_on_cancel"`
+	ValidateWithExplain   bool     `mapstructure:"validate_with_explain"`
+	MaxRowsToRead         int64    `mapstructure:"max_rows_to_read"`
+	MaxBytesToRead        int64    `mapstructure:"max_bytes_to_read"`
+	AllowedDatabases      []string `mapstructure:"allowed_databases"`
+	CaseSensitiveDBs      bool     `mapstructure:"case_sensitive_databases"`
+	AllowedQuerySettings  []string `mapstructure:"allowed_query_settings"`
+	// Table names contain dots, which viper splits into nested maps, so this
+	// stays untyped; see configuredDefaultColumns.
+	DefaultColumns   map[string]interface{} `mapstructure:"default_columns"`
+	ProxyURL         string                 `mapstructure:"proxy_url"`
+	AllowFreeformSQL bool                   `mapstructure:"allow_freeform_sql"`
+	MaxSQLLength     int                    `mapstructure:"max_sql_length"`
+	MaxSQLNesting    int                    `mapstructure:"max_sql_nesting"`
+	MaxCellLength    int                    `mapstructure:"max_cell_length"`
+	QueryLogFilter   string                 `mapstructure:"query_log_time_filter"`
+	QueryLogWindow   time.Duration          `mapstructure:"query_log_window"`
+}
+
+type PrometheusConfig struct {
+	Host          string `mapstructure:"host"`
+	Port          int    `mapstructure:"port"`
+	VMClusterMode bool   `mapstructure:"vm_cluster_mode"`
+	VMTenantID    string `mapstructure:"vm_tenant_id"`
+	VMPathPrefix  string `mapstructure:"vm_path_prefix"`
+	// Only read for prometheus; a PromQL duration such as "7d".
+	MaxRange        string   `mapstructure:"max_range"`
+	DeniedFunctions []string `mapstructure:"denied_functions"`
+}
+
+type AgentConfig struct {
+	AllowedTables        []string `mapstructure:"allowed_tables"`
+	DeniedTables         []string `mapstructure:"denied_tables"`
+	MaxConcurrentQueries int      `mapstructure:"max_concurrent_queries"`
+	PromptGuard          string   `mapstructure:"prompt_guard"`
+}
+
+type PromptsConfig struct {
+	Errors      string `mapstructure:"errors"`
+	Performance string `mapstructure:"performance"`
+	Diagnose    string `mapstructure:"diagnose"`
+}
+
+type StartupConfig struct {
+	Warmup bool `mapstructure:"warmup"`
+}
+
+type HTTPConfig struct {
+	Addr         string `mapstructure:"addr"`
+	AuthToken    string `mapstructure:"auth_token"`
+	AdminToken   string `mapstructure:"admin_token"`
+	MaxBodyBytes int64  `mapstructure:"max_body_bytes"`
+	LandingPage  bool   `mapstructure:"landing_page"`
+	Banner       string `mapstructure:"banner"`
+}
+
+type DisplayConfig struct {
+	Timezone string `mapstructure:"timezone"`
+}
+
+type MCPConfig struct {
+	EnabledTools          []string           `mapstructure:"enabled_tools"`
+	ExtraToolDescription  string             `mapstructure:"extra_tool_description"`
+	QueryExtraDescription string             `mapstructure:"query_extra_description"`
+	AnalysisTools         bool               `mapstructure:"analysis_tools"`
+	IncludeSQL            bool               `mapstructure:"include_sql"`
+	Watch                 WatchConfig        `mapstructure:"watch"`
+	LargeResults          LargeResultsConfig `mapstructure:"large_results"`
+}
+
+type WatchConfig struct {
+	MaxCount    int           `mapstructure:"max_count"`
+	MaxDuration time.Duration `mapstructure:"max_duration"`
+}
+
+type LargeResultsConfig struct {
+	ThresholdBytes int           `mapstructure:"threshold_bytes"`
+	TTL            time.Duration `mapstructure:"ttl"`
+	MaxBytes       int           `mapstructure:"max_bytes"`
+}
+
+type BedrockConfig struct {
+	Region        string  `mapstructure:"region"`
+	ModelID       string  `mapstructure:"model_id"`
+	MaxTokens     int     `mapstructure:"max_tokens"`
+	MaxIterations int     `mapstructure:"max_iterations"`
+	MaxSeconds    int     `mapstructure:"max_seconds"`
+	Temperature   float64 `mapstructure:"temperature"`
+}
+
+type AnalysisConfig struct {
+	ClickHouse       ConnectionConfig `mapstructure:"clickhouse"`
+	StackTraces      string           `mapstructure:"stack_traces"`
+	MinFreeDiskRatio float64          `mapstructure:"min_free_disk_ratio"`
+	QueryLogContext  bool             `mapstructure:"query_log_context"`
+}
+
+// mergeConfigFiles reads each of paths into v in order, later files
+// overriding earlier ones key by key: nested maps are merged, while lists and
+// other values are replaced. A directory stands for its *.yml and *.yaml files
+// in name order. Files that don't exist are skipped; other read errors are
+// returned.
+func mergeConfigFiles(v *viper.Viper, paths []string) error {
+	for _, p := range paths {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		files := []string{p}
+		if info, err := os.Stat(p); err == nil && info.IsDir() {
+			files = nil
+			for _, pattern := range []string{"*.yml", "*.yaml"} {
+				matches, _ := filepath.Glob(filepath.Join(p, pattern))
+				files = append(files, matches...)
+			}
+			sort.Strings(files)
+		}
+		for _, f := range files {
+			if _, err := os.Stat(f); err != nil {
+				logrus.WithError(err).WithField("config_file", f).Debug("Could not read config file, using defaults and flags")
+				continue
+			}
+			v.SetConfigFile(f)
+			if err := v.MergeInConfig(); err != nil {
+				return fmt.Errorf("reading config %s: %w", f, err)
+			}
+			logrus.WithField("config_file", f).Debug("Loaded config file")
+		}
+	}
+	return nil
+}
+
+// appConfig is the configuration decoded by the last loadConfig call.
+var appConfig Config
+
+// decodeConfig decodes v into a Config and returns it with the config keys
+// that match no field.
+func decodeConfig(v *viper.Viper) (Config, []string, error) {
+	var c Config
+	var md mapstructure.Metadata
+	if err := v.Unmarshal(&c, func(dc *mapstructure.DecoderConfig) { dc.Metadata = &md }); err != nil {
+		return Config{}, nil, err
+	}
+	sort.Strings(md.Unused)
+	return c, md.Unused, nil
+}
+
+// validate checks values that would otherwise be ignored or fail only when
+// first used.
+func (c *Config) validate() error {
+	for name, port := range map[string]int{
+		"clickhouse.port":            c.ClickHouse.Port,
+		"analysis.clickhouse.port":   c.Analysis.ClickHouse.Port,
+		"analyst_clickhouse.port":    c.AnalystClickHouse.Port,
+		"prometheus.port":            c.Prometheus.Port,
+		"prometheus_clickhouse.port": c.PrometheusClickHouse.Port,
+	} {
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("%s: %d is not a valid port", name, port)
+		}
+	}
+	for _, db := range c.ClickHouse.AllowedDatabases {
+		if err := validateDatabasePattern(db); err != nil {
+			return fmt.Errorf("clickhouse.allowed_databases: %w", err)
+		}
+	}
+	if c.GitHub.Repo != "" {
+		if owner, name, ok := strings.Cut(c.GitHub.Repo, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("github.repo: %q is not owner/name", c.GitHub.Repo)
+		}
+	}
+	switch strings.ToLower(c.Logging.Format) {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("logging.format: %q is not text or json", c.Logging.Format)
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Analysis.StackTraces)) {
+	case "", stackTracesDrop, stackTracesRaw, stackTracesSymbolize:
+	default:
+		return fmt.Errorf("analysis.stack_traces: %q is not drop, raw or symbolize", c.Analysis.StackTraces)
+	}
+	if c.Analysis.MinFreeDiskRatio < 0 || c.Analysis.MinFreeDiskRatio >= 1 {
+		return fmt.Errorf("analysis.min_free_disk_ratio: %v is not between 0 and 1", c.Analysis.MinFreeDiskRatio)
+	}
+	if _, ok := promptGuards[strings.ToLower(strings.TrimSpace(c.Agent.PromptGuard))]; !ok {
+		return fmt.Errorf("agent.prompt_guard: %q is not delimit or off", c.Agent.PromptGuard)
+	}
+	if _, err := model.ParseDuration(c.Prometheus.MaxRange); err != nil {
+		return fmt.Errorf("prometheus.max_range: %w", err)
+	}
+	if c.MCP.LargeResults.ThresholdBytes > 0 && c.MCP.LargeResults.TTL <= 0 {
+		return fmt.Errorf("mcp.large_results.ttl must be positive when threshold_bytes is set")
+	}
+	for target, text := range map[string]string{"slack": c.Slack.Template, "github": c.GitHub.Template} {
+		if _, err := parseNotifyTemplate(target, text); err != nil {
+			return err
+		}
+	}
+	if tz := strings.TrimSpace(c.Display.Timezone); tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			return fmt.Errorf("display.timezone: %w", err)
+		}
+	}
+	if c.ClickHouse.MaxCellLength < 0 {
+		return fmt.Errorf("clickhouse.max_cell_length must not be negative")
+	}
+	switch strings.ToLower(strings.TrimSpace(c.ClickHouse.QueryLogFilter)) {
+	case "", queryLogFilterOff, queryLogFilterInject, queryLogFilterReject:
+	default:
+		return fmt.Errorf("clickhouse.query_log_time_filter: %q is not off, inject or reject", c.ClickHouse.QueryLogFilter)
+	}
+	if c.ClickHouse.QueryLogWindow < 0 {
+		return fmt.Errorf("clickhouse.query_log_window must not be negative")
+	}
+	if c.Slack.MaxMessagesPerMinute < 0 {
+		return fmt.Errorf("slack.max_messages_per_minute must not be negative")
+	}
+	if c.MCP.Watch.MaxCount < 1 || c.MCP.Watch.MaxDuration <= 0 {
+		return fmt.Errorf("mcp.watch.max_count and mcp.watch.max_duration must be positive")
+	}
+	return nil
+}
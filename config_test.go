@@ -0,0 +1,214 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestWriteExampleConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nested", "config.yml")
+
+	if err := writeExampleConfig(path, false); err != nil {
+		t.Fatalf("writeExampleConfig() error = %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(exampleConfig) {
+		t.Error("writeExampleConfig() wrote unexpected content")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("writeExampleConfig() file mode = %o, want 600", perm)
+	}
+
+	// Refuses to overwrite without force.
+	if err := os.WriteFile(path, []byte("edited: true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeExampleConfig(path, false); err == nil {
+		t.Error("writeExampleConfig() expected error for existing file, got nil")
+	}
+	if got, _ := os.ReadFile(path); string(got) != "edited: true\n" {
+		t.Error("writeExampleConfig() modified existing file without force")
+	}
+
+	// Overwrites with force.
+	if err := writeExampleConfig(path, true); err != nil {
+		t.Fatalf("writeExampleConfig(force) error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != string(exampleConfig) {
+		t.Error("writeExampleConfig(force) did not overwrite")
+	}
+}
+
+func TestExampleConfigParses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := writeExampleConfig(path, false); err != nil {
+		t.Fatal(err)
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatalf("example config does not parse: %v", err)
+	}
+	for _, key := range []string{"clickhouse.host", "prometheus.host", "http.addr", "slack.webhook_url"} {
+		if !v.IsSet(key) {
+			t.Errorf("example config missing %s", key)
+		}
+	}
+	// Task models fall back to gemini.model unless set.
+	for _, key := range []string{"gemini.errors_model", "gemini.performance_model"} {
+		if m := v.GetString(key); m != "" {
+			t.Errorf("example config sets %s = %q, want empty", key, m)
+		}
+	}
+}
+
+func TestMergeConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	base := write("base.yml", `clickhouse:
+  host: base-host
+  port: 9000
+  allowed_databases: [system, models]
+http:
+  addr: ":8080"
+`)
+	prod := write("prod.yml", `clickhouse:
+  host: prod-host
+  allowed_databases: [system]
+`)
+	write("conf.d/10-logging.yaml", "logging:\n  level: debug\n")
+	write("conf.d/20-http.yml", "http:\n  addr: \":9090\"\nlogging:\n  level: warn\n")
+	write("conf.d/notes.txt", "not: config\n")
+
+	v := viper.New()
+	if err := mergeConfigFiles(v, []string{base, prod, filepath.Join(dir, "conf.d"), filepath.Join(dir, "missing.yml")}); err != nil {
+		t.Fatalf("mergeConfigFiles() error = %v", err)
+	}
+	for key, want := range map[string]interface{}{
+		"clickhouse.host": "prod-host", // overridden by a later file
+		"clickhouse.port": 9000,        // kept from the base file
+		"http.addr":       ":9090",     // from the directory
+		"logging.level":   "warn",      // directory files in name order
+	} {
+		if got := v.Get(key); got != want {
+			t.Errorf("%s = %v, want %v", key, got, want)
+		}
+	}
+	if got := v.GetStringSlice("clickhouse.allowed_databases"); len(got) != 1 || got[0] != "system" {
+		t.Errorf("clickhouse.allowed_databases = %v, want lists replaced by the later file", got)
+	}
+	if v.IsSet("not") {
+		t.Error("non-YAML file in the directory was read")
+	}
+
+	if err := mergeConfigFiles(viper.New(), []string{write("bad.yml", "clickhouse: [\n")}); err == nil {
+		t.Error("mergeConfigFiles() with an invalid file: error = nil")
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := writeExampleConfig(path, false); err != nil {
+		t.Fatal(err)
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, unknown, err := decodeConfig(v)
+	if err != nil {
+		t.Fatalf("decodeConfig() error = %v", err)
+	}
+	if len(unknown) > 0 {
+		t.Errorf("example config has keys Config doesn't know: %v", unknown)
+	}
+	if c.ClickHouse.Host != "127.0.0.1" || c.ClickHouse.Port != 9000 {
+		t.Errorf("clickhouse connection = %+v, want 127.0.0.1:9000", c.ClickHouse.ConnectionConfig)
+	}
+	if c.Logging.DedupWindow != 30*time.Second || c.MCP.Watch.MaxDuration != 2*time.Minute {
+		t.Errorf("durations = %s, %s, want 30s, 2m", c.Logging.DedupWindow, c.MCP.Watch.MaxDuration)
+	}
+	if err := c.validate(); err != nil {
+		t.Errorf("example config does not validate: %v", err)
+	}
+
+	v.Set("clickhouse.hots", "typo")
+	if _, unknown, _ := decodeConfig(v); len(unknown) != 1 || unknown[0] != "clickhouse.hots" {
+		t.Errorf("decodeConfig() unknown = %v, want [clickhouse.hots]", unknown)
+	}
+
+	v.Set("logging.dedup_window", "soon")
+	if _, _, err := decodeConfig(v); err == nil {
+		t.Error("decodeConfig() accepted an invalid duration")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() Config {
+		var c Config
+		c.ClickHouse.Port = 9000
+		c.Agent.PromptGuard = "delimit"
+		c.Prometheus.MaxRange = "0"
+		c.MCP.Watch = WatchConfig{MaxCount: 30, MaxDuration: time.Minute}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(c *Config) {}},
+		{name: "port out of range", modify: func(c *Config) { c.ClickHouse.Port = 70000 }, wantErr: true},
+		{name: "unknown log format", modify: func(c *Config) { c.Logging.Format = "xml" }, wantErr: true},
+		{name: "unknown stack trace mode", modify: func(c *Config) { c.Analysis.StackTraces = "full" }, wantErr: true},
+		{name: "negative min free disk ratio", modify: func(c *Config) { c.Analysis.MinFreeDiskRatio = -0.1 }, wantErr: true},
+		{name: "min free disk ratio of one", modify: func(c *Config) { c.Analysis.MinFreeDiskRatio = 1 }, wantErr: true},
+		{name: "unknown prompt guard", modify: func(c *Config) { c.Agent.PromptGuard = "strict" }, wantErr: true},
+		{name: "promql duration", modify: func(c *Config) { c.Prometheus.MaxRange = "7d" }},
+		{name: "bad max_range", modify: func(c *Config) { c.Prometheus.MaxRange = "a week" }, wantErr: true},
+		{name: "large results without ttl", modify: func(c *Config) { c.MCP.LargeResults.ThresholdBytes = 1024 }, wantErr: true},
+		{name: "zero watch count", modify: func(c *Config) { c.MCP.Watch.MaxCount = 0 }, wantErr: true},
+		{name: "database pattern", modify: func(c *Config) { c.ClickHouse.AllowedDatabases = []string{"system", "app_*"} }},
+		{name: "bad database pattern", modify: func(c *Config) { c.ClickHouse.AllowedDatabases = []string{"app_[x"} }, wantErr: true},
+		{name: "negative slack rate", modify: func(c *Config) { c.Slack.MaxMessagesPerMinute = -1 }, wantErr: true},
+		{name: "notification template", modify: func(c *Config) { c.GitHub.Template = "{{.Severity}}: {{.Body}}" }},
+		{name: "invalid notification template", modify: func(c *Config) { c.Slack.Template = "{{.Body" }, wantErr: true},
+		{name: "display timezone", modify: func(c *Config) { c.Display.Timezone = "Europe/Berlin" }},
+		{name: "unknown display timezone", modify: func(c *Config) { c.Display.Timezone = "Not/AZone" }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(&c)
+			if err := c.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
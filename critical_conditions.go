@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Kinds of criticalCondition.
+const (
+	conditionReadOnlyReplica = "read-only replica"
+	conditionLowDisk         = "low disk space"
+)
+
+// criticalErrorCodes are system.errors codes that mean a replica can't
+// take writes, mapped to the condition they indicate.
+var criticalErrorCodes = map[int32]string{
+	242: conditionReadOnlyReplica, // TABLE_IS_READ_ONLY
+	243: conditionLowDisk,         // NOT_ENOUGH_SPACE
+}
+
+// maxConditionDetails bounds the tables, disks or errors listed per condition.
+const maxConditionDetails = 5
+
+// criticalCondition is a condition found before error analysis that always
+// makes the analysis critical, with the hosts it affects.
+type criticalCondition struct {
+	Kind    string
+	Hosts   []string
+	Details []string
+}
+
+// conditionSet collects criticalConditions by kind, deduplicating hosts and
+// details.
+type conditionSet map[string]*criticalCondition
+
+func (s conditionSet) add(kind, host, detail string) {
+	c, ok := s[kind]
+	if !ok {
+		c = &criticalCondition{Kind: kind}
+		s[kind] = c
+	}
+	if !containsString(c.Hosts, host) {
+		c.Hosts = append(c.Hosts, host)
+	}
+	if detail != "" && !containsString(c.Details, detail) {
+		c.Details = append(c.Details, detail)
+	}
+}
+
+// list returns the conditions ordered by kind, with sorted hosts.
+func (s conditionSet) list() []criticalCondition {
+	out := make([]criticalCondition, 0, len(s))
+	for _, c := range s {
+		sort.Strings(c.Hosts)
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Kind < out[j].Kind })
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// addErrorConditions records the conditions indicated by chErrors.
+func addErrorConditions(set conditionSet, chErrors CHErrors) {
+	for _, e := range chErrors {
+		if kind, ok := criticalErrorCodes[e.Code]; ok {
+			set.add(kind, e.Hostname, fmt.Sprintf("%s (%d) x%d", e.Name, e.Code, e.Value))
+		}
+	}
+}
+
+// minFreeDiskRatio returns analysis.min_free_disk_ratio: disks with a smaller
+// share of free space count as low. 0 disables the disk check.
+func minFreeDiskRatio() float64 {
+	return viper.GetFloat64("analysis.min_free_disk_ratio")
+}
+
+// addReplicaConditions records replicas reporting is_readonly and disks
+// below analysis.min_free_disk_ratio on every node of the cluster.
+func addReplicaConditions(ctx context.Context, conn driver.Conn, set conditionSet) error {
+	cluster := viper.GetString("clickhouse.cluster")
+	rows, err := conn.Query(ctx, "SELECT hostname(), database, table FROM clusterAllReplicas(?, system.replicas) WHERE is_readonly", cluster)
+	usage.recordQuery(err)
+	if err != nil {
+		return fmt.Errorf("reading system.replicas: %w", err)
+	}
+	for rows.Next() {
+		var host, db, table string
+		if err := rows.Scan(&host, &db, &table); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		set.add(conditionReadOnlyReplica, host, db+"."+table)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	ratio := minFreeDiskRatio()
+	if ratio <= 0 {
+		return nil
+	}
+	rows, err = conn.Query(ctx, "SELECT hostname(), name, free_space, total_space FROM clusterAllReplicas(?, system.disks)"+
+		" WHERE total_space > 0 AND free_space < total_space * ?", cluster, ratio)
+	usage.recordQuery(err)
+	if err != nil {
+		return fmt.Errorf("reading system.disks: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
+	for rows.Next() {
+		var host, disk string
+		var free, total uint64
+		if err := rows.Scan(&host, &disk, &free, &total); err != nil {
+			return err
+		}
+		set.add(conditionLowDisk, host, fmt.Sprintf("disk %s: %s free of %s", disk, humanBytes(float64(free)), humanBytes(float64(total))))
+	}
+	return rows.Err()
+}
+
+// detectCriticalConditions finds read-only replicas and low disk space, from
+// chErrors and from system.replicas and system.disks. A failure to read the
+// system tables is logged and only the conditions found in chErrors are
+// returned.
+func detectCriticalConditions(ctx context.Context, chErrors CHErrors) []criticalCondition {
+	set := conditionSet{}
+	addErrorConditions(set, chErrors)
+
+	conn, err := connectAnalysis()
+	if err != nil {
+		logrus.WithError(err).Warn("Could not check replicas and disks before error analysis")
+		return set.list()
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing ClickHouse connection")
+		}
+	}()
+	if err := addReplicaConditions(ctx, conn, set); err != nil {
+		logrus.WithError(err).Warn("Could not check replicas and disks before error analysis")
+	}
+	return set.list()
+}
+
+// formatCriticalConditions renders conditions as Slack markdown lines marked
+// 🔴, so analysisSeverity rates the analysis critical. It returns "" for none.
+func formatCriticalConditions(conditions []criticalCondition) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("*🔴 Critical conditions detected*\n")
+	for _, c := range conditions {
+		fmt.Fprintf(&b, "• 🔴 %s on %s", c.Kind, strings.Join(c.Hosts, ", "))
+		details := c.Details
+		if len(details) > maxConditionDetails {
+			details = append(details[:maxConditionDetails:maxConditionDetails], fmt.Sprintf("%d more", len(c.Details)-maxConditionDetails))
+		}
+		if len(details) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(details, "; "))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
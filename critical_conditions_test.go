@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddErrorConditions(t *testing.T) {
+	set := conditionSet{}
+	addErrorConditions(set, CHErrors{
+		{Hostname: "ch-2", Name: "TABLE_IS_READ_ONLY", Code: 242, Value: 3},
+		{Hostname: "ch-1", Name: "TABLE_IS_READ_ONLY", Code: 242, Value: 5},
+		{Hostname: "ch-1", Name: "NOT_ENOUGH_SPACE", Code: 243, Value: 1},
+		{Hostname: "ch-1", Name: "TIMEOUT_EXCEEDED", Code: 159, Value: 9},
+	})
+	set.add(conditionReadOnlyReplica, "ch-2", "default.events")
+
+	want := []criticalCondition{
+		{Kind: conditionLowDisk, Hosts: []string{"ch-1"}, Details: []string{"NOT_ENOUGH_SPACE (243) x1"}},
+		{Kind: conditionReadOnlyReplica, Hosts: []string{"ch-1", "ch-2"}, Details: []string{"TABLE_IS_READ_ONLY (242) x3", "TABLE_IS_READ_ONLY (242) x5", "default.events"}},
+	}
+	if got := set.list(); !reflect.DeepEqual(got, want) {
+		t.Errorf("list() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFormatCriticalConditions(t *testing.T) {
+	if got := formatCriticalConditions(nil); got != "" {
+		t.Errorf("formatCriticalConditions(nil) = %q, want empty", got)
+	}
+
+	var tables []string
+	for i := 0; i < maxConditionDetails+2; i++ {
+		tables = append(tables, fmt.Sprintf("db.t%d", i))
+	}
+	got := formatCriticalConditions([]criticalCondition{
+		{Kind: conditionLowDisk, Hosts: []string{"ch-1"}, Details: []string{"disk default: 1.00GiB free of 100.00GiB"}},
+		{Kind: conditionReadOnlyReplica, Hosts: []string{"ch-1", "ch-2"}, Details: tables},
+	})
+	for _, want := range []string{
+		"🔴 low disk space on ch-1 (disk default: 1.00GiB free of 100.00GiB)",
+		"🔴 read-only replica on ch-1, ch-2 (db.t0;",
+		"db.t4; 2 more)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("formatCriticalConditions() = %q, missing %q", got, want)
+		}
+	}
+	if strings.Contains(got, "db.t5") {
+		t.Errorf("formatCriticalConditions() = %q, want at most %d details", got, maxConditionDetails)
+	}
+	if sev := analysisSeverity(got + "\n🟢 all else fine"); sev != severityCritical {
+		t.Errorf("analysisSeverity() = %q, want %q", sev, severityCritical)
+	}
+}
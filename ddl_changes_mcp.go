@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ddlChangesArgs is the input to the clickhouse_ddl_changes tool.
+type ddlChangesArgs struct {
+	Since    string `json:"since,omitempty" jsonschema:"how far back to look as a Go duration (e.g. 6h); default 24h, maximum 720h"`
+	Database string `json:"database,omitempty" jsonschema:"only statements touching this database"`
+	Cluster  string `json:"cluster,omitempty" jsonschema:"cluster to search across; defaults to the configured cluster"`
+	Limit    int    `json:"limit,omitempty" jsonschema:"maximum number of statements to return, newest first; default 50, maximum 500"`
+}
+
+// ddlChange is one successful DDL statement as recorded in system.query_log.
+type ddlChange struct {
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host"`
+	User     string    `json:"user"`
+	Kind     string    `json:"kind" jsonschema:"query_kind: Create, Alter, Drop or Rename"`
+	Database string    `json:"database" jsonschema:"current database of the session"`
+	Query    string    `json:"query"`
+	QueryID  string    `json:"query_id"`
+	Initial  bool      `json:"initial" jsonschema:"false when the statement was forwarded by ON CLUSTER to this replica"`
+}
+
+// ddlChangesResult is the structured output of clickhouse_ddl_changes.
+type ddlChangesResult struct {
+	Since   time.Time   `json:"since"`
+	Changes []ddlChange `json:"changes"`
+}
+
+const (
+	defaultDDLChangesWindow = 24 * time.Hour
+	maxDDLChangesWindow     = 30 * 24 * time.Hour
+	defaultDDLChangesLimit  = 50
+	maxDDLChangesLimit      = 500
+	maxDDLQueryLength       = 2000
+)
+
+// parseDDLChangesArgs applies defaults and bounds to the lookback window and
+// result limit.
+func parseDDLChangesArgs(a ddlChangesArgs) (time.Duration, int, error) {
+	window := defaultDDLChangesWindow
+	if strings.TrimSpace(a.Since) != "" {
+		d, err := time.ParseDuration(a.Since)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid since: %v", err)
+		}
+		window = d
+	}
+	if window <= 0 || window > maxDDLChangesWindow {
+		return 0, 0, fmt.Errorf("since must be positive and at most %s", maxDDLChangesWindow)
+	}
+
+	limit := a.Limit
+	if limit == 0 {
+		limit = defaultDDLChangesLimit
+	}
+	if limit < 1 || limit > maxDDLChangesLimit {
+		return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxDDLChangesLimit)
+	}
+
+	if a.Database != "" {
+		for i := 0; i < len(a.Database); i++ {
+			if !isIdentChar(a.Database[i]) {
+				return 0, 0, fmt.Errorf("invalid database name: %q", a.Database)
+			}
+		}
+	}
+	return window, limit, nil
+}
+
+// buildDDLChangesQuery returns the query_log query for successful DDL on every
+// replica of cluster (or the connected server when cluster is empty) and its
+// bind arguments.
+func buildDDLChangesQuery(cluster, database string, window time.Duration, limit int) (string, []interface{}) {
+	source := "system.query_log"
+	var args []interface{}
+	if cluster != "" {
+		source = "clusterAllReplicas(?, system.query_log)"
+		args = append(args, cluster)
+	}
+
+	var b strings.Builder
+	b.WriteString("SELECT event_time, hostName() AS host, user, query_kind, current_database, query, query_id, is_initial_query FROM ")
+	b.WriteString(source)
+	b.WriteString(" WHERE type = 'QueryFinish' AND query_kind IN ('Create', 'Alter', 'Drop', 'Rename')")
+	b.WriteString(" AND event_date >= toDate(now() - toIntervalSecond(?)) AND event_time >= now() - toIntervalSecond(?)")
+	secs := int64(window / time.Second)
+	args = append(args, secs, secs)
+	if database != "" {
+		b.WriteString(" AND (has(databases, ?) OR current_database = ?)")
+		args = append(args, database, database)
+	}
+	b.WriteString(" ORDER BY event_time DESC LIMIT ?")
+	args = append(args, limit)
+	return b.String(), args
+}
+
+func registerDDLChangesTool(srv *mcp.Server) {
+	addTool[ddlChangesArgs, *ddlChangesResult](
+		srv,
+		&mcp.Tool{
+			Name:        "clickhouse_ddl_changes",
+			Title:       "Recent DDL changes",
+			Description: `List recent successful CREATE/ALTER/DROP/RENAME statements from system.query_log on every replica, newest first, with who ran them, where and when. Use to answer "what changed recently?" when correlating an incident with schema changes. ON CLUSTER statements appear once per replica; initial=true marks the replica the statement was submitted to.`,
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		},
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[ddlChangesArgs]) (*mcp.CallToolResultFor[*ddlChangesResult], error) {
+			a := req.Arguments
+			window, limit, err := parseDDLChangesArgs(a)
+			if err != nil {
+				return nil, err
+			}
+			cluster := strings.TrimSpace(a.Cluster)
+			if cluster == "" {
+				cluster = viper.GetString("clickhouse.cluster")
+			}
+
+			conn, err := connect()
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				if err := conn.Close(); err != nil {
+					logrus.WithError(err).Warn("Error closing ClickHouse connection")
+				}
+			}()
+
+			res, err := fetchDDLChanges(ctx, conn, cluster, a.Database, window, limit)
+			if err != nil {
+				return nil, err
+			}
+			return &mcp.CallToolResultFor[*ddlChangesResult]{
+				Content:           []mcp.Content{&mcp.TextContent{Text: summarizeDDLChanges(res)}},
+				StructuredContent: res,
+			}, nil
+		},
+	)
+}
+
+// fetchDDLChanges runs the DDL query and truncates long statements.
+func fetchDDLChanges(ctx context.Context, conn driver.Conn, cluster, database string, window time.Duration, limit int) (*ddlChangesResult, error) {
+	query, args := buildDDLChangesQuery(cluster, database, window, limit)
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
+
+	res := &ddlChangesResult{Since: time.Now().Add(-window).UTC(), Changes: []ddlChange{}}
+	for rows.Next() {
+		var c ddlChange
+		var initial uint8
+		if err := rows.Scan(&c.Time, &c.Host, &c.User, &c.Kind, &c.Database, &c.Query, &c.QueryID, &initial); err != nil {
+			return nil, err
+		}
+		c.Time = c.Time.UTC()
+		c.Initial = initial == 1
+		if len(c.Query) > maxDDLQueryLength {
+			c.Query = c.Query[:maxDDLQueryLength] + "..."
+		}
+		res.Changes = append(res.Changes, c)
+	}
+	return res, rows.Err()
+}
+
+// summarizeDDLChanges renders one line per statement.
+func summarizeDDLChanges(res *ddlChangesResult) string {
+	if len(res.Changes) == 0 {
+		return fmt.Sprintf("No DDL statements since %s.", res.Since.Format(time.RFC3339))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d DDL statement(s) since %s:", len(res.Changes), res.Since.Format(time.RFC3339))
+	for _, c := range res.Changes {
+		query := strings.Join(strings.Fields(c.Query), " ")
+		if len(query) > 200 {
+			query = query[:200] + "..."
+		}
+		fmt.Fprintf(&b, "\n[%s] %s %s by %s: %s", c.Time.Format(time.RFC3339), c.Host, c.Kind, c.User, query)
+	}
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDDLChangesArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       ddlChangesArgs
+		wantWindow time.Duration
+		wantLimit  int
+		wantErr    bool
+	}{
+		{name: "defaults", args: ddlChangesArgs{}, wantWindow: 24 * time.Hour, wantLimit: 50},
+		{name: "explicit", args: ddlChangesArgs{Since: "6h", Limit: 10, Database: "models"}, wantWindow: 6 * time.Hour, wantLimit: 10},
+		{name: "window at limit", args: ddlChangesArgs{Since: "720h"}, wantWindow: 720 * time.Hour, wantLimit: 50},
+		{name: "window over limit", args: ddlChangesArgs{Since: "721h"}, wantErr: true},
+		{name: "negative window", args: ddlChangesArgs{Since: "-1h"}, wantErr: true},
+		{name: "invalid window", args: ddlChangesArgs{Since: "yesterday"}, wantErr: true},
+		{name: "limit over max", args: ddlChangesArgs{Limit: 501}, wantErr: true},
+		{name: "negative limit", args: ddlChangesArgs{Limit: -1}, wantErr: true},
+		{name: "invalid database", args: ddlChangesArgs{Database: "models' OR 1=1"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, limit, err := parseDDLChangesArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDDLChangesArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if window != tt.wantWindow || limit != tt.wantLimit {
+				t.Errorf("parseDDLChangesArgs() = %s, %d, want %s, %d", window, limit, tt.wantWindow, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestBuildDDLChangesQuery(t *testing.T) {
+	query, args := buildDDLChangesQuery("main", "models", time.Hour, 20)
+	for _, want := range []string{
+		"FROM clusterAllReplicas(?, system.query_log)",
+		"type = 'QueryFinish'",
+		"query_kind IN ('Create', 'Alter', 'Drop', 'Rename')",
+		"has(databases, ?)",
+		"ORDER BY event_time DESC LIMIT ?",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query missing %q: %s", want, query)
+		}
+	}
+	wantArgs := []interface{}{"main", int64(3600), int64(3600), "models", "models", 20}
+	if len(args) != len(wantArgs) {
+		t.Fatalf("args = %v, want %v", args, wantArgs)
+	}
+	for i := range args {
+		if args[i] != wantArgs[i] {
+			t.Errorf("args[%d] = %v, want %v", i, args[i], wantArgs[i])
+		}
+	}
+
+	query, args = buildDDLChangesQuery("", "", time.Hour, 20)
+	if !strings.Contains(query, "FROM system.query_log WHERE") || strings.Contains(query, "databases") {
+		t.Errorf("local query without database filter = %s", query)
+	}
+	if len(args) != 3 {
+		t.Errorf("local query args = %v, want 3", args)
+	}
+}
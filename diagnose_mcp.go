@@ -2,13 +2,11 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"reflect"
 	"strings"
 
-	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 	"github.com/sirupsen/logrus"
@@ -29,47 +27,15 @@ const maxToolResultChars = 12000
 // uses analyst_clickhouse.* config, falling back to the clickhouse.* connection
 // when no analyst user is set.
 func connectAnalyst() (driver.Conn, error) {
-	host := viper.GetString("analyst_clickhouse.host")
-	if host == "" {
-		host = viper.GetString("clickhouse.host")
-	}
-	port := viper.GetInt("analyst_clickhouse.port")
-	if port == 0 {
-		port = viper.GetInt("clickhouse.port")
-	}
-	user := viper.GetString("analyst_clickhouse.user")
-	password := viper.GetString("analyst_clickhouse.password")
-	if user == "" {
-		// No dedicated analyst credentials — fall back to the default connection.
-		user = viper.GetString("clickhouse.user")
-		password = viper.GetString("clickhouse.password")
+	if viper.GetString("analyst_clickhouse.user") == "" {
 		logrus.Warn("diagnose: analyst_clickhouse.user not set; using the default clickhouse connection")
 	}
-	database := viper.GetString("analyst_clickhouse.database")
-	if database == "" {
-		database = viper.GetString("clickhouse.database")
-	}
-
-	addr := fmt.Sprintf("%s:%d", host, port)
-	conn, err := clickhouse.Open(&clickhouse.Options{
-		Addr: []string{addr},
-		Auth: clickhouse.Auth{Database: database, Username: user, Password: password},
-		TLS:  &tls.Config{InsecureSkipVerify: true},
-		ClientInfo: clickhouse.ClientInfo{
-			Products: []struct {
-				Name    string
-				Version string
-			}{{Name: "housekeeper-diagnose", Version: "0.1"}},
-		},
-	})
+	cfg := chConnConfigFrom("analyst_clickhouse")
+	conn, err := openClickHouse(cfg, "housekeeper-diagnose")
 	if err != nil {
 		return nil, err
 	}
-	ctx := context.Background()
-	if err := conn.Ping(ctx); err != nil {
-		return nil, err
-	}
-	logrus.WithFields(logrus.Fields{"host": host, "user": user}).Debug("diagnose: analyst connection established")
+	logrus.WithFields(logrus.Fields{"host": cfg.Host, "user": cfg.User}).Debug("diagnose: analyst connection established")
 	return conn, nil
 }
 
@@ -112,9 +78,9 @@ func queryRows(ctx context.Context, conn driver.Conn, query string) ([]map[strin
 					row[c] = nil
 					continue
 				}
-				row[c] = normalizeValue(vptr.Elem().Interface())
+				row[c] = normalizeColumnValue(colTypes[i].DatabaseTypeName(), vptr.Elem().Interface())
 			} else {
-				row[c] = normalizeValue(holders[i].Elem().Interface())
+				row[c] = normalizeColumnValue(colTypes[i].DatabaseTypeName(), holders[i].Elem().Interface())
 			}
 		}
 		results = append(results, row)
@@ -154,17 +120,31 @@ Output rules:
 
 Deployment-specific details (databases, tables, clusters, node sizes) are appended below when configured.`
 
+// runSQLInputSchema is the JSON schema of the diagnose agent's run_sql input.
+var runSQLInputSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"sql": map[string]any{
+			"type":        "string",
+			"description": "A single read-only SELECT or WITH query. Use clusterAllReplicas('<cluster>', system.<table>) for system tables.",
+		},
+	},
+	"required": []any{"sql"},
+}
+
 // registerDiagnoseTool adds the in-MCP, Bedrock-backed diagnose tool. The model
 // queries ClickHouse via the analyst connection and the tool returns its summary.
 func registerDiagnoseTool(srv *mcp.Server) {
-	system := diagnoseSystemPrompt
+	system := renderSystemPrompt("diagnose", withToolSchemas(diagnoseSystemPrompt, "run_sql"), "run_sql")
 	if extra := strings.TrimSpace(viper.GetString("mcp.extra_tool_description")); extra != "" {
 		system += "\n\nDeployment-specific context:\n" + extra
 	}
+	guard := activePromptGuard()
+	system = withGuardNotice(guard, system)
 
 	desc := `Ask a natural-language question about ClickHouse health and get an investigated, attributed diagnosis. Runs an in-account LLM agent that investigates server-side and returns only a summary. Use for "why is X slow / lagging / erroring", "what's driving load on <cluster>", "who owns this query pattern". For raw row access use clickhouse_query instead.`
 
-	mcp.AddTool[diagnoseArgs, map[string]any](
+	addTool[diagnoseArgs, map[string]any](
 		srv,
 		&mcp.Tool{
 			Name:        "clickhouse_diagnose",
@@ -196,16 +176,7 @@ func registerDiagnoseTool(srv *mcp.Server) {
 			runSQL := bedrockTool{
 				name:        "run_sql",
 				description: "Execute one read-only SELECT/WITH query against ClickHouse and return the rows. Single statement only.",
-				inputSchema: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"sql": map[string]any{
-							"type":        "string",
-							"description": "A single read-only SELECT or WITH query. Use clusterAllReplicas('<cluster>', system.<table>) for system tables.",
-						},
-					},
-					"required": []any{"sql"},
-				},
+				inputSchema: runSQLInputSchema,
 			}
 
 			handle := func(name string, input map[string]any) (string, error) {
@@ -220,11 +191,15 @@ func registerDiagnoseTool(srv *mcp.Server) {
 				if err := validateFreeformSQL(sql); err != nil {
 					return "", err
 				}
+				if err := checkQueryLogTimeFilter(sql); err != nil {
+					return "", err
+				}
 				rows, err := queryRows(ctx, conn, sql)
 				if err != nil {
 					return "", err
 				}
-				return formatRowsForModel(rows), nil
+				truncateCells(rows, maxCellLength())
+				return guard.Guard("run_sql", formatRowsForModel(rows)), nil
 			}
 
 			userMsg := q
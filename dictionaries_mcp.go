@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// dictionariesArgs is the input to the clickhouse_dictionaries tool.
+type dictionariesArgs struct {
+	Cluster      string `json:"cluster,omitempty" jsonschema:"cluster to read dictionaries across; defaults to the configured cluster"`
+	Database     string `json:"database,omitempty" jsonschema:"only dictionaries in this database"`
+	ProblemsOnly bool   `json:"problems_only,omitempty" jsonschema:"only return failed or stale dictionaries"`
+}
+
+// dictionaryStatus is one row of system.dictionaries on one replica.
+type dictionaryStatus struct {
+	Host              string    `json:"host"`
+	Database          string    `json:"database"`
+	Name              string    `json:"name"`
+	Status            string    `json:"status" jsonschema:"load status, e.g. LOADED, FAILED, LOADING, NOT_LOADED (not yet used, with lazy loading)"`
+	Type              string    `json:"type,omitempty"`
+	ElementCount      uint64    `json:"element_count"`
+	BytesAllocated    uint64    `json:"bytes_allocated"`
+	LastSuccessful    time.Time `json:"last_successful_update_time"`
+	LoadingDuration   float64   `json:"loading_duration_seconds"`
+	LifetimeMaxSecond uint64    `json:"lifetime_max_seconds" jsonschema:"upper bound of the reload interval; 0 means never reloaded"`
+	LastException     string    `json:"last_exception,omitempty"`
+	Problem           string    `json:"problem,omitempty" jsonschema:"failed or stale; empty when healthy"`
+}
+
+// dictionariesResult is the structured output of clickhouse_dictionaries.
+type dictionariesResult struct {
+	Dictionaries []dictionaryStatus `json:"dictionaries"`
+	Total        int                `json:"total" jsonschema:"dictionary replicas read, before problems_only filtering"`
+	Failed       int                `json:"failed"`
+	Stale        int                `json:"stale"`
+}
+
+const (
+	dictionaryFailed = "failed"
+	dictionaryStale  = "stale"
+
+	// staleLifetimes is how many lifetime_max intervals a dictionary may go
+	// without a successful update before it counts as stale.
+	staleLifetimes = 2
+)
+
+// dictionaryProblem classifies d as failed (a failed status or a recorded
+// exception), stale (loaded, but not updated for staleLifetimes times its
+// maximum lifetime) or healthy ("").
+func dictionaryProblem(d dictionaryStatus, now time.Time) string {
+	if strings.Contains(d.Status, "FAILED") || d.LastException != "" {
+		return dictionaryFailed
+	}
+	if strings.HasPrefix(d.Status, "LOADED") && d.LifetimeMaxSecond > 0 && !d.LastSuccessful.IsZero() {
+		maxAge := time.Duration(staleLifetimes*d.LifetimeMaxSecond) * time.Second
+		if now.Sub(d.LastSuccessful) > maxAge {
+			return dictionaryStale
+		}
+	}
+	return ""
+}
+
+// buildDictionariesQuery returns the system.dictionaries query on every
+// replica of cluster and its bind arguments.
+func buildDictionariesQuery(cluster, database string) (string, []interface{}) {
+	query := "SELECT hostName() AS host, database, name, toString(status), type, element_count, bytes_allocated," +
+		" last_successful_update_time, loading_duration, lifetime_max, last_exception" +
+		" FROM clusterAllReplicas(?, system.dictionaries)"
+	args := []interface{}{cluster}
+	if database != "" {
+		query += " WHERE database = ?"
+		args = append(args, database)
+	}
+	query += " ORDER BY database, name, host"
+	return query, args
+}
+
+func registerDictionariesTool(srv *mcp.Server) {
+	addTool[dictionariesArgs, *dictionariesResult](
+		srv,
+		&mcp.Tool{
+			Name:        "clickhouse_dictionaries",
+			Title:       "ClickHouse dictionary status",
+			Description: `Show the dictionaries on every replica (system.dictionaries): load status, last successful update, element count, memory and last_exception. Failed dictionaries (failed status or an exception) and stale ones (loaded but not updated for twice their maximum lifetime) are flagged in problem. Use for "are our dictionaries loaded and fresh?" or when dictGet calls fail. Set problems_only to list only flagged ones.`,
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		},
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[dictionariesArgs]) (*mcp.CallToolResultFor[*dictionariesResult], error) {
+			a := req.Arguments
+			database := strings.TrimSpace(a.Database)
+			if strings.ContainsAny(database, ";'`\n\r\t") {
+				return nil, fmt.Errorf("invalid database name: %q", database)
+			}
+			cluster := strings.TrimSpace(a.Cluster)
+			if cluster == "" {
+				cluster = viper.GetString("clickhouse.cluster")
+			}
+
+			conn, err := connect()
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				if err := conn.Close(); err != nil {
+					logrus.WithError(err).Warn("Error closing ClickHouse connection")
+				}
+			}()
+
+			res, err := fetchDictionaries(ctx, conn, cluster, database, a.ProblemsOnly, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			return &mcp.CallToolResultFor[*dictionariesResult]{
+				Content:           []mcp.Content{&mcp.TextContent{Text: summarizeDictionaries(res)}},
+				StructuredContent: res,
+			}, nil
+		},
+	)
+}
+
+// fetchDictionaries reads system.dictionaries and flags problems as of now.
+func fetchDictionaries(ctx context.Context, conn driver.Conn, cluster, database string, problemsOnly bool, now time.Time) (*dictionariesResult, error) {
+	query, args := buildDictionariesQuery(cluster, database)
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
+
+	var all []dictionaryStatus
+	for rows.Next() {
+		var d dictionaryStatus
+		var loading float32
+		if err := rows.Scan(&d.Host, &d.Database, &d.Name, &d.Status, &d.Type, &d.ElementCount, &d.BytesAllocated,
+			&d.LastSuccessful, &loading, &d.LifetimeMaxSecond, &d.LastException); err != nil {
+			return nil, err
+		}
+		d.LoadingDuration = float64(loading)
+		all = append(all, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return classifyDictionaries(all, problemsOnly, now), nil
+}
+
+// classifyDictionaries sets each dictionary's problem, counts them and, with
+// problemsOnly, drops the healthy ones.
+func classifyDictionaries(all []dictionaryStatus, problemsOnly bool, now time.Time) *dictionariesResult {
+	res := &dictionariesResult{Dictionaries: []dictionaryStatus{}, Total: len(all)}
+	for _, d := range all {
+		d.Problem = dictionaryProblem(d, now)
+		switch d.Problem {
+		case dictionaryFailed:
+			res.Failed++
+		case dictionaryStale:
+			res.Stale++
+		}
+		if problemsOnly && d.Problem == "" {
+			continue
+		}
+		res.Dictionaries = append(res.Dictionaries, d)
+	}
+	return res
+}
+
+// summarizeDictionaries renders the problem counts and one line per
+// dictionary, problems first.
+func summarizeDictionaries(res *dictionariesResult) string {
+	if len(res.Dictionaries) == 0 {
+		if res.Total == 0 {
+			return "No dictionaries found."
+		}
+		return fmt.Sprintf("No failed or stale dictionaries (%d dictionary replica(s) healthy).", res.Total)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d dictionary replica(s): %d failed, %d stale.", res.Total, res.Failed, res.Stale)
+	for _, problem := range []string{dictionaryFailed, dictionaryStale, ""} {
+		for _, d := range res.Dictionaries {
+			if d.Problem != problem {
+				continue
+			}
+			flag := ""
+			if d.Problem != "" {
+				flag = strings.ToUpper(d.Problem) + " "
+			}
+			updated := "never"
+			if !d.LastSuccessful.IsZero() {
+				updated = d.LastSuccessful.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(&b, "\n%s%s.%s on %s: %s, %d elements, %s, last updated %s",
+				flag, d.Database, d.Name, d.Host, d.Status, d.ElementCount, humanBytes(float64(d.BytesAllocated)), updated)
+			if d.LastException != "" {
+				exc := strings.Join(strings.Fields(d.LastException), " ")
+				if len(exc) > 300 {
+					exc = exc[:300] + "..."
+				}
+				b.WriteString(": " + exc)
+			}
+		}
+	}
+	return b.String()
+}
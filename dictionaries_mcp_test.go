@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDictionaryProblem(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		d    dictionaryStatus
+		want string
+	}{
+		{name: "fresh", d: dictionaryStatus{Status: "LOADED", LifetimeMaxSecond: 300, LastSuccessful: now.Add(-5 * time.Minute)}},
+		{name: "stale", d: dictionaryStatus{Status: "LOADED", LifetimeMaxSecond: 300, LastSuccessful: now.Add(-11 * time.Minute)}, want: dictionaryStale},
+		{name: "stale while reloading", d: dictionaryStatus{Status: "LOADED_AND_RELOADING", LifetimeMaxSecond: 60, LastSuccessful: now.Add(-time.Hour)}, want: dictionaryStale},
+		{name: "never reloaded", d: dictionaryStatus{Status: "LOADED", LastSuccessful: now.Add(-30 * 24 * time.Hour)}},
+		{name: "failed", d: dictionaryStatus{Status: "FAILED"}, want: dictionaryFailed},
+		{name: "failed and reloading", d: dictionaryStatus{Status: "FAILED_AND_RELOADING"}, want: dictionaryFailed},
+		{name: "loaded with exception", d: dictionaryStatus{Status: "LOADED", LastException: "Connection refused", LastSuccessful: now}, want: dictionaryFailed},
+		{name: "lazy, not loaded", d: dictionaryStatus{Status: "NOT_LOADED", LifetimeMaxSecond: 300}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dictionaryProblem(tt.d, now); got != tt.want {
+				t.Errorf("dictionaryProblem() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildDictionariesQuery(t *testing.T) {
+	query, args := buildDictionariesQuery("main", "")
+	if !strings.Contains(query, "FROM clusterAllReplicas(?, system.dictionaries)") || strings.Contains(query, "WHERE") {
+		t.Errorf("query = %s", query)
+	}
+	if len(args) != 1 || args[0] != "main" {
+		t.Errorf("args = %v, want [main]", args)
+	}
+
+	query, args = buildDictionariesQuery("main", "geo")
+	if !strings.Contains(query, "WHERE database = ?") || len(args) != 2 || args[1] != "geo" {
+		t.Errorf("query = %s, args = %v", query, args)
+	}
+}
+
+func TestClassifyAndSummarizeDictionaries(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	all := []dictionaryStatus{
+		{Host: "ch1", Database: "geo", Name: "countries", Status: "LOADED", ElementCount: 250, BytesAllocated: 4096, LifetimeMaxSecond: 300, LastSuccessful: now.Add(-time.Minute)},
+		{Host: "ch1", Database: "geo", Name: "cities", Status: "FAILED", LastException: "Code: 210.\nConnection refused"},
+		{Host: "ch2", Database: "geo", Name: "countries", Status: "LOADED", LifetimeMaxSecond: 300, LastSuccessful: now.Add(-time.Hour)},
+	}
+
+	res := classifyDictionaries(all, false, now)
+	if res.Total != 3 || res.Failed != 1 || res.Stale != 1 || len(res.Dictionaries) != 3 {
+		t.Fatalf("classifyDictionaries() = %+v", res)
+	}
+	got := summarizeDictionaries(res)
+	for _, want := range []string{
+		"3 dictionary replica(s): 1 failed, 1 stale.",
+		"\nFAILED geo.cities on ch1: FAILED, 0 elements, 0 B, last updated never: Code: 210. Connection refused",
+		"\nSTALE geo.countries on ch2",
+		"\ngeo.countries on ch1: LOADED, 250 elements, 4.00 KB, last updated 2026-10-15T11:59:00Z",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("summary missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Index(got, "FAILED geo.cities") > strings.Index(got, "STALE") {
+		t.Errorf("failed dictionaries should come first:\n%s", got)
+	}
+
+	res = classifyDictionaries(all, true, now)
+	if len(res.Dictionaries) != 2 || res.Total != 3 {
+		t.Errorf("problems_only kept %d of %d", len(res.Dictionaries), res.Total)
+	}
+	if got := summarizeDictionaries(classifyDictionaries(all[:1], true, now)); got != "No failed or stale dictionaries (1 dictionary replica(s) healthy)." {
+		t.Errorf("healthy summary = %q", got)
+	}
+	if got := summarizeDictionaries(classifyDictionaries(nil, false, now)); got != "No dictionaries found." {
+		t.Errorf("empty summary = %q", got)
+	}
+}
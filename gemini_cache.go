@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// summaryCache keeps agent summaries for a limited time, keyed by a hash of
+// everything sent to the model, so that re-running an analysis over unchanged
+// data doesn't repeat the LLM calls.
+type summaryCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[string]cachedSummary
+}
+
+type cachedSummary struct {
+	text    string
+	expires time.Time
+}
+
+func newSummaryCache() *summaryCache {
+	return &summaryCache{now: time.Now, entries: make(map[string]cachedSummary)}
+}
+
+// summaryCacheKey hashes the model and both prompts; the user prompt carries
+// the ClickHouse data being analyzed.
+func summaryCacheKey(model, systemPrompt, prompt string) string {
+	h := sha256.New()
+	for _, s := range []string{model, systemPrompt, prompt} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// get returns the summary stored under key if it hasn't expired.
+func (c *summaryCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || c.now().After(e.expires) {
+		return "", false
+	}
+	return e.text, true
+}
+
+// put stores text under key for ttl and drops expired entries.
+func (c *summaryCache) put(key, text string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedSummary{text: text, expires: now.Add(ttl)}
+}
+
+var geminiSummaries = newSummaryCache()
+
+// runCachedGeminiAgent is runGeminiAgent for agents whose prompt includes all
+// the data under analysis. A summary produced for the same model and prompts
+// within gemini.cache_ttl is returned without calling Gemini; 0 disables the
+// cache. Failed runs, and partial summaries from runs that hit
+// gemini.max_seconds, are not cached.
+func runCachedGeminiAgent(ctx context.Context, model, systemPrompt, prompt string) (string, error) {
+	ttl := viper.GetDuration("gemini.cache_ttl")
+	if ttl <= 0 {
+		summary, _, err := runGeminiAgent(ctx, model, systemPrompt, prompt)
+		return summary, err
+	}
+	key := summaryCacheKey(model, systemPrompt, prompt)
+	if summary, ok := geminiSummaries.get(key); ok {
+		logrus.WithField("model", model).Info("Input unchanged since a recent analysis; reusing its summary")
+		return summary, nil
+	}
+	summary, partial, err := runGeminiAgent(ctx, model, systemPrompt, prompt)
+	if err != nil {
+		return "", err
+	}
+	if partial {
+		return summary, nil
+	}
+	geminiSummaries.put(key, summary, ttl)
+	return summary, nil
+}
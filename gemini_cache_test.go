@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummaryCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newSummaryCache()
+	c.now = func() time.Time { return now }
+
+	key := summaryCacheKey("gemini-2.5-flash", "system", "errors: A")
+	if key == summaryCacheKey("gemini-2.5-flash", "system", "errors: B") {
+		t.Error("different prompts share a key")
+	}
+	if key == summaryCacheKey("gemini-2.5-flash", "systemerrors: A", "") {
+		t.Error("key does not separate the prompts")
+	}
+
+	if _, ok := c.get(key); ok {
+		t.Fatal("get() on empty cache succeeded")
+	}
+	c.put(key, "summary", time.Minute)
+	if got, ok := c.get(key); !ok || got != "summary" {
+		t.Errorf("get() = %q, %v", got, ok)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.get(key); ok {
+		t.Error("expired summary still returned")
+	}
+	c.put("other", "x", time.Minute)
+	if _, ok := c.entries[key]; ok {
+		t.Error("expired entry not dropped on put")
+	}
+}
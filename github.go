@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// githubTimeout bounds each GitHub API request.
+const githubTimeout = 15 * time.Second
+
+// githubEnabled reports whether analysis summaries should also go to GitHub
+// issues, i.e. github.token and github.repo are both set.
+func githubEnabled() bool {
+	return viper.GetString("github.token") != "" && viper.GetString("github.repo") != ""
+}
+
+// issueKey is the error a run's issue is filed under: the one with the highest
+// count across all hosts, ties broken by name, so the same recurring error
+// maps to the same issue run after run.
+func issueKey(errs CHErrors) string {
+	totals := make(map[string]uint64)
+	for _, e := range errs {
+		totals[e.Name] += e.Value
+	}
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if totals[names[i]] != totals[names[j]] {
+			return totals[names[i]] > totals[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// issueMarker is hidden in issue bodies to find the issue for key again.
+func issueMarker(key string) string {
+	return "<!-- housekeeper-error: " + key + " -->"
+}
+
+type githubIssue struct {
+	Number      int       `json:"number"`
+	Body        string    `json:"body"`
+	HTMLURL     string    `json:"html_url"`
+	PullRequest *struct{} `json:"pull_request"`
+}
+
+// SendGitHubIssue files summary under the issue for errs' most frequent
+// error: as a comment when an open issue with github.label already tracks
+// that error, else as a new issue.
+func SendGitHubIssue(ctx context.Context, summary string, errs CHErrors) error {
+	key := issueKey(errs)
+	if key == "" {
+		return fmt.Errorf("no errors to file a GitHub issue for")
+	}
+	label := viper.GetString("github.label")
+	body := fmt.Sprintf("%s\n\n---\n%d error(s) across the cluster; most frequent: `%s`.\n%s", summary, len(errs), key, issueMarker(key))
+
+	existing, err := findGitHubIssue(ctx, label, key)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		var comment struct {
+			HTMLURL string `json:"html_url"`
+		}
+		path := fmt.Sprintf("/issues/%d/comments", existing.Number)
+		if err := githubRequest(ctx, http.MethodPost, path, map[string]any{"body": body}, &comment); err != nil {
+			return err
+		}
+		logrus.WithFields(logrus.Fields{"issue": existing.Number, "url": comment.HTMLURL, "error": key}).Info("Commented on GitHub issue")
+		return nil
+	}
+
+	issue := map[string]any{
+		"title": fmt.Sprintf("ClickHouse errors: %s", key),
+		"body":  body,
+	}
+	if label != "" {
+		issue["labels"] = []string{label}
+	}
+	var created githubIssue
+	if err := githubRequest(ctx, http.MethodPost, "/issues", issue, &created); err != nil {
+		return err
+	}
+	logrus.WithFields(logrus.Fields{"issue": created.Number, "url": created.HTMLURL, "error": key}).Info("Created GitHub issue")
+	return nil
+}
+
+// findGitHubIssue returns the open issue carrying key's marker among the most
+// recently updated issues with label, or nil.
+func findGitHubIssue(ctx context.Context, label, key string) (*githubIssue, error) {
+	query := url.Values{"state": {"open"}, "per_page": {"100"}, "sort": {"updated"}}
+	if label != "" {
+		query.Set("labels", label)
+	}
+	var issues []githubIssue
+	if err := githubRequest(ctx, http.MethodGet, "/issues?"+query.Encode(), nil, &issues); err != nil {
+		return nil, err
+	}
+	marker := issueMarker(key)
+	for i := range issues {
+		if issues[i].PullRequest == nil && strings.Contains(issues[i].Body, marker) {
+			return &issues[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// githubRequest calls the REST API for github.repo at path (relative to
+// /repos/<owner>/<name>), sending in as JSON when non-nil and decoding the
+// response into out.
+func githubRequest(ctx context.Context, method, path string, in, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, githubTimeout)
+	defer cancel()
+
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("encoding GitHub request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	endpoint := strings.TrimRight(viper.GetString("github.api_url"), "/") + "/repos/" + viper.GetString("github.repo") + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+viper.GetString("github.token"))
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("GitHub %s %s: %w", method, path, err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing GitHub response body")
+		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("GitHub %s %s returned status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding GitHub response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestIssueKey(t *testing.T) {
+	errs := CHErrors{
+		{Hostname: "a", Name: "TIMEOUT_EXCEEDED", Value: 5},
+		{Hostname: "a", Name: "MEMORY_LIMIT_EXCEEDED", Value: 4},
+		{Hostname: "b", Name: "MEMORY_LIMIT_EXCEEDED", Value: 4},
+		{Hostname: "b", Name: "ABORTED", Value: 8},
+	}
+	if got := issueKey(errs); got != "ABORTED" {
+		t.Errorf("issueKey() = %q, want ABORTED (ties broken by name)", got)
+	}
+	if got := issueKey(nil); got != "" {
+		t.Errorf("issueKey(nil) = %q, want empty", got)
+	}
+}
+
+// fakeGitHub serves the issue list, issue creation and comment endpoints for
+// owner/repo and records what was posted.
+type fakeGitHub struct {
+	issues   []githubIssue
+	created  []map[string]any
+	comments map[string][]string
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		http.Error(w, "bad credentials", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/issues":
+		_ = json.NewEncoder(w).Encode(f.issues)
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/owner/repo/issues":
+		var issue map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&issue)
+		f.created = append(f.created, issue)
+		_ = json.NewEncoder(w).Encode(githubIssue{Number: 7})
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/comments"):
+		var comment map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&comment)
+		f.comments[r.URL.Path] = append(f.comments[r.URL.Path], comment["body"])
+		_ = json.NewEncoder(w).Encode(map[string]string{})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestSendGitHubIssue(t *testing.T) {
+	gh := &fakeGitHub{comments: make(map[string][]string)}
+	server := httptest.NewServer(gh)
+	defer server.Close()
+
+	for k, v := range map[string]string{"github.api_url": server.URL, "github.repo": "owner/repo", "github.token": "test-token", "github.label": "housekeeper"} {
+		viper.Set(k, v)
+		defer viper.Set(k, nil)
+	}
+	errs := CHErrors{{Hostname: "a", Name: "MEMORY_LIMIT_EXCEEDED", Value: 3}}
+
+	// No tracking issue yet: one is created with the marker and label.
+	if err := SendGitHubIssue(context.Background(), "summary one", errs); err != nil {
+		t.Fatalf("SendGitHubIssue() error = %v", err)
+	}
+	if len(gh.created) != 1 {
+		t.Fatalf("created %d issues, want 1", len(gh.created))
+	}
+	body, _ := gh.created[0]["body"].(string)
+	if !strings.Contains(body, "summary one") || !strings.Contains(body, issueMarker("MEMORY_LIMIT_EXCEEDED")) {
+		t.Errorf("issue body = %q, want summary and marker", body)
+	}
+
+	// The same error again comments on the open issue instead.
+	gh.issues = []githubIssue{
+		{Number: 3, Body: "unrelated"},
+		{Number: 7, Body: body},
+	}
+	if err := SendGitHubIssue(context.Background(), "summary two", errs); err != nil {
+		t.Fatalf("SendGitHubIssue() error = %v", err)
+	}
+	if len(gh.created) != 1 {
+		t.Errorf("created %d issues, want no duplicate", len(gh.created))
+	}
+	if got := gh.comments["/repos/owner/repo/issues/7/comments"]; len(got) != 1 || !strings.Contains(got[0], "summary two") {
+		t.Errorf("comments on #7 = %q, want the second summary", got)
+	}
+
+	// The notifier files only critical results.
+	n := &githubNotifier{errs: errs}
+	for _, severity := range []string{severityWarning, severityInfo} {
+		if err := n.Notify(context.Background(), severity, "title", "summary three"); err != nil {
+			t.Errorf("Notify(%s) error = %v", severity, err)
+		}
+	}
+	if got := gh.comments["/repos/owner/repo/issues/7/comments"]; len(gh.created) != 1 || len(got) != 1 {
+		t.Errorf("non-critical results were filed: %d issues, comments %q", len(gh.created), got)
+	}
+	if err := n.Notify(context.Background(), severityCritical, "title", "summary four"); err != nil {
+		t.Fatalf("Notify(critical) error = %v", err)
+	}
+	if got := gh.comments["/repos/owner/repo/issues/7/comments"]; len(got) != 2 || !strings.Contains(got[1], "summary four") {
+		t.Errorf("comments on #7 = %q, want the critical summary", got)
+	}
+
+	viper.Set("github.token", "wrong")
+	if err := SendGitHubIssue(context.Background(), "summary", errs); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("SendGitHubIssue() with a bad token error = %v, want status 401", err)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// incidentSnapshotArgs is the input to the incident_snapshot tool, which takes
+// no arguments: every section reads the configured cluster.
+type incidentSnapshotArgs struct{}
+
+// incidentError is one system.errors entry raised in the past hour.
+type incidentError struct {
+	Host             string    `json:"host"`
+	Name             string    `json:"name"`
+	Code             int32     `json:"code"`
+	Count            uint64    `json:"count" jsonschema:"times raised since the server started"`
+	LastErrorTime    time.Time `json:"last_error_time"`
+	LastErrorMessage string    `json:"last_error_message"`
+}
+
+// incidentSnapshotResult is the structured output of incident_snapshot. A
+// section that couldn't be read is empty and listed in section_errors.
+type incidentSnapshotResult struct {
+	Processes     []runningQuery           `json:"processes" jsonschema:"running queries, longest first"`
+	Errors        []incidentError          `json:"errors" jsonschema:"errors raised in the past hour, most recent first"`
+	Merges        []map[string]interface{} `json:"merges" jsonschema:"merges and mutations in progress (system.merges), longest first"`
+	Mutations     []map[string]interface{} `json:"mutations" jsonschema:"unfinished mutations (system.mutations), oldest first"`
+	Replication   []map[string]interface{} `json:"replication" jsonschema:"replicated tables with a replication queue, read-only or with an expired session, largest queue first"`
+	Disks         []map[string]interface{} `json:"disks" jsonschema:"disks on every replica, fullest first"`
+	SectionErrors map[string]string        `json:"section_errors,omitempty" jsonschema:"sections that could not be read, with the error"`
+}
+
+// incidentSectionLimit bounds the rows returned per section.
+const incidentSectionLimit = 20
+
+// incidentQueries are the clickhouse_query structured queries behind the
+// merges, mutations, replication and disks sections, keyed by section name.
+var incidentQueries = map[string]queryArgs{
+	"merges":      {Table: "system.merges", OrderBy: "elapsed DESC", Limit: incidentSectionLimit},
+	"mutations":   {Table: "system.mutations", Where: "is_done = 0", OrderBy: "create_time", Limit: incidentSectionLimit},
+	"replication": {Table: "system.replicas", Where: "queue_size > 0 OR is_readonly OR is_session_expired", OrderBy: "queue_size DESC", Limit: incidentSectionLimit},
+	"disks": {
+		Table: "system.disks",
+		Columns: []string{"hostName() AS host", "name", "path", "free_space", "total_space",
+			"round(1 - free_space / total_space, 3) AS used_ratio"},
+		Where: "total_space > 0", OrderBy: "used_ratio DESC", Limit: incidentSectionLimit,
+	},
+}
+
+func registerIncidentSnapshotTool(srv *mcp.Server) {
+	addTool[incidentSnapshotArgs, *incidentSnapshotResult](
+		srv,
+		&mcp.Tool{
+			Name:        "incident_snapshot",
+			Title:       "ClickHouse incident snapshot",
+			Description: `Take a snapshot of the cluster for the first minutes of an incident, in one call: running queries (system.processes), errors raised in the past hour (system.errors), merges and unfinished mutations, replicas with a replication queue or that are read-only, and disk usage on every replica. Each section is capped at 20 rows; a section that fails is reported in section_errors without failing the rest. Follow up with the dedicated tools or clickhouse_query for detail.`,
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		},
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[incidentSnapshotArgs]) (*mcp.CallToolResultFor[*incidentSnapshotResult], error) {
+			conn, err := connect()
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				if err := conn.Close(); err != nil {
+					logrus.WithError(err).Warn("Error closing ClickHouse connection")
+				}
+			}()
+
+			res := takeIncidentSnapshot(ctx, conn)
+			return &mcp.CallToolResultFor[*incidentSnapshotResult]{
+				Content:           []mcp.Content{&mcp.TextContent{Text: summarizeIncidentSnapshot(res, displayLocation())}},
+				StructuredContent: res,
+			}, nil
+		},
+	)
+}
+
+// takeIncidentSnapshot reads every section concurrently. Failed sections are
+// recorded in SectionErrors rather than failing the snapshot.
+func takeIncidentSnapshot(ctx context.Context, conn driver.Conn) *incidentSnapshotResult {
+	res := &incidentSnapshotResult{
+		Processes: []runningQuery{}, Errors: []incidentError{}, Merges: []map[string]interface{}{},
+		Mutations: []map[string]interface{}{}, Replication: []map[string]interface{}{}, Disks: []map[string]interface{}{},
+	}
+	rowSections := map[string]*[]map[string]interface{}{
+		"merges": &res.Merges, "mutations": &res.Mutations, "replication": &res.Replication, "disks": &res.Disks,
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	run := func(name string, read func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := read(); err != nil {
+				logrus.WithError(err).WithField("section", name).Warn("incident_snapshot section failed")
+				mu.Lock()
+				if res.SectionErrors == nil {
+					res.SectionErrors = make(map[string]string)
+				}
+				res.SectionErrors[name] = err.Error()
+				mu.Unlock()
+			}
+		}()
+	}
+
+	run("processes", func() error {
+		procs, err := fetchProcesses(ctx, conn, viper.GetString("clickhouse.cluster"), "", 0, incidentSectionLimit)
+		if err == nil {
+			res.Processes = procs.Queries
+		}
+		return err
+	})
+	run("errors", func() error {
+		chErrors, err := queryCHErrors(ctx, conn, stackTracesDrop)
+		if err == nil {
+			res.Errors = incidentErrors(chErrors)
+		}
+		return err
+	})
+	for name, a := range incidentQueries {
+		dest := rowSections[name]
+		run(name, func() error {
+			qr, err := execQuery(ctx, conn, buildQuery(a))
+			if err != nil {
+				return err
+			}
+			truncateCells(qr.Results, maxCellLength())
+			*dest = qr.Results
+			return nil
+		})
+	}
+	wg.Wait()
+	return res
+}
+
+// incidentErrors converts chErrors, most recent first, keeping at most
+// incidentSectionLimit.
+func incidentErrors(chErrors []CHError) []incidentError {
+	out := make([]incidentError, 0, len(chErrors))
+	for _, e := range chErrors {
+		out = append(out, incidentError{
+			Host: e.Hostname, Name: e.Name, Code: e.Code, Count: e.Value,
+			LastErrorTime: e.LastErrorTime, LastErrorMessage: e.LastErrorMessage,
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].LastErrorTime.After(out[j].LastErrorTime) })
+	if len(out) > incidentSectionLimit {
+		out = out[:incidentSectionLimit]
+	}
+	return out
+}
+
+// incidentPreviewRows is how many rows of each section the summary shows.
+const incidentPreviewRows = 3
+
+// summarizeIncidentSnapshot renders one heading per section with its row
+// count and first few rows, or the error for a section that failed.
+func summarizeIncidentSnapshot(res *incidentSnapshotResult, loc *time.Location) string {
+	var b strings.Builder
+	section := func(name string, n int) bool {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		if msg, ok := res.SectionErrors[name]; ok {
+			fmt.Fprintf(&b, "%s: unavailable (%s)", name, msg)
+			return false
+		}
+		fmt.Fprintf(&b, "%s: %d", name, n)
+		return n > 0
+	}
+
+	if section("processes", len(res.Processes)) {
+		for _, q := range res.Processes[:min(len(res.Processes), incidentPreviewRows)] {
+			fmt.Fprintf(&b, "\n  %s %.1fs by %s, %s, query_id %s", q.Host, q.Elapsed, q.User, humanBytes(float64(q.MemoryUsage)), q.QueryID)
+		}
+	}
+	if section("errors", len(res.Errors)) {
+		for _, e := range res.Errors[:min(len(res.Errors), incidentPreviewRows)] {
+			msg := strings.Join(strings.Fields(e.LastErrorMessage), " ")
+			if len(msg) > 150 {
+				msg = msg[:150] + "..."
+			}
+			fmt.Fprintf(&b, "\n  %s %s (%d) x%d: %s", e.Host, e.Name, e.Code, e.Count, msg)
+		}
+	}
+	for _, s := range []struct {
+		name string
+		rows []map[string]interface{}
+	}{
+		{"merges", res.Merges}, {"mutations", res.Mutations}, {"replication", res.Replication}, {"disks", res.Disks},
+	} {
+		if section(s.name, len(s.rows)) {
+			for _, row := range s.rows[:min(len(s.rows), incidentPreviewRows)] {
+				b.WriteString("\n  " + formatRow(row, loc))
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestIncidentQueries(t *testing.T) {
+	viper.Set("clickhouse.cluster", "main")
+	defer viper.Set("clickhouse.cluster", nil)
+
+	tests := map[string][]string{
+		"merges":      {"FROM clusterAllReplicas(main, system.merges)", "ORDER BY elapsed DESC LIMIT 20"},
+		"mutations":   {"FROM clusterAllReplicas(main, system.mutations) WHERE is_done = 0", "LIMIT 20"},
+		"replication": {"FROM clusterAllReplicas(main, system.replicas) WHERE queue_size > 0 OR is_readonly", "queue_size"},
+		"disks":       {"SELECT hostName() AS host, name, path, free_space, total_space", "FROM clusterAllReplicas(main, system.disks)", "ORDER BY used_ratio DESC"},
+	}
+	if len(incidentQueries) != len(tests) {
+		t.Errorf("incidentQueries has %d sections, want %d", len(incidentQueries), len(tests))
+	}
+	for name, wants := range tests {
+		a, ok := incidentQueries[name]
+		if !ok {
+			t.Errorf("no %s section", name)
+			continue
+		}
+		if err := validateQueryArgs(a); err != nil {
+			t.Errorf("%s: validateQueryArgs() = %v", name, err)
+		}
+		query := buildQuery(a)
+		for _, want := range wants {
+			if !strings.Contains(query, want) {
+				t.Errorf("%s query missing %q: %s", name, want, query)
+			}
+		}
+	}
+}
+
+func TestIncidentErrors(t *testing.T) {
+	base := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	var chErrors []CHError
+	for i := 0; i < incidentSectionLimit+5; i++ {
+		chErrors = append(chErrors, CHError{Hostname: "ch-1", Name: fmt.Sprintf("E%d", i), Code: int32(i), Value: 1, LastErrorTime: base.Add(time.Duration(i) * time.Minute)})
+	}
+	got := incidentErrors(chErrors)
+	if len(got) != incidentSectionLimit {
+		t.Fatalf("incidentErrors() returned %d, want %d", len(got), incidentSectionLimit)
+	}
+	if got[0].Name != "E24" || got[len(got)-1].Name != "E5" {
+		t.Errorf("incidentErrors() not most recent first: first %s, last %s", got[0].Name, got[len(got)-1].Name)
+	}
+}
+
+func TestSummarizeIncidentSnapshot(t *testing.T) {
+	res := &incidentSnapshotResult{
+		Processes:     []runningQuery{{Host: "ch-1", QueryID: "q1", User: "app", Elapsed: 12.5, MemoryUsage: 2 << 30}},
+		Errors:        []incidentError{{Host: "ch-2", Name: "MEMORY_LIMIT_EXCEEDED", Code: 241, Count: 3, LastErrorMessage: "Memory limit\nexceeded"}},
+		Merges:        []map[string]interface{}{},
+		Mutations:     []map[string]interface{}{},
+		Disks:         []map[string]interface{}{{"host": "ch-1", "name": "default"}},
+		SectionErrors: map[string]string{"replication": "timeout"},
+	}
+	got := summarizeIncidentSnapshot(res, time.UTC)
+	for _, want := range []string{
+		"processes: 1\n  ch-1 12.5s by app, 2.00 GB, query_id q1",
+		"errors: 1\n  ch-2 MEMORY_LIMIT_EXCEEDED (241) x3: Memory limit exceeded",
+		"merges: 0\nmutations: 0\nreplication: unavailable (timeout)\ndisks: 1\n  ",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("summary missing %q:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logDeduper collapses repeats of the same log line. The first occurrence is
+// logged; identical lines within the window after it are counted instead, and
+// the count is attached to the next line logged once the window has passed.
+// A flood that stops is reported by flush, which also drops expired entries.
+type logDeduper struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	seen      map[string]*dedupState
+	lastFlush time.Time
+}
+
+type dedupState struct {
+	logged     time.Time
+	suppressed int
+	// report logs the count of repeats that were never followed by another
+	// logged line.
+	report func(suppressed int)
+}
+
+var defaultLogDeduper = &logDeduper{now: time.Now, seen: make(map[string]*dedupState)}
+
+// allow reports whether a line with key should be logged now and, if so, how
+// many identical lines were suppressed since it was last logged. report is
+// kept to log the count if the repeats stop; see flush. A window <= 0 disables
+// deduplication.
+func (d *logDeduper) allow(key string, window time.Duration, report func(suppressed int)) (bool, int) {
+	if window <= 0 {
+		return true, 0
+	}
+	d.mu.Lock()
+	now := d.now()
+	st, ok := d.seen[key]
+	if ok && now.Sub(st.logged) < window {
+		st.suppressed++
+		d.mu.Unlock()
+		return false, 0
+	}
+	suppressed := 0
+	if ok {
+		suppressed = st.suppressed
+	}
+	d.seen[key] = &dedupState{logged: now, report: report}
+	// Other keys' counts are reported at most once per window here, so a busy
+	// log doesn't scan the map on every line.
+	flushDue := now.Sub(d.lastFlush) >= window
+	d.mu.Unlock()
+
+	if flushDue {
+		d.flush(window)
+	}
+	return true, suppressed
+}
+
+// flush drops the entries whose window has passed, reporting the ones with
+// suppressed repeats that no later line carried.
+func (d *logDeduper) flush(window time.Duration) {
+	d.mu.Lock()
+	now := d.now()
+	d.lastFlush = now
+	var pending []*dedupState
+	for k, s := range d.seen {
+		if now.Sub(s.logged) < window {
+			continue
+		}
+		if s.suppressed > 0 && s.report != nil {
+			pending = append(pending, s)
+		}
+		delete(d.seen, k)
+	}
+	d.mu.Unlock()
+
+	// Report outside the lock so slow log output doesn't block other callers.
+	for _, s := range pending {
+		s.report(s.suppressed)
+	}
+}
+
+// flushDedupedLogs reports repeats that stopped every window until ctx is
+// done, so a flood's count is logged even if nothing else is. A window <= 0
+// disables deduplication and this with it.
+func flushDedupedLogs(ctx context.Context, window time.Duration) {
+	if window <= 0 {
+		return
+	}
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			defaultLogDeduper.flush(window)
+		}
+	}
+}
+
+// logDeduped logs msg on entry at level unless an identical message (same
+// level, text and error) was logged within logging.dedup_window. Other fields,
+// such as query_id, are not part of the comparison.
+func logDeduped(entry *logrus.Entry, level logrus.Level, msg string) {
+	key := fmt.Sprintf("%s|%s|%v", level, msg, entry.Data[logrus.ErrorKey])
+	report := func(suppressed int) {
+		entry.WithField("suppressed", suppressed).Log(level, msg+" (repeats stopped)")
+	}
+	ok, suppressed := defaultLogDeduper.allow(key, appConfig.Logging.DedupWindow, report)
+	if !ok {
+		return
+	}
+	if suppressed > 0 {
+		entry = entry.WithField("repeated", suppressed)
+	}
+	entry.Log(level, msg)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogDeduperAllow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := &logDeduper{now: func() time.Time { return now }, seen: make(map[string]*dedupState)}
+	window := 10 * time.Second
+
+	steps := []struct {
+		name           string
+		advance        time.Duration
+		key            string
+		window         time.Duration
+		wantLog        bool
+		wantSuppressed int
+	}{
+		{name: "first occurrence is logged", key: "a", window: window, wantLog: true},
+		{name: "repeat within window is suppressed", advance: time.Second, key: "a", window: window, wantLog: false},
+		{name: "second repeat is suppressed", advance: time.Second, key: "a", window: window, wantLog: false},
+		{name: "different key is logged", key: "b", window: window, wantLog: true},
+		{name: "after window, logged with count", advance: 10 * time.Second, key: "a", window: window, wantLog: true, wantSuppressed: 2},
+		{name: "count resets", advance: 11 * time.Second, key: "a", window: window, wantLog: true, wantSuppressed: 0},
+		{name: "zero window disables", key: "a", window: 0, wantLog: true},
+	}
+
+	for _, st := range steps {
+		now = now.Add(st.advance)
+		gotLog, gotSuppressed := d.allow(st.key, st.window, nil)
+		if gotLog != st.wantLog || gotSuppressed != st.wantSuppressed {
+			t.Errorf("%s: allow() = %v, %d, want %v, %d", st.name, gotLog, gotSuppressed, st.wantLog, st.wantSuppressed)
+		}
+	}
+}
+
+func TestLogDeduperFlush(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := &logDeduper{now: func() time.Time { return now }, seen: make(map[string]*dedupState)}
+	window := 10 * time.Second
+	reported := make(map[string]int)
+	reporter := func(key string) func(int) {
+		return func(n int) { reported[key] += n }
+	}
+
+	// "a" floods and stops; "b" is logged once and never repeats.
+	for i := 0; i < 4; i++ {
+		d.allow("a", window, reporter("a"))
+	}
+	d.allow("b", window, reporter("b"))
+
+	now = now.Add(5 * time.Second)
+	d.flush(window)
+	if len(reported) != 0 || len(d.seen) != 2 {
+		t.Fatalf("flush() within the window reported %v, kept %d entries; want nothing reported, 2 kept", reported, len(d.seen))
+	}
+
+	now = now.Add(5 * time.Second)
+	d.flush(window)
+	if want := map[string]int{"a": 3}; !reflect.DeepEqual(reported, want) {
+		t.Errorf("flush() reported %v, want %v", reported, want)
+	}
+	if len(d.seen) != 0 {
+		t.Errorf("flush() kept %d expired entries, want 0", len(d.seen))
+	}
+
+	// A flood that stops is also reported when another key is logged after
+	// the window, without waiting for the ticker.
+	d.allow("c", window, reporter("c"))
+	d.allow("c", window, reporter("c"))
+	now = now.Add(window)
+	d.allow("d", window, reporter("d"))
+	if reported["c"] != 1 {
+		t.Errorf("reported %v after logging another key, want c: 1", reported)
+	}
+	if _, ok := d.seen["c"]; ok {
+		t.Error("expired key c was not dropped")
+	}
+}
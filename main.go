@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
@@ -12,7 +16,14 @@ func main() {
 	// Define all flags using pflag
 	analyzeMode := pflag.Bool("analyze", false, "Run in analysis mode (error/performance analysis with Gemini AI) instead of MCP server")
 	performanceMode := pflag.Bool("performance", false, "Run query performance analysis (requires --analyze)")
-	configPath := pflag.String("config", "", "Path to YAML config (or set HOUSEKEEPER_CONFIG)")
+	tailErrors := pflag.Bool("tail-errors", false, "Follow system.errors and print new or incremented errors as they appear")
+	listToolsFlag := pflag.Bool("list-tools", false, "Print the MCP tools this config serves (name, description, input schema) as JSON and exit")
+	replay := pflag.String("replay", "", "Run one recorded MCP tool call (JSON file, or - for stdin) against the tools and print the result")
+	configPath := pflag.StringSlice("config", nil, "Path to YAML config, or a directory of them; repeat or comma-separate to merge several, later ones overriding earlier (or set HOUSEKEEPER_CONFIG)")
+	configInit := pflag.String("config-init", "", "Write a commented example config and exit (--config-init=<path>, default configs/config.yml)")
+	pflag.Lookup("config-init").NoOptDefVal = "configs/config.yml"
+	force := pflag.Bool("force", false, "Allow --config-init to overwrite an existing file")
+	safe := pflag.Bool("safe-mode", false, "Serve only a structured, system.*-only clickhouse_query with capped limits (for untrusted MCP clients)")
 	
 	// ClickHouse flags
 	pflag.String("ch-host", "127.0.0.1", "ClickHouse host")
@@ -22,6 +33,8 @@ func main() {
 	pflag.String("ch-database", "default", "ClickHouse database")
 	pflag.String("ch-cluster", "default", "ClickHouse cluster name")
 	pflag.StringSlice("ch-allowed-databases", []string{"system"}, "Comma-separated list of databases the MCP server can query")
+	pflag.Bool("ch-detect-cluster", false, "Check --ch-cluster against system.clusters at startup")
+	pflag.String("ch-proxy-url", "", "Proxy for ClickHouse connections (socks5://, socks5h://, http://, https://)")
 	
 	// Prometheus/Victoria Metrics flags
 	pflag.String("prom-host", "localhost", "Prometheus/Victoria Metrics host")
@@ -45,6 +58,8 @@ func main() {
 	_ = viper.BindPFlag("clickhouse.database", pflag.Lookup("ch-database"))
 	_ = viper.BindPFlag("clickhouse.cluster", pflag.Lookup("ch-cluster"))
 	_ = viper.BindPFlag("clickhouse.allowed_databases", pflag.Lookup("ch-allowed-databases"))
+	_ = viper.BindPFlag("clickhouse.detect_cluster", pflag.Lookup("ch-detect-cluster"))
+	_ = viper.BindPFlag("clickhouse.proxy_url", pflag.Lookup("ch-proxy-url"))
 	
 	_ = viper.BindPFlag("prometheus.host", pflag.Lookup("prom-host"))
 	_ = viper.BindPFlag("prometheus.port", pflag.Lookup("prom-port"))
@@ -55,14 +70,72 @@ func main() {
 	_ = viper.BindPFlag("http.addr", pflag.Lookup("http-addr"))
 	_ = viper.BindPFlag("http.auth_token", pflag.Lookup("http-auth-token"))
 
+	if *configInit != "" {
+		if err := writeExampleConfig(*configInit, *force); err != nil {
+			logrus.WithError(err).Fatal("Failed to write example config")
+		}
+		logrus.WithField("path", *configInit).Info("Wrote example config; edit it and pass --config")
+		return
+	}
+
+	if *tailErrors {
+		if err := loadConfig(*configPath); err != nil {
+			logrus.WithError(err).Fatal("Failed to load config")
+		}
+		if appConfig.ClickHouse.DetectCluster {
+			detectCluster()
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go flushDedupedLogs(ctx, appConfig.Logging.DedupWindow)
+		if err := runTailErrors(ctx); err != nil {
+			logrus.WithError(err).Fatal("Failed to tail ClickHouse errors")
+		}
+		return
+	}
+
+	if *listToolsFlag {
+		if err := loadConfig(*configPath); err != nil {
+			logrus.WithError(err).Fatal("Failed to load config")
+		}
+		if *safe {
+			applySafeMode()
+		}
+		if err := runListTools(context.Background()); err != nil {
+			logrus.WithError(err).Fatal("Failed to list tools")
+		}
+		return
+	}
+
+	if *replay != "" {
+		if err := loadConfig(*configPath); err != nil {
+			logrus.WithError(err).Fatal("Failed to load config")
+		}
+		if *safe {
+			applySafeMode()
+		}
+		if appConfig.ClickHouse.DetectCluster {
+			detectCluster()
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := runReplay(ctx, *replay); err != nil {
+			logrus.WithError(err).Fatal("Replay failed")
+		}
+		return
+	}
+
 	// Default to MCP mode unless analysis mode is explicitly requested
 	if !*analyzeMode {
-		// Try to load config file if provided, but don't fail if it doesn't exist
-		// Command-line flags will provide the values
+		// A missing config file is fine; command-line flags provide the values
 		if err := loadConfig(*configPath); err != nil {
-			// Only log debug message if config file wasn't found
-			logrus.WithError(err).Debug("Config file not found, using command-line flags")
-
+			logrus.WithError(err).Fatal("Failed to load config")
+		}
+		if *safe {
+			applySafeMode()
+		}
+		if appConfig.ClickHouse.DetectCluster {
+			detectCluster()
 		}
 		// Note: in stdio mode stdout is reserved for JSON-RPC; in HTTP mode it's safe to log
 		logrus.Info("Starting MCP server")
@@ -75,39 +148,70 @@ func main() {
 	if err := loadConfig(*configPath); err != nil {
 		logrus.WithError(err).Fatal("Failed to load config")
 	}
+	if appConfig.ClickHouse.DetectCluster {
+		detectCluster()
+	}
 
 	logrus.Info("Running in analysis mode (AI-powered ClickHouse monitoring)")
-	apiKey := viper.GetString("gemini_key")
-	if apiKey == "" {
-		logrus.Fatal("Please set gemini_key in configs")
+	if appConfig.GeminiKey == "" {
+		logrus.Fatal("gemini_key is not set. --analyze needs a Google Gemini API key: set gemini_key in the config file or the HOUSEKEEPER_GEMINI_KEY env var. " +
+			"To look at errors without an LLM, use --tail-errors, or run the MCP server (the default mode) and query system.errors with clickhouse_query.")
 	}
 	logrus.Debug("Gemini API key loaded")
 
+	// Ctrl-C or SIGTERM stops a running analysis instead of waiting it out.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	if *performanceMode {
 		logrus.Info("Analyzing query performance...")
-		summary := AnalyzeQueryPerformanceWithAgent()
+		summary, err := AnalyzeQueryPerformanceWithAgent(ctx)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to analyze query performance")
+		}
 		logrus.Info("Performance analysis complete")
 		fmt.Println(summary)
 		return
 	}
 
 	logrus.Info("Starting ClickHouse error analysis")
-	errors, err := CHErrorAnalysis()
+	errors, err := CHErrorAnalysis(ctx)
 	if err != nil {
 		logrus.WithError(err).Fatal("Failed to analyze ClickHouse errors")
 	}
 
-	if len(errors) > 0 {
+	// Read-only replicas and full disks are reported even in an hour without
+	// errors in system.errors.
+	conditions := detectCriticalConditions(ctx, errors)
+	switch {
+	case len(errors) > 0:
 		logrus.WithField("error_count", len(errors)).Info("Errors found, analyzing with Gemini")
-		summary := AnalyzeErrorsWithAgent(errors)
-		fmt.Println(summary)
-
-		if err := SendSlackMessage(summary, len(errors)); err != nil {
-			logrus.WithError(err).Error("Failed to send Slack message")
-		} else {
-			logrus.Info("Slack notification sent successfully")
+		summary, err := AnalyzeErrorsWithAgent(ctx, errors, conditions)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to analyze ClickHouse errors with Gemini")
 		}
-	} else {
+		fmt.Println(summary)
+		notifyAnalysis(ctx, errors, fmt.Sprintf("ClickHouse errors: %s", issueKey(errors)), summary)
+	case len(conditions) > 0:
+		logrus.WithField("conditions", len(conditions)).Warn("No errors found in the last hour, but critical conditions were detected")
+		summary := formatCriticalConditions(conditions)
+		fmt.Println(summary)
+		notifyAnalysis(ctx, errors, "ClickHouse critical conditions", summary)
+	default:
 		logrus.Info("No errors found in the last hour")
 	}
 }
+
+// notifyAnalysis sends an --analyze summary to every configured target,
+// logging the targets that fail.
+func notifyAnalysis(ctx context.Context, errors CHErrors, title, summary string) {
+	notifiers, err := errorNotifiers(errors)
+	if err != nil {
+		logrus.WithError(err).Fatal("Invalid notification config")
+	}
+	failed := notifyAll(ctx, notifiers, analysisSeverity(summary), title, summary)
+	for _, err := range failed {
+		logrus.WithError(err).Error("Failed to send notification")
+	}
+	logrus.WithFields(logrus.Fields{"targets": len(notifiers), "failed": len(failed)}).Info("Notifications done")
+}
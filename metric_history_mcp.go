@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// metricHistoryArgs is the input to the clickhouse_metric_history tool.
+type metricHistoryArgs struct {
+	Metric  string `json:"metric" jsonschema:"metric name from system.asynchronous_metrics, e.g. MemoryResident or ReplicasMaxAbsoluteDelay"`
+	Since   string `json:"since,omitempty" jsonschema:"how far back to look as a Go duration (e.g. 24h); default 24h, maximum 720h"`
+	Points  int    `json:"points,omitempty" jsonschema:"maximum points per replica; default 60, between 2 and 500"`
+	Cluster string `json:"cluster,omitempty" jsonschema:"cluster to read across; defaults to the configured cluster"`
+}
+
+// metricPoint is one downsampled interval of a metric on one replica.
+type metricPoint struct {
+	Time time.Time `json:"time" jsonschema:"start of the interval"`
+	Avg  float64   `json:"avg"`
+	Min  float64   `json:"min"`
+	Max  float64   `json:"max"`
+}
+
+// metricSeries is a metric's history on one replica, oldest point first.
+type metricSeries struct {
+	Host   string        `json:"host"`
+	Points []metricPoint `json:"points"`
+}
+
+// metricHistoryResult is the structured output of clickhouse_metric_history.
+type metricHistoryResult struct {
+	Metric      string         `json:"metric"`
+	Since       time.Time      `json:"since"`
+	StepSeconds int64          `json:"step_seconds" jsonschema:"length of each point's interval"`
+	Series      []metricSeries `json:"series"`
+}
+
+const (
+	defaultMetricHistoryWindow = 24 * time.Hour
+	maxMetricHistoryWindow     = 30 * 24 * time.Hour
+	defaultMetricHistoryPoints = 60
+	maxMetricHistoryPoints     = 500
+)
+
+// parseMetricHistoryArgs validates the metric name and applies defaults and
+// bounds to the window and point count.
+func parseMetricHistoryArgs(a metricHistoryArgs) (string, time.Duration, int, error) {
+	name := strings.TrimSpace(a.Metric)
+	if !validMetricName(name) {
+		return "", 0, 0, fmt.Errorf("invalid metric name: %q", name)
+	}
+
+	window := defaultMetricHistoryWindow
+	if strings.TrimSpace(a.Since) != "" {
+		d, err := time.ParseDuration(a.Since)
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("invalid since: %v", err)
+		}
+		window = d
+	}
+	if window <= 0 || window > maxMetricHistoryWindow {
+		return "", 0, 0, fmt.Errorf("since must be positive and at most %s", maxMetricHistoryWindow)
+	}
+
+	points := a.Points
+	if points == 0 {
+		points = defaultMetricHistoryPoints
+	}
+	if points < 2 || points > maxMetricHistoryPoints {
+		return "", 0, 0, fmt.Errorf("points must be between 2 and %d", maxMetricHistoryPoints)
+	}
+	return name, window, points, nil
+}
+
+// metricHistoryStep returns the interval length in seconds that splits window
+// into at most points intervals. Intervals are aligned to multiples of the
+// step, so a window covers one more interval than it divides into.
+func metricHistoryStep(window time.Duration, points int) int64 {
+	secs := int64(window / time.Second)
+	step := (secs + int64(points) - 2) / int64(points-1)
+	if step < 1 {
+		step = 1
+	}
+	return step
+}
+
+// buildMetricHistoryQuery returns the system.asynchronous_metric_log query
+// averaging metric over step-second intervals on every replica of cluster, and
+// its bind arguments.
+func buildMetricHistoryQuery(cluster, metric string, window time.Duration, step int64) (string, []interface{}) {
+	secs := int64(window / time.Second)
+	query := "SELECT hostName() AS host, toStartOfInterval(event_time, toIntervalSecond(?)) AS t," +
+		" avg(value) AS avg, min(value) AS min, max(value) AS max" +
+		" FROM clusterAllReplicas(?, system.asynchronous_metric_log)" +
+		" WHERE metric = ?" +
+		" AND event_date >= toDate(now() - toIntervalSecond(?)) AND event_time >= now() - toIntervalSecond(?)" +
+		" GROUP BY host, t ORDER BY host, t"
+	return query, []interface{}{step, cluster, metric, secs, secs}
+}
+
+func registerMetricHistoryTool(srv *mcp.Server) {
+	addTool[metricHistoryArgs, *metricHistoryResult](
+		srv,
+		&mcp.Tool{
+			Name:        "clickhouse_metric_history",
+			Title:       "ClickHouse metric history",
+			Description: `Return the history of one metric from system.asynchronous_metric_log on every replica, downsampled to at most points intervals (avg, min and max per interval) over a window, e.g. memory growth over the last day. Use for capacity trends without Prometheus; use clickhouse_metrics for current values. Only metrics in system.asynchronous_metrics (e.g. MemoryResident, OSMemoryAvailable, ReplicasMaxAbsoluteDelay, MaxPartCountForPartition) are recorded.`,
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		},
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[metricHistoryArgs]) (*mcp.CallToolResultFor[*metricHistoryResult], error) {
+			a := req.Arguments
+			metric, window, points, err := parseMetricHistoryArgs(a)
+			if err != nil {
+				return nil, err
+			}
+			cluster := strings.TrimSpace(a.Cluster)
+			if cluster == "" {
+				cluster = viper.GetString("clickhouse.cluster")
+			}
+
+			conn, err := connect()
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				if err := conn.Close(); err != nil {
+					logrus.WithError(err).Warn("Error closing ClickHouse connection")
+				}
+			}()
+
+			res, err := fetchMetricHistory(ctx, conn, cluster, metric, window, points)
+			if err != nil {
+				return nil, err
+			}
+			return &mcp.CallToolResultFor[*metricHistoryResult]{
+				Content:           []mcp.Content{&mcp.TextContent{Text: summarizeMetricHistory(res)}},
+				StructuredContent: res,
+			}, nil
+		},
+	)
+}
+
+// fetchMetricHistory reads the downsampled series, one per replica.
+func fetchMetricHistory(ctx context.Context, conn driver.Conn, cluster, metric string, window time.Duration, points int) (*metricHistoryResult, error) {
+	step := metricHistoryStep(window, points)
+	query, args := buildMetricHistoryQuery(cluster, metric, window, step)
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
+
+	res := &metricHistoryResult{Metric: metric, Since: time.Now().Add(-window).UTC(), StepSeconds: step, Series: []metricSeries{}}
+	for rows.Next() {
+		var host string
+		var p metricPoint
+		if err := rows.Scan(&host, &p.Time, &p.Avg, &p.Min, &p.Max); err != nil {
+			return nil, err
+		}
+		if n := len(res.Series); n == 0 || res.Series[n-1].Host != host {
+			res.Series = append(res.Series, metricSeries{Host: host})
+		}
+		s := &res.Series[len(res.Series)-1]
+		s.Points = append(s.Points, p)
+	}
+	return res, rows.Err()
+}
+
+// summarizeMetricHistory renders one line per replica with the first and last
+// averages, the change between them and the overall min and max.
+func summarizeMetricHistory(res *metricHistoryResult) string {
+	if len(res.Series) == 0 {
+		return fmt.Sprintf("No samples of %s since %s. Check the name against system.asynchronous_metrics; system.asynchronous_metric_log must be enabled.",
+			res.Metric, res.Since.Format(time.RFC3339))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s since %s, %s intervals:", res.Metric, res.Since.Format(time.RFC3339), prettyNumericWithUnits("seconds", float64(res.StepSeconds)))
+	series := append([]metricSeries(nil), res.Series...)
+	sort.Slice(series, func(i, j int) bool { return series[i].Host < series[j].Host })
+	for _, s := range series {
+		first, last := s.Points[0], s.Points[len(s.Points)-1]
+		lo, hi := first.Min, first.Max
+		for _, p := range s.Points {
+			if p.Min < lo {
+				lo = p.Min
+			}
+			if p.Max > hi {
+				hi = p.Max
+			}
+		}
+		change := "+"
+		if last.Avg < first.Avg {
+			change = "-"
+		}
+		diff := last.Avg - first.Avg
+		if diff < 0 {
+			diff = -diff
+		}
+		fmt.Fprintf(&b, "\n%s: %d points, %s -> %s (%s%s), min %s, max %s",
+			s.Host, len(s.Points), formatMetric(res.Metric, first.Avg), formatMetric(res.Metric, last.Avg),
+			change, formatMetric(res.Metric, diff), formatMetric(res.Metric, lo), formatMetric(res.Metric, hi))
+	}
+	return b.String()
+}
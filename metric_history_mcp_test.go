@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMetricHistoryArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       metricHistoryArgs
+		wantWindow time.Duration
+		wantPoints int
+		wantErr    string
+	}{
+		{name: "defaults", args: metricHistoryArgs{Metric: "MemoryResident"}, wantWindow: 24 * time.Hour, wantPoints: 60},
+		{name: "explicit", args: metricHistoryArgs{Metric: " LoadAverage1 ", Since: "6h", Points: 500}, wantWindow: 6 * time.Hour, wantPoints: 500},
+		{name: "missing metric", args: metricHistoryArgs{}, wantErr: "invalid metric name"},
+		{name: "injection", args: metricHistoryArgs{Metric: "Uptime' OR 1=1"}, wantErr: "invalid metric name"},
+		{name: "window too long", args: metricHistoryArgs{Metric: "Uptime", Since: "721h"}, wantErr: "since must be"},
+		{name: "invalid window", args: metricHistoryArgs{Metric: "Uptime", Since: "1 day"}, wantErr: "invalid since"},
+		{name: "one point", args: metricHistoryArgs{Metric: "Uptime", Points: 1}, wantErr: "points must be between 2 and 500"},
+		{name: "too many points", args: metricHistoryArgs{Metric: "Uptime", Points: 501}, wantErr: "points must be"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, window, points, err := parseMetricHistoryArgs(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseMetricHistoryArgs() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMetricHistoryArgs() error = %v", err)
+			}
+			if window != tt.wantWindow || points != tt.wantPoints {
+				t.Errorf("parseMetricHistoryArgs() = %s, %d", window, points)
+			}
+		})
+	}
+}
+
+func TestMetricHistoryStep(t *testing.T) {
+	tests := []struct {
+		window time.Duration
+		points int
+		want   int64
+	}{
+		{24 * time.Hour, 60, 1465},
+		{time.Hour, 61, 60},
+		{10 * time.Second, 500, 1},
+	}
+	for _, tt := range tests {
+		step := metricHistoryStep(tt.window, tt.points)
+		if step != tt.want {
+			t.Errorf("metricHistoryStep(%s, %d) = %d, want %d", tt.window, tt.points, step, tt.want)
+		}
+		// Aligned intervals touched by the window, at worst.
+		secs := int64(tt.window / time.Second)
+		if buckets := (secs+step-1)/step + 1; buckets > int64(tt.points) {
+			t.Errorf("metricHistoryStep(%s, %d) allows %d intervals", tt.window, tt.points, buckets)
+		}
+	}
+}
+
+func TestBuildMetricHistoryQuery(t *testing.T) {
+	query, args := buildMetricHistoryQuery("main", "MemoryResident", 2*time.Hour, 120)
+	for _, want := range []string{
+		"toStartOfInterval(event_time, toIntervalSecond(?)) AS t",
+		"FROM clusterAllReplicas(?, system.asynchronous_metric_log)",
+		"WHERE metric = ?",
+		"GROUP BY host, t ORDER BY host, t",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query missing %q: %s", want, query)
+		}
+	}
+	want := []interface{}{int64(120), "main", "MemoryResident", int64(7200), int64(7200)}
+	for i := range want {
+		if args[i] != want[i] {
+			t.Errorf("args[%d] = %v, want %v", i, args[i], want[i])
+		}
+	}
+}
+
+func TestSummarizeMetricHistory(t *testing.T) {
+	since := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	empty := summarizeMetricHistory(&metricHistoryResult{Metric: "Nope", Since: since})
+	if !strings.Contains(empty, "No samples of Nope since 2026-10-14T12:00:00Z") {
+		t.Errorf("empty summary = %q", empty)
+	}
+
+	res := &metricHistoryResult{Metric: "MemoryResident", Since: since, StepSeconds: 60, Series: []metricSeries{
+		{Host: "ch-2", Points: []metricPoint{{Avg: 4 << 30, Min: 3 << 30, Max: 4 << 30}, {Avg: 2 << 30, Min: 1 << 30, Max: 2 << 30}}},
+		{Host: "ch-1", Points: []metricPoint{{Avg: 1 << 30, Min: 1 << 30, Max: 1 << 30}, {Avg: 3 << 30, Min: 2 << 30, Max: 5 << 30}}},
+	}}
+	got := summarizeMetricHistory(res)
+	lines := strings.Split(got, "\n")
+	if len(lines) != 3 {
+		t.Fatalf("summary = %q", got)
+	}
+	if !strings.HasPrefix(lines[1], "ch-1: 2 points, 1.00 GB -> 3.00 GB (+2.00 GB), min 1.00 GB, max 5.00 GB") {
+		t.Errorf("line 1 = %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "ch-2: 2 points, 4.00 GB -> 2.00 GB (-2.00 GB), min 1.00 GB, max 4.00 GB") {
+		t.Errorf("line 2 = %q", lines[2])
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// metricsArgs is the input to the clickhouse_metrics tool.
+type metricsArgs struct {
+	Metrics []string `json:"metrics,omitempty" jsonschema:"metric names from system.metrics or system.asynchronous_metrics (e.g. MemoryTracking, ReplicasMaxAbsoluteDelay); default a set of key vitals"`
+	Cluster string   `json:"cluster,omitempty" jsonschema:"cluster to read across; defaults to the configured cluster"`
+}
+
+// metricValue is one metric on one replica.
+type metricValue struct {
+	Host      string  `json:"host"`
+	Metric    string  `json:"metric"`
+	Value     float64 `json:"value"`
+	Formatted string  `json:"formatted" jsonschema:"value with units, e.g. 1.20 GB"`
+}
+
+// metricsResult is the structured output of clickhouse_metrics.
+type metricsResult struct {
+	Metrics []metricValue `json:"metrics"`
+	Missing []string      `json:"missing,omitempty" jsonschema:"requested metrics no replica reported"`
+}
+
+// healthMetrics are the vitals returned when no metrics are requested, with
+// the unit each is rendered in ("" for counts).
+var healthMetrics = []struct{ name, unit string }{
+	// system.metrics
+	{"MemoryTracking", "bytes"},
+	{"Query", ""},
+	{"TCPConnection", ""},
+	{"HTTPConnection", ""},
+	{"Merge", ""},
+	{"PartMutation", ""},
+	{"BackgroundMergesAndMutationsPoolTask", ""},
+	{"BackgroundFetchesPoolTask", ""},
+	{"ReadonlyReplica", ""},
+	{"DelayedInserts", ""},
+	// system.asynchronous_metrics
+	{"MemoryResident", "bytes"},
+	{"OSMemoryAvailable", "bytes"},
+	{"ReplicasMaxAbsoluteDelay", "seconds"},
+	{"ReplicasMaxQueueSize", ""},
+	{"MaxPartCountForPartition", ""},
+	{"LoadAverage1", ""},
+	{"Uptime", "seconds"},
+}
+
+const maxMetricsRequested = 100
+
+// metricUnit returns the unit a metric is rendered in: its entry in
+// healthMetrics, else bytes for memory and byte counters.
+func metricUnit(name string) string {
+	for _, m := range healthMetrics {
+		if m.name == name {
+			return m.unit
+		}
+	}
+	lower := strings.ToLower(name)
+	if strings.Contains(lower, "memory") || strings.Contains(lower, "bytes") {
+		return "bytes"
+	}
+	return ""
+}
+
+// formatMetric renders val in the metric's unit using the same formatting as
+// clickhouse_query summaries.
+func formatMetric(name string, val float64) string {
+	if unit := metricUnit(name); unit != "" {
+		return prettyNumericWithUnits(unit, val)
+	}
+	return trimFloat(val)
+}
+
+// parseMetricsArgs returns the metric names to read: the requested ones,
+// deduplicated, or the default vitals.
+func parseMetricsArgs(a metricsArgs) ([]string, error) {
+	if len(a.Metrics) == 0 {
+		names := make([]string, len(healthMetrics))
+		for i, m := range healthMetrics {
+			names[i] = m.name
+		}
+		return names, nil
+	}
+	if len(a.Metrics) > maxMetricsRequested {
+		return nil, fmt.Errorf("at most %d metrics may be requested", maxMetricsRequested)
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range a.Metrics {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("empty metric name")
+		}
+		if !validMetricName(name) {
+			return nil, fmt.Errorf("invalid metric name: %q", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// validMetricName reports whether name looks like a ClickHouse metric name:
+// non-empty and identifier characters only.
+func validMetricName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isIdentChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// buildMetricsQuery reads names from system.metrics and
+// system.asynchronous_metrics on every replica of cluster (or the connected
+// server when cluster is empty).
+func buildMetricsQuery(cluster string, names []string) (string, []interface{}) {
+	var args []interface{}
+	source := func(table string) string {
+		if cluster == "" {
+			return table
+		}
+		args = append(args, cluster)
+		return "clusterAllReplicas(?, " + table + ")"
+	}
+	metrics := source("system.metrics")
+	args = append(args, names)
+	async := source("system.asynchronous_metrics")
+	args = append(args, names)
+	query := "SELECT host, metric, value FROM (" +
+		"SELECT hostName() AS host, metric, toFloat64(value) AS value FROM " + metrics + " WHERE metric IN (?)" +
+		" UNION ALL " +
+		"SELECT hostName() AS host, metric, toFloat64(value) AS value FROM " + async + " WHERE metric IN (?)" +
+		") ORDER BY host, metric"
+	return query, args
+}
+
+func registerMetricsTool(srv *mcp.Server) {
+	addTool[metricsArgs, *metricsResult](
+		srv,
+		&mcp.Tool{
+			Name:        "clickhouse_metrics",
+			Title:       "ClickHouse health snapshot",
+			Description: `Return current values of key ClickHouse metrics on every replica from system.metrics and system.asynchronous_metrics: memory, connections, running queries and merges, background pool tasks, replication delay and queue, part counts and uptime. Use for a fast vitals check before deeper queries. Pass metrics to read specific metric names instead.`,
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		},
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[metricsArgs]) (*mcp.CallToolResultFor[*metricsResult], error) {
+			a := req.Arguments
+			names, err := parseMetricsArgs(a)
+			if err != nil {
+				return nil, err
+			}
+			cluster := strings.TrimSpace(a.Cluster)
+			if cluster == "" {
+				cluster = viper.GetString("clickhouse.cluster")
+			}
+
+			conn, err := connect()
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				if err := conn.Close(); err != nil {
+					logrus.WithError(err).Warn("Error closing ClickHouse connection")
+				}
+			}()
+
+			res, err := fetchMetrics(ctx, conn, cluster, names)
+			if err != nil {
+				return nil, err
+			}
+			return &mcp.CallToolResultFor[*metricsResult]{
+				Content:           []mcp.Content{&mcp.TextContent{Text: summarizeMetrics(res)}},
+				StructuredContent: res,
+			}, nil
+		},
+	)
+}
+
+// fetchMetrics reads names and lists those no replica reported as missing.
+func fetchMetrics(ctx context.Context, conn driver.Conn, cluster string, names []string) (*metricsResult, error) {
+	query, args := buildMetricsQuery(cluster, names)
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
+
+	res := &metricsResult{Metrics: []metricValue{}}
+	found := make(map[string]bool)
+	for rows.Next() {
+		var m metricValue
+		if err := rows.Scan(&m.Host, &m.Metric, &m.Value); err != nil {
+			return nil, err
+		}
+		m.Formatted = formatMetric(m.Metric, m.Value)
+		found[m.Metric] = true
+		res.Metrics = append(res.Metrics, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if !found[name] {
+			res.Missing = append(res.Missing, name)
+		}
+	}
+	return res, nil
+}
+
+// summarizeMetrics renders one line per replica with its metrics, followed by
+// the metrics no replica reported.
+func summarizeMetrics(res *metricsResult) string {
+	if len(res.Metrics) == 0 {
+		return "No matching metrics found."
+	}
+	byHost := make(map[string][]string)
+	var hosts []string
+	for _, m := range res.Metrics {
+		if _, ok := byHost[m.Host]; !ok {
+			hosts = append(hosts, m.Host)
+		}
+		byHost[m.Host] = append(byHost[m.Host], m.Metric+"="+m.Formatted)
+	}
+	sort.Strings(hosts)
+	var b strings.Builder
+	for i, host := range hosts {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%s: %s", host, strings.Join(byHost[host], ", "))
+	}
+	if len(res.Missing) > 0 {
+		fmt.Fprintf(&b, "\nnot reported by any replica: %s", strings.Join(res.Missing, ", "))
+	}
+	return b.String()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMetricsArgs(t *testing.T) {
+	names, err := parseMetricsArgs(metricsArgs{})
+	if err != nil || len(names) != len(healthMetrics) || names[0] != "MemoryTracking" {
+		t.Errorf("default metrics = %v, %v", names, err)
+	}
+
+	names, err = parseMetricsArgs(metricsArgs{Metrics: []string{"Query", " Query ", "Uptime"}})
+	if err != nil || !equalSlices(names, []string{"Query", "Uptime"}) {
+		t.Errorf("requested metrics = %v, %v", names, err)
+	}
+
+	for _, bad := range [][]string{{""}, {"Query') OR 1=1 --"}, make([]string, maxMetricsRequested+1)} {
+		if _, err := parseMetricsArgs(metricsArgs{Metrics: bad}); err == nil {
+			t.Errorf("parseMetricsArgs(%q) accepted", bad)
+		}
+	}
+}
+
+func TestBuildMetricsQuery(t *testing.T) {
+	names := []string{"Query", "Uptime"}
+	query, args := buildMetricsQuery("main", names)
+	for _, want := range []string{
+		"FROM clusterAllReplicas(?, system.metrics) WHERE metric IN (?)",
+		"UNION ALL",
+		"FROM clusterAllReplicas(?, system.asynchronous_metrics) WHERE metric IN (?)",
+		"ORDER BY host, metric",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query missing %q: %s", want, query)
+		}
+	}
+	if len(args) != 4 || args[0] != "main" || args[2] != "main" {
+		t.Errorf("args = %v", args)
+	}
+
+	query, args = buildMetricsQuery("", names)
+	if strings.Contains(query, "clusterAllReplicas") || len(args) != 2 {
+		t.Errorf("local query = %s, args %v", query, args)
+	}
+}
+
+func TestFormatMetric(t *testing.T) {
+	tests := []struct {
+		name string
+		val  float64
+		want string
+	}{
+		{"MemoryTracking", 1536, "1.50 KB"},
+		{"ReplicasMaxAbsoluteDelay", 42, "42s"},
+		{"TCPConnection", 12, "12"},
+		{"CompiledExpressionCacheBytes", 2048, "2.00 KB"},
+		{"LoadAverage1", 0.75, "0.75"},
+	}
+	for _, tt := range tests {
+		if got := formatMetric(tt.name, tt.val); got != tt.want {
+			t.Errorf("formatMetric(%s, %v) = %q, want %q", tt.name, tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestSummarizeMetrics(t *testing.T) {
+	res := &metricsResult{
+		Metrics: []metricValue{
+			{Host: "ch1", Metric: "Query", Formatted: "3"},
+			{Host: "ch1", Metric: "Uptime", Formatted: "60s"},
+			{Host: "ch2", Metric: "Query", Formatted: "1"},
+		},
+		Missing: []string{"Nope"},
+	}
+	want := "ch1: Query=3, Uptime=60s\nch2: Query=1\nnot reported by any replica: Nope"
+	if got := summarizeMetrics(res); got != want {
+		t.Errorf("summarizeMetrics() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Severities passed to Notify, from the urgency markers the analysis prompt
+// asks the model to use.
+const (
+	severityCritical = "critical"
+	severityWarning  = "warning"
+	severityInfo     = "info"
+)
+
+// Notifier delivers an analysis result to one target.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, severity, title, body string) error
+}
+
+// notificationData is what a <target>.template can reference.
+type notificationData struct {
+	Severity string
+	Title    string
+	Body     string
+}
+
+// parseNotifyTemplate parses the <target>.template setting; nil means the body
+// is sent as is.
+func parseNotifyTemplate(target, text string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(target).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s.template: %w", target, err)
+	}
+	return tmpl, nil
+}
+
+// renderNotification applies tmpl to the notification, or returns body when
+// tmpl is nil.
+func renderNotification(tmpl *template.Template, severity, title, body string) (string, error) {
+	if tmpl == nil {
+		return body, nil
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, notificationData{Severity: severity, Title: title, Body: body}); err != nil {
+		return "", fmt.Errorf("rendering %s template: %w", tmpl.Name(), err)
+	}
+	return b.String(), nil
+}
+
+// analysisSeverity maps the model's urgency markers in summary to a severity.
+func analysisSeverity(summary string) string {
+	switch {
+	case strings.Contains(summary, "🔴"):
+		return severityCritical
+	case strings.Contains(summary, "🟡"):
+		return severityWarning
+	default:
+		return severityInfo
+	}
+}
+
+// slackNotifier posts to slack.webhook_url.
+type slackNotifier struct {
+	tmpl       *template.Template
+	errorCount int
+}
+
+func (n *slackNotifier) Name() string { return "slack" }
+
+func (n *slackNotifier) Notify(ctx context.Context, severity, title, body string) error {
+	text, err := renderNotification(n.tmpl, severity, title, body)
+	if err != nil {
+		return err
+	}
+	return SendSlackMessage(text, n.errorCount)
+}
+
+// githubNotifier files the result as an issue or issue comment in github.repo.
+// Only critical results are filed, so the tracker isn't filled with warnings.
+type githubNotifier struct {
+	tmpl *template.Template
+	errs CHErrors
+}
+
+func (n *githubNotifier) Name() string { return "github" }
+
+func (n *githubNotifier) Notify(ctx context.Context, severity, title, body string) error {
+	if severity != severityCritical {
+		logrus.WithField("severity", severity).Debug("Not filing a GitHub issue for a non-critical analysis")
+		return nil
+	}
+	if len(n.errs) == 0 {
+		// Issues are keyed by error name; critical conditions alone have none.
+		logrus.Info("Not filing a GitHub issue: no system.errors entries to file it under")
+		return nil
+	}
+	text, err := renderNotification(n.tmpl, severity, title, body)
+	if err != nil {
+		return err
+	}
+	return SendGitHubIssue(ctx, text, n.errs)
+}
+
+// errorNotifiers returns a Notifier for each configured target, for the
+// analysis of errs: Slack when slack.webhook_url is set and GitHub when
+// github.token and github.repo are set.
+func errorNotifiers(errs CHErrors) ([]Notifier, error) {
+	var notifiers []Notifier
+	if viper.GetString("slack.webhook_url") != "" {
+		tmpl, err := parseNotifyTemplate("slack", viper.GetString("slack.template"))
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, &slackNotifier{tmpl: tmpl, errorCount: len(errs)})
+	}
+	if githubEnabled() {
+		tmpl, err := parseNotifyTemplate("github", viper.GetString("github.template"))
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, &githubNotifier{tmpl: tmpl, errs: errs})
+	}
+	return notifiers, nil
+}
+
+// notifyAll sends the notification to every notifier, continuing past
+// failures, and returns one error per failed target.
+func notifyAll(ctx context.Context, notifiers []Notifier, severity, title, body string) []error {
+	var errs []error
+	for _, n := range notifiers {
+		if err := n.Notify(ctx, severity, title, body); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", n.Name(), err))
+		}
+	}
+	return errs
+}
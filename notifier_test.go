@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRenderNotification(t *testing.T) {
+	got, err := renderNotification(nil, severityWarning, "title", "body")
+	if err != nil || got != "body" {
+		t.Errorf("renderNotification(nil) = %q, %v, want the body", got, err)
+	}
+
+	tmpl, err := parseNotifyTemplate("slack", "*{{.Severity}}* {{.Title}}\n{{.Body}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = renderNotification(tmpl, severityCritical, "ClickHouse errors: X", "summary")
+	if err != nil || got != "*critical* ClickHouse errors: X\nsummary" {
+		t.Errorf("renderNotification() = %q, %v", got, err)
+	}
+
+	tmpl, _ = parseNotifyTemplate("github", "{{.Missing}}")
+	if _, err := renderNotification(tmpl, severityInfo, "", ""); err == nil {
+		t.Error("template referencing an unknown field rendered without error")
+	}
+	if tmpl, err := parseNotifyTemplate("github", "  "); tmpl != nil || err != nil {
+		t.Errorf("blank template = %v, %v, want nil, nil", tmpl, err)
+	}
+}
+
+func TestAnalysisSeverity(t *testing.T) {
+	tests := map[string]string{
+		"🔴 replication stuck\n🟡 many parts": severityCritical,
+		"🟡 many parts":                      severityWarning,
+		"🟢 all good":                        severityInfo,
+		"no markers":                        severityInfo,
+	}
+	for summary, want := range tests {
+		if got := analysisSeverity(summary); got != want {
+			t.Errorf("analysisSeverity(%q) = %q, want %q", summary, got, want)
+		}
+	}
+}
+
+// recordingNotifier records notifications and fails with err when set.
+type recordingNotifier struct {
+	name string
+	err  error
+	got  []string
+}
+
+func (n *recordingNotifier) Name() string { return n.name }
+
+func (n *recordingNotifier) Notify(ctx context.Context, severity, title, body string) error {
+	n.got = append(n.got, severity+"|"+title+"|"+body)
+	return n.err
+}
+
+func TestNotifyAll(t *testing.T) {
+	failing := &recordingNotifier{name: "slack", err: errors.New("status 500")}
+	ok := &recordingNotifier{name: "github"}
+
+	errs := notifyAll(context.Background(), []Notifier{failing, ok}, severityWarning, "title", "body")
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "slack: ") {
+		t.Errorf("notifyAll() errors = %v, want one slack error", errs)
+	}
+	if len(ok.got) != 1 || ok.got[0] != "warning|title|body" {
+		t.Errorf("notifier after a failure got %v", ok.got)
+	}
+}
+
+func TestGitHubNotifierWithoutErrors(t *testing.T) {
+	// Critical conditions alone have no error name to file an issue under, so
+	// nothing is sent (github.api_url is unset; a request would fail).
+	n := &githubNotifier{}
+	if err := n.Notify(context.Background(), severityCritical, "ClickHouse critical conditions", "🔴 low disk space on ch-1"); err != nil {
+		t.Errorf("Notify() without errors = %v, want nil", err)
+	}
+}
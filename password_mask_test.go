@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+func TestMaskPassword(t *testing.T) {
+	viper.Set("clickhouse.password", "s3cret!")
+	viper.Set("analysis.clickhouse.password", "an4lysis")
+	defer viper.Set("clickhouse.password", nil)
+	defer viper.Set("analysis.clickhouse.password", nil)
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "configured password", in: "auth failed for default with s3cret!", want: "auth failed for default with ****"},
+		{name: "analysis password", in: "password an4lysis rejected", want: "password **** rejected"},
+		{name: "dsn userinfo", in: "dial clickhouse://bob:other@ch:9000/db", want: "dial clickhouse://bob:****@ch:9000/db"},
+		{name: "dsn parameter", in: "tcp://ch:9000?username=bob&password=other&secure=true", want: "tcp://ch:9000?username=bob&password=****&secure=true"},
+		{name: "nothing to mask", in: "connection refused", want: "connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maskPassword(tt.in); got != tt.want {
+				t.Errorf("maskPassword(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	viper.Set("logging.mask_passwords", false)
+	defer viper.Set("logging.mask_passwords", nil)
+	if got := maskPassword("s3cret!"); got != "s3cret!" {
+		t.Errorf("maskPassword with masking off = %q", got)
+	}
+}
+
+func TestMaskError(t *testing.T) {
+	viper.Set("clickhouse.password", "s3cret!")
+	defer viper.Set("clickhouse.password", nil)
+
+	if maskError(nil) != nil {
+		t.Error("maskError(nil) != nil")
+	}
+	plain := errors.New("connection refused")
+	if got := maskError(plain); got != plain {
+		t.Errorf("maskError without a password = %v, want the original error", got)
+	}
+
+	err := maskError(fmt.Errorf("ping: %w", &clickhouse.Exception{Code: 516, Message: "bad password s3cret!"}))
+	if strings.Contains(err.Error(), "s3cret!") {
+		t.Errorf("maskError() = %q still contains the password", err)
+	}
+	var exception *clickhouse.Exception
+	if !errors.As(err, &exception) || exception.Code != 516 {
+		t.Errorf("maskError() lost the wrapped exception: %v", err)
+	}
+}
+
+func TestPasswordMaskHook(t *testing.T) {
+	viper.Set("clickhouse.password", "s3cret!")
+	defer viper.Set("clickhouse.password", nil)
+
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	logger.AddHook(passwordMaskHook{})
+	logger.WithError(errors.New("login with s3cret! failed")).WithField("dsn", "clickhouse://u:s3cret!@h").Warn("connect s3cret!")
+
+	out := buf.String()
+	if strings.Contains(out, "s3cret!") {
+		t.Errorf("log line contains the password: %s", out)
+	}
+	if !strings.Contains(out, "login with **** failed") {
+		t.Errorf("log line = %s", out)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// processesArgs is the input to the clickhouse_processes tool.
+type processesArgs struct {
+	Cluster    string `json:"cluster,omitempty" jsonschema:"cluster to list queries across; defaults to the configured cluster"`
+	User       string `json:"user,omitempty" jsonschema:"only queries run by this user"`
+	MinElapsed string `json:"min_elapsed,omitempty" jsonschema:"only queries running at least this long, as a Go duration (e.g. 30s)"`
+	Limit      int    `json:"limit,omitempty" jsonschema:"maximum number of queries to return, longest-running first; default 50, maximum 500"`
+}
+
+// runningQuery is one row of system.processes.
+type runningQuery struct {
+	Host        string  `json:"host"`
+	QueryID     string  `json:"query_id"`
+	User        string  `json:"user"`
+	Elapsed     float64 `json:"elapsed_seconds"`
+	MemoryUsage int64   `json:"memory_usage" jsonschema:"bytes of memory in use"`
+	ReadRows    uint64  `json:"read_rows"`
+	Query       string  `json:"query"`
+	Initial     bool    `json:"initial" jsonschema:"false for the part of a distributed query running on a remote replica"`
+}
+
+// processesResult is the structured output of clickhouse_processes.
+type processesResult struct {
+	Queries []runningQuery `json:"queries"`
+}
+
+const (
+	defaultProcessesLimit  = 50
+	maxProcessesLimit      = 500
+	maxProcessQueryLength  = 2000
+	processesKillQueryHint = "To stop a query, an operator can run KILL QUERY WHERE query_id = '<query_id>' (this server is read-only and does not kill queries)."
+)
+
+// parseProcessesArgs applies defaults and bounds to the filters and limit.
+func parseProcessesArgs(a processesArgs) (time.Duration, int, error) {
+	var minElapsed time.Duration
+	if strings.TrimSpace(a.MinElapsed) != "" {
+		d, err := time.ParseDuration(a.MinElapsed)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid min_elapsed: %v", err)
+		}
+		if d < 0 {
+			return 0, 0, fmt.Errorf("min_elapsed must not be negative")
+		}
+		minElapsed = d
+	}
+
+	limit := a.Limit
+	if limit == 0 {
+		limit = defaultProcessesLimit
+	}
+	if limit < 1 || limit > maxProcessesLimit {
+		return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxProcessesLimit)
+	}
+	return minElapsed, limit, nil
+}
+
+// buildProcessesQuery returns the system.processes query on every replica of
+// cluster (or the connected server when cluster is empty) and its bind
+// arguments. ownQueryID excludes the query itself and its remote parts.
+func buildProcessesQuery(cluster, user string, minElapsed time.Duration, limit int, ownQueryID string) (string, []interface{}) {
+	source := "system.processes"
+	var args []interface{}
+	if cluster != "" {
+		source = "clusterAllReplicas(?, system.processes)"
+		args = append(args, cluster)
+	}
+
+	var b strings.Builder
+	b.WriteString("SELECT hostName() AS host, query_id, user, elapsed, memory_usage, read_rows, query, is_initial_query FROM ")
+	b.WriteString(source)
+	b.WriteString(" WHERE initial_query_id != ?")
+	args = append(args, ownQueryID)
+	if user != "" {
+		b.WriteString(" AND user = ?")
+		args = append(args, user)
+	}
+	if minElapsed > 0 {
+		b.WriteString(" AND elapsed >= ?")
+		args = append(args, minElapsed.Seconds())
+	}
+	b.WriteString(" ORDER BY elapsed DESC LIMIT ?")
+	args = append(args, limit)
+	return b.String(), args
+}
+
+func registerProcessesTool(srv *mcp.Server) {
+	addTool[processesArgs, *processesResult](
+		srv,
+		&mcp.Tool{
+			Name:        "clickhouse_processes",
+			Title:       "Running ClickHouse queries",
+			Description: `List the queries running right now on every replica (system.processes), longest-running first, with user, elapsed time, memory, rows read and query_id. Use for "what's running / what's slow right now?". Filter by user or min_elapsed. Read-only: to stop a query, an operator runs KILL QUERY WHERE query_id = '<query_id>'.`,
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		},
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[processesArgs]) (*mcp.CallToolResultFor[*processesResult], error) {
+			a := req.Arguments
+			minElapsed, limit, err := parseProcessesArgs(a)
+			if err != nil {
+				return nil, err
+			}
+			cluster := strings.TrimSpace(a.Cluster)
+			if cluster == "" {
+				cluster = viper.GetString("clickhouse.cluster")
+			}
+
+			conn, err := connect()
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				if err := conn.Close(); err != nil {
+					logrus.WithError(err).Warn("Error closing ClickHouse connection")
+				}
+			}()
+
+			res, err := fetchProcesses(ctx, conn, cluster, strings.TrimSpace(a.User), minElapsed, limit)
+			if err != nil {
+				return nil, err
+			}
+			return &mcp.CallToolResultFor[*processesResult]{
+				Content:           []mcp.Content{&mcp.TextContent{Text: summarizeProcesses(res)}},
+				StructuredContent: res,
+			}, nil
+		},
+	)
+}
+
+// fetchProcesses runs the processes query and truncates long query texts.
+func fetchProcesses(ctx context.Context, conn driver.Conn, cluster, user string, minElapsed time.Duration, limit int) (*processesResult, error) {
+	ctx, queryID := withQueryID(ctx)
+	query, args := buildProcessesQuery(cluster, user, minElapsed, limit, queryID)
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
+
+	res := &processesResult{Queries: []runningQuery{}}
+	for rows.Next() {
+		var q runningQuery
+		var initial uint8
+		if err := rows.Scan(&q.Host, &q.QueryID, &q.User, &q.Elapsed, &q.MemoryUsage, &q.ReadRows, &q.Query, &initial); err != nil {
+			return nil, err
+		}
+		q.Initial = initial == 1
+		if len(q.Query) > maxProcessQueryLength {
+			q.Query = q.Query[:maxProcessQueryLength] + "..."
+		}
+		res.Queries = append(res.Queries, q)
+	}
+	return res, rows.Err()
+}
+
+// summarizeProcesses renders one line per query followed by how to stop one.
+func summarizeProcesses(res *processesResult) string {
+	if len(res.Queries) == 0 {
+		return "No queries running."
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d running query(ies), longest first:", len(res.Queries))
+	for _, q := range res.Queries {
+		query := strings.Join(strings.Fields(q.Query), " ")
+		if len(query) > 200 {
+			query = query[:200] + "..."
+		}
+		remote := ""
+		if !q.Initial {
+			remote = " (remote)"
+		}
+		fmt.Fprintf(&b, "\n%s %.1fs by %s%s, %s, %d rows read, query_id %s: %s",
+			q.Host, q.Elapsed, q.User, remote, humanBytes(float64(q.MemoryUsage)), q.ReadRows, q.QueryID, query)
+	}
+	b.WriteString("\n" + processesKillQueryHint)
+	return b.String()
+}
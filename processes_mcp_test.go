@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProcessesArgs(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           processesArgs
+		wantMinElapsed time.Duration
+		wantLimit      int
+		wantErr        bool
+	}{
+		{name: "defaults", args: processesArgs{}, wantLimit: 50},
+		{name: "explicit", args: processesArgs{MinElapsed: "30s", Limit: 10, User: "app"}, wantMinElapsed: 30 * time.Second, wantLimit: 10},
+		{name: "limit at max", args: processesArgs{Limit: 500}, wantLimit: 500},
+		{name: "limit over max", args: processesArgs{Limit: 501}, wantErr: true},
+		{name: "negative limit", args: processesArgs{Limit: -1}, wantErr: true},
+		{name: "negative min_elapsed", args: processesArgs{MinElapsed: "-1s"}, wantErr: true},
+		{name: "invalid min_elapsed", args: processesArgs{MinElapsed: "a while"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			minElapsed, limit, err := parseProcessesArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProcessesArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if minElapsed != tt.wantMinElapsed || limit != tt.wantLimit {
+				t.Errorf("parseProcessesArgs() = %s, %d, want %s, %d", minElapsed, limit, tt.wantMinElapsed, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestBuildProcessesQuery(t *testing.T) {
+	query, args := buildProcessesQuery("main", "app", 30*time.Second, 20, "self")
+	for _, want := range []string{
+		"FROM clusterAllReplicas(?, system.processes)",
+		"initial_query_id != ?",
+		"user = ?",
+		"elapsed >= ?",
+		"ORDER BY elapsed DESC LIMIT ?",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query missing %q: %s", want, query)
+		}
+	}
+	wantArgs := []interface{}{"main", "self", "app", 30.0, 20}
+	if len(args) != len(wantArgs) {
+		t.Fatalf("args = %v, want %v", args, wantArgs)
+	}
+	for i := range args {
+		if args[i] != wantArgs[i] {
+			t.Errorf("args[%d] = %v, want %v", i, args[i], wantArgs[i])
+		}
+	}
+
+	query, args = buildProcessesQuery("", "", 0, 20, "self")
+	if !strings.Contains(query, "FROM system.processes WHERE") || strings.Contains(query, "user = ?") || strings.Contains(query, "elapsed >=") {
+		t.Errorf("local query without filters = %s", query)
+	}
+	if len(args) != 2 {
+		t.Errorf("local query args = %v, want 2", args)
+	}
+}
+
+func TestSummarizeProcesses(t *testing.T) {
+	if got := summarizeProcesses(&processesResult{}); got != "No queries running." {
+		t.Errorf("empty summary = %q", got)
+	}
+	got := summarizeProcesses(&processesResult{Queries: []runningQuery{
+		{Host: "ch1", QueryID: "abc", User: "app", Elapsed: 12.5, MemoryUsage: 2048, ReadRows: 100, Query: "SELECT\n  1", Initial: true},
+		{Host: "ch2", QueryID: "abc", User: "app", Elapsed: 12.4, Query: "SELECT 1"},
+	}})
+	for _, want := range []string{"2 running", "ch1 12.5s by app, 2.00 KB", "query_id abc: SELECT 1", "ch2 12.4s by app (remote)", "KILL QUERY WHERE query_id"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("summary missing %q:\n%s", want, got)
+		}
+	}
+}
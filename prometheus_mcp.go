@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
@@ -29,6 +31,12 @@ type prometheusArgs struct {
 	Step  string `json:"step,omitempty"`  // Step duration (e.g. "15s", "1m", "1h")
 }
 
+// promQueryResult is the structured output of the prometheus_query tools. Its
+// shape is published to clients as the tool's output schema.
+type promQueryResult struct {
+	Result interface{} `json:"result" jsonschema:"for range (matrix) results an object with raw_result (the full series) and last_values (metric, value, time of each series' last point); otherwise the raw Prometheus result"`
+}
+
 func buildPromBaseURL(configKey string) string {
 	host := viper.GetString(configKey + ".host")
 	port := viper.GetInt(configKey + ".port")
@@ -88,11 +96,186 @@ func queryPrometheus(endpoint, query string, start, end time.Time, step time.Dur
 
 	result, _, err := client.QueryRange(ctx, query, r)
 	if err != nil {
-		return nil, fmt.Errorf("error querying prometheus (%s): %v", endpoint, err)
+		return nil, promQueryError(endpoint, query, err)
 	}
 	return summarizePromResult(result)
 }
 
+// promNoDataHint is shown when a range query matches no series.
+const promNoDataHint = "No series matched. Check the metric name and label values (e.g. query {__name__=~\"<prefix>.*\"} or count by (__name__) ({job=\"<job>\"}) to discover names), and that the time range is when the metric existed."
+
+// promErrorHints maps substrings of Prometheus / VictoriaMetrics error
+// messages to advice for correcting the query. First match wins.
+var promErrorHints = []struct {
+	substr string
+	hint   string
+}{
+	{"parse error", "PromQL syntax error. Check balanced braces and parentheses, quoted label values (label=\"value\"), and that range selectors like [5m] are inside rate()/increase()."},
+	{"cannot parse", "PromQL syntax error. Check balanced braces and parentheses, quoted label values (label=\"value\"), and that range selectors like [5m] are inside rate()/increase()."},
+	{"unknown function", "Unknown function name; check spelling and that it is supported by this backend."},
+	{"unsupported function", "Unknown function name; check spelling and that it is supported by this backend."},
+	{"ranges only allowed for vector selectors", "A range selector like [5m] must follow a plain metric selector; wrap it in rate()/increase() or use a subquery [5m:1m]."},
+	{"maximum resolution", "Too many points per series; increase step or shorten the time range."},
+	{"too many samples", "Query touches too many samples; narrow the label selector, shorten the range, or aggregate (sum by (...))."},
+	{"cannot select more than", "Query matches too many series; narrow the label selector or aggregate (sum by (...))."},
+	{"the number of matching timeseries exceeds", "Query matches too many series; narrow the label selector or aggregate (sum by (...))."},
+	{"deadline exceeded", "Query timed out; shorten the range, increase step, or narrow the label selector."},
+	{"timeout", "Query timed out; shorten the range, increase step, or narrow the label selector."},
+}
+
+// validatePromQuery rejects PromQL that would make the backend scan every
+// series: selectors with no metric name and no label matcher that actually
+// narrows the match (e.g. {__name__=~".+"} or {job=~".*"}), and functions in
+// prometheus.denied_functions. When prometheus.max_range is set, the query's
+// time range and any range selector or subquery window must not exceed it; it
+// takes PromQL durations such as 7d.
+func validatePromQuery(query string, queryRange time.Duration) error {
+	var maxRange time.Duration
+	if d, err := model.ParseDuration(viper.GetString("prometheus.max_range")); err == nil {
+		maxRange = time.Duration(d)
+	}
+	if maxRange > 0 && queryRange > maxRange {
+		return fmt.Errorf("time range %s exceeds prometheus.max_range (%s); shorten the range", queryRange, maxRange)
+	}
+
+	denied := make(map[string]bool)
+	for _, f := range viper.GetStringSlice("prometheus.denied_functions") {
+		denied[strings.ToLower(strings.TrimSpace(f))] = true
+	}
+
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; c {
+		case '"', '\'', '`':
+			i = skipPromString(query, i)
+		case '{':
+			end := strings.IndexByte(query[i:], '}')
+			if end < 0 {
+				return nil // let the backend report the syntax error
+			}
+			if !promSelectorNamed(query[:i]) && !promMatchersNarrow(query[i+1:i+end]) {
+				return fmt.Errorf("selector %s matches every series; add a metric name or a label matcher such as job=\"...\"", query[i:i+end+1])
+			}
+			i += end
+		case '[':
+			end := strings.IndexByte(query[i:], ']')
+			if end < 0 || maxRange <= 0 {
+				continue
+			}
+			window, _, _ := strings.Cut(query[i+1:i+end], ":")
+			if d, err := model.ParseDuration(strings.TrimSpace(window)); err == nil && time.Duration(d) > maxRange {
+				return fmt.Errorf("range [%s] exceeds prometheus.max_range (%s)", window, maxRange)
+			}
+		case '(':
+			name := promIdentBefore(query[:i])
+			if denied[strings.ToLower(name)] {
+				return fmt.Errorf("function %s() is not allowed on this server (prometheus.denied_functions)", name)
+			}
+		}
+	}
+	return nil
+}
+
+// skipPromString returns the index of the quote closing the string that
+// starts at query[start].
+func skipPromString(query string, start int) int {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		if query[i] == '\\' && quote != '`' {
+			i++
+		} else if query[i] == quote {
+			return i
+		}
+	}
+	return len(query)
+}
+
+func isPromIdentChar(c byte) bool {
+	return c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// promIdentBefore returns the identifier immediately before the end of s,
+// ignoring trailing whitespace.
+func promIdentBefore(s string) string {
+	s = strings.TrimRight(s, " \t\n")
+	i := len(s)
+	for i > 0 && isPromIdentChar(s[i-1]) {
+		i--
+	}
+	return s[i:]
+}
+
+// promKeywords are PromQL keywords that can precede a selector's "{" but are
+// not metric names, e.g. the "or" in up or {job=~".+"}. PromQL keywords are
+// case-insensitive.
+var promKeywords = map[string]bool{
+	"and": true, "or": true, "unless": true,
+	"by": true, "without": true, "on": true, "ignoring": true,
+	"group_left": true, "group_right": true, "offset": true, "bool": true,
+}
+
+// promSelectorNamed reports whether the selector whose "{" follows before has
+// a metric name.
+func promSelectorNamed(before string) bool {
+	name := promIdentBefore(before)
+	return name != "" && !promKeywords[strings.ToLower(name)]
+}
+
+// promMatcherRe matches one label matcher inside a selector's braces.
+var promMatcherRe = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*)\s*(=~|!~|!=|=)\s*("(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|` + "`[^`]*`" + `)`)
+
+// promMatchersNarrow reports whether at least one matcher restricts the series
+// selected: an equality with a non-empty value, or a regex that matches
+// neither the empty string nor arbitrary values.
+func promMatchersNarrow(matchers string) bool {
+	for _, m := range promMatcherRe.FindAllStringSubmatch(matchers, -1) {
+		op, value := m[2], m[3][1:len(m[3])-1]
+		switch op {
+		case "=":
+			if value != "" {
+				return true
+			}
+		case "=~":
+			re, err := regexp.Compile("^(?:" + value + ")$")
+			if err != nil {
+				return true // let the backend report it
+			}
+			if !re.MatchString("") && !(re.MatchString("a") && re.MatchString("Zz_9:x")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// promQueryError builds the error returned for a failed range query: the
+// backend's structured error (type, message and detail when available), the
+// offending query, and a hint for common mistakes so the caller can correct it.
+func promQueryError(endpoint, query string, err error) error {
+	msg := err.Error()
+	var apiErr *v1.Error
+	if errors.As(err, &apiErr) {
+		msg = fmt.Sprintf("%s: %s", apiErr.Type, apiErr.Msg)
+		if detail := strings.TrimSpace(apiErr.Detail); detail != "" && detail != apiErr.Msg {
+			// Detail can be a whole (HTML) error page from a proxy.
+			if len(detail) > 500 {
+				detail = detail[:500] + "…"
+			}
+			msg += " (" + detail + ")"
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "error querying prometheus (%s): %s\nquery: %s", endpoint, msg, query)
+	lower := strings.ToLower(msg)
+	for _, h := range promErrorHints {
+		if strings.Contains(lower, h.substr) {
+			b.WriteString("\nhint: " + h.hint)
+			break
+		}
+	}
+	return errors.New(b.String())
+}
+
 func validateAndParseTimeRange(start, end string) (time.Time, time.Time, error) {
 	parsed_start, err := parseTime(start)
 	if err != nil {
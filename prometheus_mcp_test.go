@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/spf13/viper"
 )
 
 func TestValidateAndParseTimeRange_RejectsFutureStart(t *testing.T) {
@@ -68,3 +73,116 @@ func TestValidateAndParseTimeRange_RejectsStartAfterEnd(t *testing.T) {
 		t.Errorf("expected error to mention ordering, got: %v", err)
 	}
 }
+
+func TestPromQueryError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantContain []string
+		wantHint    string
+	}{
+		{
+			name:        "parse error from prometheus",
+			err:         &v1.Error{Type: v1.ErrBadData, Msg: `invalid parameter "query": 1:14: parse error: unexpected "}"`},
+			wantContain: []string{"bad_data", "parse error", "query: rate(foo{job=\"x\"}[5m]"},
+			wantHint:    "PromQL syntax error",
+		},
+		{
+			name:        "victoria metrics parse error",
+			err:         &v1.Error{Type: "422", Msg: `cannot parse "rate(foo[5m]": unexpected end of stream`},
+			wantContain: []string{"422", "cannot parse"},
+			wantHint:    "PromQL syntax error",
+		},
+		{
+			name:        "structured detail is surfaced",
+			err:         &v1.Error{Type: v1.ErrServer, Msg: "server error: 502", Detail: "upstream connect error"},
+			wantContain: []string{"server error: 502", "(upstream connect error)"},
+		},
+		{
+			name:     "resolution limit",
+			err:      &v1.Error{Type: v1.ErrBadData, Msg: "exceeded maximum resolution of 11,000 points per timeseries"},
+			wantHint: "Too many points per series",
+		},
+		{
+			name:     "timeout",
+			err:      fmt.Errorf("Post \"http://prom/api/v1/query_range\": %w", context.DeadlineExceeded),
+			wantHint: "Query timed out",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := promQueryError("default", `rate(foo{job="x"}[5m]`, tt.err).Error()
+			if !strings.HasPrefix(got, "error querying prometheus (default): ") {
+				t.Errorf("promQueryError() = %q, want endpoint prefix", got)
+			}
+			for _, want := range tt.wantContain {
+				if !strings.Contains(got, want) {
+					t.Errorf("promQueryError() = %q, want to contain %q", got, want)
+				}
+			}
+			if tt.wantHint == "" {
+				if strings.Contains(got, "hint:") {
+					t.Errorf("promQueryError() = %q, want no hint", got)
+				}
+			} else if !strings.Contains(got, "hint: "+tt.wantHint) {
+				t.Errorf("promQueryError() = %q, want hint %q", got, tt.wantHint)
+			}
+		})
+	}
+}
+
+func TestValidatePromQuery(t *testing.T) {
+	defer viper.Set("prometheus.max_range", nil)
+	defer viper.Set("prometheus.denied_functions", nil)
+	viper.Set("prometheus.max_range", "7d")
+	viper.Set("prometheus.denied_functions", []string{"count_values"})
+
+	tests := []struct {
+		name    string
+		query   string
+		rng     time.Duration
+		wantErr string
+	}{
+		{name: "named metric", query: `rate(http_requests_total{job="api"}[5m])`, rng: time.Hour},
+		{name: "bare metric", query: `up`, rng: time.Hour},
+		{name: "name regex with prefix", query: `{__name__=~"node_.*"}`, rng: time.Hour},
+		{name: "nameless selector with label", query: `sum by (job) ({job="api", instance=~".+"})`, rng: time.Hour},
+		{name: "braces in string ignored", query: `label_replace(up, "dst", "{x}", "src", ".*")`, rng: time.Hour},
+		{name: "match-all name", query: `count({__name__=~".+"})`, rng: time.Hour, wantErr: "matches every series"},
+		{name: "empty selector", query: `{}`, rng: time.Hour, wantErr: "matches every series"},
+		{name: "or before nameless selector", query: `up or {__name__=~".+"}`, rng: time.Hour, wantErr: "matches every series"},
+		{name: "upper-case OR", query: `up OR {__name__=~".+"}`, rng: time.Hour, wantErr: "matches every series"},
+		{name: "and before nameless selector", query: `up and{job=~".*"}`, rng: time.Hour, wantErr: "matches every series"},
+		{name: "unless before nameless selector", query: `up unless {}`, rng: time.Hour, wantErr: "matches every series"},
+		{name: "bool before nameless selector", query: `up > bool {__name__=~".+"}`, rng: time.Hour, wantErr: "matches every series"},
+		{name: "or before named selector", query: `up or node_load1{job="node"}`, rng: time.Hour},
+		{name: "metric named like a keyword prefix", query: `order_total{job=""}`, rng: time.Hour},
+		{name: "only match-all labels", query: `{job=~".*", env!="prod"}`, rng: time.Hour, wantErr: "matches every series"},
+		{name: "empty equality", query: `{job=""}`, rng: time.Hour, wantErr: "matches every series"},
+		{name: "range too long", query: `up`, rng: 8 * 24 * time.Hour, wantErr: "prometheus.max_range"},
+		{name: "range selector too long", query: `increase(up[30d])`, rng: time.Hour, wantErr: "range [30d]"},
+		{name: "subquery window too long", query: `max_over_time(up[14d:1h])`, rng: time.Hour, wantErr: "range [14d]"},
+		{name: "denied function", query: `count_values ("v", up)`, rng: time.Hour, wantErr: "count_values() is not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePromQuery(tt.query, tt.rng)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validatePromQuery(%q) unexpected error: %v", tt.query, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validatePromQuery(%q) error = %v, want %q", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+
+	viper.Set("prometheus.max_range", "0")
+	if err := validatePromQuery(`increase(up[90d])`, 90*24*time.Hour); err != nil {
+		t.Errorf("max_range 0 should not limit ranges: %v", err)
+	}
+}
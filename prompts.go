@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// systemPromptData is what a prompts.<agent> template can reference:
+// {{.Default}} is the built-in system prompt and {{.Tools}} the comma-separated
+// names of the functions the agent can call.
+type systemPromptData struct {
+	Default string
+	Tools   string
+}
+
+// renderSystemPrompt returns the system prompt for agent (errors, performance
+// or diagnose): the prompts.<agent> template rendered over def and tools, or
+// def itself when no template is configured. A template that fails to parse or
+// render is logged and def is used, so a bad override never breaks the agent.
+func renderSystemPrompt(agent, def string, tools ...string) string {
+	text := viper.GetString("prompts." + agent)
+	if strings.TrimSpace(text) == "" {
+		return def
+	}
+	entry := logrus.WithField("prompt", "prompts."+agent)
+	tmpl, err := template.New(agent).Parse(text)
+	if err != nil {
+		entry.WithError(err).Warn("Invalid system prompt template; using the built-in prompt")
+		return def
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, systemPromptData{Default: def, Tools: strings.Join(tools, ", ")}); err != nil {
+		entry.WithError(err).Warn("Could not render system prompt template; using the built-in prompt")
+		return def
+	}
+	return b.String()
+}
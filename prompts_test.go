@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestRenderSystemPrompt(t *testing.T) {
+	defer viper.Set("prompts.diagnose", nil)
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{name: "unset keeps default", template: "", want: "built-in"},
+		{name: "replace", template: "Custom prompt.", want: "Custom prompt."},
+		{name: "extend default with tools", template: "{{.Default}}\nAlways LIMIT {{.Tools}} queries.", want: "built-in\nAlways LIMIT run_sql, other queries."},
+		{name: "parse error falls back", template: "{{.Default", want: "built-in"},
+		{name: "unknown field falls back", template: "{{.Missing}}", want: "built-in"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("prompts.diagnose", tt.template)
+			if got := renderSystemPrompt("diagnose", "built-in", "run_sql", "other"); got != tt.want {
+				t.Errorf("renderSystemPrompt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
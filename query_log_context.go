@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// queryErrorCodes are system.errors codes raised by the queries themselves,
+// for which the failing queries in system.query_log explain the error.
+var queryErrorCodes = map[int32]bool{
+	43:  true, // ILLEGAL_TYPE_OF_ARGUMENT
+	46:  true, // UNKNOWN_FUNCTION
+	47:  true, // UNKNOWN_IDENTIFIER
+	53:  true, // TYPE_MISMATCH
+	60:  true, // UNKNOWN_TABLE
+	62:  true, // SYNTAX_ERROR
+	158: true, // TOO_MANY_ROWS
+	159: true, // TIMEOUT_EXCEEDED
+	202: true, // TOO_MANY_SIMULTANEOUS_QUERIES
+	241: true, // MEMORY_LIMIT_EXCEEDED
+	307: true, // TOO_MANY_BYTES
+	394: true, // QUERY_WAS_CANCELLED
+}
+
+// queryLogContextPerCode bounds the failed query patterns fetched per error
+// code.
+const queryLogContextPerCode = 5
+
+// failedQuery is a recent system.query_log exception for one query pattern.
+type failedQuery struct {
+	Host                string
+	EventTime           time.Time
+	ExceptionCode       int32
+	NormalizedQueryHash uint64
+	User                string
+	DurationMs          uint64
+	MemoryUsage         uint64
+	Failures            uint64
+	Exception           string
+}
+
+// queryRelatedCodes returns the distinct query-related error codes in
+// chErrors, in ascending order.
+func queryRelatedCodes(chErrors CHErrors) []int32 {
+	seen := make(map[int32]bool)
+	var codes []int32
+	for _, e := range chErrors {
+		if queryErrorCodes[e.Code] && !seen[e.Code] {
+			seen[e.Code] = true
+			codes = append(codes, e.Code)
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	return codes
+}
+
+// buildFailedQueriesQuery returns the query for the latest failure of each
+// query pattern in the past hour with one of codes, at most
+// queryLogContextPerCode patterns per code, and its bind args. Query text is
+// left out; patterns are identified by normalized_query_hash.
+func buildFailedQueriesQuery(cluster string, codes []int32) (string, []any) {
+	query := "SELECT hostname() AS host, max(event_time) AS last_time, exception_code, normalized_query_hash," +
+		" any(user) AS user, max(query_duration_ms) AS duration_ms, max(memory_usage) AS memory_usage, count() AS failures," +
+		" substring(argMax(exception, event_time), 1, 300) AS exception" +
+		" FROM clusterAllReplicas(?, system.query_log)" +
+		" WHERE event_time > now() - INTERVAL 1 HOUR" +
+		" AND type IN ('ExceptionBeforeStart', 'ExceptionWhileProcessing')" +
+		" AND exception_code IN (?)" +
+		" GROUP BY host, exception_code, normalized_query_hash" +
+		" ORDER BY exception_code, failures DESC" +
+		" LIMIT ? BY exception_code"
+	return query, []any{cluster, codes, queryLogContextPerCode}
+}
+
+// fetchFailedQueries reads the recent failed queries for codes.
+func fetchFailedQueries(ctx context.Context, conn driver.Conn, codes []int32) ([]failedQuery, error) {
+	query, args := buildFailedQueriesQuery(viper.GetString("clickhouse.cluster"), codes)
+	rows, err := conn.Query(ctx, query, args...)
+	usage.recordQuery(err)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
+
+	var out []failedQuery
+	for rows.Next() {
+		var q failedQuery
+		if err := rows.Scan(&q.Host, &q.EventTime, &q.ExceptionCode, &q.NormalizedQueryHash, &q.User,
+			&q.DurationMs, &q.MemoryUsage, &q.Failures, &q.Exception); err != nil {
+			return nil, err
+		}
+		out = append(out, q)
+	}
+	return out, rows.Err()
+}
+
+// formatFailedQueries renders failed queries for the analysis prompt, one
+// line per query pattern.
+func formatFailedQueries(queries []failedQuery) string {
+	lines := make([]string, len(queries))
+	for i, q := range queries {
+		lines[i] = fmt.Sprintf("Code: %d, Host: %s, NormalizedQueryHash: %d, User: %s, Failures: %d, LastTime: %s, MaxDurationMs: %d, MaxMemory: %s, Exception: %s",
+			q.ExceptionCode, q.Host, q.NormalizedQueryHash, q.User, q.Failures, q.EventTime.UTC().Format(time.RFC3339),
+			q.DurationMs, humanBytes(float64(q.MemoryUsage)), strings.Join(strings.Fields(q.Exception), " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// queryLogContext returns the recent failed queries behind the query-related
+// errors in chErrors, formatted for the analysis prompt, when
+// analysis.query_log_context is set. It returns "" when disabled, when no
+// error is query-related, or when system.query_log can't be read (logged).
+func queryLogContext(ctx context.Context, chErrors CHErrors) string {
+	if !viper.GetBool("analysis.query_log_context") {
+		return ""
+	}
+	codes := queryRelatedCodes(chErrors)
+	if len(codes) == 0 {
+		return ""
+	}
+	conn, err := connectAnalysis()
+	if err != nil {
+		logrus.WithError(err).Warn("Could not read system.query_log for error analysis")
+		return ""
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing ClickHouse connection")
+		}
+	}()
+	queries, err := fetchFailedQueries(ctx, conn, codes)
+	if err != nil {
+		logrus.WithError(err).Warn("Could not read system.query_log for error analysis")
+		return ""
+	}
+	logrus.WithFields(logrus.Fields{"codes": codes, "patterns": len(queries)}).Debug("Fetched failed queries for error analysis")
+	return formatFailedQueries(queries)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryRelatedCodes(t *testing.T) {
+	got := queryRelatedCodes(CHErrors{
+		{Name: "MEMORY_LIMIT_EXCEEDED", Code: 241, Hostname: "ch-1"},
+		{Name: "SYNTAX_ERROR", Code: 62, Hostname: "ch-1"},
+		{Name: "MEMORY_LIMIT_EXCEEDED", Code: 241, Hostname: "ch-2"},
+		{Name: "KEEPER_EXCEPTION", Code: 999, Hostname: "ch-1"},
+	})
+	if want := []int32{62, 241}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queryRelatedCodes() = %v, want %v", got, want)
+	}
+	if got := queryRelatedCodes(CHErrors{{Name: "NOT_ENOUGH_SPACE", Code: 243}}); got != nil {
+		t.Errorf("queryRelatedCodes() = %v, want none", got)
+	}
+}
+
+func TestBuildFailedQueriesQuery(t *testing.T) {
+	query, args := buildFailedQueriesQuery("main", []int32{62, 241})
+	for _, want := range []string{
+		"FROM clusterAllReplicas(?, system.query_log)",
+		"exception_code IN (?)",
+		"type IN ('ExceptionBeforeStart', 'ExceptionWhileProcessing')",
+		"LIMIT ? BY exception_code",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query = %q, missing %q", query, want)
+		}
+	}
+	if strings.Contains(query, "query,") || strings.Contains(query, "any(query)") {
+		t.Errorf("query = %q, must not select query text", query)
+	}
+	if want := []any{"main", []int32{62, 241}, queryLogContextPerCode}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
+
+func TestFormatFailedQueries(t *testing.T) {
+	got := formatFailedQueries([]failedQuery{{
+		Host: "ch-1", EventTime: time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC), ExceptionCode: 241,
+		NormalizedQueryHash: 42, User: "app", DurationMs: 1200, MemoryUsage: 2 << 30, Failures: 7,
+		Exception: "Code: 241. DB::Exception: Memory limit\n exceeded",
+	}})
+	want := "Code: 241, Host: ch-1, NormalizedQueryHash: 42, User: app, Failures: 7, LastTime: 2026-10-15T09:30:00Z, MaxDurationMs: 1200, MaxMemory: 2.00 GB, Exception: Code: 241. DB::Exception: Memory limit exceeded"
+	if got != want {
+		t.Errorf("formatFailedQueries() =\n%q\nwant\n%q", got, want)
+	}
+}
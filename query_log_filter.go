@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Modes of clickhouse.query_log_time_filter.
+const (
+	queryLogFilterOff    = "off"
+	queryLogFilterInject = "inject"
+	queryLogFilterReject = "reject"
+)
+
+// defaultQueryLogWindow is the window injected into structured system.query_log
+// queries when clickhouse.query_log_window is unset.
+const defaultQueryLogWindow = 24 * time.Hour
+
+// queryLogRefRe matches a reference to system.query_log, bare, backtick-quoted
+// or as the table argument of clusterAllReplicas().
+var queryLogRefRe = regexp.MustCompile("(?i)`?\\bsystem`?\\s*\\.\\s*`?query_log\\b")
+
+// queryLogTimeColumnRe matches a filter column of system.query_log.
+var queryLogTimeColumnRe = regexp.MustCompile(`(?i)\bevent_(date|time|time_microseconds)\b`)
+
+// queryLogWhereRe matches a WHERE or PREWHERE followed, anywhere later in the
+// query, by a filter column. It is a heuristic: the column may sit in a
+// subquery's WHERE rather than the one on system.query_log.
+var queryLogWhereRe = regexp.MustCompile(`(?i)\b(pre)?where\b.*\bevent_(date|time|time_microseconds)\b`)
+
+// queryLogTimeFilter returns clickhouse.query_log_time_filter: off (the
+// default), inject or reject.
+func queryLogTimeFilter() string {
+	mode := strings.ToLower(strings.TrimSpace(viper.GetString("clickhouse.query_log_time_filter")))
+	if mode == "" {
+		return queryLogFilterOff
+	}
+	return mode
+}
+
+// queryLogWindow returns clickhouse.query_log_window, or the default when unset.
+func queryLogWindow() time.Duration {
+	if d := viper.GetDuration("clickhouse.query_log_window"); d > 0 {
+		return d
+	}
+	return defaultQueryLogWindow
+}
+
+// queryLogWindowFilter returns the WHERE condition limiting system.query_log to
+// the past window. event_date prunes partitions; event_time narrows to the
+// exact window.
+func queryLogWindowFilter(window time.Duration) string {
+	secs := int64(window / time.Second)
+	return fmt.Sprintf("event_date >= toDate(now() - toIntervalSecond(%d)) AND event_time >= now() - toIntervalSecond(%d)", secs, secs)
+}
+
+// missingQueryLogFilter is the error for a system.query_log query without a
+// time filter.
+func missingQueryLogFilter() error {
+	return invalidQuery(reasonMissingTimeFilter,
+		"queries on system.query_log must filter on event_date or event_time to avoid scanning the whole log; add e.g. WHERE %s",
+		queryLogWindowFilter(queryLogWindow()))
+}
+
+// applyQueryLogTimeFilter enforces clickhouse.query_log_time_filter on a
+// validated query. A structured query on system.query_log whose where doesn't
+// mention event_date or event_time gets the default window added in inject
+// mode and is rejected in reject mode. Free-form SQL can't be rewritten
+// safely, so it is rejected in both modes; see checkQueryLogTimeFilter.
+func applyQueryLogTimeFilter(a queryArgs) (queryArgs, error) {
+	mode := queryLogTimeFilter()
+	if mode == queryLogFilterOff {
+		return a, nil
+	}
+	if strings.TrimSpace(a.SQL) != "" {
+		return a, checkQueryLogTimeFilter(a.SQL)
+	}
+	table := strings.ReplaceAll(strings.TrimSpace(a.Table), "`", "")
+	if !strings.EqualFold(table, "system.query_log") || queryLogTimeColumnRe.MatchString(stripQuotedLiterals(a.Where)) {
+		return a, nil
+	}
+	if mode == queryLogFilterReject {
+		return a, missingQueryLogFilter()
+	}
+	filter := queryLogWindowFilter(queryLogWindow())
+	if strings.TrimSpace(a.Where) == "" {
+		a.Where = filter
+	} else {
+		a.Where = "(" + a.Where + ") AND " + filter
+	}
+	return a, nil
+}
+
+// checkQueryLogTimeFilter rejects free-form sql that reads system.query_log
+// without a WHERE or PREWHERE on event_date or event_time, unless
+// clickhouse.query_log_time_filter is off.
+func checkQueryLogTimeFilter(sql string) error {
+	if queryLogTimeFilter() == queryLogFilterOff {
+		return nil
+	}
+	s := stripQuotedLiterals(normalizeSQL(sql))
+	if !queryLogRefRe.MatchString(s) || queryLogWhereRe.MatchString(s) {
+		return nil
+	}
+	return missingQueryLogFilter()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestApplyQueryLogTimeFilterInject(t *testing.T) {
+	viper.Set("clickhouse.query_log_time_filter", "inject")
+	viper.Set("clickhouse.query_log_window", "1h")
+	defer viper.Set("clickhouse.query_log_time_filter", nil)
+	defer viper.Set("clickhouse.query_log_window", nil)
+
+	filter := "event_date >= toDate(now() - toIntervalSecond(3600)) AND event_time >= now() - toIntervalSecond(3600)"
+	tests := []struct {
+		name      string
+		args      queryArgs
+		wantWhere string
+		wantErr   bool
+	}{
+		{name: "no where", args: queryArgs{Table: "system.query_log"}, wantWhere: filter},
+		{name: "where without time", args: queryArgs{Table: "system.query_log", Where: "type = 'ExceptionWhileProcessing' OR exception_code = 241"},
+			wantWhere: "(type = 'ExceptionWhileProcessing' OR exception_code = 241) AND " + filter},
+		{name: "table case and backticks", args: queryArgs{Table: " `system`.`QUERY_LOG` "}, wantWhere: filter},
+		{name: "event_time filter kept", args: queryArgs{Table: "system.query_log", Where: "event_time > now() - INTERVAL 10 MINUTE"},
+			wantWhere: "event_time > now() - INTERVAL 10 MINUTE"},
+		{name: "event_date filter kept", args: queryArgs{Table: "system.query_log", Where: "event_date = today()"}, wantWhere: "event_date = today()"},
+		{name: "column name in a literal is not a filter", args: queryArgs{Table: "system.query_log", Where: "query LIKE '%event_time%'"},
+			wantWhere: "(query LIKE '%event_time%') AND " + filter},
+		{name: "other table untouched", args: queryArgs{Table: "system.query_thread_log"}, wantWhere: ""},
+		{name: "sql without filter rejected", args: queryArgs{SQL: "SELECT count() FROM system.query_log"}, wantErr: true},
+		{name: "sql with filter", args: queryArgs{SQL: "SELECT count() FROM system.query_log WHERE event_date = today()"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyQueryLogTimeFilter(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyQueryLogTimeFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Where != tt.wantWhere {
+				t.Errorf("applyQueryLogTimeFilter() where = %q, want %q", got.Where, tt.wantWhere)
+			}
+		})
+	}
+}
+
+func TestApplyQueryLogTimeFilterReject(t *testing.T) {
+	viper.Set("clickhouse.query_log_time_filter", "reject")
+	defer viper.Set("clickhouse.query_log_time_filter", nil)
+
+	_, err := applyQueryLogTimeFilter(queryArgs{Table: "system.query_log", Where: "user = 'app'"})
+	var verr *queryValidationError
+	if !errors.As(err, &verr) || verr.Reason != reasonMissingTimeFilter {
+		t.Fatalf("applyQueryLogTimeFilter() error = %v, want reason %s", err, reasonMissingTimeFilter)
+	}
+	// The default window is suggested.
+	want := "queries on system.query_log must filter on event_date or event_time to avoid scanning the whole log; add e.g. WHERE " +
+		"event_date >= toDate(now() - toIntervalSecond(86400)) AND event_time >= now() - toIntervalSecond(86400)"
+	if verr.Message != want {
+		t.Errorf("message = %q, want %q", verr.Message, want)
+	}
+
+	got, err := applyQueryLogTimeFilter(queryArgs{Table: "system.query_log", Where: "event_date = today()"})
+	if err != nil || got.Where != "event_date = today()" {
+		t.Errorf("applyQueryLogTimeFilter(filtered) = %q, %v; want where unchanged", got.Where, err)
+	}
+}
+
+func TestApplyQueryLogTimeFilterOff(t *testing.T) {
+	for _, a := range []queryArgs{{Table: "system.query_log"}, {SQL: "SELECT * FROM system.query_log"}} {
+		got, err := applyQueryLogTimeFilter(a)
+		if err != nil || got.Where != "" {
+			t.Errorf("applyQueryLogTimeFilter(%+v) = %q, %v; want unchanged", a, got.Where, err)
+		}
+	}
+}
+
+func TestCheckQueryLogTimeFilter(t *testing.T) {
+	viper.Set("clickhouse.query_log_time_filter", "reject")
+	defer viper.Set("clickhouse.query_log_time_filter", nil)
+
+	tests := []struct {
+		name    string
+		sql     string
+		wantErr bool
+	}{
+		{name: "no where", sql: "SELECT * FROM system.query_log", wantErr: true},
+		{name: "where without time", sql: "SELECT * FROM system.query_log WHERE query_duration_ms > 1000", wantErr: true},
+		{name: "clusterAllReplicas", sql: "SELECT count() FROM clusterAllReplicas(default, system.query_log)", wantErr: true},
+		{name: "backticks", sql: "SELECT count() FROM `system`.`query_log`", wantErr: true},
+		{name: "column in a literal", sql: "SELECT * FROM system.query_log WHERE query LIKE '%event_date%'", wantErr: true},
+		{name: "event_time", sql: "SELECT * FROM system.query_log WHERE event_time > now() - INTERVAL 1 HOUR"},
+		{name: "event_date", sql: "select * from system.query_log\nwhere\tevent_date = today() and user = 'app'"},
+		{name: "event_time_microseconds", sql: "SELECT * FROM system.query_log WHERE event_time_microseconds > now64() - 60"},
+		{name: "prewhere", sql: "SELECT * FROM system.query_log PREWHERE event_date = today()"},
+		{name: "filter in a CTE", sql: "WITH q AS (SELECT * FROM system.query_log WHERE event_date = today()) SELECT count() FROM q"},
+		{name: "time only in select list", sql: "SELECT max(event_time) FROM system.query_log", wantErr: true},
+		{name: "other table", sql: "SELECT * FROM system.query_views_log"},
+		{name: "query_log in a literal", sql: "SELECT * FROM system.processes WHERE query LIKE '%system.query_log%'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkQueryLogTimeFilter(tt.sql)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkQueryLogTimeFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
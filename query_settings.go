@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/spf13/viper"
+)
+
+// defaultAllowedQuerySettings are the query-level settings clickhouse_query
+// accepts unless clickhouse.allowed_query_settings says otherwise. They only
+// tune resource use and caching; none can lift the read-only guarantees.
+var defaultAllowedQuerySettings = []string{
+	"max_threads",
+	"max_execution_time",
+	"max_memory_usage",
+	"max_rows_to_read",
+	"max_bytes_to_read",
+	"max_result_rows",
+	"max_block_size",
+	"use_query_cache",
+	"query_cache_ttl",
+	"optimize_read_in_order",
+}
+
+// protectedQuerySettings can never be set per query, even if listed in
+// clickhouse.allowed_query_settings, since they control what a query may
+// modify or inspect.
+var protectedQuerySettings = map[string]bool{
+	"readonly":                      true,
+	"allow_ddl":                     true,
+	"allow_introspection_functions": true,
+}
+
+// maxQuerySettingValue bounds the length of a single setting value.
+const maxQuerySettingValue = 256
+
+// validateQuerySettings rejects settings that are not allowed or whose values
+// could not be a plain setting value.
+func validateQuerySettings(settings map[string]string) error {
+	if len(settings) == 0 {
+		return nil
+	}
+	allowed := make(map[string]bool)
+	for _, name := range viper.GetStringSlice("clickhouse.allowed_query_settings") {
+		allowed[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := strings.ToLower(name)
+		if protectedQuerySettings[key] || !allowed[key] {
+			return invalidQuery(reasonSettingNotAllowed, "setting %q is not allowed; allowed settings: %s", name,
+				strings.Join(viper.GetStringSlice("clickhouse.allowed_query_settings"), ", "))
+		}
+		value := settings[name]
+		if len(value) > maxQuerySettingValue || strings.ContainsAny(value, ";\n\r\x00") {
+			return invalidQuery(reasonInvalidSetting, "invalid value for setting %q", name)
+		}
+	}
+	return nil
+}
+
+// capSetting sets name to limit unless settings already hold a lower positive
+// value for it, so per-query settings can tighten a configured scan limit but
+// not lift it. A limit <= 0 leaves settings unchanged.
+func capSetting(settings clickhouse.Settings, name string, limit int64) {
+	if limit <= 0 {
+		return
+	}
+	if s, ok := settings[name].(string); ok {
+		if v, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil && v > 0 && v <= limit {
+			return
+		}
+	}
+	settings[name] = limit
+}
+
+type querySettingsKey struct{}
+
+// withQuerySettings attaches validated per-query settings to ctx for
+// execQuery, which merges them with the settings it adds itself.
+func withQuerySettings(ctx context.Context, settings map[string]string) context.Context {
+	if len(settings) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, querySettingsKey{}, settings)
+}
+
+// querySettingsFrom returns a fresh copy of the settings attached to ctx by
+// withQuerySettings, keyed by lower-case name.
+func querySettingsFrom(ctx context.Context) clickhouse.Settings {
+	out := clickhouse.Settings{}
+	settings, _ := ctx.Value(querySettingsKey{}).(map[string]string)
+	for name, value := range settings {
+		out[strings.ToLower(name)] = value
+	}
+	return out
+}
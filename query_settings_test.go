@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestValidateQuerySettings(t *testing.T) {
+	viper.Set("clickhouse.allowed_query_settings", []string{"max_threads", "use_query_cache", "readonly"})
+	defer viper.Set("clickhouse.allowed_query_settings", nil)
+
+	tests := []struct {
+		name     string
+		settings map[string]string
+		wantErr  bool
+	}{
+		{name: "none", settings: nil},
+		{name: "allowed", settings: map[string]string{"max_threads": "4", "use_query_cache": "1"}},
+		{name: "case insensitive", settings: map[string]string{"Max_Threads": "4"}},
+		{name: "not allowed", settings: map[string]string{"max_insert_threads": "4"}, wantErr: true},
+		{name: "protected even if listed", settings: map[string]string{"readonly": "0"}, wantErr: true},
+		{name: "allow_ddl", settings: map[string]string{"allow_ddl": "1"}, wantErr: true},
+		{name: "value with semicolon", settings: map[string]string{"max_threads": "4; DROP TABLE t"}, wantErr: true},
+		{name: "value with newline", settings: map[string]string{"max_threads": "4\n"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateQuerySettings(tt.settings)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateQuerySettings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQuerySettingsFrom(t *testing.T) {
+	if got := querySettingsFrom(context.Background()); len(got) != 0 {
+		t.Errorf("querySettingsFrom(empty) = %v, want empty", got)
+	}
+
+	ctx := withQuerySettings(context.Background(), map[string]string{"Max_Threads": "4"})
+	got := querySettingsFrom(ctx)
+	if got["max_threads"] != "4" || len(got) != 1 {
+		t.Errorf("querySettingsFrom() = %v, want max_threads=4", got)
+	}
+	// execQuery adds to the returned map; that must not leak into ctx.
+	got["skip_unavailable_shards"] = 1
+	if again := querySettingsFrom(ctx); len(again) != 1 {
+		t.Errorf("querySettingsFrom() shares its map across calls: %v", again)
+	}
+}
+
+func TestCapSetting(t *testing.T) {
+	tests := []struct {
+		name  string
+		given map[string]string
+		limit int64
+		want  interface{}
+	}{
+		{name: "no limit", limit: 0, want: nil},
+		{name: "limit applied", limit: 1000, want: int64(1000)},
+		{name: "lower per-query value kept", given: map[string]string{"max_rows_to_read": "10"}, limit: 1000, want: "10"},
+		{name: "higher per-query value capped", given: map[string]string{"max_rows_to_read": "5000"}, limit: 1000, want: int64(1000)},
+		{name: "zero per-query value capped", given: map[string]string{"max_rows_to_read": "0"}, limit: 1000, want: int64(1000)},
+		{name: "unparsable value capped", given: map[string]string{"max_rows_to_read": "lots"}, limit: 1000, want: int64(1000)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := querySettingsFrom(withQuerySettings(context.Background(), tt.given))
+			capSetting(settings, "max_rows_to_read", tt.limit)
+			if got := settings["max_rows_to_read"]; got != tt.want {
+				t.Errorf("max_rows_to_read = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// The scan limits are sent as driver settings, which a SETTINGS clause in the
+// query itself would override, so free-form sql must not carry one.
+func TestScanLimitsNotLiftedInline(t *testing.T) {
+	viper.Set("clickhouse.allowed_databases", []string{"system"})
+	viper.Set("clickhouse.max_rows_to_read", 1000)
+	viper.Set("clickhouse.max_bytes_to_read", 1<<20)
+	defer viper.Set("clickhouse.allowed_databases", nil)
+	defer viper.Set("clickhouse.max_rows_to_read", nil)
+	defer viper.Set("clickhouse.max_bytes_to_read", nil)
+
+	for _, sql := range []string{
+		"SELECT * FROM system.parts SETTINGS max_rows_to_read = 0, max_bytes_to_read = 0",
+		"SELECT * FROM system.parts\nsettings\tmax_rows_to_read=0",
+		"WITH p AS (SELECT * FROM system.parts SETTINGS max_bytes_to_read = 0) SELECT count() FROM p",
+		`SELECT * FROM system.parts SETTINGS "max_rows_to_read" = 0`,
+		"SELECT * FROM system.parts SETTINGS `max_rows_to_read` = 0, `max_bytes_to_read` = 0",
+	} {
+		if err := validateQueryArgs(queryArgs{SQL: sql}); err == nil {
+			t.Errorf("validateQueryArgs(%q) = nil, want the SETTINGS clause rejected", sql)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// Reason codes of queryValidationError. They are part of the tool contract:
+// clients may branch on them, so don't rename existing ones.
+const (
+	reasonMissingTable       = "missing_table"
+	reasonTableNotAllowed    = "table_not_allowed"
+	reasonInvalidIdentifier  = "invalid_identifier"
+	reasonInvalidClause      = "invalid_clause"
+	reasonInvalidLimit       = "invalid_limit"
+	reasonEmptyQuery         = "empty_query"
+	reasonQueryTooLong       = "query_too_long"
+	reasonQueryTooNested     = "query_too_nested"
+	reasonMultipleStatements = "multiple_statements"
+	reasonNotSelect          = "not_select"
+	reasonForbiddenKeyword   = "forbidden_keyword"
+	reasonInvalidSubquery    = "invalid_subquery"
+	reasonSettingNotAllowed  = "setting_not_allowed"
+	reasonInvalidSetting     = "invalid_setting_value"
+	reasonSQLDisabled        = "sql_disabled"
+	reasonMissingTimeFilter  = "missing_time_filter"
+)
+
+// queryValidationError is a query rejected before it was sent to ClickHouse.
+// Reason is a stable code; AllowedDatabases is set when the query referenced
+// a table outside them.
+type queryValidationError struct {
+	Reason           string   `json:"reason"`
+	Message          string   `json:"message"`
+	AllowedDatabases []string `json:"allowed_databases,omitempty"`
+}
+
+func (e *queryValidationError) Error() string { return e.Message }
+
+func invalidQuery(reason, format string, args ...interface{}) error {
+	return &queryValidationError{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// tableNotAllowed reports a table outside the allowed databases. The allowed
+// databases are passed to format after args.
+func tableNotAllowed(format string, args ...interface{}) error {
+	allowed := getAllowedDatabases()
+	return &queryValidationError{
+		Reason:           reasonTableNotAllowed,
+		Message:          fmt.Sprintf(format, append(args, allowed)...),
+		AllowedDatabases: allowed,
+	}
+}
+
+// validationErrorResult turns a validation failure into an error result whose
+// text is the JSON-encoded queryValidationError, so the model can read the
+// reason code and allowed databases and correct its call. Other errors are
+// returned unchanged.
+func validationErrorResult[Out any](err error) (*mcp.CallToolResultFor[Out], error) {
+	var verr *queryValidationError
+	if !errors.As(err, &verr) {
+		return nil, err
+	}
+	body, merr := json.Marshal(map[string]*queryValidationError{"error": verr})
+	if merr != nil {
+		return nil, err
+	}
+	return &mcp.CallToolResultFor[Out]{
+		Content: []mcp.Content{&mcp.TextContent{Text: string(body)}},
+		IsError: true,
+	}, nil
+}
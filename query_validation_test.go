@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/spf13/viper"
+)
+
+func TestValidationReasons(t *testing.T) {
+	viper.Set("clickhouse.allowed_databases", []string{"system", "models"})
+	defer viper.Set("clickhouse.allowed_databases", nil)
+	viper.Set("clickhouse.max_sql_length", 200)
+	defer viper.Set("clickhouse.max_sql_length", nil)
+	viper.Set("clickhouse.allowed_query_settings", []string{"max_threads"})
+	defer viper.Set("clickhouse.allowed_query_settings", nil)
+
+	tests := []struct {
+		name string
+		args queryArgs
+		want string
+	}{
+		{name: "missing table", args: queryArgs{}, want: reasonMissingTable},
+		{name: "table outside allowed databases", args: queryArgs{Table: "secret.users"}, want: reasonTableNotAllowed},
+		{name: "invalid table name", args: queryArgs{Table: "system.parts;x"}, want: reasonInvalidIdentifier},
+		{name: "invalid column", args: queryArgs{Table: "system.parts", Columns: []string{""}}, want: reasonInvalidIdentifier},
+		{name: "invalid clause", args: queryArgs{Table: "system.parts", Where: "1; DROP TABLE x"}, want: reasonInvalidClause},
+		{name: "negative limit", args: queryArgs{Table: "system.parts", Limit: -1}, want: reasonInvalidLimit},
+		{name: "sql too long", args: queryArgs{SQL: "SELECT " + strings.Repeat("1 + ", 60) + "1"}, want: reasonQueryTooLong},
+		{name: "sql too nested", args: queryArgs{SQL: "SELECT " + strings.Repeat("(", 65) + "1" + strings.Repeat(")", 65)}, want: reasonQueryTooNested},
+		{name: "multiple statements", args: queryArgs{SQL: "SELECT 1; SELECT 2"}, want: reasonMultipleStatements},
+		{name: "not a select", args: queryArgs{SQL: "SHOW TABLES"}, want: reasonNotSelect},
+		{name: "forbidden keyword", args: queryArgs{SQL: "SELECT * FROM system.parts WHERE 1 = (kill)"}, want: reasonForbiddenKeyword},
+		{name: "non-query subquery", args: queryArgs{SQL: "SELECT * FROM (1, 2)"}, want: reasonInvalidSubquery},
+		{name: "sql table outside allowed databases", args: queryArgs{SQL: "SELECT * FROM secret.users"}, want: reasonTableNotAllowed},
+		{name: "setting not allowed", args: queryArgs{Table: "system.parts", Settings: map[string]string{"readonly": "0"}}, want: reasonSettingNotAllowed},
+		{name: "inline settings clause", args: queryArgs{SQL: "SELECT * FROM system.parts SETTINGS readonly = 0"}, want: reasonSettingNotAllowed},
+		{name: "invalid setting value", args: queryArgs{Table: "system.parts", Settings: map[string]string{"max_threads": "1;"}}, want: reasonInvalidSetting},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateQueryArgs(tt.args)
+			var verr *queryValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("validateQueryArgs() error = %v, want a queryValidationError", err)
+			}
+			if verr.Reason != tt.want {
+				t.Errorf("reason = %q, want %q (%v)", verr.Reason, tt.want, err)
+			}
+			if (verr.Reason == reasonTableNotAllowed) != (len(verr.AllowedDatabases) > 0) {
+				t.Errorf("allowed_databases = %v for reason %q", verr.AllowedDatabases, verr.Reason)
+			}
+		})
+	}
+}
+
+func TestValidationErrorResult(t *testing.T) {
+	viper.Set("clickhouse.allowed_databases", []string{"system", "models"})
+	defer viper.Set("clickhouse.allowed_databases", nil)
+
+	res, err := validationErrorResult[*queryResult](validateQueryArgs(queryArgs{Table: "secret.users"}))
+	if err != nil {
+		t.Fatalf("validationErrorResult() error = %v", err)
+	}
+	if !res.IsError || len(res.Content) != 1 {
+		t.Fatalf("result = %+v, want one error content", res)
+	}
+	var body struct {
+		Error queryValidationError `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(res.Content[0].(*mcp.TextContent).Text), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Reason != reasonTableNotAllowed || !equalSlices(body.Error.AllowedDatabases, []string{"system", "models"}) {
+		t.Errorf("error = %+v", body.Error)
+	}
+
+	other := errors.New("connection refused")
+	if _, err := validationErrorResult[*queryResult](other); err != other {
+		t.Errorf("non-validation error = %v, want it returned unchanged", err)
+	}
+}
+
+func TestAllowFreeformSQL(t *testing.T) {
+	defer viper.Set("clickhouse.allow_freeform_sql", nil)
+
+	tests := []struct {
+		name  string
+		allow bool
+		args  queryArgs
+		want  string // reason; "" = valid
+	}{
+		{name: "sql allowed", allow: true, args: queryArgs{SQL: "SELECT * FROM system.parts"}},
+		{name: "sql disabled", allow: false, args: queryArgs{SQL: "SELECT * FROM system.parts"}, want: reasonSQLDisabled},
+		{name: "structured query with sql disabled", allow: false, args: queryArgs{Table: "system.parts"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("clickhouse.allow_freeform_sql", tt.allow)
+			err := validateQueryArgs(tt.args)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("validateQueryArgs() error = %v", err)
+				}
+				return
+			}
+			var verr *queryValidationError
+			if !errors.As(err, &verr) || verr.Reason != tt.want {
+				t.Fatalf("validateQueryArgs() error = %v, want reason %q", err, tt.want)
+			}
+			if !strings.Contains(verr.Message, "structured") {
+				t.Errorf("message %q should point to the structured fields", verr.Message)
+			}
+		})
+	}
+}
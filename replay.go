@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// parseToolCall reads a recorded tool call: either tools/call params
+// ({"name": ..., "arguments": {...}}) or the whole JSON-RPC request carrying
+// them in "params".
+func parseToolCall(data []byte) (*mcp.CallToolParams, error) {
+	var envelope struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("parsing tool call: %w", err)
+	}
+	if len(envelope.Params) > 0 {
+		if envelope.Method != "" && envelope.Method != "tools/call" {
+			return nil, fmt.Errorf("not a tools/call request: method %q", envelope.Method)
+		}
+		data = envelope.Params
+	}
+	var params mcp.CallToolParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, fmt.Errorf("parsing tool call: %w", err)
+	}
+	if params.Name == "" {
+		return nil, fmt.Errorf("tool call has no name")
+	}
+	return &params, nil
+}
+
+// replayToolCall runs call against srv over an in-memory MCP session, so it
+// goes through the same argument validation and handler as a client's call,
+// and writes the raw result to w as JSON. A result flagged as an error is
+// written and also returned as an error.
+func replayToolCall(ctx context.Context, srv *mcp.Server, call *mcp.CallToolParams, w io.Writer) error {
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	ss, err := srv.Connect(ctx, serverTransport)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = ss.Close() }()
+	cs, err := mcp.NewClient(&mcp.Implementation{Name: "housekeeper-replay", Version: serverImpl.Version}, nil).Connect(ctx, clientTransport)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cs.Close() }()
+
+	res, err := cs.CallTool(ctx, call)
+	if err != nil {
+		return fmt.Errorf("calling %s: %w", call.Name, err)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(res); err != nil {
+		return err
+	}
+	if res.IsError {
+		return fmt.Errorf("%s returned an error result", call.Name)
+	}
+	return nil
+}
+
+// runReplay replays the tool call recorded in path ("-" for stdin) against
+// the tools this configuration would serve and prints the result to stdout.
+func runReplay(ctx context.Context, path string) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	call, err := parseToolCall(data)
+	if err != nil {
+		return err
+	}
+	srv, err := buildMCPServer()
+	if err != nil {
+		return err
+	}
+	return replayToolCall(ctx, srv, call, os.Stdout)
+}
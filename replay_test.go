@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestParseToolCall(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantName string
+		wantErr  bool
+	}{
+		{name: "params", data: `{"name": "clickhouse_query", "arguments": {"table": "system.parts"}}`, wantName: "clickhouse_query"},
+		{name: "json-rpc request", data: `{"jsonrpc": "2.0", "id": 3, "method": "tools/call", "params": {"name": "clickhouse_watch", "arguments": {}}}`, wantName: "clickhouse_watch"},
+		{name: "other method", data: `{"jsonrpc": "2.0", "method": "tools/list", "params": {"name": "x"}}`, wantErr: true},
+		{name: "no name", data: `{"arguments": {}}`, wantErr: true},
+		{name: "not json", data: `name=clickhouse_query`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call, err := parseToolCall([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseToolCall() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && call.Name != tt.wantName {
+				t.Errorf("parseToolCall() name = %q, want %q", call.Name, tt.wantName)
+			}
+		})
+	}
+}
+
+type echoArgs struct {
+	Text string `json:"text"`
+}
+
+func TestReplayToolCall(t *testing.T) {
+	catalog := toolCatalog
+	defer func() { toolCatalog = catalog }()
+
+	srv := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0.0.0"}, &mcp.ServerOptions{})
+	addTool[echoArgs, any](srv, &mcp.Tool{Name: "echo"}, func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[echoArgs]) (*mcp.CallToolResultFor[any], error) {
+		if req.Arguments.Text == "" {
+			return nil, fmt.Errorf("text is required")
+		}
+		return &mcp.CallToolResultFor[any]{Content: []mcp.Content{&mcp.TextContent{Text: "echo: " + req.Arguments.Text}}}, nil
+	})
+
+	var out bytes.Buffer
+	call, _ := parseToolCall([]byte(`{"name": "echo", "arguments": {"text": "hi"}}`))
+	if err := replayToolCall(context.Background(), srv, call, &out); err != nil {
+		t.Fatalf("replayToolCall() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "echo: hi") {
+		t.Errorf("replayToolCall() output = %s, want the tool's text", out.String())
+	}
+
+	out.Reset()
+	call, _ = parseToolCall([]byte(`{"name": "echo", "arguments": {}}`))
+	if err := replayToolCall(context.Background(), srv, call, &out); err == nil {
+		t.Error("replayToolCall() error = nil for a call the handler rejects")
+	}
+
+	call, _ = parseToolCall([]byte(`{"name": "missing", "arguments": {}}`))
+	if err := replayToolCall(context.Background(), srv, call, &out); err == nil {
+		t.Error("replayToolCall() error = nil for an unknown tool")
+	}
+}
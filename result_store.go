@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// resultURIPrefix is the URI scheme and path of stored query results; the
+// result id follows it.
+const resultURIPrefix = "housekeeper://results/"
+
+// resultPreviewRows is how many rows stay inline when a result is stored.
+const resultPreviewRows = 20
+
+// resultStore keeps oversized query results in memory for a limited time so
+// clients can read them as MCP resources. Entries expire after ttl, and the
+// oldest are evicted to keep the total size within maxBytes.
+type resultStore struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	ttl      time.Duration
+	maxBytes int
+	total    int
+	order    []string
+	entries  map[string]*storedResult
+}
+
+type storedResult struct {
+	data    []byte
+	expires time.Time
+}
+
+func newResultStore(ttl time.Duration, maxBytes int) *resultStore {
+	return &resultStore{now: time.Now, ttl: ttl, maxBytes: maxBytes, entries: make(map[string]*storedResult)}
+}
+
+// put stores data and returns its id, or false if data alone exceeds the
+// store's capacity.
+func (s *resultStore) put(data []byte) (string, bool) {
+	if len(data) > s.maxBytes {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for len(s.order) > 0 {
+		oldest := s.entries[s.order[0]]
+		if !now.After(oldest.expires) && s.total+len(data) <= s.maxBytes {
+			break
+		}
+		s.total -= len(oldest.data)
+		delete(s.entries, s.order[0])
+		s.order = s.order[1:]
+	}
+
+	id := uuid.NewString()
+	s.entries[id] = &storedResult{data: data, expires: now.Add(s.ttl)}
+	s.order = append(s.order, id)
+	s.total += len(data)
+	return id, true
+}
+
+// get returns the data stored under id if it hasn't expired.
+func (s *resultStore) get(id string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.entries[id]
+	if !ok || s.now().After(r.expires) {
+		return nil, false
+	}
+	return r.data, true
+}
+
+// largeResults is set by registerResultResources when
+// mcp.large_results.threshold_bytes is positive.
+var largeResults *resultStore
+
+// registerResultResources enables storing oversized clickhouse_query results
+// and serves them under resultURIPrefix.
+func registerResultResources(srv *mcp.Server) {
+	largeResults = newResultStore(viper.GetDuration("mcp.large_results.ttl"), viper.GetInt("mcp.large_results.max_bytes"))
+	srv.AddResourceTemplate(&mcp.ResourceTemplate{
+		Name:        "query-results",
+		Title:       "Stored query results",
+		Description: "Full rows of a clickhouse_query result that was too large to return inline. Links are returned by clickhouse_query and expire.",
+		URITemplate: resultURIPrefix + "{id}",
+		MIMEType:    "application/json",
+	}, readResultResource)
+}
+
+func readResultResource(ctx context.Context, ss *mcp.ServerSession, params *mcp.ReadResourceParams) (*mcp.ReadResourceResult, error) {
+	id := strings.TrimPrefix(params.URI, resultURIPrefix)
+	if largeResults == nil {
+		return nil, mcp.ResourceNotFoundError(params.URI)
+	}
+	data, ok := largeResults.get(id)
+	if !ok {
+		return nil, mcp.ResourceNotFoundError(params.URI)
+	}
+	return &mcp.ReadResourceResult{
+		Contents: []*mcp.ResourceContents{{URI: params.URI, MIMEType: "application/json", Text: string(data)}},
+	}, nil
+}
+
+// offloadLargeResult stores res's rows when their JSON encoding exceeds
+// mcp.large_results.threshold_bytes, trims res.Results to a preview and sets
+// res.ResultURI. It returns a link to the stored rows, or nil when the result
+// is kept inline.
+func offloadLargeResult(res *queryResult) *mcp.ResourceLink {
+	threshold := viper.GetInt("mcp.large_results.threshold_bytes")
+	if largeResults == nil || threshold <= 0 || len(res.Results) <= resultPreviewRows {
+		return nil
+	}
+	data, err := json.Marshal(res.Results)
+	if err != nil || len(data) <= threshold {
+		return nil
+	}
+	id, ok := largeResults.put(data)
+	if !ok {
+		logrus.WithField("bytes", len(data)).Warn("Query result exceeds mcp.large_results.max_bytes; returning it inline")
+		return nil
+	}
+
+	res.ResultURI = resultURIPrefix + id
+	res.Results = res.Results[:resultPreviewRows]
+	size := int64(len(data))
+	return &mcp.ResourceLink{
+		URI:         res.ResultURI,
+		Name:        "query-results-" + id,
+		Title:       "Full query results",
+		Description: "All rows of the query as a JSON array",
+		MIMEType:    "application/json",
+		Size:        &size,
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/spf13/viper"
+)
+
+func TestResultStore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newResultStore(time.Minute, 10)
+	s.now = func() time.Time { return now }
+
+	a, ok := s.put([]byte("aaaa"))
+	if !ok {
+		t.Fatal("put(a) rejected")
+	}
+	b, _ := s.put([]byte("bbbb"))
+	if _, ok := s.put([]byte("this is too large")); ok {
+		t.Error("put() accepted data larger than the store")
+	}
+
+	// Adding c exceeds 10 bytes, so the oldest entry (a) is evicted.
+	c, _ := s.put([]byte("cccc"))
+	if _, ok := s.get(a); ok {
+		t.Error("oldest entry not evicted when over capacity")
+	}
+	if got, ok := s.get(b); !ok || string(got) != "bbbb" {
+		t.Errorf("get(b) = %q, %v", got, ok)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := s.get(c); ok {
+		t.Error("expired entry still readable")
+	}
+	if _, ok := s.put([]byte("dddd")); !ok || s.total != 4 {
+		t.Errorf("expired entries not dropped on put: total = %d", s.total)
+	}
+}
+
+func TestOffloadLargeResult(t *testing.T) {
+	defer func() { largeResults = nil }()
+	defer viper.Set("mcp.large_results.threshold_bytes", nil)
+	largeResults = newResultStore(time.Minute, 1<<20)
+
+	rows := make([]map[string]interface{}, 100)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"n": i, "name": fmt.Sprintf("row-%d", i)}
+	}
+
+	viper.Set("mcp.large_results.threshold_bytes", 1<<20)
+	res := &queryResult{Results: rows, Count: len(rows)}
+	if link := offloadLargeResult(res); link != nil || len(res.Results) != 100 {
+		t.Fatalf("small result was offloaded: link %v, %d rows", link, len(res.Results))
+	}
+
+	viper.Set("mcp.large_results.threshold_bytes", 100)
+	res = &queryResult{Results: rows, Count: len(rows)}
+	link := offloadLargeResult(res)
+	if link == nil {
+		t.Fatal("large result was not offloaded")
+	}
+	if len(res.Results) != resultPreviewRows || res.Count != 100 || res.ResultURI != link.URI {
+		t.Errorf("offloaded result = %d rows, count %d, uri %q, link %q", len(res.Results), res.Count, res.ResultURI, link.URI)
+	}
+
+	read, err := readResultResource(context.Background(), nil, &mcp.ReadResourceParams{URI: link.URI})
+	if err != nil {
+		t.Fatalf("readResultResource() error = %v", err)
+	}
+	var all []map[string]interface{}
+	if err := json.Unmarshal([]byte(read.Contents[0].Text), &all); err != nil || len(all) != 100 {
+		t.Errorf("resource contents decoded to %d rows (err %v), want 100", len(all), err)
+	}
+
+	if _, err := readResultResource(context.Background(), nil, &mcp.ReadResourceParams{URI: resultURIPrefix + "missing"}); err == nil {
+		t.Error("readResultResource() of unknown id succeeded")
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rowFormatter renders query rows for a text summary. summarizeRows uses the
+// first formatter in rowFormatters whose match accepts the rows.
+type rowFormatter struct {
+	name   string
+	match  func(rows []map[string]interface{}) bool
+	format func(rows []map[string]interface{}, loc *time.Location) string
+}
+
+// rowFormatters are tried in order; new shapes are supported by adding an
+// entry ahead of the general ones. Rows are never empty when matched.
+var rowFormatters = []rowFormatter{
+	{name: "time_series", match: isTimeSeries, format: formatTimeSeries},
+	{name: "single_value", match: isSingleValue, format: formatSingleValue},
+	{name: "few_rows", match: func(rows []map[string]interface{}) bool { return len(rows) <= maxListedRows }, format: formatRows},
+	{name: "preview", match: func([]map[string]interface{}) bool { return true }, format: formatPreview},
+}
+
+const (
+	// maxListedRows is the most rows summaries print one per line.
+	maxListedRows = 5
+	// maxSparklineWidth bounds the characters in a sparkline; longer series
+	// are averaged into this many buckets.
+	maxSparklineWidth = 40
+)
+
+// formatRows prints each row on its own line as key=value pairs.
+func formatRows(rows []map[string]interface{}, loc *time.Location) string {
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		lines[i] = formatRow(row, loc)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatPreview prints the row count and the first row.
+func formatPreview(rows []map[string]interface{}, loc *time.Location) string {
+	return fmt.Sprintf("rows: %d\nfirst: %s", len(rows), formatRow(rows[0], loc))
+}
+
+// isSingleValue reports whether rows are one row of one numeric column, such
+// as the result of SELECT count().
+func isSingleValue(rows []map[string]interface{}) bool {
+	if len(rows) != 1 || len(rows[0]) != 1 {
+		return false
+	}
+	for _, v := range rows[0] {
+		_, ok := rowNumber(v)
+		return ok
+	}
+	return false
+}
+
+// formatSingleValue prints the value on its own, as "column = value", with
+// units for durations and bytes and thousands separators for plain numbers.
+func formatSingleValue(rows []map[string]interface{}, loc *time.Location) string {
+	for col, v := range rows[0] {
+		n, _ := rowNumber(v)
+		lkey := strings.ToLower(col)
+		s := prettyNumericWithUnits(lkey, n)
+		if s == trimFloat(n) {
+			s = groupThousands(s)
+		}
+		return fmt.Sprintf("%s = %s", col, s)
+	}
+	return ""
+}
+
+// groupThousands inserts commas between groups of three digits in the integer
+// part of the decimal number s.
+func groupThousands(s string) string {
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	intPart, frac, hasFrac := strings.Cut(s, ".")
+	if strings.ContainsAny(intPart, "e+") || len(intPart) <= 3 {
+		return sign + s
+	}
+	var b strings.Builder
+	for i, d := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	if hasFrac {
+		b.WriteString("." + frac)
+	}
+	return sign + b.String()
+}
+
+// timeSeriesColumns returns the time column and the numeric columns of rows
+// when they form a time series: more rows than are listed, one column holding
+// timestamps in non-decreasing order and every other column numeric in every
+// row. ok is false otherwise.
+func timeSeriesColumns(rows []map[string]interface{}) (timeCol string, valueCols []string, ok bool) {
+	if len(rows) <= maxListedRows {
+		return "", nil, false
+	}
+	for col := range rows[0] {
+		if _, isTime := rowTime(rows[0][col]); isTime {
+			if timeCol != "" {
+				return "", nil, false
+			}
+			timeCol = col
+			continue
+		}
+		valueCols = append(valueCols, col)
+	}
+	if timeCol == "" || len(valueCols) == 0 {
+		return "", nil, false
+	}
+	sort.Strings(valueCols)
+	var prev time.Time
+	for i, row := range rows {
+		if len(row) != len(rows[0]) {
+			return "", nil, false
+		}
+		t, isTime := rowTime(row[timeCol])
+		if !isTime || (i > 0 && t.Before(prev)) {
+			return "", nil, false
+		}
+		prev = t
+		for _, col := range valueCols {
+			if _, isNum := rowNumber(row[col]); !isNum {
+				return "", nil, false
+			}
+		}
+	}
+	return timeCol, valueCols, true
+}
+
+func isTimeSeries(rows []map[string]interface{}) bool {
+	_, _, ok := timeSeriesColumns(rows)
+	return ok
+}
+
+// formatTimeSeries prints the time range, then one sparkline per numeric
+// column with its minimum, maximum and last value.
+func formatTimeSeries(rows []map[string]interface{}, loc *time.Location) string {
+	timeCol, valueCols, _ := timeSeriesColumns(rows)
+	var b strings.Builder
+	fmt.Fprintf(&b, "rows: %d, %s from %s to %s", len(rows), timeCol,
+		prettyValue(timeCol, rows[0][timeCol], loc), prettyValue(timeCol, rows[len(rows)-1][timeCol], loc))
+	for _, col := range valueCols {
+		values := make([]float64, len(rows))
+		for i, row := range rows {
+			values[i], _ = rowNumber(row[col])
+		}
+		lo, hi := values[0], values[0]
+		for _, v := range values {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+		lkey := strings.ToLower(col)
+		fmt.Fprintf(&b, "\n%s: %s min %s, max %s, last %s", col, sparkline(values, maxSparklineWidth),
+			prettyNumericWithUnits(lkey, lo), prettyNumericWithUnits(lkey, hi), prettyNumericWithUnits(lkey, values[len(values)-1]))
+	}
+	return b.String()
+}
+
+// sparkBlocks are the sparkline levels, lowest first.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders values as block characters scaled between their minimum
+// and maximum, averaging consecutive values so it is at most width long.
+func sparkline(values []float64, width int) string {
+	if len(values) > width {
+		buckets := make([]float64, width)
+		for i := range buckets {
+			start, end := i*len(values)/width, (i+1)*len(values)/width
+			sum := 0.0
+			for _, v := range values[start:end] {
+				sum += v
+			}
+			buckets[i] = sum / float64(end-start)
+		}
+		values = buckets
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	out := make([]rune, len(values))
+	for i, v := range values {
+		level := 0
+		if hi > lo {
+			level = int((v - lo) / (hi - lo) * float64(len(sparkBlocks)-1))
+		}
+		out[i] = sparkBlocks[level]
+	}
+	return string(out)
+}
+
+// rowTime returns v as a time if it is a timestamp or a date as rendered by
+// normalizeColumnValue.
+func rowTime(v interface{}) (time.Time, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, s)
+	}
+	return t, err == nil
+}
+
+// rowNumber returns v as a float64 if it is a finite number as produced by
+// normalizeValue (or an int, as in hand-built rows).
+func rowNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, !math.IsNaN(n) && !math.IsInf(n, 0)
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seriesRows returns n rows of a minute-by-minute series with value i*i.
+func seriesRows(n int) []map[string]interface{} {
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	rows := make([]map[string]interface{}, n)
+	for i := range rows {
+		rows[i] = map[string]interface{}{
+			"t":          start.Add(time.Duration(i) * time.Minute).Format(time.RFC3339Nano),
+			"value":      int64(i * i),
+			"read_bytes": uint64(1024 * (i + 1)),
+		}
+	}
+	return rows
+}
+
+func TestRowFormatterSelection(t *testing.T) {
+	many := make([]map[string]interface{}, 8)
+	for i := range many {
+		many[i] = map[string]interface{}{"host": fmt.Sprintf("ch%d", i), "n": int64(i)}
+	}
+	unordered := seriesRows(8)
+	unordered[3], unordered[4] = unordered[4], unordered[3]
+	daily := seriesRows(8)
+	for i, row := range daily {
+		row["t"] = fmt.Sprintf("2026-10-%02d", i+1)
+	}
+	mixed := seriesRows(8)
+	for _, row := range mixed {
+		row["host"] = "ch1"
+	}
+
+	tests := []struct {
+		name string
+		rows []map[string]interface{}
+		want string
+	}{
+		{name: "series", rows: seriesRows(8), want: "time_series"},
+		{name: "daily series", rows: daily, want: "time_series"},
+		{name: "short series is listed", rows: seriesRows(3), want: "few_rows"},
+		{name: "out of order", rows: unordered, want: "preview"},
+		{name: "series with a string column", rows: mixed, want: "preview"},
+		{name: "single value", rows: []map[string]interface{}{{"n": int64(1)}}, want: "single_value"},
+		{name: "single string value", rows: []map[string]interface{}{{"version": "24.8.1"}}, want: "few_rows"},
+		{name: "single row", rows: []map[string]interface{}{{"host": "ch1", "n": int64(1)}}, want: "few_rows"},
+		{name: "many rows", rows: many, want: "preview"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, f := range rowFormatters {
+				if f.match(tt.rows) {
+					if f.name != tt.want {
+						t.Errorf("matched %s, want %s", f.name, tt.want)
+					}
+					return
+				}
+			}
+			t.Errorf("no formatter matched")
+		})
+	}
+}
+
+func TestFormatTimeSeries(t *testing.T) {
+	got := formatTimeSeries(seriesRows(8), nil)
+	want := "rows: 8, t from 2026-10-15T12:00:00Z to 2026-10-15T12:07:00Z" +
+		"\nread_bytes: ▁▂▃▄▅▆▇█ min 1.00 KB, max 8.00 KB, last 8.00 KB" +
+		"\nvalue: ▁▁▁▂▃▄▆█ min 0, max 49, last 49"
+	if got != want {
+		t.Errorf("formatTimeSeries() =\n%s\nwant\n%s", got, want)
+	}
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatTimeSeries(seriesRows(8), loc); !strings.HasPrefix(got, "rows: 8, t from 2026-10-15 08:00:00 EDT to 2026-10-15 08:07:00 EDT") {
+		t.Errorf("formatTimeSeries() with timezone = %q", got)
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		width  int
+		want   string
+	}{
+		{name: "rising", values: []float64{0, 1, 2, 3, 4, 5, 6, 7}, width: 40, want: "▁▂▃▄▅▆▇█"},
+		{name: "flat", values: []float64{3, 3, 3}, width: 40, want: "▁▁▁"},
+		{name: "downsampled", values: []float64{0, 0, 7, 7}, width: 2, want: "▁█"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sparkline(tt.values, tt.width); got != tt.want {
+				t.Errorf("sparkline() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if got := sparkline(make([]float64, 1000), maxSparklineWidth); len([]rune(got)) != maxSparklineWidth {
+		t.Errorf("sparkline() of 1000 values has %d characters", len([]rune(got)))
+	}
+}
+
+func TestFormatRowsAndPreview(t *testing.T) {
+	rows := []map[string]interface{}{{"host": "ch1", "n": int64(1)}, {"host": "ch2", "n": int64(2)}}
+	if got := formatRows(rows, nil); got != "host=ch1 n=1\nhost=ch2 n=2" {
+		t.Errorf("formatRows() = %q", got)
+	}
+	if got := formatPreview(rows, nil); got != "rows: 2\nfirst: host=ch1 n=1" {
+		t.Errorf("formatPreview() = %q", got)
+	}
+}
+
+func TestFormatSingleValue(t *testing.T) {
+	tests := []struct {
+		row  map[string]interface{}
+		want string
+	}{
+		{row: map[string]interface{}{"count()": uint64(1234567)}, want: "count() = 1,234,567"},
+		{row: map[string]interface{}{"n": int64(-12345)}, want: "n = -12,345"},
+		{row: map[string]interface{}{"n": int64(999)}, want: "n = 999"},
+		{row: map[string]interface{}{"ratio": 1234.5}, want: "ratio = 1,234.5"},
+		{row: map[string]interface{}{"read_bytes": uint64(3 << 30)}, want: "read_bytes = 3.00 GB"},
+		{row: map[string]interface{}{"elapsed_seconds": 2.5}, want: "elapsed_seconds = 2.5s"},
+	}
+	for _, tt := range tests {
+		rows := []map[string]interface{}{tt.row}
+		if !isSingleValue(rows) {
+			t.Errorf("isSingleValue(%v) = false", tt.row)
+			continue
+		}
+		if got := formatSingleValue(rows, nil); got != tt.want {
+			t.Errorf("formatSingleValue() = %q, want %q", got, tt.want)
+		}
+	}
+}
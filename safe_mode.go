@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Limits enforced on clickhouse_query in safe mode, whatever the config says.
+const (
+	safeModeMaxRows        = 1000
+	safeModeMaxRowsToRead  = 100_000_000
+	safeModeMaxBytesToRead = 10 << 30
+)
+
+// safeMode is set by --safe-mode. It restricts the MCP server to a
+// structured-only clickhouse_query over system tables, for hosts whose
+// clients aren't trusted with free-form SQL.
+var safeMode bool
+
+// applySafeMode turns on safe mode and overrides the config it depends on:
+// only clickhouse_query is registered, only the system database is readable,
+// the admin API and per-query settings are off, and the read limits are
+// capped. Call it after loadConfig.
+func applySafeMode() {
+	safeMode = true
+	viper.Set("mcp.enabled_tools", []string{"clickhouse_query"})
+	viper.Set("mcp.analysis_tools", false)
+	viper.Set("mcp.large_results.threshold_bytes", 0)
+	viper.Set("bedrock.region", "")
+	viper.Set("http.admin_token", "")
+	viper.Set("clickhouse.allowed_databases", []string{"system"})
+	viper.Set("clickhouse.allowed_query_settings", []string{})
+	viper.Set("clickhouse.allow_freeform_sql", false)
+	capConfigInt("clickhouse.max_rows_to_read", safeModeMaxRowsToRead)
+	capConfigInt("clickhouse.max_bytes_to_read", safeModeMaxBytesToRead)
+	logrus.WithFields(logrus.Fields{
+		"tools":     "clickhouse_query",
+		"databases": "system",
+		"max_rows":  safeModeMaxRows,
+	}).Info("Safe mode: free-form SQL, settings and all other tools are disabled")
+}
+
+// capConfigInt lowers key to max when it is unset (0) or larger.
+func capConfigInt(key string, max int64) {
+	if v := viper.GetInt64(key); v <= 0 || v > max {
+		viper.Set(key, max)
+	}
+}
+
+// validateSafeModeArgs applies the safe mode restrictions on top of
+// validateQueryArgs: no settings, no subqueries in the structured
+// clauses and at most safeModeMaxRows rows.
+func validateSafeModeArgs(a queryArgs) error {
+	if len(a.Settings) > 0 {
+		return invalidQuery(reasonSettingNotAllowed, "settings are disabled in safe mode")
+	}
+	clauses := append([]string{a.Where, a.OrderBy}, a.Columns...)
+	for _, c := range clauses {
+		if containsWord(strings.ToLower(stripQuotedLiterals(c)), "select") {
+			return invalidQuery(reasonInvalidClause, "subqueries are not allowed in safe mode")
+		}
+	}
+	if a.Limit > safeModeMaxRows {
+		return invalidQuery(reasonInvalidLimit, "limit must be at most %d in safe mode", safeModeMaxRows)
+	}
+	return nil
+}
+
+// containsWord reports whether word appears in lower as a whole identifier
+// token.
+func containsWord(lower, word string) bool {
+	for i := 0; i < len(lower); {
+		if !isIdentChar(lower[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(lower) && isIdentChar(lower[i]) {
+			i++
+		}
+		if lower[start:i] == word {
+			return true
+		}
+	}
+	return false
+}
+
+// safeModeDescription is appended to the clickhouse_query description in
+// safe mode.
+const safeModeDescription = `Safe mode: only structured queries (table, columns, where, order_by, limit, final, sample) on system.* tables. sql, settings and subqueries are rejected; limit is at most 1000 and defaults to 1000.`
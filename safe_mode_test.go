@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// withSafeMode runs applySafeMode and returns a func that undoes it.
+func withSafeMode(t *testing.T) func() {
+	t.Helper()
+	keys := []string{
+		"mcp.enabled_tools", "mcp.analysis_tools", "mcp.large_results.threshold_bytes",
+		"bedrock.region", "http.admin_token", "clickhouse.allowed_databases",
+		"clickhouse.allowed_query_settings", "clickhouse.allow_freeform_sql", "clickhouse.max_rows_to_read", "clickhouse.max_bytes_to_read",
+	}
+	applySafeMode()
+	return func() {
+		safeMode = false
+		for _, k := range keys {
+			viper.Set(k, nil)
+		}
+	}
+}
+
+func TestApplySafeMode(t *testing.T) {
+	viper.Set("clickhouse.allowed_databases", []string{"system", "models"})
+	viper.Set("mcp.enabled_tools", []string{"clickhouse_query", "prometheus_query"})
+	viper.Set("http.admin_token", "secret")
+	viper.Set("clickhouse.max_rows_to_read", 5000)
+	viper.Set("clickhouse.max_bytes_to_read", int64(1)<<40)
+	defer withSafeMode(t)()
+
+	if !safeMode {
+		t.Fatal("safeMode not set")
+	}
+	if got := getAllowedDatabases(); !reflect.DeepEqual(got, []string{"system"}) {
+		t.Errorf("allowed databases = %v, want [system]", got)
+	}
+	if !toolEnabled("clickhouse_query") || toolEnabled("prometheus_query") || toolEnabled("clickhouse_watch") {
+		t.Errorf("enabled tools = %v, want only clickhouse_query", viper.GetStringSlice("mcp.enabled_tools"))
+	}
+	if viper.GetString("http.admin_token") != "" {
+		t.Error("admin token not cleared")
+	}
+	if got := viper.GetInt64("clickhouse.max_rows_to_read"); got != 5000 {
+		t.Errorf("max_rows_to_read = %d, want the smaller configured 5000", got)
+	}
+	if got := viper.GetInt64("clickhouse.max_bytes_to_read"); got != safeModeMaxBytesToRead {
+		t.Errorf("max_bytes_to_read = %d, want %d", got, safeModeMaxBytesToRead)
+	}
+}
+
+func TestSafeModeValidation(t *testing.T) {
+	defer withSafeMode(t)()
+
+	tests := []struct {
+		name string
+		args queryArgs
+		want string // reason; "" = valid
+	}{
+		{name: "structured system query", args: queryArgs{Table: "system.parts", Where: "active", Limit: 10}},
+		{name: "select in a string literal", args: queryArgs{Table: "system.query_log", Where: "query LIKE '%select%'"}},
+		{name: "column named like a keyword", args: queryArgs{Table: "system.query_log", Columns: []string{"selected_marks"}}},
+		{name: "sql", args: queryArgs{SQL: "SELECT 1"}, want: reasonSQLDisabled},
+		{name: "user database", args: queryArgs{Table: "models.users"}, want: reasonTableNotAllowed},
+		{name: "settings", args: queryArgs{Table: "system.parts", Settings: map[string]string{"max_threads": "1"}}, want: reasonSettingNotAllowed},
+		{name: "subquery in where", args: queryArgs{Table: "system.parts", Where: "table IN (SELECT name FROM models.t)"}, want: reasonInvalidClause},
+		{name: "subquery in columns", args: queryArgs{Table: "system.parts", Columns: []string{"(select 1)"}}, want: reasonInvalidClause},
+		{name: "limit over the cap", args: queryArgs{Table: "system.parts", Limit: safeModeMaxRows + 1}, want: reasonInvalidLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateQueryArgs(tt.args)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("validateQueryArgs() error = %v", err)
+				}
+				return
+			}
+			var verr *queryValidationError
+			if !errors.As(err, &verr) || verr.Reason != tt.want {
+				t.Errorf("validateQueryArgs() error = %v, want reason %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafeModeDefaultLimit(t *testing.T) {
+	defer withSafeMode(t)()
+
+	if got := buildQuery(queryArgs{Table: "system.parts", Columns: []string{"name"}}); !strings.HasSuffix(got, " LIMIT 1000") {
+		t.Errorf("buildQuery() = %q, want the safe mode default LIMIT 1000", got)
+	}
+	if got := buildQuery(queryArgs{Table: "system.parts", Columns: []string{"name"}, Limit: 5}); !strings.HasSuffix(got, " LIMIT 5") {
+		t.Errorf("buildQuery() = %q, want LIMIT 5", got)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// schemaDiffArgs is the input to the clickhouse_schema_diff tool.
+type schemaDiffArgs struct {
+	Table   string `json:"table" jsonschema:"table to compare across replicas, as database.table"`
+	Cluster string `json:"cluster,omitempty" jsonschema:"cluster to compare across; defaults to the configured cluster"`
+}
+
+// schemaVariant is one distinct CREATE TABLE statement and the hosts that have
+// it. Missing/Extra are relative to the first (most common) variant.
+type schemaVariant struct {
+	Hosts   []string `json:"hosts" jsonschema:"replicas with this definition"`
+	DDL     string   `json:"ddl" jsonschema:"CREATE TABLE statement as reported by system.tables"`
+	Missing []string `json:"missing,omitempty" jsonschema:"DDL elements present in the most common definition but not in this one"`
+	Extra   []string `json:"extra,omitempty" jsonschema:"DDL elements present in this definition but not in the most common one"`
+}
+
+// schemaDiffResult is the structured output of clickhouse_schema_diff.
+type schemaDiffResult struct {
+	Table      string          `json:"table"`
+	Cluster    string          `json:"cluster"`
+	Consistent bool            `json:"consistent" jsonschema:"true when every replica has the table with an identical definition"`
+	Variants   []schemaVariant `json:"variants" jsonschema:"distinct definitions, most common first"`
+	MissingOn  []string        `json:"missing_on,omitempty" jsonschema:"replicas where the table does not exist"`
+}
+
+func registerSchemaDiffTool(srv *mcp.Server) {
+	addTool[schemaDiffArgs, *schemaDiffResult](
+		srv,
+		&mcp.Tool{
+			Name:        "clickhouse_schema_diff",
+			Title:       "Compare a table's schema across replicas",
+			Description: `Fetch a table's CREATE TABLE statement from every replica in the cluster and report any differences. Use to confirm or rule out schema drift between replicas (e.g. a missed ALTER, a column present on some nodes only). table must be database.table in an allowed database.`,
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		},
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[schemaDiffArgs]) (*mcp.CallToolResultFor[*schemaDiffResult], error) {
+			database, table, err := splitTableName(req.Arguments.Table)
+			if err != nil {
+				return nil, err
+			}
+			cluster := strings.TrimSpace(req.Arguments.Cluster)
+			if cluster == "" {
+				cluster = viper.GetString("clickhouse.cluster")
+			}
+
+			conn, err := connect()
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				if err := conn.Close(); err != nil {
+					logrus.WithError(err).Warn("Error closing ClickHouse connection")
+				}
+			}()
+
+			res, err := diffTableSchema(ctx, conn, cluster, database, table)
+			if err != nil {
+				return nil, err
+			}
+			if !res.Consistent {
+				logrus.WithFields(logrus.Fields{
+					"table":      res.Table,
+					"cluster":    cluster,
+					"variants":   len(res.Variants),
+					"missing_on": res.MissingOn,
+				}).Warn("Schema drift detected between replicas")
+			}
+			return &mcp.CallToolResultFor[*schemaDiffResult]{
+				Content:           []mcp.Content{&mcp.TextContent{Text: summarizeSchemaDiff(res)}},
+				StructuredContent: res,
+			}, nil
+		},
+	)
+}
+
+// splitTableName validates a database.table reference against the allowed
+// databases and returns its parts.
+func splitTableName(ref string) (string, string, error) {
+	ref = strings.TrimSpace(ref)
+	database, table, ok := strings.Cut(ref, ".")
+	if !ok || database == "" || table == "" || strings.Contains(table, ".") {
+		return "", "", fmt.Errorf("table must be database.table, got %q", ref)
+	}
+	for _, part := range []string{database, table} {
+		for i := 0; i < len(part); i++ {
+			if !isIdentChar(part[i]) {
+				return "", "", fmt.Errorf("invalid table name: %q", ref)
+			}
+		}
+	}
+	if !isTableAllowed(ref) {
+		return "", "", fmt.Errorf("table must be in allowed databases: %v", getAllowedDatabases())
+	}
+	return database, table, nil
+}
+
+// diffTableSchema collects database.table's definition from every replica of
+// cluster and groups replicas by identical definition.
+func diffTableSchema(ctx context.Context, conn driver.Conn, cluster, database, table string) (*schemaDiffResult, error) {
+	hosts, err := clusterHosts(ctx, conn, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(ctx,
+		"SELECT hostName() AS host, create_table_query FROM clusterAllReplicas(?, system.tables) WHERE database = ? AND name = ?",
+		cluster, database, table)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
+	ddlByHost := make(map[string]string)
+	for rows.Next() {
+		var host, ddl string
+		if err := rows.Scan(&host, &ddl); err != nil {
+			return nil, err
+		}
+		ddlByHost[host] = ddl
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ddlByHost) == 0 {
+		return nil, fmt.Errorf("table %s.%s not found on any replica of cluster %s", database, table, cluster)
+	}
+	return compareSchemas(database+"."+table, cluster, hosts, ddlByHost), nil
+}
+
+// clusterHosts returns the hostname of every replica in cluster.
+func clusterHosts(ctx context.Context, conn driver.Conn, cluster string) ([]string, error) {
+	rows, err := conn.Query(ctx, "SELECT DISTINCT hostName() FROM clusterAllReplicas(?, system.one)", cluster)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
+	var hosts []string
+	for rows.Next() {
+		var host string
+		if err := rows.Scan(&host); err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, rows.Err()
+}
+
+// compareSchemas groups hosts by identical DDL, most common first, and diffs
+// each other variant against it.
+func compareSchemas(table, cluster string, hosts []string, ddlByHost map[string]string) *schemaDiffResult {
+	res := &schemaDiffResult{Table: table, Cluster: cluster}
+
+	byDDL := make(map[string]*schemaVariant)
+	for host, ddl := range ddlByHost {
+		v, ok := byDDL[ddl]
+		if !ok {
+			v = &schemaVariant{DDL: ddl}
+			byDDL[ddl] = v
+		}
+		v.Hosts = append(v.Hosts, host)
+	}
+	for _, v := range byDDL {
+		sort.Strings(v.Hosts)
+		res.Variants = append(res.Variants, *v)
+	}
+	sort.Slice(res.Variants, func(i, j int) bool {
+		if len(res.Variants[i].Hosts) != len(res.Variants[j].Hosts) {
+			return len(res.Variants[i].Hosts) > len(res.Variants[j].Hosts)
+		}
+		return res.Variants[i].Hosts[0] < res.Variants[j].Hosts[0]
+	})
+
+	base := ddlElements(res.Variants[0].DDL)
+	for i := 1; i < len(res.Variants); i++ {
+		other := ddlElements(res.Variants[i].DDL)
+		res.Variants[i].Missing = elementsNotIn(base, other)
+		res.Variants[i].Extra = elementsNotIn(other, base)
+	}
+
+	for _, host := range hosts {
+		if _, ok := ddlByHost[host]; !ok {
+			res.MissingOn = append(res.MissingOn, host)
+		}
+	}
+	sort.Strings(res.MissingOn)
+
+	res.Consistent = len(res.Variants) == 1 && len(res.MissingOn) == 0
+	return res
+}
+
+// ddlClauses are the CREATE TABLE clauses after the column list that are
+// compared as separate elements.
+var ddlClauses = []string{"ENGINE", "PARTITION BY", "PRIMARY KEY", "ORDER BY", "SAMPLE BY", "TTL", "SETTINGS", "COMMENT"}
+
+// ddlElements splits a single-line CREATE TABLE statement into comparable
+// elements: the header, each top-level entry of the column list (columns,
+// indexes, projections, constraints) and each trailing clause.
+func ddlElements(ddl string) []string {
+	var elems []string
+	add := func(s string) {
+		if s = strings.TrimSpace(s); s != "" {
+			elems = append(elems, s)
+		}
+	}
+
+	depth, start := 0, 0
+	var quote byte
+	tail := ""
+	for i := 0; i < len(ddl); i++ {
+		c := ddl[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+		case '(':
+			depth++
+			if depth == 1 {
+				add(ddl[start:i])
+				start = i + 1
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				add(ddl[start:i])
+				tail = ddl[i+1:]
+				i = len(ddl)
+			}
+		case ',':
+			if depth == 1 {
+				add(ddl[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if tail == "" && len(elems) == 0 {
+		add(ddl)
+		return elems
+	}
+
+	// Split the tail before each known clause keyword at paren depth 0.
+	cut := []int{0}
+	upper := strings.ToUpper(tail)
+	depth = 0
+	for i := 0; i < len(tail); i++ {
+		switch tail[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ' ':
+			if depth != 0 {
+				continue
+			}
+			for _, kw := range ddlClauses {
+				if strings.HasPrefix(upper[i+1:], kw+" ") {
+					cut = append(cut, i+1)
+					break
+				}
+			}
+		}
+	}
+	for i, from := range cut {
+		to := len(tail)
+		if i+1 < len(cut) {
+			to = cut[i+1]
+		}
+		add(tail[from:to])
+	}
+	return elems
+}
+
+// elementsNotIn returns the elements of a that are not in b, in order.
+func elementsNotIn(a, b []string) []string {
+	seen := make(map[string]struct{}, len(b))
+	for _, e := range b {
+		seen[e] = struct{}{}
+	}
+	var out []string
+	for _, e := range a {
+		if _, ok := seen[e]; !ok {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func summarizeSchemaDiff(res *schemaDiffResult) string {
+	if res.Consistent {
+		return fmt.Sprintf("%s: identical on all %d replicas of %s", res.Table, len(res.Variants[0].Hosts), res.Cluster)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "WARNING: %s schema differs across replicas of %s", res.Table, res.Cluster)
+	if len(res.MissingOn) > 0 {
+		fmt.Fprintf(&b, "\nmissing on: %s", strings.Join(res.MissingOn, ", "))
+	}
+	fmt.Fprintf(&b, "\nmost common definition (%s)", strings.Join(res.Variants[0].Hosts, ", "))
+	for _, v := range res.Variants[1:] {
+		fmt.Fprintf(&b, "\ndiffers on %s:", strings.Join(v.Hosts, ", "))
+		for _, e := range v.Missing {
+			fmt.Fprintf(&b, "\n  - %s", e)
+		}
+		for _, e := range v.Extra {
+			fmt.Fprintf(&b, "\n  + %s", e)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSplitTableName(t *testing.T) {
+	viper.Set("clickhouse.allowed_databases", []string{"system", "models"})
+	defer viper.Set("clickhouse.allowed_databases", nil)
+
+	tests := []struct {
+		name      string
+		ref       string
+		wantDB    string
+		wantTable string
+		wantErr   bool
+	}{
+		{name: "valid", ref: "models.events", wantDB: "models", wantTable: "events"},
+		{name: "surrounding whitespace", ref: " system.parts ", wantDB: "system", wantTable: "parts"},
+		{name: "no database", ref: "events", wantErr: true},
+		{name: "three parts", ref: "a.b.c", wantErr: true},
+		{name: "disallowed database", ref: "secret.events", wantErr: true},
+		{name: "injection attempt", ref: "models.events' OR 1=1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, table, err := splitTableName(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitTableName(%q) error = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			}
+			if db != tt.wantDB || table != tt.wantTable {
+				t.Errorf("splitTableName(%q) = %q, %q, want %q, %q", tt.ref, db, table, tt.wantDB, tt.wantTable)
+			}
+		})
+	}
+}
+
+func TestDDLElements(t *testing.T) {
+	ddl := "CREATE TABLE models.events (`id` UInt64, `ts` DateTime64(3, 'UTC'), `props` Map(String, String), INDEX idx_ts ts TYPE minmax GRANULARITY 1) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/events', '{replica}') PARTITION BY toYYYYMM(ts) ORDER BY (id, ts) SETTINGS index_granularity = 8192"
+	want := []string{
+		"CREATE TABLE models.events",
+		"`id` UInt64",
+		"`ts` DateTime64(3, 'UTC')",
+		"`props` Map(String, String)",
+		"INDEX idx_ts ts TYPE minmax GRANULARITY 1",
+		"ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/events', '{replica}')",
+		"PARTITION BY toYYYYMM(ts)",
+		"ORDER BY (id, ts)",
+		"SETTINGS index_granularity = 8192",
+	}
+	if got := ddlElements(ddl); !equalSlices(got, want) {
+		t.Errorf("ddlElements() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestCompareSchemas(t *testing.T) {
+	base := "CREATE TABLE models.events (`id` UInt64, `name` String) ENGINE = MergeTree ORDER BY id"
+	drifted := "CREATE TABLE models.events (`id` UInt64, `name` LowCardinality(String), `extra` UInt8) ENGINE = MergeTree ORDER BY id"
+
+	t.Run("consistent", func(t *testing.T) {
+		res := compareSchemas("models.events", "c", []string{"h1", "h2"}, map[string]string{"h1": base, "h2": base})
+		if !res.Consistent || len(res.Variants) != 1 {
+			t.Fatalf("compareSchemas() = %+v, want a single consistent variant", res)
+		}
+		if got := summarizeSchemaDiff(res); strings.Contains(got, "WARNING") {
+			t.Errorf("summary for consistent schema contains a warning: %q", got)
+		}
+	})
+
+	t.Run("drift and missing replica", func(t *testing.T) {
+		res := compareSchemas("models.events", "c", []string{"h1", "h2", "h3", "h4"},
+			map[string]string{"h1": base, "h2": drifted, "h3": base})
+		if res.Consistent {
+			t.Fatal("compareSchemas() reported consistent for drifted schemas")
+		}
+		if !equalSlices(res.Variants[0].Hosts, []string{"h1", "h3"}) {
+			t.Errorf("most common variant hosts = %v, want [h1 h3]", res.Variants[0].Hosts)
+		}
+		if !equalSlices(res.MissingOn, []string{"h4"}) {
+			t.Errorf("MissingOn = %v, want [h4]", res.MissingOn)
+		}
+		v := res.Variants[1]
+		if !equalSlices(v.Missing, []string{"`name` String"}) {
+			t.Errorf("Missing = %q, want [`name` String]", v.Missing)
+		}
+		if !equalSlices(v.Extra, []string{"`name` LowCardinality(String)", "`extra` UInt8"}) {
+			t.Errorf("Extra = %q", v.Extra)
+		}
+		if got := summarizeSchemaDiff(res); !strings.HasPrefix(got, "WARNING") {
+			t.Errorf("summary = %q, want a WARNING", got)
+		}
+	})
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
@@ -15,14 +19,30 @@ import (
 	"github.com/spf13/viper"
 )
 
-// RunMCPServer starts an MCP stdio server using the official go-sdk.
+// RunMCPServer starts the MCP server over HTTP using the official go-sdk.
 func RunMCPServer() error {
-	impl := &mcp.Implementation{Name: "housekeeper-clickhouse-mcp", Title: "Housekeeper ClickHouse", Version: "0.3.0"}
-	srv := mcp.NewServer(impl, &mcp.ServerOptions{})
+	srv, err := buildMCPServer()
+	if err != nil {
+		return err
+	}
+
+	if appConfig.Startup.Warmup {
+		runWarmup(context.Background(), startupProbes())
+	}
+	go logUsage(context.Background(), appConfig.Logging.UsageInterval)
+	go flushDedupedLogs(context.Background(), appConfig.Logging.DedupWindow)
+
+	return runHTTPMCPServer(srv)
+}
+
+// buildMCPServer creates the MCP server and registers every configured tool
+// and resource on it.
+func buildMCPServer() (*mcp.Server, error) {
+	srv := mcp.NewServer(serverImpl, &mcp.ServerOptions{})
 
 	// Initialize Prometheus client
 	if err := initPrometheus(); err != nil {
-		return fmt.Errorf("failed to initialize prometheus client: %v", err)
+		return nil, fmt.Errorf("failed to initialize prometheus client: %v", err)
 	}
 
 	// Build description with allowed databases
@@ -34,6 +54,9 @@ Query patterns:
 - system.* tables are per-node — wrap in clusterAllReplicas('<cluster>', system.<table>) for cluster-wide visibility.
 - For user-database tables: replicated tables (same data on every replica) should be queried directly to avoid duplicates; sharded tables (different data per shard) need clusterAllReplicas to see everything. Check system.tables.engine if unsure, or test counts both ways.
 - Prefer structured fields (table, columns, where, order_by, limit); use sql for joins/aggregations/CTEs.
+- final: true reads a ReplacingMergeTree/CollapsingMergeTree table with FINAL for deduplicated rows (slower; use only when correctness needs it). sample: 0.1 reads a tenth of a table with SAMPLE BY. Both apply to structured queries only.
+- settings passes query-level ClickHouse settings, e.g. {"max_threads": "4", "use_query_cache": "1"}; only allowlisted names are accepted, and a SETTINGS clause in sql is rejected.
+- Without columns, common wide system tables (query_log, errors, parts, merges, mutations, replicas, replication_queue, processes, tables) return a default set of key columns plus host; name columns explicitly to get others.
 
 Validator limitations:
 - Only db.table and clusterAllReplicas('cluster', db.table) table references are accepted. cluster() and remote() are blocked.
@@ -55,9 +78,23 @@ Investigation tips:
 	if qextra := strings.TrimSpace(viper.GetString("mcp.query_extra_description")); qextra != "" {
 		toolDesc = toolDesc + "\n\n" + qextra
 	}
+	if n := maxCellLength(); n > 0 {
+		toolDesc += fmt.Sprintf("\n\nString values longer than %d characters are cut and end in %s; pass verbose: true to get them in full.", n, truncatedMarker)
+	}
+	switch queryLogTimeFilter() {
+	case queryLogFilterInject:
+		toolDesc += fmt.Sprintf("\n\nStructured queries on system.query_log without an event_date/event_time condition in where are limited to the past %s. Free-form sql reading system.query_log must filter on event_date or event_time.", queryLogWindow())
+	case queryLogFilterReject:
+		toolDesc += "\n\nQueries on system.query_log must filter on event_date or event_time in where; others are rejected."
+	}
+	if safeMode {
+		toolDesc = toolDesc + "\n\n" + safeModeDescription
+	} else if !freeformSQLAllowed() {
+		toolDesc = toolDesc + "\n\nFree-form sql is disabled on this server: use the structured fields only."
+	}
 
 	// Register ClickHouse tool with inferred input schema (from queryArgs)
-	mcp.AddTool[queryArgs, map[string]any](
+	addTool[queryArgs, *queryResult](
 		srv,
 		&mcp.Tool{
 			Name:        "clickhouse_query",
@@ -65,26 +102,56 @@ Investigation tips:
 			Description: toolDesc,
 			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
 		},
-		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[queryArgs]) (*mcp.CallToolResultFor[map[string]any], error) {
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[queryArgs]) (*mcp.CallToolResultFor[*queryResult], error) {
 			qa := req.Arguments
 			// Note: OrderBy might be empty, which is valid
 			if err := validateQueryArgs(qa); err != nil {
-				return nil, err
+				return validationErrorResult[*queryResult](err)
 			}
-			rows, err := runClickhouseQuery(qa)
+			qa, err := applyQueryLogTimeFilter(qa)
+			if err != nil {
+				return validationErrorResult[*queryResult](err)
+			}
+			res, err := runClickhouseQuery(ctx, qa)
 			if err != nil {
 				return nil, err
 			}
-			data := map[string]any{"results": rows, "count": len(rows)}
-			// Produce a concise, useful text summary for the LLM/UI
-			summary := summarizeRows(rows)
-			return &mcp.CallToolResultFor[map[string]any]{
-				Content:           []mcp.Content{&mcp.TextContent{Text: summary}},
-				StructuredContent: data,
+			if !qa.Verbose {
+				res.TruncatedCells = truncateCells(res.Results, maxCellLength())
+			}
+			summary := summarizeQueryResult(res)
+			link := offloadLargeResult(res)
+			if link != nil {
+				summary += fmt.Sprintf("\nresults: first %d of %d rows inline; read %s for all rows", len(res.Results), res.Count, res.ResultURI)
+			}
+			content := []mcp.Content{&mcp.TextContent{Text: summary}}
+			if link != nil {
+				content = append(content, link)
+			}
+			return &mcp.CallToolResultFor[*queryResult]{
+				Content:           content,
+				StructuredContent: res,
 			}, nil
 		},
 	)
 
+	// Optional: serve oversized clickhouse_query results as MCP resources.
+	if viper.GetInt("mcp.large_results.threshold_bytes") > 0 {
+		registerResultResources(srv)
+	}
+
+	registerWatchTool(srv)
+	registerSchemaDiffTool(srv)
+	registerDDLChangesTool(srv)
+	registerProcessesTool(srv)
+	registerClustersTool(srv)
+	registerMetricsTool(srv)
+	registerMetricHistoryTool(srv)
+	registerDictionariesTool(srv)
+	registerBackupsTool(srv)
+	registerTopTool(srv)
+	registerIncidentSnapshotTool(srv)
+
 	defaultPromDesc := `Execute PromQL range queries against Prometheus metrics.
 
 start/end: RFC3339 UTC or relative ("-30m", "-1h"). end defaults to now(). Future timestamps are rejected. Prefer relative ("-30m") when the current time isn't known.
@@ -112,11 +179,18 @@ step: Go duration ("15s", "30s", "1m"). 15s exploits the upstream's native resol
 		logrus.Info("diagnose tool enabled (Bedrock in-account analysis)")
 	}
 
-	return runHTTPMCPServer(srv)
+	// Optional: Gemini-backed analyze_errors / analyze_performance (the same
+	// agents as analyze mode) and explain_error. Off by default since they call an external LLM.
+	if analysisToolsEnabled() {
+		registerAnalysisTools(srv)
+		logrus.Info("analyze tools enabled (Gemini)")
+	}
+
+	return srv, nil
 }
 
 func registerPrometheusTool(srv *mcp.Server, name, title, description, endpoint string) {
-	mcp.AddTool[prometheusArgs, map[string]any](
+	addTool[prometheusArgs, *promQueryResult](
 		srv,
 		&mcp.Tool{
 			Name:        name,
@@ -124,7 +198,7 @@ func registerPrometheusTool(srv *mcp.Server, name, title, description, endpoint
 			Description: description,
 			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
 		},
-		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[prometheusArgs]) (*mcp.CallToolResultFor[map[string]any], error) {
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[prometheusArgs]) (*mcp.CallToolResultFor[*promQueryResult], error) {
 			pa := req.Arguments
 
 			if pa.Query == "" {
@@ -141,12 +215,16 @@ func registerPrometheusTool(srv *mcp.Server, name, title, description, endpoint
 				return nil, fmt.Errorf("invalid step duration: %v", err)
 			}
 
+			if err := validatePromQuery(pa.Query, end.Sub(start)); err != nil {
+				return nil, err
+			}
+
 			result, err := queryPrometheus(endpoint, pa.Query, start, end, step)
 			if err != nil {
 				return nil, err
 			}
 
-			data := map[string]any{"result": result}
+			data := &promQueryResult{Result: result}
 
 			var summary string
 			if resultMap, ok := result.(map[string]interface{}); ok {
@@ -163,11 +241,13 @@ func registerPrometheusTool(srv *mcp.Server, name, title, description, endpoint
 				} else {
 					summary = "Query returned data in non-matrix format"
 				}
+			} else if m, ok := result.(model.Matrix); ok && len(m) == 0 {
+				summary = fmt.Sprintf("no data for query: %s\nhint: %s", pa.Query, promNoDataHint)
 			} else {
 				summary = fmt.Sprintf("%v", result)
 			}
 
-			return &mcp.CallToolResultFor[map[string]any]{
+			return &mcp.CallToolResultFor[*promQueryResult]{
 				Content:           []mcp.Content{&mcp.TextContent{Text: summary}},
 				StructuredContent: data,
 			}, nil
@@ -177,9 +257,25 @@ func registerPrometheusTool(srv *mcp.Server, name, title, description, endpoint
 
 // runHTTPMCPServer starts the MCP server over HTTP using the streamable HTTP transport.
 func runHTTPMCPServer(srv *mcp.Server) error {
-	addr := viper.GetString("http.addr")
-	authToken := viper.GetString("http.auth_token")
+	addr := appConfig.HTTP.Addr
+	authToken := appConfig.HTTP.AuthToken
+
+	handler := newHTTPHandler(srv, authToken)
 
+	logrus.WithFields(logrus.Fields{
+		"addr":       addr,
+		"mcp_url":    "http://<host>" + addr + "/",
+		"health_url": "http://<host>" + addr + "/health",
+		"auth":       authToken != "",
+	}).Info("MCP server ready")
+
+	return http.ListenAndServe(addr, handler)
+}
+
+// newHTTPHandler builds the HTTP routing for the MCP server: an unauthenticated
+// /health probe and the streamable MCP transport on /, gated by authToken when
+// it is non-empty.
+func newHTTPHandler(srv *mcp.Server, authToken string) http.Handler {
 	mux := http.NewServeMux()
 
 	// Health check — no auth required; used by k8s probes and connectivity tests.
@@ -199,25 +295,58 @@ func runHTTPMCPServer(srv *mcp.Server) error {
 		return srv
 	}, nil)
 
+	var root http.Handler = streamHandler
 	if authToken != "" {
-		mux.Handle("/", bearerAuthMiddleware(authToken, streamHandler))
+		root = bearerAuthMiddleware(authToken, streamHandler)
 		logrus.Info("HTTP authentication enabled (bearer token)")
 	} else {
-		mux.Handle("/", streamHandler)
 		logrus.Info("HTTP authentication disabled (no auth_token configured)")
 	}
+	// Browser GETs on / get a human-readable landing page instead of the MCP
+	// transport; it sits in front of auth so a deployment can be checked from a
+	// browser without a token, and with a token set it shows only the server.
+	if viper.GetBool("http.landing_page") {
+		root = landingPageMiddleware(authToken != "", root)
+	}
+	mux.Handle("/", root)
 
-	// Wrap everything with CORS + request logging.
-	handler := requestLoggingMiddleware(corsMiddleware(mux))
+	// Admin API, only served when a separate admin token is configured.
+	if adminToken := viper.GetString("http.admin_token"); adminToken != "" {
+		mux.Handle("/admin/allowed-databases", bearerAuthMiddleware(adminToken, adminAllowedDatabasesHandler()))
+	}
 
-	logrus.WithFields(logrus.Fields{
-		"addr":       addr,
-		"mcp_url":    "http://<host>" + addr + "/",
-		"health_url": "http://<host>" + addr + "/health",
-		"auth":       authToken != "",
-	}).Info("MCP server ready")
+	// Wrap everything with CORS + request logging.
+	return requestLoggingMiddleware(corsMiddleware(maxBodyMiddleware(viper.GetInt64("http.max_body_bytes"), mux)))
+}
 
-	return http.ListenAndServe(addr, handler)
+// maxBodyMiddleware rejects request bodies larger than limit bytes with 413.
+// The body is buffered here so that oversize chunked requests also get a 413
+// rather than a generic read error from the MCP transport. limit <= 0
+// disables the check.
+func maxBodyMiddleware(limit int64, next http.Handler) http.Handler {
+	if limit <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+			if err != nil {
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // requestLoggingMiddleware logs every incoming HTTP request (except health checks).
@@ -258,12 +387,12 @@ func bearerAuthMiddleware(token string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		auth := r.Header.Get("Authorization")
 		if !strings.HasPrefix(auth, "Bearer ") || strings.TrimPrefix(auth, "Bearer ") != token {
-			logrus.WithFields(logrus.Fields{
+			logDeduped(logrus.WithFields(logrus.Fields{
 				"remote_addr": r.RemoteAddr,
 				"path":        r.URL.Path,
 				"method":      r.Method,
 				"has_auth":    auth != "",
-			}).Warn("Rejected unauthorized request")
+			}), logrus.WarnLevel, "Rejected unauthorized request")
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -271,33 +400,82 @@ func bearerAuthMiddleware(token string, next http.Handler) http.Handler {
 	})
 }
 
-// summarizeRows renders a compact, human-friendly summary of results.
+// emptyResultSummary replaces the row summary when a query returns nothing,
+// so a model reading it doesn't mistake "no rows" for missing data to fill in.
+const emptyResultSummary = "No results found for that query: it ran successfully and matched no rows."
+
+// summarizeQueryResult produces a concise text summary of a clickhouse_query
+// result for the LLM/UI: the rows, then the SQL and any warnings.
+func summarizeQueryResult(res *queryResult) string {
+	summary := summarizeRows(res.Results)
+	if res.Count == 0 {
+		summary = emptyResultSummary
+	}
+	if res.SQL != "" {
+		summary += "\nsql: " + res.SQL
+	}
+	if len(res.UnavailableReplicas) > 0 {
+		summary += "\nwarning: partial results; replicas with connection errors: " + strings.Join(res.UnavailableReplicas, ", ")
+	}
+	if res.TruncatedCells > 0 {
+		summary += fmt.Sprintf("\nnote: %d long string value(s) truncated; repeat with verbose: true for full values", res.TruncatedCells)
+	}
+	return summary
+}
+
+// summarizeRows renders a compact, human-friendly summary of results with the
+// first matching formatter in rowFormatters:
 // - If 0 rows: "no rows"
+// - If a time column and only numeric columns: time range and a sparkline per column
 // - If 1 row: print key=value pairs (enhance common units)
 // - If few rows (<=5): print each row on a line with k=v pairs
 // - Else: print count and first row preview
+// Timestamps are shown in display.timezone when it is set.
 func summarizeRows(rows []map[string]interface{}) string {
 	if len(rows) == 0 {
 		return "no rows"
 	}
-	if len(rows) == 1 {
-		return formatRow(rows[0])
-	}
-	if len(rows) <= 5 {
-		var b strings.Builder
-		for i := range rows {
-			if i > 0 {
-				b.WriteString("\n")
-			}
-			b.WriteString(formatRow(rows[i]))
+	loc := displayLocation()
+	for _, f := range rowFormatters {
+		if f.match(rows) {
+			return f.format(rows, loc)
 		}
-		return b.String()
 	}
-	// many rows: show count and a preview of the first row
-	return fmt.Sprintf("rows: %d\nfirst: %s", len(rows), formatRow(rows[0]))
+	return formatPreview(rows, loc)
 }
 
-func formatRow(row map[string]interface{}) string {
+// displayZone caches the location resolved for display.timezone, keyed by
+// the name it was resolved from.
+var displayZone struct {
+	sync.Mutex
+	name string
+	loc  *time.Location
+}
+
+// displayLocation returns the zone configured in display.timezone, or nil to
+// leave timestamps as returned (UTC). The zone is loaded once per configured
+// name; validate rejects an invalid one at startup.
+func displayLocation() *time.Location {
+	name := strings.TrimSpace(viper.GetString("display.timezone"))
+	displayZone.Lock()
+	defer displayZone.Unlock()
+	if name == displayZone.name {
+		return displayZone.loc
+	}
+	displayZone.name, displayZone.loc = name, nil
+	if name == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		logrus.WithError(err).WithField("timezone", name).Warn("Invalid display.timezone; showing UTC")
+		return nil
+	}
+	displayZone.loc = loc
+	return loc
+}
+
+func formatRow(row map[string]interface{}, loc *time.Location) string {
 	// stable key order
 	keys := make([]string, 0, len(row))
 	for k := range row {
@@ -307,12 +485,12 @@ func formatRow(row map[string]interface{}) string {
 	parts := make([]string, 0, len(keys))
 	for _, k := range keys {
 		v := row[k]
-		parts = append(parts, fmt.Sprintf("%s=%s", k, prettyValue(k, v)))
+		parts = append(parts, fmt.Sprintf("%s=%s", k, prettyValue(k, v, loc)))
 	}
 	return strings.Join(parts, " ")
 }
 
-func prettyValue(key string, v interface{}) string {
+func prettyValue(key string, v interface{}, loc *time.Location) string {
 	// Special-case time units
 	lk := strings.ToLower(key)
 	switch x := v.(type) {
@@ -327,6 +505,13 @@ func prettyValue(key string, v interface{}) string {
 	case float64, float32:
 		return fmt.Sprintf("%v", v)
 	case string:
+		// DateTime values arrive as RFC3339 UTC strings from normalizeValue;
+		// Date values are YYYY-MM-DD and don't parse, so stay as they are.
+		if loc != nil {
+			if t, err := time.Parse(time.RFC3339Nano, x); err == nil {
+				return t.In(loc).Format("2006-01-02 15:04:05.999999999 MST")
+			}
+		}
 		return x
 	case nil:
 		return "null"
@@ -336,9 +521,6 @@ func prettyValue(key string, v interface{}) string {
 }
 
 func prettyNumericWithUnits(lkey string, val float64) string {
-	if strings.Contains(lkey, "second") || strings.HasSuffix(lkey, "_seconds") || strings.HasSuffix(lkey, "seconds") {
-		return fmt.Sprintf("%ss", trimFloat(val))
-	}
 	if strings.Contains(lkey, "microsecond") {
 		// render both microseconds and seconds
 		secs := val / 1_000_000.0
@@ -352,6 +534,10 @@ func prettyNumericWithUnits(lkey string, val float64) string {
 		secs := val / 1_000_000_000.0
 		return fmt.Sprintf("%.0fns (%.3fs)", val, secs)
 	}
+	// Checked after the sub-second units, which also contain "second".
+	if strings.Contains(lkey, "second") || strings.HasSuffix(lkey, "_seconds") || strings.HasSuffix(lkey, "seconds") {
+		return fmt.Sprintf("%ss", trimFloat(val))
+	}
 	if strings.Contains(lkey, "bytes") || strings.HasSuffix(lkey, "_bytes") {
 		return humanBytes(val)
 	}
@@ -372,7 +558,7 @@ func humanBytes(val float64) string {
 	if val < 1024 {
 		return fmt.Sprintf("%.0f B", val)
 	}
-	units := []string{"KB", "MB", "GB", "TB", "PB"}
+	units := []string{"B", "KB", "MB", "GB", "TB", "PB"}
 	v := val
 	i := 0
 	for v >= 1024 && i < len(units)-1 {
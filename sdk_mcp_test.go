@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/spf13/viper"
+)
+
+func TestBearerAuthMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := bearerAuthMiddleware("secret-token", next)
+
+	tests := []struct {
+		name       string
+		authHeader string
+		wantStatus int
+	}{
+		{
+			name:       "valid token",
+			authHeader: "Bearer secret-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing header",
+			authHeader: "",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong token",
+			authHeader: "Bearer other-token",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "token prefix only",
+			authHeader: "Bearer secret",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong scheme",
+			authHeader: "Basic secret-token",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "lowercase scheme",
+			authHeader: "bearer secret-token",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "empty bearer",
+			authHeader: "Bearer ",
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("bearerAuthMiddleware() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestNewHTTPHandlerAuth(t *testing.T) {
+	srv := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0.0.0"}, &mcp.ServerOptions{})
+
+	tests := []struct {
+		name       string
+		authToken  string
+		method     string
+		path       string
+		authHeader string
+		wantStatus int
+	}{
+		{
+			name:       "health bypasses auth",
+			authToken:  "secret-token",
+			method:     http.MethodGet,
+			path:       "/health",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "mcp endpoint rejects missing token",
+			authToken:  "secret-token",
+			method:     http.MethodPost,
+			path:       "/",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "mcp endpoint rejects wrong token",
+			authToken:  "secret-token",
+			method:     http.MethodPost,
+			path:       "/",
+			authHeader: "Bearer wrong",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "CORS preflight is not gated",
+			authToken:  "secret-token",
+			method:     http.MethodOptions,
+			path:       "/",
+			wantStatus: http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newHTTPHandler(srv, tt.authToken)
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("newHTTPHandler() %s %s status = %d, want %d", tt.method, tt.path, rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestLandingPage(t *testing.T) {
+	viper.Set("http.landing_page", true)
+	viper.Set("http.banner", "staging cluster")
+	defer viper.Set("http.landing_page", nil)
+	defer viper.Set("http.banner", nil)
+
+	prev := toolCatalog
+	toolCatalog = []*mcp.Tool{{Name: "clickhouse_query", Title: "Query ClickHouse tables", Description: "secret topology notes"}}
+	defer func() { toolCatalog = prev }()
+
+	srv := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0.0.0"}, &mcp.ServerOptions{})
+
+	tests := []struct {
+		name          string
+		authToken     string
+		method        string
+		accept        string
+		wantStatus    int
+		wantBody      []string
+		wantNotInBody []string
+	}{
+		{
+			name:       "browser GET renders landing page",
+			method:     http.MethodGet,
+			accept:     "text/html,application/xhtml+xml",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{"clickhouse_query", "Query ClickHouse tables", "secret topology notes", "staging cluster", "/health"},
+		},
+		{
+			name:          "browser GET with auth shows only the server and skips token check",
+			authToken:     "secret-token",
+			method:        http.MethodGet,
+			accept:        "text/html",
+			wantStatus:    http.StatusOK,
+			wantBody:      []string{"bearer token required", "/health"},
+			wantNotInBody: []string{"clickhouse_query", "Query ClickHouse tables", "secret topology notes", "staging cluster"},
+		},
+		{
+			name:       "SSE GET is passed to the MCP transport",
+			authToken:  "secret-token",
+			method:     http.MethodGet,
+			accept:     "text/event-stream",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "POST is passed to the MCP transport",
+			authToken:  "secret-token",
+			method:     http.MethodPost,
+			accept:     "text/html",
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newHTTPHandler(srv, tt.authToken)
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			body := rec.Body.String()
+			for _, want := range tt.wantBody {
+				if !contains(body, want) {
+					t.Errorf("landing page missing %q", want)
+				}
+			}
+			for _, unwanted := range tt.wantNotInBody {
+				if contains(body, unwanted) {
+					t.Errorf("landing page unexpectedly contains %q", unwanted)
+				}
+			}
+		})
+	}
+}
+
+func TestToolOutputSchemas(t *testing.T) {
+	tests := []struct {
+		name         string
+		schema       func() (*jsonschema.Schema, error)
+		wantRequired []string
+		sample       any
+	}{
+		{
+			name:         "clickhouse_query",
+			schema:       jsonschema.For[*queryResult],
+			wantRequired: []string{"results", "count", "columns"},
+			sample: &queryResult{
+				Results: []map[string]interface{}{{"name": "system.parts", "rows": int64(10)}},
+				Count:   1,
+				Columns: []string{"name", "rows"},
+				QueryID: "3f1c7c3e-0000-0000-0000-000000000000",
+				SQL:     "SELECT name, rows FROM system.parts LIMIT 1",
+			},
+		},
+		{
+			name:         "prometheus_query",
+			schema:       jsonschema.For[*promQueryResult],
+			wantRequired: []string{"result"},
+			sample:       &promQueryResult{Result: map[string]interface{}{"last_values": []interface{}{}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := tt.schema()
+			if err != nil {
+				t.Fatalf("inferring output schema: %v", err)
+			}
+			if !equalSlices(schema.Required, tt.wantRequired) {
+				t.Errorf("output schema required = %v, want %v", schema.Required, tt.wantRequired)
+			}
+			resolved, err := schema.Resolve(nil)
+			if err != nil {
+				t.Fatalf("resolving output schema: %v", err)
+			}
+			// Validate the JSON form, as a client would receive it.
+			b, err := json.Marshal(tt.sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var instance map[string]any
+			if err := json.Unmarshal(b, &instance); err != nil {
+				t.Fatal(err)
+			}
+			if err := resolved.Validate(instance); err != nil {
+				t.Errorf("structured content does not conform to output schema: %v", err)
+			}
+		})
+	}
+}
+
+func TestSummarizeRowsDisplayTimezone(t *testing.T) {
+	defer viper.Set("display.timezone", nil)
+
+	rows := []map[string]interface{}{{"event_date": "2024-01-01", "event_time": "2024-01-01T12:00:00Z", "name": "merge"}}
+	tests := []struct {
+		name     string
+		timezone string
+		want     string
+	}{
+		{name: "unset keeps UTC string", timezone: "", want: "event_date=2024-01-01 event_time=2024-01-01T12:00:00Z name=merge"},
+		{name: "converted and labeled", timezone: "America/New_York", want: "event_date=2024-01-01 event_time=2024-01-01 07:00:00 EST name=merge"},
+		{name: "invalid zone falls back", timezone: "Not/AZone", want: "event_date=2024-01-01 event_time=2024-01-01T12:00:00Z name=merge"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("display.timezone", tt.timezone)
+			if got := summarizeRows(rows); got != tt.want {
+				t.Errorf("summarizeRows() = %q, want %q", got, tt.want)
+			}
+			if rows[0]["event_time"] != "2024-01-01T12:00:00Z" {
+				t.Errorf("summarizeRows() modified the structured row: %v", rows[0]["event_time"])
+			}
+			if first, again := displayLocation(), displayLocation(); first != again {
+				t.Errorf("displayLocation() resolved %q again: %p, %p", tt.timezone, first, again)
+			}
+		})
+	}
+}
+
+func TestMaxBodyMiddleware(t *testing.T) {
+	var gotBody string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := maxBodyMiddleware(16, next)
+
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		wantStatus    int
+	}{
+		{name: "within limit", body: "small body", contentLength: 10, wantStatus: http.StatusOK},
+		{name: "exactly at limit", body: strings.Repeat("x", 16), contentLength: 16, wantStatus: http.StatusOK},
+		{name: "declared length over limit", body: strings.Repeat("x", 17), contentLength: 17, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "chunked body over limit", body: strings.Repeat("x", 64), contentLength: -1, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotBody = ""
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.ContentLength = tt.contentLength
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("maxBodyMiddleware() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && gotBody != tt.body {
+				t.Errorf("next handler read %q, want %q", gotBody, tt.body)
+			}
+		})
+	}
+}
+
+func TestEnabledTools(t *testing.T) {
+	defer viper.Set("mcp.enabled_tools", nil)
+	catalog := toolCatalog
+	defer func() { toolCatalog = catalog }()
+
+	tests := []struct {
+		name    string
+		enabled []string
+		want    []string
+	}{
+		{name: "all by default", want: []string{"clickhouse_watch", "clickhouse_ddl_changes"}},
+		{name: "allowlist", enabled: []string{"clickhouse_watch"}, want: []string{"clickhouse_watch"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("mcp.enabled_tools", tt.enabled)
+			toolCatalog = nil
+			srv := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0.0.0"}, &mcp.ServerOptions{})
+			registerWatchTool(srv)
+			registerDDLChangesTool(srv)
+
+			ctx := context.Background()
+			serverTransport, clientTransport := mcp.NewInMemoryTransports()
+			ss, err := srv.Connect(ctx, serverTransport)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ss.Close()
+			cs, err := mcp.NewClient(&mcp.Implementation{Name: "client", Version: "0.0.0"}, nil).Connect(ctx, clientTransport)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cs.Close()
+
+			res, err := cs.ListTools(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, tool := range res.Tools {
+				got = append(got, tool.Name)
+			}
+			sort.Strings(got)
+			want := append([]string(nil), tt.want...)
+			sort.Strings(want)
+			if !equalSlices(got, want) {
+				t.Errorf("listed tools = %v, want %v", got, want)
+			}
+			if len(toolCatalog) != len(tt.want) {
+				t.Errorf("toolCatalog has %d tools, want %d", len(toolCatalog), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestListTools(t *testing.T) {
+	defer viper.Set("mcp.enabled_tools", nil)
+	catalog := toolCatalog
+	defer func() { toolCatalog = catalog }()
+
+	viper.Set("mcp.enabled_tools", []string{"clickhouse_watch", "clickhouse_ddl_changes"})
+	srv := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0.0.0"}, &mcp.ServerOptions{})
+	registerWatchTool(srv)
+	registerDDLChangesTool(srv)
+	registerProcessesTool(srv)
+
+	var out strings.Builder
+	if err := listTools(context.Background(), srv, &out); err != nil {
+		t.Fatal(err)
+	}
+	var got []listedTool
+	if err := json.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Fatalf("output is not a JSON tool list: %v\n%s", err, out.String())
+	}
+	var names []string
+	for _, tool := range got {
+		names = append(names, tool.Name)
+		if tool.Description == "" || tool.InputSchema == nil || tool.InputSchema.Type != "object" {
+			t.Errorf("tool %s: description %q, input schema %+v; want both set", tool.Name, tool.Description, tool.InputSchema)
+		}
+	}
+	if want := []string{"clickhouse_ddl_changes", "clickhouse_watch"}; !equalSlices(names, want) {
+		t.Errorf("listed tools = %v, want %v", names, want)
+	}
+	if got[1].InputSchema.Properties["interval"] == nil {
+		t.Errorf("clickhouse_watch input schema has no interval property: %+v", got[1].InputSchema)
+	}
+}
+
+func TestSummarizeQueryResult(t *testing.T) {
+	tests := []struct {
+		name string
+		res  *queryResult
+		want string
+	}{
+		{
+			name: "empty result",
+			res:  &queryResult{Results: []map[string]interface{}{}},
+			want: emptyResultSummary,
+		},
+		{
+			name: "empty result with sql and partial replicas",
+			res:  &queryResult{SQL: "SELECT 1 WHERE 0", UnavailableReplicas: []string{"ch2:9000"}},
+			want: emptyResultSummary + "\nsql: SELECT 1 WHERE 0\nwarning: partial results; replicas with connection errors: ch2:9000",
+		},
+		{
+			name: "rows",
+			res:  &queryResult{Results: []map[string]interface{}{{"n": 1}}, Count: 1},
+			want: "n = 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeQueryResult(tt.res); got != tt.want {
+				t.Errorf("summarizeQueryResult() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
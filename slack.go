@@ -3,12 +3,17 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
@@ -32,12 +37,22 @@ type SlackElement struct {
 	Text string `json:"text"`
 }
 
-func SendSlackMessage(summary string, errorCount int) error {
-	webhookURL := viper.GetString("slack.webhook_url")
-	if webhookURL == "" {
-		return fmt.Errorf("slack webhook URL not configured")
-	}
+// slackEscaper applies Slack's own escaping, which turns control sequences
+// such as <!channel>, <!here>, <!subteam^ID> and <@U123> into plain text.
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
 
+// slackBareMentionRe matches mention keywords Slack may expand without
+// angle brackets.
+var slackBareMentionRe = regexp.MustCompile(`(?i)@(here|channel|everyone)\b`)
+
+// sanitizeSlackText neutralizes mentions in text that comes from the model or
+// from ClickHouse data, so a posted message can never ping a channel, group or
+// user. Bare @here-style keywords get a zero-width space after the @.
+func sanitizeSlackText(text string) string {
+	return slackBareMentionRe.ReplaceAllString(slackEscaper.Replace(text), "@\u200b$1")
+}
+
+func SendSlackMessage(summary string, errorCount int) error {
 	timestamp := time.Now().Format("2006-01-02 15:04:05 MST")
 
 	message := SlackMessage{
@@ -53,7 +68,7 @@ func SendSlackMessage(summary string, errorCount int) error {
 				Type: "section",
 				Text: &SlackText{
 					Type: "mrkdwn",
-					Text: summary,
+					Text: sanitizeSlackText(summary),
 				},
 			},
 			{
@@ -75,6 +90,176 @@ func SendSlackMessage(summary string, errorCount int) error {
 		},
 	}
 
+	return postSlackMessage(message)
+}
+
+// slackSectionLimit is the maximum length of a section block's text.
+const slackSectionLimit = 3000
+
+// SendSlackErrorFeed posts newly seen ClickHouse errors from tail mode, one
+// per line, truncating the list to fit a single message.
+func SendSlackErrorFeed(lines []string) error {
+	body := sanitizeSlackText(strings.Join(lines, "\n"))
+	if limit := slackSectionLimit - len("```\n\n…\n```"); len(body) > limit {
+		cut := strings.LastIndex(body[:limit], "\n")
+		if cut < 0 {
+			cut = limit
+		}
+		body = body[:cut] + "\n…"
+	}
+	text := "```\n" + body + "\n```"
+
+	message := SlackMessage{
+		Blocks: []SlackBlock{
+			{
+				Type: "section",
+				Text: &SlackText{
+					Type: "mrkdwn",
+					Text: fmt.Sprintf("*%d new ClickHouse error(s)*", len(lines)),
+				},
+			},
+			{
+				Type: "section",
+				Text: &SlackText{
+					Type: "mrkdwn",
+					Text: text,
+				},
+			},
+		},
+	}
+	return postSlackMessage(message)
+}
+
+// errSlackRateLimited is returned for a message dropped by
+// slack.max_messages_per_minute.
+var errSlackRateLimited = errors.New("slack message dropped: slack.max_messages_per_minute reached")
+
+// slackRateLimiter caps outbound Slack messages per minute. Messages over the
+// cap are dropped and counted; the count is reported with the next message
+// sent once the minute has passed, or on its own by flush if none is.
+type slackRateLimiter struct {
+	mu           sync.Mutex
+	now          func() time.Time
+	start        time.Time
+	sent         int
+	suppressed   int
+	flushPending bool
+}
+
+var defaultSlackLimiter = &slackRateLimiter{now: time.Now}
+
+// allow reports whether another message fits within max per minute and, if
+// so, how many messages were dropped since the last one sent. For the first
+// message dropped while no flush is pending, flushIn is the time left in the
+// minute, after which the caller should call flush. max <= 0 disables the
+// limit.
+func (l *slackRateLimiter) allow(max int) (ok bool, suppressed int, flushIn time.Duration) {
+	if max <= 0 {
+		return true, 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.start) >= time.Minute {
+		l.start = now
+		l.sent = 0
+	}
+	if l.sent >= max {
+		l.suppressed++
+		if !l.flushPending {
+			l.flushPending = true
+			flushIn = l.start.Add(time.Minute).Sub(now)
+		}
+		return false, 0, flushIn
+	}
+	l.sent++
+	suppressed = l.suppressed
+	l.suppressed = 0
+	return true, suppressed, 0
+}
+
+// flush returns the count of dropped messages that no later message reported,
+// once the minute they were dropped in has passed, and counts the notice for
+// them as a message sent. If messages are being dropped in a minute that is
+// still running, it returns how long until that one ends instead.
+func (l *slackRateLimiter) flush() (suppressed int, retryIn time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if left := l.start.Add(time.Minute).Sub(now); left > 0 && l.suppressed > 0 {
+		return 0, left
+	}
+	l.flushPending = false
+	if l.suppressed == 0 {
+		return 0, 0
+	}
+	suppressed = l.suppressed
+	l.start = now
+	l.sent = 1
+	l.suppressed = 0
+	return suppressed, 0
+}
+
+// scheduleSlackFlush posts the count of dropped messages after d unless a
+// later message has reported it by then.
+func scheduleSlackFlush(d time.Duration) {
+	time.AfterFunc(d, func() {
+		suppressed, retryIn := defaultSlackLimiter.flush()
+		if retryIn > 0 {
+			scheduleSlackFlush(retryIn)
+			return
+		}
+		if suppressed == 0 {
+			return
+		}
+		webhookURL := viper.GetString("slack.webhook_url")
+		if webhookURL == "" {
+			return
+		}
+		message := SlackMessage{Blocks: []SlackBlock{suppressedBlock(suppressed)}}
+		if err := sendSlackWebhook(webhookURL, message); err != nil {
+			logrus.WithError(err).WithField("suppressed", suppressed).Error("Failed to send Slack suppressed-messages notice")
+		}
+	})
+}
+
+// suppressedBlock reports the count of messages dropped by the rate limit.
+func suppressedBlock(suppressed int) SlackBlock {
+	return SlackBlock{
+		Type: "context",
+		Elements: []SlackElement{{
+			Type: "mrkdwn",
+			Text: fmt.Sprintf("_%d earlier message(s) suppressed by slack.max_messages_per_minute_", suppressed),
+		}},
+	}
+}
+
+// postSlackMessage sends message to slack.webhook_url, unless that would exceed
+// slack.max_messages_per_minute, in which case the message is dropped and
+// errSlackRateLimited returned.
+func postSlackMessage(message SlackMessage) error {
+	webhookURL := viper.GetString("slack.webhook_url")
+	if webhookURL == "" {
+		return fmt.Errorf("slack webhook URL not configured")
+	}
+
+	ok, suppressed, flushIn := defaultSlackLimiter.allow(viper.GetInt("slack.max_messages_per_minute"))
+	if !ok {
+		if flushIn > 0 {
+			scheduleSlackFlush(flushIn)
+		}
+		return errSlackRateLimited
+	}
+	if suppressed > 0 {
+		message.Blocks = append(message.Blocks, suppressedBlock(suppressed))
+	}
+	return sendSlackWebhook(webhookURL, message)
+}
+
+// sendSlackWebhook posts message to webhookURL.
+func sendSlackWebhook(webhookURL string, message SlackMessage) error {
 	jsonData, err := json.Marshal(message)
 	if err != nil {
 		return fmt.Errorf("error marshaling slack message: %v", err)
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// errorDelta is a system.errors row that appeared or whose count grew since
+// the previous poll.
+type errorDelta struct {
+	CHError
+	Delta uint64
+}
+
+// errorKey identifies a system.errors row across polls. system.errors has one
+// row per error name and remote flag on each host.
+func errorKey(e CHError) string {
+	return fmt.Sprintf("%s|%s|%d|%t", e.Hostname, e.Name, e.Code, e.Remote)
+}
+
+// diffErrors compares current against the counts seen on the previous poll and
+// returns the rows that are new or whose value increased, ordered by last error
+// time, together with the counts to compare the next poll against. A value
+// lower than before (the server restarted) counts in full.
+func diffErrors(prev map[string]uint64, current []CHError) ([]errorDelta, map[string]uint64) {
+	seen := make(map[string]uint64, len(current))
+	var deltas []errorDelta
+	for _, e := range current {
+		key := errorKey(e)
+		seen[key] = e.Value
+		before, ok := prev[key]
+		switch {
+		case !ok || e.Value < before:
+			deltas = append(deltas, errorDelta{CHError: e, Delta: e.Value})
+		case e.Value > before:
+			deltas = append(deltas, errorDelta{CHError: e, Delta: e.Value - before})
+		}
+	}
+	sort.SliceStable(deltas, func(i, j int) bool {
+		return deltas[i].LastErrorTime.Before(deltas[j].LastErrorTime)
+	})
+	return deltas, seen
+}
+
+// String renders d as one feed line.
+func (d errorDelta) String() string {
+	remote := ""
+	if d.Remote {
+		remote = " (remote)"
+	}
+	return fmt.Sprintf("%s %s %s(%d)%s +%d: %s",
+		d.LastErrorTime.UTC().Format(time.RFC3339), d.Hostname, d.Name, d.Code, remote, d.Delta,
+		strings.Join(strings.Fields(d.LastErrorMessage), " "))
+}
+
+// pollCHErrors returns the current system.errors counters from every replica.
+func pollCHErrors(ctx context.Context, conn driver.Conn) ([]CHError, error) {
+	cluster := viper.GetString("clickhouse.cluster")
+	query := "SELECT hostname() hostname, name, code, value, last_error_time, last_error_message, remote" +
+		" FROM clusterAllReplicas(" + cluster + ", system.errors)"
+	rows, err := conn.Query(ctx, query)
+	usage.recordQuery(err)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
+
+	var errors []CHError
+	for rows.Next() {
+		var e CHError
+		if err := rows.Scan(&e.Hostname, &e.Name, &e.Code, &e.Value, &e.LastErrorTime, &e.LastErrorMessage, &e.Remote); err != nil {
+			return nil, err
+		}
+		errors = append(errors, e)
+	}
+	return errors, rows.Err()
+}
+
+// runTailErrors polls system.errors every tail_errors.interval until ctx is
+// done and prints errors that are new or incremented since the previous poll.
+// The first poll only records a baseline. With tail_errors.slack set, each
+// batch of new errors is also posted to the Slack webhook.
+func runTailErrors(ctx context.Context) error {
+	interval := appConfig.TailErrors.Interval
+	if interval < time.Second {
+		return fmt.Errorf("tail_errors.interval must be at least 1s, got %s", interval)
+	}
+	toSlack := appConfig.TailErrors.Slack
+
+	conn, err := connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing ClickHouse connection")
+		}
+	}()
+
+	current, err := pollCHErrors(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("reading system.errors: %w", err)
+	}
+	_, counts := diffErrors(nil, current)
+	logrus.WithFields(logrus.Fields{
+		"existing_errors": len(counts),
+		"interval":        interval.String(),
+		"slack":           toSlack,
+	}).Info("Tailing system.errors; showing new errors only")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := pollCHErrors(ctx, conn)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logDeduped(logrus.WithError(err), logrus.WarnLevel, "Polling system.errors failed")
+			continue
+		}
+		var deltas []errorDelta
+		deltas, counts = diffErrors(counts, current)
+		if len(deltas) == 0 {
+			continue
+		}
+
+		lines := make([]string, len(deltas))
+		for i, d := range deltas {
+			lines[i] = d.String()
+			fmt.Println(lines[i])
+		}
+		if toSlack {
+			if err := SendSlackErrorFeed(lines); errors.Is(err, errSlackRateLimited) {
+				logDeduped(logrus.WithError(err), logrus.WarnLevel, "Slack message dropped")
+			} else if err != nil {
+				logrus.WithError(err).Error("Failed to send Slack message")
+			}
+		}
+	}
+}
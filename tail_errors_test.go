@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiffErrors(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	base := []CHError{
+		{Hostname: "host1", Name: "NETWORK_ERROR", Code: 210, Value: 5, LastErrorTime: t0},
+		{Hostname: "host2", Name: "NETWORK_ERROR", Code: 210, Value: 3, LastErrorTime: t0},
+		{Hostname: "host1", Name: "MEMORY_LIMIT_EXCEEDED", Code: 241, Value: 7, LastErrorTime: t0},
+	}
+
+	deltas, counts := diffErrors(nil, base)
+	if len(deltas) != 3 || len(counts) != 3 {
+		t.Fatalf("first poll: got %d deltas, %d counts, want 3, 3", len(deltas), len(counts))
+	}
+
+	next := []CHError{
+		{Hostname: "host1", Name: "NETWORK_ERROR", Code: 210, Value: 9, LastErrorTime: t0.Add(2 * time.Minute)},
+		{Hostname: "host2", Name: "NETWORK_ERROR", Code: 210, Value: 3, LastErrorTime: t0},
+		{Hostname: "host1", Name: "MEMORY_LIMIT_EXCEEDED", Code: 241, Value: 2, LastErrorTime: t0.Add(3 * time.Minute)},
+		{Hostname: "host1", Name: "NETWORK_ERROR", Code: 210, Value: 1, Remote: true, LastErrorTime: t0.Add(time.Minute)},
+	}
+	deltas, counts = diffErrors(counts, next)
+
+	want := []struct {
+		host   string
+		name   string
+		remote bool
+		delta  uint64
+	}{
+		{host: "host1", name: "NETWORK_ERROR", remote: true, delta: 1},
+		{host: "host1", name: "NETWORK_ERROR", delta: 4},
+		{host: "host1", name: "MEMORY_LIMIT_EXCEEDED", delta: 2},
+	}
+	if len(deltas) != len(want) {
+		t.Fatalf("second poll: got %d deltas, want %d: %+v", len(deltas), len(want), deltas)
+	}
+	for i, w := range want {
+		d := deltas[i]
+		if d.Hostname != w.host || d.Name != w.name || d.Remote != w.remote || d.Delta != w.delta {
+			t.Errorf("delta[%d] = %s %s remote=%v +%d, want %s %s remote=%v +%d",
+				i, d.Hostname, d.Name, d.Remote, d.Delta, w.host, w.name, w.remote, w.delta)
+		}
+	}
+	if counts[errorKey(next[0])] != 9 || len(counts) != 4 {
+		t.Errorf("counts after second poll = %v", counts)
+	}
+
+	if deltas, _ = diffErrors(counts, next); len(deltas) != 0 {
+		t.Errorf("unchanged poll: got %d deltas, want 0", len(deltas))
+	}
+}
+
+func TestErrorDeltaString(t *testing.T) {
+	d := errorDelta{
+		CHError: CHError{
+			Hostname:         "host1",
+			Name:             "NETWORK_ERROR",
+			Code:             210,
+			LastErrorTime:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+			LastErrorMessage: "Connection reset\n  by peer",
+			Remote:           true,
+		},
+		Delta: 4,
+	}
+	want := "2024-01-01T12:00:00Z host1 NETWORK_ERROR(210) (remote) +4: Connection reset by peer"
+	if got := d.String(); got != want {
+		t.Errorf("errorDelta.String() = %q, want %q", got, want)
+	}
+}
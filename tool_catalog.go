@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// serverImpl identifies this MCP server to clients and on the landing page.
+var serverImpl = &mcp.Implementation{Name: "housekeeper-clickhouse-mcp", Title: "Housekeeper ClickHouse", Version: "0.3.0"}
+
+// toolCatalog records every tool registered through addTool, in registration
+// order. The go-sdk server doesn't expose its tool list, so we keep our own.
+var toolCatalog []*mcp.Tool
+
+// toolEnabled reports whether mcp.enabled_tools allows the named tool. An
+// empty list enables every tool.
+func toolEnabled(name string) bool {
+	enabled := viper.GetStringSlice("mcp.enabled_tools")
+	if len(enabled) == 0 {
+		return true
+	}
+	for _, n := range enabled {
+		if strings.TrimSpace(n) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// addTool registers a tool on srv and records it in toolCatalog, unless
+// mcp.enabled_tools leaves it out. Calls are counted in the usage summary.
+func addTool[In, Out any](srv *mcp.Server, t *mcp.Tool, h mcp.ToolHandlerFor[In, Out]) {
+	if !toolEnabled(t.Name) {
+		logrus.WithField("tool", t.Name).Info("Tool not in mcp.enabled_tools; not registering it")
+		return
+	}
+	mcp.AddTool(srv, t, func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[In]) (*mcp.CallToolResultFor[Out], error) {
+		res, err := h(ctx, ss, req)
+		usage.recordTool(err)
+		return res, err
+	})
+	toolCatalog = append(toolCatalog, t)
+}
+
+// listedTool is one entry of the --list-tools output.
+type listedTool struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	InputSchema *jsonschema.Schema `json:"inputSchema"`
+}
+
+// listTools writes the tools srv serves, sorted by name, to w as a
+// JSON array. They are read back over an in-memory MCP session, so the schemas
+// are exactly what a client's tools/list returns.
+func listTools(ctx context.Context, srv *mcp.Server, w io.Writer) error {
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	ss, err := srv.Connect(ctx, serverTransport)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = ss.Close() }()
+	cs, err := mcp.NewClient(&mcp.Implementation{Name: "housekeeper-list-tools", Version: serverImpl.Version}, nil).Connect(ctx, clientTransport)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cs.Close() }()
+
+	tools := []listedTool{}
+	for t, err := range cs.Tools(ctx, nil) {
+		if err != nil {
+			return err
+		}
+		tools = append(tools, listedTool{Name: t.Name, Description: t.Description, InputSchema: t.InputSchema})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(tools)
+}
+
+// runListTools builds the MCP server this configuration would run, without
+// connecting to ClickHouse or serving, and prints its tool catalog to stdout.
+func runListTools(ctx context.Context) error {
+	srv, err := buildMCPServer()
+	if err != nil {
+		return err
+	}
+	return listTools(ctx, srv, os.Stdout)
+}
+
+var landingPageTmpl = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Name}} {{.Version}} — Model Context Protocol server. Point an MCP client at this URL.</p>
+{{if .Banner}}<pre>{{.Banner}}</pre>{{end}}
+{{if .Tools}}<h2>Tools</h2>
+<ul>
+{{range .Tools}}<li><code>{{.Name}}</code>{{if .Title}} — {{.Title}}{{end}}<pre>{{.Description}}</pre></li>
+{{end}}</ul>
+{{end}}
+<h2>Endpoints</h2>
+<ul>
+<li><code>/</code> — MCP streamable HTTP transport{{if .Auth}} (bearer token required){{end}}</li>
+<li><code>/health</code> — health check</li>
+</ul>
+</body>
+</html>
+`))
+
+// isBrowserRequest reports whether r looks like a human opening the server URL
+// in a browser, as opposed to an MCP client (which POSTs, or GETs with
+// Accept: text/event-stream).
+func isBrowserRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/html") && !strings.Contains(accept, "text/event-stream")
+}
+
+// landingPageMiddleware serves a small HTML page describing the server and its
+// tools to browser GETs on /, and passes everything else through to next. The
+// page needs no token, so when authenticated is true it shows only the server
+// name and endpoints: the banner, tool list and descriptions may carry
+// deployment-specific details that only authorized MCP clients should see.
+func landingPageMiddleware(authenticated bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" || !isBrowserRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		data := struct {
+			*mcp.Implementation
+			Banner string
+			Tools  []*mcp.Tool
+			Auth   bool
+		}{
+			Implementation: serverImpl,
+			Auth:           authenticated,
+		}
+		if !authenticated {
+			data.Banner = strings.TrimSpace(viper.GetString("http.banner"))
+			data.Tools = toolCatalog
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := landingPageTmpl.Execute(w, data); err != nil {
+			logrus.WithError(err).Warn("Error rendering landing page")
+		}
+	})
+}
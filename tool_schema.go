@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// agentToolSchema returns the JSON schema of the arguments of an agent
+// function (query_clickhouse_system_table for the Gemini agents, run_sql for
+// diagnose), or nil for an unknown name.
+func agentToolSchema(name string) map[string]any {
+	switch name {
+	case "query_clickhouse_system_table":
+		return genaiSchemaJSON(querySystemTableTool.FunctionDeclarations[0].Parameters)
+	case "run_sql":
+		return runSQLInputSchema
+	}
+	return nil
+}
+
+// genaiSchemaJSON converts a Gemini schema to the equivalent JSON schema.
+func genaiSchemaJSON(s *genai.Schema) map[string]any {
+	out := map[string]any{"type": strings.ToLower(string(s.Type))}
+	if s.Description != "" {
+		out["description"] = s.Description
+	}
+	if len(s.Properties) > 0 {
+		props := make(map[string]any, len(s.Properties))
+		for name, p := range s.Properties {
+			props[name] = genaiSchemaJSON(p)
+		}
+		out["properties"] = props
+	}
+	if s.Items != nil {
+		out["items"] = genaiSchemaJSON(s.Items)
+	}
+	if len(s.Required) > 0 {
+		out["required"] = s.Required
+	}
+	return out
+}
+
+// withToolSchemas appends the argument schema of each of tools to an agent's
+// system prompt, so the model sees the exact argument names and types rather
+// than only the function declarations.
+func withToolSchemas(prompt string, tools ...string) string {
+	var b strings.Builder
+	for _, name := range tools {
+		schema := agentToolSchema(name)
+		if schema == nil {
+			continue
+		}
+		data, err := json.Marshal(schema)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "\n- %s: %s", name, data)
+	}
+	if b.Len() == 0 {
+		return prompt
+	}
+	return prompt + "\n\nFunction arguments must conform to these JSON schemas. Use only the listed argument names, always include the required ones, and use the stated types; calls that don't conform are rejected." + b.String()
+}
+
+// checkToolArgs reports arguments that don't conform to schema: missing
+// required arguments, unknown names and values of the wrong type. The error
+// restates the expected arguments so the model can correct its next call.
+func checkToolArgs(schema, args map[string]any) error {
+	if schema == nil {
+		return nil
+	}
+	props, _ := schema["properties"].(map[string]any)
+	var problems []string
+	for _, name := range requiredArgs(schema) {
+		if args[name] == nil {
+			problems = append(problems, fmt.Sprintf("missing required argument %q", name))
+		}
+	}
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prop, ok := props[name].(map[string]any)
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("unknown argument %q", name))
+		case args[name] != nil && !matchesSchemaType(prop, args[name]):
+			problems = append(problems, fmt.Sprintf("argument %q must be %s", name, schemaTypeName(prop)))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid arguments: %s; expected %s", strings.Join(problems, "; "), argsSignature(schema))
+}
+
+// requiredArgs returns schema's required argument names.
+func requiredArgs(schema map[string]any) []string {
+	switch r := schema["required"].(type) {
+	case []string:
+		return r
+	case []any:
+		names := make([]string, 0, len(r))
+		for _, n := range r {
+			names = append(names, fmt.Sprint(n))
+		}
+		return names
+	}
+	return nil
+}
+
+// matchesSchemaType reports whether v, as decoded from JSON, has the type
+// schema declares. Schemas without a type accept anything.
+func matchesSchemaType(schema map[string]any, v any) bool {
+	switch schema["type"] {
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := schemaNumber(v)
+		return ok
+	case "integer":
+		f, ok := schemaNumber(v)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return false
+		}
+		itemSchema, _ := schema["items"].(map[string]any)
+		for _, item := range items {
+			if itemSchema != nil && !matchesSchemaType(itemSchema, item) {
+				return false
+			}
+		}
+		return true
+	}
+	return true
+}
+
+// schemaNumber returns v as a float64 if it is a number: a float64 from
+// encoding/json, an int, or a json.Number-like value from a Bedrock tool
+// input.
+func schemaNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case interface{ Float64() (float64, error) }:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// schemaTypeName describes schema's type, e.g. "string" or "array of string".
+func schemaTypeName(schema map[string]any) string {
+	t, _ := schema["type"].(string)
+	if t == "" {
+		return "any"
+	}
+	if items, ok := schema["items"].(map[string]any); ok && t == "array" {
+		return "array of " + schemaTypeName(items)
+	}
+	return t
+}
+
+// argsSignature renders schema's arguments compactly, e.g.
+// "{table: string (required), limit: number}".
+func argsSignature(schema map[string]any) string {
+	props, _ := schema["properties"].(map[string]any)
+	required := make(map[string]bool)
+	for _, name := range requiredArgs(schema) {
+		required[name] = true
+	}
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if required[names[i]] != required[names[j]] {
+			return required[names[i]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, len(names))
+	for i, name := range names {
+		prop, _ := props[name].(map[string]any)
+		parts[i] = name + ": " + schemaTypeName(prop)
+		if required[name] {
+			parts[i] += " (required)"
+		}
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
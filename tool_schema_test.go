@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCheckToolArgs(t *testing.T) {
+	schema := agentToolSchema("query_clickhouse_system_table")
+
+	tests := []struct {
+		name string
+		args map[string]any
+		want []string // substrings of the error; nil = valid
+	}{
+		{name: "valid", args: map[string]any{"table": "system.parts", "columns": []any{"name", "rows"}, "limit": float64(10)}},
+		{name: "optional null", args: map[string]any{"table": "system.parts", "where": nil}},
+		{name: "missing required", args: map[string]any{"where": "active"}, want: []string{`missing required argument "table"`}},
+		{name: "invented name", args: map[string]any{"table": "system.parts", "filter": "active"}, want: []string{`unknown argument "filter"`}},
+		{name: "wrong type", args: map[string]any{"table": "system.parts", "limit": "10"}, want: []string{`argument "limit" must be number`}},
+		{name: "wrong item type", args: map[string]any{"table": "system.parts", "columns": []any{"name", float64(1)}}, want: []string{`argument "columns" must be array of string`}},
+		{name: "columns as a string", args: map[string]any{"table": "system.parts", "columns": "name"}, want: []string{"array of string"}},
+		{name: "restates the arguments", args: map[string]any{}, want: []string{"expected {table: string (required), columns: array of string, limit: number, order_by: string, where: string}"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkToolArgs(schema, tt.args)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("checkToolArgs() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("checkToolArgs() error = nil")
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("checkToolArgs() error = %q, want it to contain %q", err, w)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckToolArgsRunSQL(t *testing.T) {
+	if err := checkToolArgs(runSQLInputSchema, map[string]any{"sql": "SELECT 1"}); err != nil {
+		t.Errorf("checkToolArgs() error = %v", err)
+	}
+	if err := checkToolArgs(runSQLInputSchema, map[string]any{"query": "SELECT 1"}); err == nil || !strings.Contains(err.Error(), `missing required argument "sql"`) {
+		t.Errorf("checkToolArgs() error = %v, want missing sql", err)
+	}
+	if err := checkToolArgs(runSQLInputSchema, map[string]any{"sql": "SELECT 1", "limit": json.Number("5")}); err == nil || !strings.Contains(err.Error(), `unknown argument "limit"`) {
+		t.Errorf("checkToolArgs() error = %v, want unknown limit", err)
+	}
+}
+
+func TestWithToolSchemas(t *testing.T) {
+	got := withToolSchemas("base", "query_clickhouse_system_table")
+	if !strings.HasPrefix(got, "base\n\nFunction arguments must conform") {
+		t.Errorf("withToolSchemas() = %q", got)
+	}
+	_, schemaJSON, ok := strings.Cut(got, "- query_clickhouse_system_table: ")
+	if !ok {
+		t.Fatalf("withToolSchemas() = %q, missing the schema", got)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(schemaJSON), &schema); err != nil {
+		t.Fatalf("schema is not JSON: %v", err)
+	}
+	if req := requiredArgs(schema); len(req) != 1 || req[0] != "table" {
+		t.Errorf("required = %v, want [table]", req)
+	}
+	if got := withToolSchemas("base", "unknown"); got != "base" {
+		t.Errorf("withToolSchemas() with no known tools = %q, want base", got)
+	}
+}
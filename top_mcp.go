@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// topArgs is the input to the clickhouse_top tool.
+type topArgs struct {
+	Metric  string `json:"metric" jsonschema:"what to rank query patterns by: duration, memory, read_rows or read_bytes"`
+	Since   string `json:"since,omitempty" jsonschema:"how far back to look as a Go duration (e.g. 6h); default 1h, maximum 720h"`
+	N       int    `json:"n,omitempty" jsonschema:"number of query patterns to return; default 10, maximum 100"`
+	Cluster string `json:"cluster,omitempty" jsonschema:"cluster to aggregate across; defaults to the configured cluster"`
+}
+
+// topMetric is how one clickhouse_top metric is computed from query_log.
+type topMetric struct {
+	column string
+	// rank is the aggregate patterns are ordered by: sum for cumulative cost,
+	// max for peaks such as memory.
+	rank string
+	unit string // for prettyNumericWithUnits
+}
+
+// topMetrics are the metrics clickhouse_top accepts.
+var topMetrics = map[string]topMetric{
+	"duration":   {column: "query_duration_ms", rank: "sum", unit: "milliseconds"},
+	"memory":     {column: "memory_usage", rank: "max", unit: "bytes"},
+	"read_rows":  {column: "read_rows", rank: "sum"},
+	"read_bytes": {column: "read_bytes", rank: "sum", unit: "bytes"},
+}
+
+// topQuery is one query pattern in the clickhouse_top ranking.
+type topQuery struct {
+	Rank                int      `json:"rank"`
+	NormalizedQueryHash uint64   `json:"normalized_query_hash"`
+	Queries             uint64   `json:"queries" jsonschema:"finished queries with this pattern in the window"`
+	Total               float64  `json:"total" jsonschema:"sum of the metric over those queries"`
+	Max                 float64  `json:"max" jsonschema:"largest value of the metric for a single query"`
+	Users               []string `json:"users"`
+	Example             string   `json:"example" jsonschema:"one query with this pattern, truncated"`
+}
+
+// topResult is the structured output of clickhouse_top.
+type topResult struct {
+	Metric  string     `json:"metric"`
+	Column  string     `json:"column" jsonschema:"system.query_log column the metric is read from"`
+	RankBy  string     `json:"rank_by" jsonschema:"sum or max"`
+	Since   time.Time  `json:"since"`
+	Queries []topQuery `json:"queries"`
+}
+
+const (
+	defaultTopWindow    = time.Hour
+	maxTopWindow        = 30 * 24 * time.Hour
+	defaultTopN         = 10
+	maxTopN             = 100
+	maxTopExampleLength = 500
+	maxTopUsersPerQuery = 5
+)
+
+// topMetricNames returns the accepted metric names, sorted.
+func topMetricNames() []string {
+	names := make([]string, 0, len(topMetrics))
+	for name := range topMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseTopArgs validates the metric against topMetrics and applies defaults
+// and bounds to the window and N.
+func parseTopArgs(a topArgs) (string, topMetric, time.Duration, int, error) {
+	name := strings.ToLower(strings.TrimSpace(a.Metric))
+	m, ok := topMetrics[name]
+	if !ok {
+		return "", topMetric{}, 0, 0, fmt.Errorf("metric must be one of %s", strings.Join(topMetricNames(), ", "))
+	}
+
+	window := defaultTopWindow
+	if strings.TrimSpace(a.Since) != "" {
+		d, err := time.ParseDuration(a.Since)
+		if err != nil {
+			return "", topMetric{}, 0, 0, fmt.Errorf("invalid since: %v", err)
+		}
+		window = d
+	}
+	if window <= 0 || window > maxTopWindow {
+		return "", topMetric{}, 0, 0, fmt.Errorf("since must be positive and at most %s", maxTopWindow)
+	}
+
+	n := a.N
+	if n == 0 {
+		n = defaultTopN
+	}
+	if n < 1 || n > maxTopN {
+		return "", topMetric{}, 0, 0, fmt.Errorf("n must be between 1 and %d", maxTopN)
+	}
+	return name, m, window, n, nil
+}
+
+// buildTopQuery returns the query_log aggregation ranking query patterns by m
+// on every replica of cluster, and its bind arguments. Only initial queries
+// count, so a distributed query isn't counted once per shard. Aggregates are
+// Float64 since memory_usage is signed and the other columns aren't.
+func buildTopQuery(cluster string, m topMetric, window time.Duration, n int) (string, []interface{}) {
+	secs := int64(window / time.Second)
+	query := fmt.Sprintf("SELECT normalized_query_hash, count() AS queries, toFloat64(sum(%[1]s)) AS total, toFloat64(max(%[1]s)) AS peak,"+
+		" groupUniqArray(%[2]d)(user) AS users, any(query) AS example"+
+		" FROM clusterAllReplicas(?, system.query_log)"+
+		" WHERE type = 'QueryFinish' AND is_initial_query"+
+		" AND event_date >= toDate(now() - toIntervalSecond(?)) AND event_time >= now() - toIntervalSecond(?)"+
+		" GROUP BY normalized_query_hash"+
+		" ORDER BY %[3]s DESC LIMIT ?", m.column, maxTopUsersPerQuery, rankColumn(m))
+	return query, []interface{}{cluster, secs, secs, n}
+}
+
+// rankColumn is the result column buildTopQuery orders by.
+func rankColumn(m topMetric) string {
+	if m.rank == "max" {
+		return "peak"
+	}
+	return "total"
+}
+
+func registerTopTool(srv *mcp.Server) {
+	addTool[topArgs, *topResult](
+		srv,
+		&mcp.Tool{
+			Name:        "clickhouse_top",
+			Title:       "Top query patterns",
+			Description: `Rank query patterns (normalized_query_hash) in system.query_log across replicas by a metric over a time window, e.g. "top 10 queries by memory in the last hour". metric is one of duration (total query_duration_ms), memory (peak memory_usage), read_rows or read_bytes (totals). Returns each pattern's query count, total, peak, users and an example query. Prefer this over hand-written query_log aggregations.`,
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		},
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[topArgs]) (*mcp.CallToolResultFor[*topResult], error) {
+			a := req.Arguments
+			name, m, window, n, err := parseTopArgs(a)
+			if err != nil {
+				return nil, err
+			}
+			cluster := strings.TrimSpace(a.Cluster)
+			if cluster == "" {
+				cluster = viper.GetString("clickhouse.cluster")
+			}
+
+			conn, err := connect()
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				if err := conn.Close(); err != nil {
+					logrus.WithError(err).Warn("Error closing ClickHouse connection")
+				}
+			}()
+
+			res, err := fetchTop(ctx, conn, cluster, name, m, window, n)
+			if err != nil {
+				return nil, err
+			}
+			return &mcp.CallToolResultFor[*topResult]{
+				Content:           []mcp.Content{&mcp.TextContent{Text: summarizeTop(res, m)}},
+				StructuredContent: res,
+			}, nil
+		},
+	)
+}
+
+// fetchTop runs the ranking query and truncates long example queries.
+func fetchTop(ctx context.Context, conn driver.Conn, cluster, name string, m topMetric, window time.Duration, n int) (*topResult, error) {
+	query, args := buildTopQuery(cluster, m, window, n)
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing rows")
+		}
+	}()
+
+	res := &topResult{Metric: name, Column: m.column, RankBy: m.rank, Since: time.Now().Add(-window).UTC(), Queries: []topQuery{}}
+	for rows.Next() {
+		var q topQuery
+		if err := rows.Scan(&q.NormalizedQueryHash, &q.Queries, &q.Total, &q.Max, &q.Users, &q.Example); err != nil {
+			return nil, err
+		}
+		q.Rank = len(res.Queries) + 1
+		if len(q.Example) > maxTopExampleLength {
+			q.Example = q.Example[:maxTopExampleLength] + "..."
+		}
+		res.Queries = append(res.Queries, q)
+	}
+	return res, rows.Err()
+}
+
+// summarizeTop renders one line per query pattern, in rank order.
+func summarizeTop(res *topResult, m topMetric) string {
+	if len(res.Queries) == 0 {
+		return fmt.Sprintf("No finished queries since %s.", res.Since.Format(time.RFC3339))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Top %d query pattern(s) by %s (%s of %s) since %s:", len(res.Queries), res.Metric, res.RankBy, res.Column, res.Since.Format(time.RFC3339))
+	for _, q := range res.Queries {
+		example := strings.Join(strings.Fields(q.Example), " ")
+		if len(example) > 150 {
+			example = example[:150] + "..."
+		}
+		fmt.Fprintf(&b, "\n%d. hash %d: %d queries, total %s, peak %s, users %s: %s",
+			q.Rank, q.NormalizedQueryHash, q.Queries,
+			prettyNumericWithUnits(m.unit, q.Total), prettyNumericWithUnits(m.unit, q.Max),
+			strings.Join(q.Users, ", "), example)
+	}
+	return b.String()
+}
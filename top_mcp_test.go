@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTopArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       topArgs
+		wantMetric string
+		wantWindow time.Duration
+		wantN      int
+		wantErr    string
+	}{
+		{name: "defaults", args: topArgs{Metric: "memory"}, wantMetric: "memory", wantWindow: time.Hour, wantN: 10},
+		{name: "explicit", args: topArgs{Metric: " Duration ", Since: "6h", N: 25}, wantMetric: "duration", wantWindow: 6 * time.Hour, wantN: 25},
+		{name: "missing metric", args: topArgs{}, wantErr: "metric must be one of duration, memory, read_bytes, read_rows"},
+		{name: "unknown metric", args: topArgs{Metric: "cpu"}, wantErr: "metric must be one of"},
+		{name: "window too long", args: topArgs{Metric: "read_rows", Since: "721h"}, wantErr: "since must be"},
+		{name: "invalid window", args: topArgs{Metric: "read_rows", Since: "yesterday"}, wantErr: "invalid since"},
+		{name: "n over max", args: topArgs{Metric: "read_bytes", N: 101}, wantErr: "n must be between 1 and 100"},
+		{name: "negative n", args: topArgs{Metric: "read_bytes", N: -1}, wantErr: "n must be"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, m, window, n, err := parseTopArgs(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseTopArgs() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTopArgs() error = %v", err)
+			}
+			if name != tt.wantMetric || m != topMetrics[tt.wantMetric] || window != tt.wantWindow || n != tt.wantN {
+				t.Errorf("parseTopArgs() = %s, %+v, %s, %d", name, m, window, n)
+			}
+		})
+	}
+}
+
+func TestBuildTopQuery(t *testing.T) {
+	query, args := buildTopQuery("main", topMetrics["memory"], 2*time.Hour, 5)
+	for _, want := range []string{
+		"toFloat64(sum(memory_usage)) AS total, toFloat64(max(memory_usage)) AS peak",
+		"FROM clusterAllReplicas(?, system.query_log)",
+		"type = 'QueryFinish' AND is_initial_query",
+		"GROUP BY normalized_query_hash",
+		"ORDER BY peak DESC LIMIT ?",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query missing %q: %s", want, query)
+		}
+	}
+	wantArgs := []interface{}{"main", int64(7200), int64(7200), 5}
+	for i := range wantArgs {
+		if args[i] != wantArgs[i] {
+			t.Errorf("args[%d] = %v, want %v", i, args[i], wantArgs[i])
+		}
+	}
+
+	query, _ = buildTopQuery("main", topMetrics["duration"], time.Hour, 5)
+	if !strings.Contains(query, "toFloat64(sum(query_duration_ms)) AS total") || !strings.Contains(query, "ORDER BY total DESC") {
+		t.Errorf("duration query = %s", query)
+	}
+}
+
+func TestSummarizeTop(t *testing.T) {
+	since := time.Date(2026, 10, 15, 11, 0, 0, 0, time.UTC)
+	if got := summarizeTop(&topResult{Since: since}, topMetrics["memory"]); got != "No finished queries since 2026-10-15T11:00:00Z." {
+		t.Errorf("empty summary = %q", got)
+	}
+	res := &topResult{Metric: "memory", Column: "memory_usage", RankBy: "max", Since: since, Queries: []topQuery{
+		{Rank: 1, NormalizedQueryHash: 42, Queries: 3, Total: 3 << 30, Max: 2 << 30, Users: []string{"app", "etl"}, Example: "SELECT\n  *\nFROM events"},
+	}}
+	got := summarizeTop(res, topMetrics["memory"])
+	duration := summarizeTop(&topResult{Metric: "duration", Column: "query_duration_ms", RankBy: "sum", Since: since, Queries: []topQuery{
+		{Rank: 1, NormalizedQueryHash: 7, Queries: 2, Total: 1500, Max: 1000, Users: []string{"app"}, Example: "SELECT 1"},
+	}}, topMetrics["duration"])
+	if !strings.Contains(duration, "total 1500ms (1.500s), peak 1000ms (1.000s)") {
+		t.Errorf("duration summary = %q", duration)
+	}
+	for _, want := range []string{"Top 1 query pattern(s) by memory (max of memory_usage)", "1. hash 42: 3 queries, total 3.00 GB, peak 2.00 GB, users app, etl: SELECT * FROM events"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("summary missing %q:\n%s", want, got)
+		}
+	}
+}
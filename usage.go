@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// usageCounters aggregates tool and backend usage between summary log lines.
+// All fields are updated atomically, so handlers can record from any
+// goroutine.
+type usageCounters struct {
+	toolCalls    atomic.Int64
+	toolErrors   atomic.Int64
+	queries      atomic.Int64
+	queryErrors  atomic.Int64
+	llmCalls     atomic.Int64
+	inputTokens  atomic.Int64
+	outputTokens atomic.Int64
+}
+
+var usage = &usageCounters{}
+
+// recordTool counts one tool call and whether it failed.
+func (u *usageCounters) recordTool(err error) {
+	u.toolCalls.Add(1)
+	if err != nil {
+		u.toolErrors.Add(1)
+	}
+}
+
+// recordQuery counts one ClickHouse query and whether it failed.
+func (u *usageCounters) recordQuery(err error) {
+	u.queries.Add(1)
+	if err != nil {
+		u.queryErrors.Add(1)
+	}
+}
+
+// recordLLM counts one LLM request and its token usage.
+func (u *usageCounters) recordLLM(inputTokens, outputTokens int64) {
+	u.llmCalls.Add(1)
+	u.inputTokens.Add(inputTokens)
+	u.outputTokens.Add(outputTokens)
+}
+
+// drain returns the counts since the last drain and resets them.
+func (u *usageCounters) drain() logrus.Fields {
+	return logrus.Fields{
+		"tool_calls":    u.toolCalls.Swap(0),
+		"tool_errors":   u.toolErrors.Swap(0),
+		"queries":       u.queries.Swap(0),
+		"query_errors":  u.queryErrors.Swap(0),
+		"llm_calls":     u.llmCalls.Swap(0),
+		"input_tokens":  u.inputTokens.Swap(0),
+		"output_tokens": u.outputTokens.Swap(0),
+	}
+}
+
+// logUsage logs a usage summary every interval until ctx is done. An interval
+// <= 0 disables it.
+func logUsage(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			logrus.WithFields(usage.drain()).WithField("interval", interval.String()).Info("Usage summary")
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestUsageCounters(t *testing.T) {
+	u := &usageCounters{}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if i%10 == 0 {
+				err = errors.New("boom")
+			}
+			u.recordTool(err)
+			u.recordQuery(err)
+			u.recordLLM(100, 10)
+		}(i)
+	}
+	wg.Wait()
+
+	want := logrus.Fields{
+		"tool_calls":    int64(50),
+		"tool_errors":   int64(5),
+		"queries":       int64(50),
+		"query_errors":  int64(5),
+		"llm_calls":     int64(50),
+		"input_tokens":  int64(5000),
+		"output_tokens": int64(500),
+	}
+	got := u.drain()
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("drain()[%q] = %v, want %v", k, got[k], v)
+		}
+	}
+	for k, v := range u.drain() {
+		if v != int64(0) {
+			t.Errorf("after drain, %q = %v, want 0", k, v)
+		}
+	}
+}
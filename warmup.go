@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// warmupTimeout bounds each startup probe.
+const warmupTimeout = 10 * time.Second
+
+// startupProbe checks one backend at startup.
+type startupProbe struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// startupProbes returns a probe for ClickHouse and for each configured
+// Prometheus endpoint.
+func startupProbes() []startupProbe {
+	probes := []startupProbe{{name: "clickhouse", run: probeClickHouse}}
+	names := make([]string, 0, len(promClients))
+	for name := range promClients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		client := promClients[name]
+		probes = append(probes, startupProbe{name: name, run: func(ctx context.Context) error {
+			_, _, err := client.Query(ctx, "1", time.Now())
+			return err
+		}})
+	}
+	return probes
+}
+
+// probeClickHouse opens a connection, runs SELECT 1 on it and closes it. Tool
+// calls open connections of their own, so this doesn't save them any setup.
+func probeClickHouse(ctx context.Context) error {
+	conn, err := connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			logrus.WithError(err).Warn("Error closing ClickHouse connection")
+		}
+	}()
+	rows, err := conn.Query(ctx, "SELECT 1")
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// runWarmup runs probes one after another and logs each backend as ready or
+// unreachable, so connectivity problems (DNS, TLS, proxy) show up at startup
+// rather than on the first tool call. Failures are only logged; it reports
+// whether all passed.
+func runWarmup(ctx context.Context, probes []startupProbe) bool {
+	ok := true
+	for _, p := range probes {
+		pctx, cancel := context.WithTimeout(ctx, warmupTimeout)
+		start := time.Now()
+		err := p.run(pctx)
+		cancel()
+		entry := logrus.WithFields(logrus.Fields{"backend": p.name, "latency": time.Since(start).Round(time.Millisecond).String()})
+		if err != nil {
+			ok = false
+			entry.WithError(err).Warn("Startup probe failed; tool calls using this backend will fail until it is reachable")
+			continue
+		}
+		entry.Info("Backend ready")
+	}
+	return ok
+}
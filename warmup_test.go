@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunWarmup(t *testing.T) {
+	var ran []string
+	probe := func(name string, err error) startupProbe {
+		return startupProbe{name: name, run: func(ctx context.Context) error {
+			ran = append(ran, name)
+			if _, ok := ctx.Deadline(); !ok {
+				t.Errorf("probe %s ran without a deadline", name)
+			}
+			return err
+		}}
+	}
+
+	if !runWarmup(context.Background(), []startupProbe{probe("clickhouse", nil), probe("default", nil)}) {
+		t.Error("runWarmup() = false with all probes passing")
+	}
+	ran = nil
+	if runWarmup(context.Background(), []startupProbe{probe("clickhouse", errors.New("connection refused")), probe("default", nil)}) {
+		t.Error("runWarmup() = true with a failing probe")
+	}
+	if !equalSlices(ran, []string{"clickhouse", "default"}) {
+		t.Errorf("probes run = %v, want every probe despite the failure", ran)
+	}
+}
+
+func TestRunWarmupTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	slow := startupProbe{name: "slow", run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	if runWarmup(ctx, []startupProbe{slow}) {
+		t.Error("runWarmup() = true for a probe that timed out")
+	}
+}
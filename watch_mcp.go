@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// watchArgs is the input to clickhouse_watch: the clickhouse_query arguments
+// plus how often and how many times to run the query.
+type watchArgs struct {
+	queryArgs
+	Interval string `json:"interval,omitempty" jsonschema:"time between runs as a Go duration (e.g. 10s); default 10s, minimum 1s"`
+	Count    int    `json:"count,omitempty" jsonschema:"number of runs; default 6"`
+}
+
+// watchInputSchema is the input schema of clickhouse_watch. Schema inference
+// skips embedded structs, so the queryArgs properties are merged in here. The
+// types are fixed, so an error is a bug and panics, as mcp.AddTool does.
+func watchInputSchema() *jsonschema.Schema {
+	schema, err := jsonschema.For[queryArgs]()
+	if err != nil {
+		panic(err)
+	}
+	watch, err := jsonschema.For[watchArgs]()
+	if err != nil {
+		panic(err)
+	}
+	for name, prop := range watch.Properties {
+		schema.Properties[name] = prop
+	}
+	schema.Required = append(schema.Required, watch.Required...)
+	return schema
+}
+
+// watchSample is the result of one run.
+type watchSample struct {
+	Time           time.Time                `json:"time"`
+	Results        []map[string]interface{} `json:"results"`
+	Count          int                      `json:"count"`
+	QueryID        string                   `json:"query_id,omitempty"`
+	TruncatedCells int                      `json:"truncated_cells,omitempty" jsonschema:"number of string values truncated and marked ...(truncated); repeat with verbose for full values"`
+}
+
+// watchResult is the structured output of clickhouse_watch.
+type watchResult struct {
+	Samples []watchSample `json:"samples" jsonschema:"one entry per run, oldest first"`
+}
+
+const (
+	defaultWatchInterval = 10 * time.Second
+	defaultWatchCount    = 6
+	minWatchInterval     = time.Second
+)
+
+// parseWatchArgs applies defaults and enforces mcp.watch.max_count and
+// mcp.watch.max_duration (the span from first to last run).
+func parseWatchArgs(a watchArgs) (time.Duration, int, error) {
+	interval := defaultWatchInterval
+	if strings.TrimSpace(a.Interval) != "" {
+		d, err := time.ParseDuration(a.Interval)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid interval: %v", err)
+		}
+		interval = d
+	}
+	if interval < minWatchInterval {
+		return 0, 0, fmt.Errorf("interval must be at least %s", minWatchInterval)
+	}
+
+	count := a.Count
+	if count == 0 {
+		count = defaultWatchCount
+	}
+	if count < 1 {
+		return 0, 0, fmt.Errorf("count must be >= 1")
+	}
+	if maxCount := viper.GetInt("mcp.watch.max_count"); count > maxCount {
+		return 0, 0, fmt.Errorf("count must be <= %d", maxCount)
+	}
+	maxDuration := viper.GetDuration("mcp.watch.max_duration")
+	if span := interval * time.Duration(count-1); span > maxDuration {
+		return 0, 0, fmt.Errorf("interval × (count-1) = %s exceeds the %s limit; use a shorter interval or fewer runs", span, maxDuration)
+	}
+	return interval, count, nil
+}
+
+func registerWatchTool(srv *mcp.Server) {
+	addTool[watchArgs, *watchResult](
+		srv,
+		&mcp.Tool{
+			Name:        "clickhouse_watch",
+			Title:       "Watch a ClickHouse query over time",
+			Description: `Run a read-only clickhouse_query (same arguments) repeatedly, every interval for count runs, and return each run's rows with a timestamp. Use for short "is it recovering?" checks such as watching replication_queue size, merges in flight or running query count. Runs are bounded in number and total duration; keep queries small and aggregated. For metrics history, use prometheus_query instead.`,
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+			InputSchema: watchInputSchema(),
+		},
+		func(ctx context.Context, ss *mcp.ServerSession, req *mcp.CallToolParamsFor[watchArgs]) (*mcp.CallToolResultFor[*watchResult], error) {
+			a := req.Arguments
+			if err := validateQueryArgs(a.queryArgs); err != nil {
+				return validationErrorResult[*watchResult](err)
+			}
+			qa, err := applyQueryLogTimeFilter(a.queryArgs)
+			if err != nil {
+				return validationErrorResult[*watchResult](err)
+			}
+			interval, count, err := parseWatchArgs(a)
+			if err != nil {
+				return nil, err
+			}
+
+			conn, err := connect()
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				if err := conn.Close(); err != nil {
+					logrus.WithError(err).Warn("Error closing ClickHouse connection")
+				}
+			}()
+
+			query := buildQuery(qa)
+			ctx = withQuerySettings(ctx, qa.Settings)
+			res := &watchResult{}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for i := 0; i < count; i++ {
+				if i > 0 {
+					select {
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					case <-ticker.C:
+					}
+				}
+				qr, err := queryClickhouse(ctx, conn, query)
+				if err != nil {
+					return nil, fmt.Errorf("run %d of %d: %w", i+1, count, err)
+				}
+				sample := watchSample{
+					Time:    time.Now().UTC(),
+					Results: qr.Results,
+					Count:   qr.Count,
+					QueryID: qr.QueryID,
+				}
+				if !qa.Verbose {
+					sample.TruncatedCells = truncateCells(sample.Results, maxCellLength())
+				}
+				res.Samples = append(res.Samples, sample)
+			}
+
+			return &mcp.CallToolResultFor[*watchResult]{
+				Content:           []mcp.Content{&mcp.TextContent{Text: summarizeWatch(res)}},
+				StructuredContent: res,
+			}, nil
+		},
+	)
+}
+
+// summarizeWatch renders one line per run: its time and a row summary.
+func summarizeWatch(res *watchResult) string {
+	var b strings.Builder
+	for i, s := range res.Samples {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[%s] %s", s.Time.Format(time.RFC3339), strings.ReplaceAll(summarizeRows(s.Results), "\n", "; "))
+	}
+	return b.String()
+}
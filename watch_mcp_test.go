@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestParseWatchArgs(t *testing.T) {
+	viper.Set("mcp.watch.max_count", 30)
+	viper.Set("mcp.watch.max_duration", "2m")
+	defer viper.Set("mcp.watch.max_count", nil)
+	defer viper.Set("mcp.watch.max_duration", nil)
+
+	tests := []struct {
+		name         string
+		args         watchArgs
+		wantInterval time.Duration
+		wantCount    int
+		wantErr      bool
+	}{
+		{name: "defaults", args: watchArgs{}, wantInterval: 10 * time.Second, wantCount: 6},
+		{name: "explicit", args: watchArgs{Interval: "5s", Count: 12}, wantInterval: 5 * time.Second, wantCount: 12},
+		{name: "single run ignores span", args: watchArgs{Interval: "1h", Count: 1}, wantInterval: time.Hour, wantCount: 1},
+		{name: "span exactly at limit", args: watchArgs{Interval: "30s", Count: 5}, wantInterval: 30 * time.Second, wantCount: 5},
+		{name: "span over limit", args: watchArgs{Interval: "30s", Count: 6}, wantErr: true},
+		{name: "count over limit", args: watchArgs{Interval: "1s", Count: 31}, wantErr: true},
+		{name: "negative count", args: watchArgs{Count: -1}, wantErr: true},
+		{name: "interval too short", args: watchArgs{Interval: "100ms"}, wantErr: true},
+		{name: "invalid interval", args: watchArgs{Interval: "soon"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			interval, count, err := parseWatchArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWatchArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if interval != tt.wantInterval || count != tt.wantCount {
+				t.Errorf("parseWatchArgs() = %s, %d, want %s, %d", interval, count, tt.wantInterval, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestWatchInputSchema(t *testing.T) {
+	schema := watchInputSchema()
+	for _, name := range []string{"table", "sql", "final", "sample", "settings", "verbose", "interval", "count"} {
+		if schema.Properties[name] == nil {
+			t.Errorf("clickhouse_watch input schema has no %s property", name)
+		}
+	}
+	if schema.Properties["final"] != nil && schema.Properties["final"].Description == "" {
+		t.Error("final lost its clickhouse_query description")
+	}
+	if _, err := schema.Resolve(nil); err != nil {
+		t.Errorf("schema does not resolve: %v", err)
+	}
+
+	var a watchArgs
+	raw := `{"table": "system.merges", "settings": {"max_threads": "2"}, "verbose": true, "interval": "5s", "count": 3}`
+	if err := json.Unmarshal([]byte(raw), &a); err != nil {
+		t.Fatal(err)
+	}
+	if a.Table != "system.merges" || a.Settings["max_threads"] != "2" || !a.Verbose || a.Interval != "5s" || a.Count != 3 {
+		t.Errorf("decoded watchArgs = %+v", a)
+	}
+}